@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -17,14 +19,26 @@ import (
 func main() {
 	// Parse command line flags
 	logLevel := flag.String("log-level", "info", "Log level (debug, info, warn, error)")
+	configPath := flag.String("config", "", "Path to a YAML configuration file (environment variables take precedence)")
 	flag.Parse()
 
+	// Load configuration
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Create logger
-	log := logger.NewLogger(logger.LogLevelFromString(*logLevel), "main")
+	log := logger.NewLogger(logger.LogLevelFromString(*logLevel), "main", logger.LogFormatFromString(cfg.LogFormat))
 	log.Info("Starting WalletConnect Test App")
+	for _, warning := range cfg.Warnings() {
+		log.Warn(warning)
+	}
 
-	// Load configuration
-	cfg := config.LoadFromEnv()
+	if cfg.LogBufferSize > 0 {
+		log.EnableRingBuffer(cfg.LogBufferSize)
+	}
 	log.Info(fmt.Sprintf("Server address: %s", cfg.ServerAddress()))
 	log.Info(fmt.Sprintf("Relay address: %s", cfg.RelayAddress()))
 
@@ -38,7 +52,7 @@ func main() {
 	// Start server in a goroutine
 	go func() {
 		log.Info("Server starting")
-		if err := srv.Start(); err != nil {
+		if err := srv.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Error(fmt.Sprintf("Server error: %v", err))
 			stop <- os.Interrupt
 		}
@@ -1,34 +1,159 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 // Config holds the application configuration
 type Config struct {
 	// Server configuration
-	ServerHost string
-	ServerPort int
-	ServerURL  string // External URL for the server (for QR codes)
+	ServerHost string `yaml:"server_host"`
+	ServerPort int    `yaml:"server_port"`
+	ServerURL  string `yaml:"server_url"` // External URL for the server (for QR codes)
 
 	// Relay configuration
-	RelayHost string
-	RelayPort int
+	RelayHost string `yaml:"relay_host"`
+	RelayPort int    `yaml:"relay_port"`
+	RelayPath string `yaml:"relay_path"` // HTTP path the relay WebSocket endpoint is mounted at
+
+	// Scheme of the relay WebSocket URL: "ws" or "wss" (empty derives it from TLS and the server URL)
+	RelayWSScheme string `yaml:"relay_ws_scheme"`
+
+	// JSON-RPC version used by the relay, and whether requests must match it
+	JSONRPCVersion string `yaml:"jsonrpc_version"`
+	StrictJSONRPC  bool   `yaml:"strict_jsonrpc"`
+
+	// Refuse to connect the wallet client to a relay that is not wss://
+	RequireSecureRelay bool `yaml:"require_secure_relay"`
+
+	// Relays the wallet client fails over to, in order, when its own relay is unavailable
+	FallbackRelayURLs []string `yaml:"fallback_relay_urls"`
+
+	// PEM file of CA certificates the wallet client trusts for wss:// relays, in addition to the system roots
+	RelayCAFile string `yaml:"relay_ca_file"`
+
+	// Skip certificate verification of wss:// relays (development only)
+	RelayInsecureSkipVerify bool `yaml:"relay_insecure_skip_verify"`
+
+	// Use one topic for both the pairing and session phases of a session
+	SingleTopicMode bool `yaml:"single_topic_mode"`
+
+	// How long relay connections may stay open before clients are asked to reconnect (0 disables)
+	MaxConnectionAge time.Duration `yaml:"max_connection_age"`
+
+	// Simultaneous relay WebSocket connections allowed; further ones get 503 (0 is unlimited)
+	MaxConnections int `yaml:"max_connections"`
+
+	// How long a relay connection may go without reading a message or delivering a notification before it is closed (0 disables)
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+
+	// Longest TTL a published relay message may have; longer TTLs are clamped to it
+	MaxMessageTTL time.Duration `yaml:"max_message_ttl"`
+
+	// JSON-lines file recording relay messages that could not be delivered; empty disables it
+	DeadLetterPath string `yaml:"dead_letter_path"`
+
+	// Largest WebSocket message in bytes the relay and wallet client accept; larger ones close the connection
+	MaxMessageSize int64 `yaml:"max_message_size"`
+
+	// Reject publishes to topics that have no subscribers instead of dropping them
+	RejectPublishNoSubscribers bool `yaml:"reject_publish_no_subscribers"`
+
+	// Publishes per second each relay client may make (0 disables the limit), and the burst allowed above it
+	PublishRateLimit float64 `yaml:"publish_rate_limit"`
+	PublishRateBurst int     `yaml:"publish_rate_burst"`
+
+	// Regular expressions for the topics relay clients may use, matched at the start of the topic (empty allows all)
+	TopicAllowPatterns []string `yaml:"topic_allow_patterns"`
+
+	// Browser origins allowed to open relay connections ("*" or empty allows all)
+	AllowedOrigins []string `yaml:"allowed_origins"`
+
+	// Number of goroutines delivering published messages (topics are sharded across them)
+	MessageWorkers int `yaml:"message_workers"`
+
+	// Upstream relay that unknown JSON-RPC methods are forwarded to (empty disables forwarding)
+	UpstreamRelayURL string `yaml:"upstream_relay_url"`
+	// Reconnect to the upstream relay with backoff when its connection drops
+	UpstreamReconnect bool `yaml:"upstream_reconnect"`
 
 	// Web configuration
-	StaticDir   string
-	TemplateDir string
+	StaticDir   string `yaml:"static_dir"`
+	TemplateDir string `yaml:"template_dir"`
 
 	// TLS configuration
-	EnableTLS bool
-	CertFile  string
-	KeyFile   string
+	EnableTLS bool   `yaml:"enable_tls"`
+	CertFile  string `yaml:"cert_file"`
+	KeyFile   string `yaml:"key_file"`
+
+	// Time budget for creating a session and subscribing on the relay
+	CreateSessionTimeout time.Duration `yaml:"create_session_timeout"`
+
+	// How often expired sessions are cleaned up (0 disables the automatic cleanup)
+	CleanupInterval time.Duration `yaml:"cleanup_interval"`
+
+	// Where sessions are kept: "memory" or "file"
+	SessionStore string `yaml:"session_store"`
+	// Path of the sessions file used by the file session store
+	SessionStorePath string `yaml:"session_store_path"`
+
+	// How long new sessions live before they expire
+	SessionTTL time.Duration `yaml:"session_ttl"`
+
+	// How long past their expiry sessions and relay messages are still considered valid
+	ClockSkewTolerance time.Duration `yaml:"clock_skew_tolerance"`
+
+	// How long a session whose relay connection dropped may take to recover before it is disconnected
+	DisconnectGracePeriod time.Duration `yaml:"disconnect_grace_period"`
+	// How often a dropped relay connection is re-dialed before giving up (0 retries until the grace period ends)
+	ReconnectMaxRetries int `yaml:"reconnect_max_retries"`
+	// How often the wallet client pings its relay connections (0 disables pings)
+	WalletPingInterval time.Duration `yaml:"wallet_ping_interval"`
+
+	// Ethereum JSON-RPC node used to verify smart contract wallet signatures with EIP-1271 (empty disables it)
+	EthRPCURL string `yaml:"eth_rpc_url"`
+
+	// Sign methods the wallet client may forward to a wallet (empty allows all)
+	AllowedSignMethods []string `yaml:"allowed_sign_methods"`
+
+	// Log per-connection WebSocket frame counts when relay connections close
+	LogFrameStats bool `yaml:"log_frame_stats"`
+
+	// Expose Prometheus metrics at /metrics
+	EnableMetrics bool `yaml:"enable_metrics"`
+
+	// Format of log lines: "text" or "json"
+	LogFormat string `yaml:"log_format"`
+
+	// Number of recent log lines kept in memory for the admin logs endpoint (0 disables)
+	LogBufferSize int `yaml:"log_buffer_size"`
+
+	// Include full decrypted payloads in the debug message log (otherwise methods and IDs only)
+	LogSecrets bool `yaml:"log_secrets"`
+
+	// Token required by admin endpoints; if empty, admin endpoints are only available in debug mode
+	AdminToken string `yaml:"admin_token"`
+
+	// Pair and sign with an in-process demo wallet instead of an external one (requires Debug)
+	DemoWallet bool `yaml:"demo_wallet"`
+	// Hex private key for the demo wallet (a key is generated if empty)
+	DemoWalletKey string `yaml:"demo_wallet_key"`
 
 	// Debug mode
-	Debug bool
+	Debug bool `yaml:"debug"`
+
+	// Problems found while loading the configuration file, see Warnings
+	warnings []string
 }
 
 // DefaultConfig returns the default configuration
@@ -39,19 +164,115 @@ func DefaultConfig() *Config {
 		ServerURL:   "", // Will be auto-generated if not provided
 		RelayHost:   "0.0.0.0",
 		RelayPort:   8081,
+		RelayPath:   "/relay",
 		StaticDir:   "web/static",
 		TemplateDir: "web/templates",
 		EnableTLS:   false,
 		CertFile:    "certs/server.crt",
 		KeyFile:     "certs/server.key",
 		Debug:       true,
+
+		CreateSessionTimeout:  10 * time.Second,
+		DisconnectGracePeriod: 30 * time.Second,
+		WalletPingInterval:    30 * time.Second,
+		CleanupInterval:       time.Hour,
+		SessionStore:          "memory",
+		SessionStorePath:      "data/sessions.json",
+		SessionTTL:            24 * time.Hour,
+		JSONRPCVersion:        "2.0",
+		UpstreamReconnect:     true,
+		MessageWorkers:        1,
+		MaxMessageTTL:         24 * time.Hour,
+		MaxMessageSize:        256 << 10,
+		PublishRateLimit:      10,
+		PublishRateBurst:      20,
+		LogFormat:             "text",
 	}
 }
 
 // LoadFromEnv loads configuration from environment variables
 func LoadFromEnv() *Config {
 	config := DefaultConfig()
+	applyEnv(config)
+	deriveDefaults(config)
+	return config
+}
+
+// LoadFromFile loads configuration from a YAML file on top of the defaults.
+// Keys are the lowercase names of the environment variables, e.g. server_port.
+// Unknown keys are reported by Warnings rather than failing the load.
+func LoadFromFile(path string) (*Config, error) {
+	config := DefaultConfig()
+	if err := applyFile(config, path); err != nil {
+		return nil, err
+	}
+	deriveDefaults(config)
+	return config, nil
+}
+
+// Load loads configuration from the defaults, then the YAML file at path,
+// then environment variables, so environment variables win over the file.
+// An empty path skips the file; a missing file is reported by Warnings and
+// falls back to defaults and environment variables.
+func Load(path string) (*Config, error) {
+	config := DefaultConfig()
+	if path != "" {
+		err := applyFile(config, path)
+		if errors.Is(err, os.ErrNotExist) {
+			config.warnings = append(config.warnings, fmt.Sprintf("config file %s not found, using defaults and environment", path))
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	applyEnv(config)
+	deriveDefaults(config)
+	return config, nil
+}
+
+// Warnings returns problems found while loading the configuration, such as
+// unknown keys in the configuration file
+func (c *Config) Warnings() []string {
+	return c.warnings
+}
+
+// applyFile overrides the configuration with the values set in a YAML file
+func applyFile(config *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	// Collect unknown keys first, so a typo is reported instead of silently ignored
+	var keys map[string]any
+	if err := yaml.Unmarshal(data, &keys); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	known := make(map[string]bool)
+	configType := reflect.TypeOf(*config)
+	for i := 0; i < configType.NumField(); i++ {
+		if name, _, _ := strings.Cut(configType.Field(i).Tag.Get("yaml"), ","); name != "" {
+			known[name] = true
+		}
+	}
+	unknown := make([]string, 0)
+	for key := range keys {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		config.warnings = append(config.warnings, fmt.Sprintf("unknown key %q in config file %s", key, path))
+	}
 
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv overrides the configuration with the environment variables that are set
+func applyEnv(config *Config) {
 	if host := os.Getenv("SERVER_HOST"); host != "" {
 		config.ServerHost = host
 	}
@@ -76,6 +297,118 @@ func LoadFromEnv() *Config {
 		}
 	}
 
+	if version := os.Getenv("JSONRPC_VERSION"); version != "" {
+		config.JSONRPCVersion = version
+	}
+
+	if strict := os.Getenv("STRICT_JSONRPC"); strict != "" {
+		if st, err := strconv.ParseBool(strict); err == nil {
+			config.StrictJSONRPC = st
+		}
+	}
+
+	if reject := os.Getenv("REJECT_PUBLISH_NO_SUBSCRIBERS"); reject != "" {
+		if r, err := strconv.ParseBool(reject); err == nil {
+			config.RejectPublishNoSubscribers = r
+		}
+	}
+
+	if rate := os.Getenv("PUBLISH_RATE_LIMIT"); rate != "" {
+		if r, err := strconv.ParseFloat(rate, 64); err == nil && r >= 0 {
+			config.PublishRateLimit = r
+		}
+	}
+
+	if burst := os.Getenv("PUBLISH_RATE_BURST"); burst != "" {
+		if b, err := strconv.Atoi(burst); err == nil && b >= 0 {
+			config.PublishRateBurst = b
+		}
+	}
+
+	if patterns := os.Getenv("TOPIC_ALLOW_PATTERNS"); patterns != "" {
+		config.TopicAllowPatterns = splitList(patterns)
+	}
+
+	if origins := os.Getenv("ALLOWED_ORIGINS"); origins != "" {
+		config.AllowedOrigins = splitList(origins)
+	}
+
+	if relays := os.Getenv("FALLBACK_RELAY_URLS"); relays != "" {
+		config.FallbackRelayURLs = splitList(relays)
+	}
+
+	if caFile := os.Getenv("RELAY_CA_FILE"); caFile != "" {
+		config.RelayCAFile = caFile
+	}
+
+	if insecure := os.Getenv("RELAY_INSECURE_SKIP_VERIFY"); insecure != "" {
+		if i, err := strconv.ParseBool(insecure); err == nil {
+			config.RelayInsecureSkipVerify = i
+		}
+	}
+
+	if requireSecure := os.Getenv("REQUIRE_SECURE_RELAY"); requireSecure != "" {
+		if r, err := strconv.ParseBool(requireSecure); err == nil {
+			config.RequireSecureRelay = r
+		}
+	}
+
+	if singleTopic := os.Getenv("SINGLE_TOPIC_MODE"); singleTopic != "" {
+		if st, err := strconv.ParseBool(singleTopic); err == nil {
+			config.SingleTopicMode = st
+		}
+	}
+
+	if age := os.Getenv("MAX_CONNECTION_AGE"); age != "" {
+		if d, err := time.ParseDuration(age); err == nil {
+			config.MaxConnectionAge = d
+		}
+	}
+
+	if max := os.Getenv("MAX_CONNECTIONS"); max != "" {
+		if n, err := strconv.Atoi(max); err == nil && n >= 0 {
+			config.MaxConnections = n
+		}
+	}
+
+	if timeout := os.Getenv("IDLE_TIMEOUT"); timeout != "" {
+		if d, err := time.ParseDuration(timeout); err == nil && d >= 0 {
+			config.IdleTimeout = d
+		}
+	}
+
+	if path := os.Getenv("DEAD_LETTER_PATH"); path != "" {
+		config.DeadLetterPath = path
+	}
+
+	if size := os.Getenv("MAX_MESSAGE_SIZE"); size != "" {
+		if n, err := strconv.ParseInt(size, 10, 64); err == nil && n > 0 {
+			config.MaxMessageSize = n
+		}
+	}
+
+	if ttl := os.Getenv("MAX_MESSAGE_TTL"); ttl != "" {
+		if d, err := time.ParseDuration(ttl); err == nil && d > 0 {
+			config.MaxMessageTTL = d
+		}
+	}
+
+	if workers := os.Getenv("MESSAGE_WORKERS"); workers != "" {
+		if n, err := strconv.Atoi(workers); err == nil && n > 0 {
+			config.MessageWorkers = n
+		}
+	}
+
+	if url := os.Getenv("UPSTREAM_RELAY_URL"); url != "" {
+		config.UpstreamRelayURL = url
+	}
+
+	if reconnect := os.Getenv("UPSTREAM_RECONNECT"); reconnect != "" {
+		if r, err := strconv.ParseBool(reconnect); err == nil {
+			config.UpstreamReconnect = r
+		}
+	}
+
 	if dir := os.Getenv("STATIC_DIR"); dir != "" {
 		config.StaticDir = dir
 	}
@@ -98,12 +431,139 @@ func LoadFromEnv() *Config {
 		config.KeyFile = keyFile
 	}
 
+	if timeout := os.Getenv("CREATE_SESSION_TIMEOUT"); timeout != "" {
+		if d, err := time.ParseDuration(timeout); err == nil {
+			config.CreateSessionTimeout = d
+		}
+	}
+
+	// SESSION_CLEANUP_INTERVAL is an alias of CLEANUP_INTERVAL, which wins if both are set
+	for _, name := range []string{"SESSION_CLEANUP_INTERVAL", "CLEANUP_INTERVAL"} {
+		if interval := os.Getenv(name); interval != "" {
+			if d, err := time.ParseDuration(interval); err == nil {
+				config.CleanupInterval = d
+			}
+		}
+	}
+
+	if store := os.Getenv("SESSION_STORE"); store != "" {
+		config.SessionStore = store
+	}
+
+	if path := os.Getenv("SESSION_STORE_PATH"); path != "" {
+		config.SessionStorePath = path
+	}
+
+	if ttl := os.Getenv("SESSION_TTL"); ttl != "" {
+		if d, err := time.ParseDuration(ttl); err == nil && d > 0 {
+			config.SessionTTL = d
+		}
+	}
+
+	if tolerance := os.Getenv("CLOCK_SKEW_TOLERANCE"); tolerance != "" {
+		if d, err := time.ParseDuration(tolerance); err == nil {
+			config.ClockSkewTolerance = d
+		}
+	}
+
+	if grace := os.Getenv("DISCONNECT_GRACE_PERIOD"); grace != "" {
+		if d, err := time.ParseDuration(grace); err == nil {
+			config.DisconnectGracePeriod = d
+		}
+	}
+
+	if retries := os.Getenv("RECONNECT_MAX_RETRIES"); retries != "" {
+		if n, err := strconv.Atoi(retries); err == nil && n >= 0 {
+			config.ReconnectMaxRetries = n
+		}
+	}
+
+	if interval := os.Getenv("WALLET_PING_INTERVAL"); interval != "" {
+		if d, err := time.ParseDuration(interval); err == nil {
+			config.WalletPingInterval = d
+		}
+	}
+
+	if rpcURL := os.Getenv("ETH_RPC_URL"); rpcURL != "" {
+		config.EthRPCURL = rpcURL
+	}
+
+	if methods := os.Getenv("ALLOWED_SIGN_METHODS"); methods != "" {
+		config.AllowedSignMethods = splitList(methods)
+	}
+
+	if enableMetrics := os.Getenv("ENABLE_METRICS"); enableMetrics != "" {
+		if e, err := strconv.ParseBool(enableMetrics); err == nil {
+			config.EnableMetrics = e
+		}
+	}
+
+	if frameStats := os.Getenv("LOG_FRAME_STATS"); frameStats != "" {
+		if f, err := strconv.ParseBool(frameStats); err == nil {
+			config.LogFrameStats = f
+		}
+	}
+
+	if format := os.Getenv("LOG_FORMAT"); format != "" {
+		config.LogFormat = format
+	}
+
+	if size := os.Getenv("LOG_BUFFER_SIZE"); size != "" {
+		if n, err := strconv.Atoi(size); err == nil {
+			config.LogBufferSize = n
+		}
+	}
+
+	if logSecrets := os.Getenv("LOG_SECRETS"); logSecrets != "" {
+		if ls, err := strconv.ParseBool(logSecrets); err == nil {
+			config.LogSecrets = ls
+		}
+	}
+
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		config.AdminToken = token
+	}
+
+	if demo := os.Getenv("DEMO_WALLET"); demo != "" {
+		if d, err := strconv.ParseBool(demo); err == nil {
+			config.DemoWallet = d
+		}
+	}
+
+	if path := os.Getenv("RELAY_PATH"); path != "" {
+		config.RelayPath = path
+	}
+
+	if scheme := os.Getenv("RELAY_WS_SCHEME"); scheme != "" {
+		config.RelayWSScheme = scheme
+	}
+
+	if key := os.Getenv("DEMO_WALLET_KEY"); key != "" {
+		config.DemoWalletKey = key
+	}
+
 	if debug := os.Getenv("DEBUG"); debug != "" {
 		if d, err := strconv.ParseBool(debug); err == nil {
 			config.Debug = d
 		}
 	}
 
+}
+
+// deriveDefaults fills in settings that default to values derived from other settings
+func deriveDefaults(config *Config) {
+	// The relay path is mounted on the server's router, so it must be absolute
+	// and must not take over the root path
+	if !strings.HasPrefix(config.RelayPath, "/") || config.RelayPath == "/" {
+		config.warnings = append(config.warnings, fmt.Sprintf("invalid relay path %q, it must begin with / and not be /; using /relay", config.RelayPath))
+		config.RelayPath = "/relay"
+	}
+
+	if config.RelayWSScheme != "" && config.RelayWSScheme != "ws" && config.RelayWSScheme != "wss" {
+		config.warnings = append(config.warnings, fmt.Sprintf("invalid relay WebSocket scheme %q, it must be ws or wss; deriving it", config.RelayWSScheme))
+		config.RelayWSScheme = ""
+	}
+
 	// If SERVER_URL is not provided, generate it based on host and port
 	if config.ServerURL == "" {
 		protocol := "http"
@@ -112,7 +572,7 @@ func LoadFromEnv() *Config {
 		}
 
 		host := config.ServerHost
-		if host == "0.0.0.0" {
+		if host == "0.0.0.0" || host == "::" || host == "[::]" {
 			// Try to get the machine's hostname or IP
 			hostname, err := os.Hostname()
 			if err == nil {
@@ -122,20 +582,36 @@ func LoadFromEnv() *Config {
 			}
 		}
 
-		config.ServerURL = fmt.Sprintf("%s://%s:%d", protocol, host, config.ServerPort)
+		config.ServerURL = fmt.Sprintf("%s://%s", protocol, joinHostPort(host, config.ServerPort))
 	}
+}
 
-	return config
+// splitList splits a comma-separated list, trimming whitespace and dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// joinHostPort joins a host and port into an address, bracketing IPv6
+// literals. The host may already be bracketed.
+func joinHostPort(host string, port int) string {
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return net.JoinHostPort(host, strconv.Itoa(port))
 }
 
 // ServerAddress returns the full server address
 func (c *Config) ServerAddress() string {
-	return fmt.Sprintf("%s:%d", c.ServerHost, c.ServerPort)
+	return joinHostPort(c.ServerHost, c.ServerPort)
 }
 
 // RelayAddress returns the full relay address
 func (c *Config) RelayAddress() string {
-	return fmt.Sprintf("%s:%d", c.RelayHost, c.RelayPort)
+	return joinHostPort(c.RelayHost, c.RelayPort)
 }
 
 // ExternalURL returns the external URL for the server
@@ -143,12 +619,17 @@ func (c *Config) ExternalURL() string {
 	return c.ServerURL
 }
 
-// RelayWebSocketURL returns the WebSocket URL for the relay server
+// RelayWebSocketURL returns the WebSocket URL for the relay server. The
+// scheme is wss when TLS is enabled or the server URL is https, and ws
+// otherwise; RelayWSScheme overrides it for proxies that terminate TLS.
 func (c *Config) RelayWebSocketURL() string {
-	protocol := "wss" //dirty for caddy
-	if c.EnableTLS {
+	protocol := "ws"
+	if c.EnableTLS || strings.HasPrefix(c.ServerURL, "https://") {
 		protocol = "wss"
 	}
+	if c.RelayWSScheme != "" {
+		protocol = c.RelayWSScheme
+	}
 
 	// Extract the host and port from the server URL
 	serverURL := c.ServerURL
@@ -158,10 +639,16 @@ func (c *Config) RelayWebSocketURL() string {
 		serverURL = strings.TrimPrefix(serverURL, "https://")
 	}
 
-	// Remove any path
-	if idx := strings.Index(serverURL, "/"); idx != -1 {
+	// Remove any path, query or fragment
+	if idx := strings.IndexAny(serverURL, "/?#"); idx != -1 {
 		serverURL = serverURL[:idx]
 	}
 
-	return fmt.Sprintf("%s://%s/relay", protocol, serverURL)
+	// A bracketed IPv6 host such as [::1]:8080 is kept as is, but a bare IPv6
+	// literal must be bracketed to be a valid URL host
+	if ip := net.ParseIP(serverURL); ip != nil && strings.Contains(serverURL, ":") {
+		serverURL = "[" + serverURL + "]"
+	}
+
+	return fmt.Sprintf("%s://%s%s", protocol, serverURL, c.RelayPath)
 }
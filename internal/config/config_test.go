@@ -0,0 +1,115 @@
+package config
+
+import "testing"
+
+func TestRelayWebSocketURLScheme(t *testing.T) {
+	tests := []struct {
+		name      string
+		serverURL string
+		enableTLS bool
+		scheme    string
+		want      string
+	}{
+		{name: "http", serverURL: "http://localhost:8080", want: "ws://localhost:8080/relay"},
+		{name: "https", serverURL: "https://example.com", want: "wss://example.com/relay"},
+		{name: "tls enabled", serverURL: "http://localhost:8443", enableTLS: true, want: "wss://localhost:8443/relay"},
+		{name: "path is dropped", serverURL: "https://example.com/app?x=1", want: "wss://example.com/relay"},
+		{name: "override behind a TLS proxy", serverURL: "http://localhost:8080", scheme: "wss", want: "wss://localhost:8080/relay"},
+		{name: "override to plaintext", serverURL: "https://example.com", scheme: "ws", want: "ws://example.com/relay"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.ServerURL = tt.serverURL
+			config.EnableTLS = tt.enableTLS
+			config.RelayWSScheme = tt.scheme
+
+			if got := config.RelayWebSocketURL(); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRelayWSSchemeFromEnv(t *testing.T) {
+	t.Setenv("SERVER_URL", "http://localhost:8080")
+	t.Setenv("RELAY_WS_SCHEME", "wss")
+	if got := LoadFromEnv().RelayWebSocketURL(); got != "wss://localhost:8080/relay" {
+		t.Errorf("got %s, want the overridden scheme", got)
+	}
+
+	// An invalid override is reported and the scheme is derived instead
+	t.Setenv("RELAY_WS_SCHEME", "http")
+	config := LoadFromEnv()
+	if got := config.RelayWebSocketURL(); got != "ws://localhost:8080/relay" {
+		t.Errorf("got %s, want the derived scheme", got)
+	}
+	if len(config.Warnings()) != 1 {
+		t.Errorf("got warnings %v, want one for the invalid scheme", config.Warnings())
+	}
+}
+
+func TestAddresses(t *testing.T) {
+	tests := []struct {
+		name string
+		host string
+		want string
+	}{
+		{name: "IPv4", host: "127.0.0.1", want: "127.0.0.1:8080"},
+		{name: "all IPv4 interfaces", host: "0.0.0.0", want: "0.0.0.0:8080"},
+		{name: "IPv6", host: "::1", want: "[::1]:8080"},
+		{name: "bracketed IPv6", host: "[::1]", want: "[::1]:8080"},
+		{name: "all IPv6 interfaces", host: "::", want: "[::]:8080"},
+		{name: "hostname", host: "localhost", want: "localhost:8080"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{ServerHost: tt.host, ServerPort: 8080, RelayHost: tt.host, RelayPort: 8080}
+			if got := config.ServerAddress(); got != tt.want {
+				t.Errorf("got server address %s, want %s", got, tt.want)
+			}
+			if got := config.RelayAddress(); got != tt.want {
+				t.Errorf("got relay address %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRelayWebSocketURLHost(t *testing.T) {
+	tests := []struct {
+		name      string
+		serverURL string
+		want      string
+	}{
+		{name: "IPv4", serverURL: "http://127.0.0.1:8080", want: "ws://127.0.0.1:8080/relay"},
+		{name: "bracketed IPv6 with port", serverURL: "http://[::1]:8080", want: "ws://[::1]:8080/relay"},
+		{name: "bracketed IPv6", serverURL: "http://[2001:db8::1]/", want: "ws://[2001:db8::1]/relay"},
+		{name: "bare IPv6", serverURL: "http://2001:db8::1", want: "ws://[2001:db8::1]/relay"},
+		{name: "hostname", serverURL: "http://wallet.local:8080", want: "ws://wallet.local:8080/relay"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.ServerURL = tt.serverURL
+			if got := config.RelayWebSocketURL(); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDerivedServerURLBracketsIPv6(t *testing.T) {
+	config := DefaultConfig()
+	config.ServerHost = "::1"
+	deriveDefaults(config)
+
+	if config.ServerURL != "http://[::1]:8080" {
+		t.Errorf("got server URL %s, want http://[::1]:8080", config.ServerURL)
+	}
+	if got := config.RelayWebSocketURL(); got != "ws://[::1]:8080/relay" {
+		t.Errorf("got relay URL %s, want ws://[::1]:8080/relay", got)
+	}
+}
@@ -1,9 +1,12 @@
 package logger
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -21,20 +24,70 @@ const (
 	ErrorLevel
 )
 
+// LogFormat represents the format of log lines
+type LogFormat string
+
+const (
+	// TextFormat logs lines as "[timestamp] [LEVEL] [prefix] msg"
+	TextFormat LogFormat = "text"
+	// JSONFormat logs each line as a JSON object with ts, level, prefix and msg fields
+	JSONFormat LogFormat = "json"
+)
+
+// FieldLogger is the logging interface used throughout the application. The
+// formatted variants avoid building messages with fmt.Sprintf at call sites,
+// and With attaches a key/value pair, such as a topic or client ID, to every
+// line logged through the returned logger.
+type FieldLogger interface {
+	Debug(msg string)
+	Info(msg string)
+	Warn(msg string)
+	Error(msg string)
+
+	Debugf(format string, args ...any)
+	Infof(format string, args ...any)
+	Warnf(format string, args ...any)
+	Errorf(format string, args ...any)
+
+	With(key string, val any) FieldLogger
+}
+
 // Logger represents a logger
 type Logger struct {
 	level  LogLevel
 	prefix string
+	format LogFormat
+	fields map[string]any // attached to every line, see WithFields
 	logger *log.Logger
+	recent *RingBuffer // optional in-memory copy of recent lines
 }
 
 // NewLogger creates a new logger
-func NewLogger(level LogLevel, prefix string) *Logger {
-	return &Logger{
+func NewLogger(level LogLevel, prefix string, format LogFormat) *Logger {
+	l := &Logger{
 		level:  level,
 		prefix: prefix,
 		logger: log.New(os.Stdout, "", log.LstdFlags),
 	}
+	l.SetFormat(format)
+	return l
+}
+
+// WithFields returns a logger that attaches the given fields to every line,
+// in addition to the fields already attached to l. The returned logger shares
+// the output and ring buffer of l.
+func (l *Logger) WithFields(fields map[string]any) *Logger {
+	merged := make(map[string]any, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+
+	child := *l
+	child.fields = merged
+	return &child
 }
 
 // Debug logs a debug message
@@ -65,10 +118,141 @@ func (l *Logger) Error(msg string) {
 	}
 }
 
+// Debugf logs a formatted debug message
+func (l *Logger) Debugf(format string, args ...any) {
+	if l.level <= DebugLevel {
+		l.log("DEBUG", fmt.Sprintf(format, args...))
+	}
+}
+
+// Infof logs a formatted info message
+func (l *Logger) Infof(format string, args ...any) {
+	if l.level <= InfoLevel {
+		l.log("INFO", fmt.Sprintf(format, args...))
+	}
+}
+
+// Warnf logs a formatted warning message
+func (l *Logger) Warnf(format string, args ...any) {
+	if l.level <= WarnLevel {
+		l.log("WARN", fmt.Sprintf(format, args...))
+	}
+}
+
+// Errorf logs a formatted error message
+func (l *Logger) Errorf(format string, args ...any) {
+	if l.level <= ErrorLevel {
+		l.log("ERROR", fmt.Sprintf(format, args...))
+	}
+}
+
+// With returns a logger that attaches the key/value pair to every line
+func (l *Logger) With(key string, val any) FieldLogger {
+	return l.WithFields(map[string]any{key: val})
+}
+
 // log logs a message with the given level
 func (l *Logger) log(level, msg string) {
+	var line string
+	if l.format == JSONFormat {
+		line = l.jsonLine(level, msg)
+	} else {
+		line = l.textLine(level, msg)
+	}
+	l.logger.Print(line)
+
+	if l.recent != nil {
+		l.recent.Add(line)
+	}
+}
+
+// textLine formats a log line as text, with fields appended as sorted key=value pairs
+func (l *Logger) textLine(level, msg string) string {
 	timestamp := time.Now().Format("2006-01-02 15:04:05.000")
-	l.logger.Printf("[%s] [%s] [%s] %s", timestamp, level, l.prefix, msg)
+	line := fmt.Sprintf("[%s] [%s] [%s] %s", timestamp, level, l.prefix, msg)
+	if len(l.fields) == 0 {
+		return line
+	}
+
+	keys := make([]string, 0, len(l.fields))
+	for k := range l.fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(line)
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%v", k, l.fields[k])
+	}
+	return b.String()
+}
+
+// jsonLine formats a log line as a JSON object. Fields cannot override the
+// ts, level, prefix and msg keys.
+func (l *Logger) jsonLine(level, msg string) string {
+	entry := make(map[string]any, len(l.fields)+4)
+	for k, v := range l.fields {
+		entry[k] = v
+	}
+	entry["ts"] = time.Now().Format(time.RFC3339Nano)
+	entry["level"] = level
+	entry["prefix"] = l.prefix
+	entry["msg"] = msg
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		// Fall back to the bare line if a field cannot be encoded
+		data, _ = json.Marshal(map[string]any{
+			"ts":     entry["ts"],
+			"level":  level,
+			"prefix": l.prefix,
+			"msg":    msg,
+			"error":  fmt.Sprintf("failed to encode log fields: %v", err),
+		})
+	}
+	return string(data)
+}
+
+// EnableRingBuffer keeps a copy of the most recent size log lines in memory.
+// It should be called before the logger is shared between goroutines.
+func (l *Logger) EnableRingBuffer(size int) {
+	l.recent = NewRingBuffer(size)
+}
+
+// RecentLines returns up to n of the most recent log lines, oldest first.
+// It returns nil if the ring buffer is not enabled.
+func (l *Logger) RecentLines(n int) []string {
+	if l.recent == nil {
+		return nil
+	}
+	return l.recent.Last(n)
+}
+
+// RingBufferEnabled checks if recent log lines are being kept in memory
+func (l *Logger) RingBufferEnabled() bool {
+	return l.recent != nil
+}
+
+// SetFormat sets the format of log lines. Unknown formats fall back to text.
+// It should be called before the logger is shared between goroutines.
+func (l *Logger) SetFormat(format LogFormat) {
+	if format != JSONFormat {
+		format = TextFormat
+	}
+	l.format = format
+
+	// JSON lines carry their own timestamp and must not be prefixed
+	if format == JSONFormat {
+		l.logger.SetFlags(0)
+	} else {
+		l.logger.SetFlags(log.LstdFlags)
+	}
+}
+
+// GetFormat gets the format of log lines
+func (l *Logger) GetFormat() LogFormat {
+	return l.format
 }
 
 // SetLevel sets the log level
@@ -107,6 +291,16 @@ func LogLevelFromString(level string) LogLevel {
 	}
 }
 
+// LogFormatFromString converts a string to a log format
+func LogFormatFromString(format string) LogFormat {
+	switch format {
+	case "json":
+		return JSONFormat
+	default:
+		return TextFormat
+	}
+}
+
 // String returns the string representation of a log level
 func (l LogLevel) String() string {
 	switch l {
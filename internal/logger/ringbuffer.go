@@ -0,0 +1,56 @@
+package logger
+
+import "sync"
+
+// RingBuffer holds the most recent log lines in memory
+type RingBuffer struct {
+	lines []string
+	next  int  // index the next line is written to
+	full  bool // whether the buffer has wrapped around
+	mutex sync.Mutex
+}
+
+// NewRingBuffer creates a new ring buffer that holds up to size lines
+func NewRingBuffer(size int) *RingBuffer {
+	if size <= 0 {
+		size = 1
+	}
+	return &RingBuffer{
+		lines: make([]string, size),
+	}
+}
+
+// Add adds a line, overwriting the oldest line when the buffer is full
+func (b *RingBuffer) Add(line string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.lines[b.next] = line
+	b.next = (b.next + 1) % len(b.lines)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// Last returns up to n of the most recent lines, oldest first.
+// A non-positive n returns all buffered lines.
+func (b *RingBuffer) Last(n int) []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	count := b.next
+	if b.full {
+		count = len(b.lines)
+	}
+	if n <= 0 || n > count {
+		n = count
+	}
+
+	result := make([]string, n)
+	start := (b.next - n + len(b.lines)) % len(b.lines)
+	for i := 0; i < n; i++ {
+		result[i] = b.lines[(start+i)%len(b.lines)]
+	}
+
+	return result
+}
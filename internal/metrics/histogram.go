@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+)
+
+// Histogram counts observed values in cumulative buckets
+type Histogram struct {
+	upperBounds []float64
+	counts      []uint64 // non-cumulative count per bucket
+	sum         float64
+	count       uint64
+	mutex       sync.Mutex
+}
+
+// Bucket is a cumulative histogram bucket
+type Bucket struct {
+	UpperBound float64 `json:"le"`
+	Count      uint64  `json:"count"`
+}
+
+// HistogramSnapshot is a point-in-time copy of a histogram.
+// Count includes observations above the largest bucket bound.
+type HistogramSnapshot struct {
+	Buckets []Bucket `json:"buckets"`
+	Sum     float64  `json:"sum"`
+	Count   uint64   `json:"count"`
+}
+
+// NewHistogram creates a new histogram with the given bucket upper bounds
+func NewHistogram(upperBounds []float64) *Histogram {
+	bounds := make([]float64, len(upperBounds))
+	copy(bounds, upperBounds)
+	sort.Float64s(bounds)
+
+	return &Histogram{
+		upperBounds: bounds,
+		counts:      make([]uint64, len(bounds)),
+	}
+}
+
+// Observe records a value
+func (h *Histogram) Observe(value float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.sum += value
+	h.count++
+
+	// Values above the largest bound are only reflected in the total count
+	if i := sort.SearchFloat64s(h.upperBounds, value); i < len(h.counts) {
+		h.counts[i]++
+	}
+}
+
+// Snapshot returns a copy of the histogram with cumulative bucket counts
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	buckets := make([]Bucket, len(h.upperBounds))
+	var cumulative uint64
+	for i, bound := range h.upperBounds {
+		cumulative += h.counts[i]
+		buckets[i] = Bucket{
+			UpperBound: bound,
+			Count:      cumulative,
+		}
+	}
+
+	return HistogramSnapshot{
+		Buckets: buckets,
+		Sum:     h.sum,
+		Count:   h.count,
+	}
+}
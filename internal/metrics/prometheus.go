@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Prometheus collectors updated by the relay and wallet. They are always
+// updated, but only exported once registered with Register.
+var (
+	// RelayConnections is the number of open relay WebSocket connections
+	RelayConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "wctestapp_relay_connections",
+		Help: "Number of open relay WebSocket connections.",
+	})
+
+	// RelayMessagesPublished counts messages accepted from publishers
+	RelayMessagesPublished = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "wctestapp_relay_messages_published_total",
+		Help: "Messages accepted from publishers by the relay.",
+	})
+
+	// RelayMessagesDelivered counts notifications written to subscribers and observers
+	RelayMessagesDelivered = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "wctestapp_relay_messages_delivered_total",
+		Help: "Message notifications written to relay subscribers and observers.",
+	})
+
+	// RelayMessagesExpired counts messages dropped because their TTL passed before delivery
+	RelayMessagesExpired = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "wctestapp_relay_messages_expired_total",
+		Help: "Messages dropped by the relay because they expired before delivery.",
+	})
+
+	// RelayMessagesDropped counts publishes rejected because the message queue was full
+	RelayMessagesDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "wctestapp_relay_messages_dropped_total",
+		Help: "Publishes rejected by the relay because its message queue was full.",
+	})
+
+	// WalletDecryptFailures counts relay messages the wallet client could not decrypt
+	WalletDecryptFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "wctestapp_wallet_decrypt_failures_total",
+		Help: "Relay messages the wallet client failed to decrypt.",
+	})
+)
+
+// LabeledGaugeFunc is a gauge with one label whose values are read from a
+// callback at scrape time, for state that is already tracked elsewhere
+type LabeledGaugeFunc struct {
+	desc  *prometheus.Desc
+	value func() map[string]int
+}
+
+// NewLabeledGaugeFunc creates a gauge that reports one series per key of the
+// map returned by value, with the key as the value of label
+func NewLabeledGaugeFunc(name, help, label string, value func() map[string]int) *LabeledGaugeFunc {
+	return &LabeledGaugeFunc{
+		desc:  prometheus.NewDesc(name, help, []string{label}, nil),
+		value: value,
+	}
+}
+
+// Describe implements prometheus.Collector
+func (g *LabeledGaugeFunc) Describe(ch chan<- *prometheus.Desc) {
+	ch <- g.desc
+}
+
+// Collect implements prometheus.Collector
+func (g *LabeledGaugeFunc) Collect(ch chan<- prometheus.Metric) {
+	for labelValue, value := range g.value() {
+		ch <- prometheus.MustNewConstMetric(g.desc, prometheus.GaugeValue, float64(value), labelValue)
+	}
+}
+
+// Register registers the relay and wallet collectors, plus any extra
+// collectors, with reg. Collectors that are already registered are skipped.
+func Register(reg prometheus.Registerer, extra ...prometheus.Collector) error {
+	collectors := []prometheus.Collector{
+		RelayConnections,
+		RelayMessagesPublished,
+		RelayMessagesDelivered,
+		RelayMessagesExpired,
+		RelayMessagesDropped,
+		WalletDecryptFailures,
+	}
+	collectors = append(collectors, extra...)
+
+	var errs []error
+	for _, collector := range collectors {
+		err := reg.Register(collector)
+		var alreadyRegistered prometheus.AlreadyRegisteredError
+		if err != nil && !errors.As(err, &alreadyRegistered) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
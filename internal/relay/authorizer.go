@@ -0,0 +1,89 @@
+package relay
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// ErrorCodeUnauthorized is the JSON-RPC error code returned for subscribes and
+// publishes the topic authorizer does not allow. It is the same code as
+// ErrorCodeNoSubscribers; the error message tells the two apart.
+const ErrorCodeUnauthorized = -32001
+
+// TopicAuthorizer decides which topics a client may use
+type TopicAuthorizer interface {
+	// CanSubscribe reports whether the client may subscribe to the topic
+	CanSubscribe(clientID, topic string) bool
+	// CanPublish reports whether the client may publish to the topic
+	CanPublish(clientID, topic string) bool
+}
+
+// AllowAllTopics is a TopicAuthorizer that allows every client to use every topic
+type AllowAllTopics struct{}
+
+// CanSubscribe implements TopicAuthorizer
+func (AllowAllTopics) CanSubscribe(clientID, topic string) bool { return true }
+
+// CanPublish implements TopicAuthorizer
+func (AllowAllTopics) CanPublish(clientID, topic string) bool { return true }
+
+// PatternTopicAuthorizer is a TopicAuthorizer that allows topics matching any
+// of a list of regular expressions. Each expression is anchored at the start
+// of the topic, so "wc-" allows all topics with that prefix.
+type PatternTopicAuthorizer struct {
+	patterns []*regexp.Regexp
+}
+
+// NewPatternTopicAuthorizer creates a PatternTopicAuthorizer from regular expressions
+func NewPatternTopicAuthorizer(patterns []string) (*PatternTopicAuthorizer, error) {
+	authorizer := &PatternTopicAuthorizer{}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")")
+		if err != nil {
+			return nil, fmt.Errorf("invalid topic pattern %q: %w", pattern, err)
+		}
+		authorizer.patterns = append(authorizer.patterns, re)
+	}
+	return authorizer, nil
+}
+
+// CanSubscribe implements TopicAuthorizer
+func (a *PatternTopicAuthorizer) CanSubscribe(clientID, topic string) bool {
+	return a.allows(topic)
+}
+
+// CanPublish implements TopicAuthorizer
+func (a *PatternTopicAuthorizer) CanPublish(clientID, topic string) bool {
+	return a.allows(topic)
+}
+
+// allows reports whether the topic matches any pattern
+func (a *PatternTopicAuthorizer) allows(topic string) bool {
+	for _, re := range a.patterns {
+		if re.MatchString(topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetTopicAuthorizer sets the authorizer that decides which topics clients may
+// subscribe and publish to. A nil authorizer allows all topics.
+func (s *RelayServer) SetTopicAuthorizer(authorizer TopicAuthorizer) {
+	if authorizer == nil {
+		authorizer = AllowAllTopics{}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.topicAuthorizer = authorizer
+}
+
+// getTopicAuthorizer returns the current topic authorizer
+func (s *RelayServer) getTopicAuthorizer() TopicAuthorizer {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.topicAuthorizer
+}
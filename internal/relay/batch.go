@@ -0,0 +1,98 @@
+package relay
+
+import (
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+)
+
+// BatchSubscribeParams represents the parameters for an irn_batchSubscribe
+// request. The params may also be given as a bare array of topics.
+type BatchSubscribeParams struct {
+	Topics []string `json:"topics"`
+}
+
+// BatchPublishParams represents the parameters for an irn_batchPublish
+// request. The params may also be given as a bare array of publish params.
+type BatchPublishParams struct {
+	Messages []PublishParams `json:"messages"`
+}
+
+// BatchResult is the outcome of one item of a batch request. Batch requests
+// succeed as a whole and return one result per item, in request order.
+type BatchResult struct {
+	Topic  string        `json:"topic"`
+	Result any           `json:"result,omitempty"`
+	Error  *JSONRPCError `json:"error,omitempty"`
+}
+
+// handleBatchSubscribe handles an irn_batchSubscribe request, subscribing the
+// client to each topic as a subscribe request would. The result of each topic
+// is its subscription ID.
+func (s *RelayServer) handleBatchSubscribe(conn *websocket.Conn, clientID string, request *JSONRPCRequest) {
+	var params BatchSubscribeParams
+	if err := decodeBatchParams(request.Params, &params, &params.Topics); err != nil || len(params.Topics) == 0 {
+		s.logger.Errorf("Invalid irn_batchSubscribe params from client %s: %v", clientID, err)
+		s.sendErrorResponse(conn, request.ID, -32602, "Invalid params")
+		return
+	}
+
+	results := make([]BatchResult, 0, len(params.Topics))
+	subscriptions := make([]*Subscription, 0, len(params.Topics))
+	for _, topic := range params.Topics {
+		subscription, rpcErr := s.subscribe(conn, clientID, SubscribeParams{Topic: topic})
+		if rpcErr != nil {
+			results = append(results, batchResult(topic, nil, rpcErr))
+			continue
+		}
+		subscriptions = append(subscriptions, subscription)
+		results = append(results, batchResult(topic, subscription.ID, nil))
+	}
+
+	s.sendSuccessResponse(conn, request.ID, results)
+	s.logger.Infof("Client %s batch subscribed to %d topics", clientID, len(params.Topics))
+
+	for _, subscription := range subscriptions {
+		s.sendReplay(subscription)
+	}
+}
+
+// handleBatchPublish handles an irn_batchPublish request, publishing each
+// message as a publish request would
+func (s *RelayServer) handleBatchPublish(conn *websocket.Conn, clientID string, request *JSONRPCRequest) {
+	var params BatchPublishParams
+	if err := decodeBatchParams(request.Params, &params, &params.Messages); err != nil || len(params.Messages) == 0 {
+		s.logger.Errorf("Invalid irn_batchPublish params from client %s: %v", clientID, err)
+		s.sendErrorResponse(conn, request.ID, -32602, "Invalid params")
+		return
+	}
+
+	results := make([]BatchResult, 0, len(params.Messages))
+	for _, message := range params.Messages {
+		results = append(results, batchResult(message.Topic, true, s.publish(conn, clientID, message)))
+	}
+
+	s.sendSuccessResponse(conn, request.ID, results)
+	s.logger.Infof("Client %s batch published %d messages", clientID, len(params.Messages))
+}
+
+// batchResult creates the result of a batch item
+func batchResult(topic string, result any, rpcErr *JSONRPCError) BatchResult {
+	if rpcErr != nil {
+		return BatchResult{Topic: topic, Error: rpcErr}
+	}
+	return BatchResult{Topic: topic, Result: result}
+}
+
+// decodeBatchParams decodes batch params given either as an object into
+// params, or as a bare array into items
+func decodeBatchParams(raw any, params any, items any) error {
+	paramsBytes, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	if _, isArray := raw.([]any); isArray {
+		return json.Unmarshal(paramsBytes, items)
+	}
+	return json.Unmarshal(paramsBytes, params)
+}
@@ -0,0 +1,107 @@
+package relay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrMessageExpired is the dead-letter reason for messages whose TTL passed
+// before they could be delivered to their subscribers
+var ErrMessageExpired = errors.New("message expired before delivery")
+
+// DeadLetterSink receives messages the relay could not deliver to a
+// subscriber, with the reason delivery failed
+type DeadLetterSink interface {
+	Record(msg *Message, clientID string, err error)
+}
+
+// DiscardDeadLetters is a DeadLetterSink that drops every record
+type DiscardDeadLetters struct{}
+
+// Record implements DeadLetterSink
+func (DiscardDeadLetters) Record(msg *Message, clientID string, err error) {}
+
+// DeadLetterRecord is one line of a dead-letter file
+type DeadLetterRecord struct {
+	Time          time.Time `json:"time"`
+	MessageID     string    `json:"message_id"`
+	Topic         string    `json:"topic"`
+	ClientID      string    `json:"client_id"`
+	PayloadLength int       `json:"payload_length"`
+	Reason        string    `json:"reason"`
+}
+
+// FileDeadLetterSink is a DeadLetterSink that appends records to a file as
+// JSON lines. Payloads are not written, only their length.
+type FileDeadLetterSink struct {
+	path   string
+	mutex  sync.Mutex
+	logger Logger
+}
+
+// NewFileDeadLetterSink creates a sink that appends to the file at path,
+// creating it and its directory if needed
+func NewFileDeadLetterSink(path string, logger Logger) (*FileDeadLetterSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create dead-letter directory: %w", err)
+	}
+	return &FileDeadLetterSink{path: path, logger: logger}, nil
+}
+
+// Record implements DeadLetterSink. Dead letters are rare, so the file is
+// opened for each record rather than held open.
+func (s *FileDeadLetterSink) Record(msg *Message, clientID string, err error) {
+	line, marshalErr := json.Marshal(DeadLetterRecord{
+		Time:          time.Now(),
+		MessageID:     msg.ID,
+		Topic:         msg.Topic,
+		ClientID:      clientID,
+		PayloadLength: len(msg.Payload),
+		Reason:        err.Error(),
+	})
+	if marshalErr != nil {
+		s.logger.Errorf("Failed to marshal dead letter for topic %s: %v", msg.Topic, marshalErr)
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	file, openErr := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if openErr != nil {
+		s.logger.Errorf("Failed to open dead-letter file %s: %v", s.path, openErr)
+		return
+	}
+	defer file.Close()
+
+	if _, writeErr := file.Write(append(line, '\n')); writeErr != nil {
+		s.logger.Errorf("Failed to write dead letter to %s: %v", s.path, writeErr)
+	}
+}
+
+// SetDeadLetterSink sets the sink that records undeliverable messages. A nil
+// sink discards them.
+func (s *RelayServer) SetDeadLetterSink(sink DeadLetterSink) {
+	if sink == nil {
+		sink = DiscardDeadLetters{}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.deadLetters = sink
+}
+
+// recordDeadLetter passes an undeliverable message to the dead-letter sink
+func (s *RelayServer) recordDeadLetter(msg *Message, clientID string, err error) {
+	s.mutex.RLock()
+	sink := s.deadLetters
+	s.mutex.RUnlock()
+
+	sink.Record(msg, clientID, err)
+}
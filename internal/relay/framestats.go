@@ -0,0 +1,65 @@
+package relay
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// FrameStats counts WebSocket frames sent and received on a connection by type
+type FrameStats struct {
+	received [websocket.PongMessage + 1]atomic.Int64
+	sent     [websocket.PongMessage + 1]atomic.Int64
+}
+
+// RecordReceived counts a received frame of the given websocket message type
+func (f *FrameStats) RecordReceived(messageType int) {
+	if messageType >= 0 && messageType < len(f.received) {
+		f.received[messageType].Add(1)
+	}
+}
+
+// RecordSent counts a sent frame of the given websocket message type
+func (f *FrameStats) RecordSent(messageType int) {
+	if messageType >= 0 && messageType < len(f.sent) {
+		f.sent[messageType].Add(1)
+	}
+}
+
+// Summary returns a one-line summary of the frame counts
+func (f *FrameStats) Summary() string {
+	count := func(counters *[websocket.PongMessage + 1]atomic.Int64, messageType int) int64 {
+		return counters[messageType].Load()
+	}
+
+	return fmt.Sprintf("received text=%d binary=%d ping=%d pong=%d close=%d; sent text=%d binary=%d ping=%d pong=%d close=%d",
+		count(&f.received, websocket.TextMessage),
+		count(&f.received, websocket.BinaryMessage),
+		count(&f.received, websocket.PingMessage),
+		count(&f.received, websocket.PongMessage),
+		count(&f.received, websocket.CloseMessage),
+		count(&f.sent, websocket.TextMessage),
+		count(&f.sent, websocket.BinaryMessage),
+		count(&f.sent, websocket.PingMessage),
+		count(&f.sent, websocket.PongMessage),
+		count(&f.sent, websocket.CloseMessage),
+	)
+}
+
+// CountingPingHandler returns a ping handler that counts the ping and the pong
+// reply, replying the same way as the default gorilla handler
+func CountingPingHandler(conn *websocket.Conn, stats *FrameStats) func(string) error {
+	return func(appData string) error {
+		stats.RecordReceived(websocket.PingMessage)
+		err := conn.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(time.Second))
+		if err == websocket.ErrCloseSent {
+			return nil
+		}
+		if err == nil {
+			stats.RecordSent(websocket.PongMessage)
+		}
+		return err
+	}
+}
@@ -0,0 +1,72 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// ID is a JSON-RPC request ID. Relays and wallets send both numeric IDs, often
+// larger than 32 bits, and string IDs, so an ID holds either and marshals back
+// to the form it was parsed from. The zero ID is the number 0; a null ID
+// unmarshals to it. IDs are comparable and can be used as map keys.
+type ID struct {
+	num   int64
+	str   string
+	isStr bool
+}
+
+// NumberID creates a numeric ID
+func NumberID(n int64) ID {
+	return ID{num: n}
+}
+
+// StringID creates a string ID
+func StringID(s string) ID {
+	return ID{str: s, isStr: true}
+}
+
+// Int64 returns the value of a numeric ID. It reports false for string IDs.
+func (id ID) Int64() (int64, bool) {
+	return id.num, !id.isStr
+}
+
+// String returns the ID as text, for logging
+func (id ID) String() string {
+	if id.isStr {
+		return strconv.Quote(id.str)
+	}
+	return strconv.FormatInt(id.num, 10)
+}
+
+// MarshalJSON implements json.Marshaler
+func (id ID) MarshalJSON() ([]byte, error) {
+	if id.isStr {
+		return json.Marshal(id.str)
+	}
+	return []byte(strconv.FormatInt(id.num, 10)), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler. It accepts integers that fit in
+// an int64, strings and null.
+func (id *ID) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		*id = ID{}
+	case len(data) > 0 && data[0] == '"':
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return fmt.Errorf("invalid JSON-RPC id: %w", err)
+		}
+		*id = StringID(s)
+	default:
+		n, err := strconv.ParseInt(string(data), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid JSON-RPC id %s: must be an integer or a string", data)
+		}
+		*id = NumberID(n)
+	}
+	return nil
+}
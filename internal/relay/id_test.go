@@ -0,0 +1,68 @@
+package relay
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestIDRoundTrip(t *testing.T) {
+	tests := []struct {
+		json string
+		want ID
+	}{
+		{json: `1699999999999999`, want: NumberID(1699999999999999)},
+		{json: `"abc-123"`, want: StringID("abc-123")},
+		{json: `0`, want: NumberID(0)},
+		{json: `"42"`, want: StringID("42")},
+	}
+
+	for _, tt := range tests {
+		var id ID
+		if err := json.Unmarshal([]byte(tt.json), &id); err != nil {
+			t.Errorf("unmarshal %s: %v", tt.json, err)
+			continue
+		}
+		if id != tt.want {
+			t.Errorf("unmarshal %s: got %v, want %v", tt.json, id, tt.want)
+		}
+
+		data, err := json.Marshal(id)
+		if err != nil {
+			t.Errorf("marshal %v: %v", id, err)
+			continue
+		}
+		if string(data) != tt.json {
+			t.Errorf("round trip of %s gave %s", tt.json, data)
+		}
+	}
+}
+
+func TestIDUnmarshalNullAndInvalid(t *testing.T) {
+	var id ID
+	if err := json.Unmarshal([]byte(`null`), &id); err != nil || id != (ID{}) {
+		t.Errorf("null: got %v, %v, want the zero ID", id, err)
+	}
+
+	for _, data := range []string{`1.5`, `true`, `{}`, `99999999999999999999`} {
+		if err := json.Unmarshal([]byte(data), &id); err == nil {
+			t.Errorf("%s: expected an error", data)
+		}
+	}
+}
+
+func TestRequestIDsAreEchoedInResponses(t *testing.T) {
+	_, url := startTestRelay(t, nil)
+	client := dialTestRelay(t, url)
+
+	for _, raw := range []string{`1699999999999999`, `"abc-123"`} {
+		request := `{"jsonrpc":"2.0","id":` + raw + `,"method":"subscribe","params":{"topic":"topic"}}`
+		if err := client.conn.WriteMessage(websocket.TextMessage, []byte(request)); err != nil {
+			t.Fatal(err)
+		}
+		if frame := client.read(); string(frame.ID) != raw || frame.Error != nil {
+			t.Errorf("got response %s (error %+v), want id %s", frame.ID, frame.Error, raw)
+		}
+	}
+}
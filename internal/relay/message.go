@@ -1,14 +1,21 @@
 package relay
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"time"
+
+	"github.com/gorilla/websocket"
 )
 
+// JSONRPCVersion is the JSON-RPC protocol version used by default
+const JSONRPCVersion = "2.0"
+
 // JSONRPCRequest represents a JSON-RPC request
 type JSONRPCRequest struct {
-	ID      int    `json:"id"`
+	ID      ID     `json:"id"`
 	JSONRPC string `json:"jsonrpc"`
 	Method  string `json:"method"`
 	Params  any    `json:"params"`
@@ -16,7 +23,7 @@ type JSONRPCRequest struct {
 
 // JSONRPCResponse represents a JSON-RPC response
 type JSONRPCResponse struct {
-	ID      int           `json:"id"`
+	ID      ID            `json:"id"`
 	JSONRPC string        `json:"jsonrpc"`
 	Result  any           `json:"result,omitempty"`
 	Error   *JSONRPCError `json:"error,omitempty"`
@@ -31,6 +38,9 @@ type JSONRPCError struct {
 // SubscribeParams represents the parameters for a subscribe request
 type SubscribeParams struct {
 	Topic string `json:"topic"`
+	// Observer marks the subscription as read-only: it receives messages but
+	// does not count as a subscriber and the client may not publish
+	Observer bool `json:"observer,omitempty"`
 }
 
 // PublishParams represents the parameters for a publish request
@@ -38,6 +48,30 @@ type PublishParams struct {
 	Topic   string `json:"topic"`
 	Message string `json:"message"`
 	TTL     int    `json:"ttl"`
+	// Receipt asks the relay to send an irn_receipt notification back to the
+	// publisher once the message has been delivered
+	Receipt bool `json:"receipt,omitempty"`
+}
+
+// SubscriptionParams represents the parameters for an irn_subscription notification
+type SubscriptionParams struct {
+	ID   string           `json:"id"` // subscription ID returned by subscribe
+	Data SubscriptionData `json:"data"`
+}
+
+// SubscriptionData is a message delivered in an irn_subscription notification
+type SubscriptionData struct {
+	Topic       string `json:"topic"`
+	Message     string `json:"message"`
+	PublishedAt int64  `json:"publishedAt"` // Unix milliseconds
+	Seq         uint64 `json:"seq"`         // increases with each message the relay accepts
+}
+
+// ReceiptParams represents the parameters for an irn_receipt notification
+type ReceiptParams struct {
+	ID        string `json:"id"`
+	Topic     string `json:"topic"`
+	Delivered int    `json:"delivered"`
 }
 
 // UnsubscribeParams represents the parameters for an unsubscribe request
@@ -47,16 +81,35 @@ type UnsubscribeParams struct {
 
 // Message represents a message in the relay server
 type Message struct {
+	ID        string    `json:"id"`
 	Topic     string    `json:"topic"`
 	Payload   string    `json:"payload"`
 	CreatedAt time.Time `json:"created_at"`
 	ExpiresAt time.Time `json:"expires_at"`
+
+	// Seq is stamped by the relay when it accepts the message. Messages on a
+	// topic are delivered in increasing Seq order.
+	Seq uint64 `json:"seq"`
+
+	// receiptConn is the publisher's connection when a delivery receipt was requested
+	receiptConn     *websocket.Conn
+	receiptClientID string
+
+	// skewTolerance delays expiry to absorb clock differences between peers
+	skewTolerance time.Duration
+}
+
+// MessageID returns the relay message id for a payload (hex SHA-256)
+func MessageID(payload string) string {
+	hash := sha256.Sum256([]byte(payload))
+	return hex.EncodeToString(hash[:])
 }
 
 // NewMessage creates a new message
 func NewMessage(topic string, payload string, ttl int) *Message {
 	now := time.Now()
 	return &Message{
+		ID:        MessageID(payload),
 		Topic:     topic,
 		Payload:   payload,
 		CreatedAt: now,
@@ -64,9 +117,19 @@ func NewMessage(topic string, payload string, ttl int) *Message {
 	}
 }
 
-// IsExpired checks if the message is expired
+// IsExpired checks if the message is expired, allowing for the clock skew tolerance
 func (m *Message) IsExpired() bool {
-	return time.Now().After(m.ExpiresAt)
+	return m.isExpiredAt(time.Now())
+}
+
+// isExpiredAt checks if the message is expired at the given time
+func (m *Message) isExpiredAt(now time.Time) bool {
+	return now.After(m.ExpiresAt.Add(m.skewTolerance))
+}
+
+// SetClockSkewTolerance sets how long past ExpiresAt the message is still delivered
+func (m *Message) SetClockSkewTolerance(tolerance time.Duration) {
+	m.skewTolerance = tolerance
 }
 
 // ToJSON converts the message to JSON
@@ -78,30 +141,45 @@ func (m *Message) ToJSON() (string, error) {
 	return string(bytes), nil
 }
 
-// NewJSONRPCRequest creates a new JSON-RPC request
-func NewJSONRPCRequest(id int, method string, params interface{}) *JSONRPCRequest {
+// NewJSONRPCRequest creates a new JSON-RPC 2.0 request
+func NewJSONRPCRequest(id ID, method string, params interface{}) *JSONRPCRequest {
+	return NewJSONRPCRequestWithVersion(JSONRPCVersion, id, method, params)
+}
+
+// NewJSONRPCRequestWithVersion creates a new JSON-RPC request with the given version string
+func NewJSONRPCRequestWithVersion(version string, id ID, method string, params interface{}) *JSONRPCRequest {
 	return &JSONRPCRequest{
 		ID:      id,
-		JSONRPC: "2.0",
+		JSONRPC: version,
 		Method:  method,
 		Params:  params,
 	}
 }
 
-// NewJSONRPCResponse creates a new JSON-RPC response
-func NewJSONRPCResponse(id int, result interface{}) *JSONRPCResponse {
+// NewJSONRPCResponse creates a new JSON-RPC 2.0 response
+func NewJSONRPCResponse(id ID, result interface{}) *JSONRPCResponse {
+	return NewJSONRPCResponseWithVersion(JSONRPCVersion, id, result)
+}
+
+// NewJSONRPCResponseWithVersion creates a new JSON-RPC response with the given version string
+func NewJSONRPCResponseWithVersion(version string, id ID, result interface{}) *JSONRPCResponse {
 	return &JSONRPCResponse{
 		ID:      id,
-		JSONRPC: "2.0",
+		JSONRPC: version,
 		Result:  result,
 	}
 }
 
-// NewJSONRPCErrorResponse creates a new JSON-RPC error response
-func NewJSONRPCErrorResponse(id int, code int, message string) *JSONRPCResponse {
+// NewJSONRPCErrorResponse creates a new JSON-RPC 2.0 error response
+func NewJSONRPCErrorResponse(id ID, code int, message string) *JSONRPCResponse {
+	return NewJSONRPCErrorResponseWithVersion(JSONRPCVersion, id, code, message)
+}
+
+// NewJSONRPCErrorResponseWithVersion creates a new JSON-RPC error response with the given version string
+func NewJSONRPCErrorResponseWithVersion(version string, id ID, code int, message string) *JSONRPCResponse {
 	return &JSONRPCResponse{
 		ID:      id,
-		JSONRPC: "2.0",
+		JSONRPC: version,
 		Error: &JSONRPCError{
 			Code:    code,
 			Message: message,
@@ -0,0 +1,33 @@
+package relay
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMessageIsExpiredAtBoundary(t *testing.T) {
+	message := NewMessage("topic", "payload", 300)
+	expiresAt := message.ExpiresAt
+
+	if message.isExpiredAt(expiresAt.Add(-time.Nanosecond)) {
+		t.Error("expired before ExpiresAt")
+	}
+	if message.isExpiredAt(expiresAt) {
+		t.Error("expired at exactly ExpiresAt")
+	}
+	if !message.isExpiredAt(expiresAt.Add(time.Nanosecond)) {
+		t.Error("not expired after ExpiresAt")
+	}
+
+	// The clock skew tolerance moves the boundary
+	message.SetClockSkewTolerance(time.Minute)
+	if message.isExpiredAt(expiresAt.Add(time.Nanosecond)) {
+		t.Error("expired just after ExpiresAt, within the clock skew tolerance")
+	}
+	if message.isExpiredAt(expiresAt.Add(time.Minute)) {
+		t.Error("expired at the end of the clock skew tolerance")
+	}
+	if !message.isExpiredAt(expiresAt.Add(time.Minute + time.Nanosecond)) {
+		t.Error("not expired after the clock skew tolerance")
+	}
+}
@@ -0,0 +1,77 @@
+package relay
+
+import (
+	"math"
+	"time"
+)
+
+// ErrorCodeRateLimited is the JSON-RPC error code returned for publishes from
+// clients that exceed the publish rate limit
+const ErrorCodeRateLimited = -32005
+
+// tokenBucket is a token bucket rate limiter. It holds up to burst tokens and
+// is refilled at rate tokens per second; each allowed event takes one token.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket creates a full token bucket
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+// allow takes a token if one is available
+func (b *tokenBucket) allow(now time.Time) bool {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// SetPublishRateLimit limits each client to rate publishes per second, with
+// bursts of up to burst publishes. Publishes over the limit are rejected with
+// a "Rate limited" error (code -32005). A non-positive rate disables the
+// limit; a burst below one is raised to the rate, rounded up.
+func (s *RelayServer) SetPublishRateLimit(rate float64, burst int) {
+	if rate > 0 && burst < 1 {
+		burst = int(math.Ceil(rate))
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.publishRate = max(rate, 0)
+	s.publishBurst = burst
+	s.publishLimiters = make(map[string]*tokenBucket)
+}
+
+// allowPublish reports whether a client may publish under the rate limit
+func (s *RelayServer) allowPublish(clientID string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.publishRate <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	limiter, ok := s.publishLimiters[clientID]
+	if !ok {
+		limiter = newTokenBucket(s.publishRate, s.publishBurst, now)
+		s.publishLimiters[clientID] = limiter
+	}
+	return limiter.allow(now)
+}
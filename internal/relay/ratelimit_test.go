@@ -0,0 +1,70 @@
+package relay
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketAllowsBurstThenRefills(t *testing.T) {
+	now := time.Now()
+	bucket := newTokenBucket(2, 3, now)
+
+	for i := range 3 {
+		if !bucket.allow(now) {
+			t.Fatalf("event %d of the burst was refused", i+1)
+		}
+	}
+	if bucket.allow(now) {
+		t.Fatal("event beyond the burst was allowed")
+	}
+
+	// At 2 tokens per second, half a second refills one token
+	now = now.Add(500 * time.Millisecond)
+	if !bucket.allow(now) {
+		t.Error("refilled token was refused")
+	}
+	if bucket.allow(now) {
+		t.Error("more tokens refilled than the rate allows")
+	}
+
+	// A long pause refills no more than the burst
+	now = now.Add(time.Hour)
+	allowed := 0
+	for bucket.allow(now) {
+		allowed++
+	}
+	if allowed != 3 {
+		t.Errorf("allowed %d events after a pause, want the burst of 3", allowed)
+	}
+}
+
+func TestSetPublishRateLimitDefaultsBurstToRate(t *testing.T) {
+	s := NewRelayServer(newTestLogger())
+
+	s.SetPublishRateLimit(2.5, 0)
+	if s.publishBurst != 3 {
+		t.Errorf("got burst %d, want the rate rounded up", s.publishBurst)
+	}
+
+	s.SetPublishRateLimit(-1, 5)
+	if !s.allowPublish("client") {
+		t.Error("publish refused with the limit disabled")
+	}
+}
+
+func TestPublishesOverTheRateLimitAreRejected(t *testing.T) {
+	_, url := startTestRelay(t, func(s *RelayServer) { s.SetPublishRateLimit(0.001, 3) })
+
+	publisher := dialTestRelay(t, url)
+	for range 3 {
+		publisher.publish("topic", "within the burst")
+	}
+	frame := publisher.call("publish", PublishParams{Topic: "topic", Message: "over the limit", TTL: 300})
+	if frame.Error == nil || frame.Error.Code != ErrorCodeRateLimited {
+		t.Fatalf("got error %+v, want code %d", frame.Error, ErrorCodeRateLimited)
+	}
+
+	// Each client has its own limit
+	other := dialTestRelay(t, url)
+	other.publish("topic", "from another client")
+}
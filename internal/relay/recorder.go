@@ -0,0 +1,93 @@
+package relay
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// maxRecordedFrames bounds the frame recorder so a forgotten recording cannot grow without limit
+const maxRecordedFrames = 10000
+
+// FrameRecord describes a JSON-RPC frame exchanged between the relay and a client
+type FrameRecord struct {
+	Time      time.Time       `json:"time"`
+	Direction string          `json:"direction"` // "in" (client to relay) or "out" (relay to client)
+	ClientID  string          `json:"client_id"`
+	Method    string          `json:"method,omitempty"`
+	ID        *ID             `json:"id,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+}
+
+// frameRecorder captures JSON-RPC frames for protocol-conformance tests
+type frameRecorder struct {
+	enabled         bool
+	includePayloads bool
+	frames          []FrameRecord
+	mutex           sync.Mutex
+}
+
+// record captures a frame if recording is enabled
+func (r *frameRecorder) record(direction string, clientID string, data []byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.enabled || len(r.frames) >= maxRecordedFrames {
+		return
+	}
+
+	var frame struct {
+		ID     *ID    `json:"id"`
+		Method string `json:"method"`
+	}
+	// Frames that are not valid JSON-RPC are still recorded, without method or ID
+	_ = json.Unmarshal(data, &frame)
+
+	record := FrameRecord{
+		Time:      time.Now(),
+		Direction: direction,
+		ClientID:  clientID,
+		Method:    frame.Method,
+		ID:        frame.ID,
+	}
+	if r.includePayloads {
+		if json.Valid(data) {
+			record.Payload = append(json.RawMessage(nil), data...)
+		} else {
+			record.Payload, _ = json.Marshal(string(data))
+		}
+	}
+
+	r.frames = append(r.frames, record)
+}
+
+// RecordFrames starts or stops recording JSON-RPC frames. Starting a
+// recording discards previously recorded frames.
+func (s *RelayServer) RecordFrames(enabled bool) {
+	s.recorder.mutex.Lock()
+	defer s.recorder.mutex.Unlock()
+
+	if enabled && !s.recorder.enabled {
+		s.recorder.frames = nil
+	}
+	s.recorder.enabled = enabled
+}
+
+// SetRecordFramePayloads includes full frame payloads in recorded frames.
+// Payloads can contain encrypted session traffic, so this is meant for debug mode only.
+func (s *RelayServer) SetRecordFramePayloads(include bool) {
+	s.recorder.mutex.Lock()
+	defer s.recorder.mutex.Unlock()
+
+	s.recorder.includePayloads = include
+}
+
+// GetRecordedFrames returns a copy of the frames recorded so far, oldest first
+func (s *RelayServer) GetRecordedFrames() []FrameRecord {
+	s.recorder.mutex.Lock()
+	defer s.recorder.mutex.Unlock()
+
+	frames := make([]FrameRecord, len(s.recorder.frames))
+	copy(frames, s.recorder.frames)
+	return frames
+}
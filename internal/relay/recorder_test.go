@@ -0,0 +1,40 @@
+package relay
+
+import "testing"
+
+func idPtr(id ID) *ID {
+	return &id
+}
+
+func TestFrameRecorderIDs(t *testing.T) {
+	tests := []struct {
+		name   string
+		frame  string
+		method string
+		id     *ID
+	}{
+		{name: "numeric id", frame: `{"jsonrpc":"2.0","id":1699999999999999,"method":"subscribe"}`, method: "subscribe", id: idPtr(NumberID(1699999999999999))},
+		{name: "string id", frame: `{"jsonrpc":"2.0","id":"abc-123","method":"publish"}`, method: "publish", id: idPtr(StringID("abc-123"))},
+		{name: "null id", frame: `{"jsonrpc":"2.0","id":null,"result":true}`},
+		{name: "no id", frame: `{"jsonrpc":"2.0","method":"irn_subscription"}`, method: "irn_subscription"},
+		{name: "not JSON", frame: `not json`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &frameRecorder{enabled: true}
+			r.record("in", "client", []byte(tt.frame))
+
+			if len(r.frames) != 1 {
+				t.Fatalf("recorded %d frames, want 1", len(r.frames))
+			}
+			got := r.frames[0]
+			if got.Method != tt.method {
+				t.Errorf("got method %q, want %q", got.Method, tt.method)
+			}
+			if (got.ID == nil) != (tt.id == nil) || got.ID != nil && *got.ID != *tt.id {
+				t.Errorf("got id %v, want %v", got.ID, tt.id)
+			}
+		})
+	}
+}
@@ -1,79 +1,613 @@
 package relay
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"net/http"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/korjavin/wctestapp/internal/metrics"
 )
 
 // RelayServer represents a WebSocket relay server
 type RelayServer struct {
 	upgrader            websocket.Upgrader
 	subscriptionManager *SubscriptionManager
-	messageQueue        chan *Message
-	clients             map[*websocket.Conn]string // connection -> clientID
+	messageQueues       []chan *Message                 // one queue per worker; topics are sharded across them
+	clients             map[*websocket.Conn]*ClientInfo // connection -> client info
 	mutex               sync.RWMutex
 	logger              Logger
+
+	upstream          *upstreamRelay // forwards unknown methods when set
+	upstreamReconnect bool
+	logFrameStats     bool // log per-connection frame counts on disconnect
+
+	clockSkewTolerance time.Duration // delays message expiry to absorb clock differences
+	maxConnectionAge   time.Duration // connections older than this are asked to reconnect; 0 disables
+	maxMessageTTL      time.Duration // longer publish TTLs are clamped to this
+	maxMessageSize     int64         // larger incoming messages close the connection
+
+	maxConnections    int64        // connections beyond this are refused with 503; 0 is unlimited
+	activeConnections atomic.Int64 // accepted connections plus upgrades in progress
+
+	idleTimeout time.Duration // connections without reads or deliveries for this long are closed; 0 disables
+
+	rejectPublishNoSubscribers bool // fail publishes to topics nobody is subscribed to
+
+	// Per-client publish rate limit; a zero rate disables it
+	publishRate     float64
+	publishBurst    int
+	publishLimiters map[string]*tokenBucket // client ID -> limiter; guarded by mutex
+
+	jsonrpcVersion string // version used in responses and, in strict mode, required in requests
+	strictJSONRPC  bool
+
+	authFunc        AuthFunc        // authenticates connections before upgrading; nil allows all
+	allowedOrigins  []string        // browser origins allowed to connect; empty or "*" allows all
+	topicAuthorizer TopicAuthorizer // decides which topics clients may subscribe and publish to
+	deadLetters     DeadLetterSink  // records messages that could not be delivered
+
+	recorder frameRecorder // captures JSON-RPC frames for conformance tests
+
+	startedAt         time.Time    // used to report uptime
+	messagesReceived  atomic.Int64 // messages accepted from publishers
+	messagesDelivered atomic.Int64 // notifications written to subscribers and observers
+	messagesExpired   atomic.Int64 // messages dropped because their TTL passed before delivery
+	messagesDropped   atomic.Int64 // publishes rejected because the message queue was full
+
+	notificationCounter atomic.Int64 // IDs of irn_subscription notifications
+
+	// Sequence numbers stamped on accepted messages, in delivery order per topic
+	lastSequence  uint64
+	sequenceMutex sync.Mutex
+
+	done         chan struct{} // closed on shutdown to stop background goroutines
+	shutdownOnce sync.Once
+	connWg       sync.WaitGroup // tracks connection handlers
+	workerWg     sync.WaitGroup // tracks message workers
+}
+
+// DefaultMaxMessageTTL is the longest TTL a published message may have unless
+// configured otherwise
+const DefaultMaxMessageTTL = 24 * time.Hour
+
+// DefaultMaxMessageSize is the largest message in bytes a client may send
+// unless configured otherwise
+const DefaultMaxMessageSize = 256 << 10
+
+// ErrorCodeNoSubscribers is the JSON-RPC error code returned for publishes to
+// topics without subscribers when the relay rejects them
+const ErrorCodeNoSubscribers = -32001
+
+// ErrorCodeQueueFull is the JSON-RPC error code returned for publishes that
+// arrive while the message queue is full. The message is not relayed, and the
+// client may retry once the workers have caught up.
+const ErrorCodeQueueFull = -32006
+
+// AuthFunc authenticates a WebSocket connection request. It returns the client
+// ID to use for the connection and whether the request is allowed. An empty
+// client ID makes the relay generate a random one. Client IDs should be unique
+// per connection, since subscriptions are tracked by client ID.
+type AuthFunc func(r *http.Request) (clientID string, ok bool)
+
+// ClientInfo holds information about a connected client
+type ClientInfo struct {
+	ID          string    `json:"id"`
+	RemoteAddr  string    `json:"remote_addr"`
+	UserAgent   string    `json:"user_agent"`
+	Origin      string    `json:"origin"`
+	ConnectedAt time.Time `json:"connected_at"`
+
+	frames       *FrameStats
+	writeMutex   *sync.Mutex   // serializes writes from workers and the connection handler
+	lastActivity *atomic.Int64 // unix nanoseconds of the last message read or notification delivered
 }
 
+const (
+	// clientReconcileInterval is how often the clients map is reconciled
+	clientReconcileInterval = 5 * time.Minute
+	// clientProbeTimeout bounds the ping that checks a connection is alive when reconciling
+	clientProbeTimeout = 10 * time.Second
+	// messageQueueSize is the capacity of each worker's message queue
+	messageQueueSize = 100
+	// closeGracePeriod is how long a client may take to answer our close frame
+	closeGracePeriod = 5 * time.Second
+)
+
+// CloseReasonReconnect is the close frame reason sent to clients whose
+// connection reached the maximum connection age. Clients should reconnect
+// right away when they receive it.
+const CloseReasonReconnect = "please reconnect"
+
+// CloseReasonShutdown is the close frame reason sent to clients when the
+// relay server shuts down
+const CloseReasonShutdown = "server shutting down"
+
+// CloseReasonIdle is the close frame reason sent to clients whose connection
+// was idle for longer than the idle timeout
+const CloseReasonIdle = "idle timeout"
+
 // NewRelayServer creates a new relay server
 func NewRelayServer(logger Logger) *RelayServer {
-	return &RelayServer{
+	s := &RelayServer{
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
-			CheckOrigin: func(r *http.Request) bool {
-				return true // Allow all origins for educational purposes
-			},
 		},
 		subscriptionManager: NewSubscriptionManager(logger),
-		messageQueue:        make(chan *Message, 100),
-		clients:             make(map[*websocket.Conn]string),
+		messageQueues:       []chan *Message{make(chan *Message, messageQueueSize)},
+		clients:             make(map[*websocket.Conn]*ClientInfo),
 		logger:              logger,
+		done:                make(chan struct{}),
+		jsonrpcVersion:      JSONRPCVersion,
+		startedAt:           time.Now(),
+		maxMessageTTL:       DefaultMaxMessageTTL,
+		maxMessageSize:      DefaultMaxMessageSize,
+		publishLimiters:     make(map[string]*tokenBucket),
+		topicAuthorizer:     AllowAllTopics{},
+		deadLetters:         DiscardDeadLetters{},
 	}
+	s.upgrader.CheckOrigin = s.checkOrigin
+	return s
+}
+
+// SetAuthFunc sets the callback used to authenticate connections. A nil
+// callback allows all connections.
+func (s *RelayServer) SetAuthFunc(authFunc AuthFunc) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.authFunc = authFunc
+}
+
+// SetAllowedOrigins restricts the browser origins that may open relay
+// connections, such as "https://example.com". An empty list or one containing
+// "*" allows all origins, which is the default. Requests without an Origin
+// header, which come from non-browser clients, are always allowed.
+func (s *RelayServer) SetAllowedOrigins(origins []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.allowedOrigins = origins
+}
+
+// checkOrigin checks the Origin header of an upgrade request against the allowed origins
+func (s *RelayServer) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	s.mutex.RLock()
+	allowed := s.allowedOrigins
+	s.mutex.RUnlock()
+
+	if len(allowed) == 0 || slices.Contains(allowed, "*") {
+		return true
+	}
+	for _, o := range allowed {
+		if strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+
+	s.logger.Warnf("Rejected WebSocket connection from %s with origin %s", r.RemoteAddr, origin)
+	return false
+}
+
+// SetJSONRPCVersion sets the JSON-RPC version string used in responses. In strict
+// mode, requests whose jsonrpc field does not match it are rejected.
+func (s *RelayServer) SetJSONRPCVersion(version string, strict bool) {
+	if version == "" {
+		version = JSONRPCVersion
+	}
+	s.jsonrpcVersion = version
+	s.strictJSONRPC = strict
+}
+
+// SetClockSkewTolerance sets how long past their TTL queued messages are still delivered
+func (s *RelayServer) SetClockSkewTolerance(tolerance time.Duration) {
+	s.clockSkewTolerance = tolerance
+}
+
+// SetMaxConnectionAge sets how long a client connection may stay open before
+// the client is asked to reconnect. Zero disables the limit.
+func (s *RelayServer) SetMaxConnectionAge(age time.Duration) {
+	s.maxConnectionAge = age
+}
+
+// SetMaxMessageTTL sets the longest TTL a published message may have. Longer
+// TTLs are clamped to it. A non-positive value restores DefaultMaxMessageTTL,
+// and values below one second are raised to one second.
+func (s *RelayServer) SetMaxMessageTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = DefaultMaxMessageTTL
+	}
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	s.maxMessageTTL = ttl
+}
+
+// SetMaxMessageSize sets the largest message in bytes a client may send. A
+// client that sends a larger message is disconnected with close code 1009
+// (message too big). A non-positive size restores DefaultMaxMessageSize.
+func (s *RelayServer) SetMaxMessageSize(size int64) {
+	if size <= 0 {
+		size = DefaultMaxMessageSize
+	}
+	s.maxMessageSize = size
+}
+
+// SetMaxConnections sets how many WebSocket connections the relay accepts at
+// once. Further connection attempts are refused with 503 Service Unavailable
+// before upgrading. Zero or a negative value means no limit.
+func (s *RelayServer) SetMaxConnections(max int) {
+	if max < 0 {
+		max = 0
+	}
+	s.maxConnections = int64(max)
+}
+
+// SetIdleTimeout sets how long a connection may go without reading a message
+// from the client or delivering a notification to it before it is closed.
+// Pings and pongs do not count as activity. Zero disables the timeout. It
+// must be set before Start.
+func (s *RelayServer) SetIdleTimeout(timeout time.Duration) {
+	if timeout < 0 {
+		timeout = 0
+	}
+	s.idleTimeout = timeout
+}
+
+// SetRejectPublishNoSubscribers makes publishes to topics without subscribers
+// fail with a "No subscribers" error instead of being accepted and dropped
+func (s *RelayServer) SetRejectPublishNoSubscribers(enabled bool) {
+	s.rejectPublishNoSubscribers = enabled
+}
+
+// SetLogFrameStats enables logging a summary of frame counts when a client disconnects
+func (s *RelayServer) SetLogFrameStats(enabled bool) {
+	s.logFrameStats = enabled
+}
+
+// SetUpstreamRelayURL enables forwarding of unknown JSON-RPC methods to an
+// upstream relay. Subscribe, publish and unsubscribe are always handled locally.
+func (s *RelayServer) SetUpstreamRelayURL(url string) {
+	if url == "" {
+		s.upstream = nil
+		return
+	}
+	s.upstream = newUpstreamRelay(url, s.writeText, s.logger)
+	s.upstream.reconnect = s.upstreamReconnect
+}
+
+// SetUpstreamReconnect enables automatic reconnection with backoff when the
+// upstream relay connection drops
+func (s *RelayServer) SetUpstreamReconnect(enabled bool) {
+	s.upstreamReconnect = enabled
+	if s.upstream != nil {
+		s.upstream.mutex.Lock()
+		s.upstream.reconnect = enabled
+		s.upstream.mutex.Unlock()
+	}
+}
+
+// SetMessageWorkers sets how many goroutines deliver published messages.
+// Topics are sharded across the workers, so messages on one topic are still
+// delivered in order. It must be called before Start.
+func (s *RelayServer) SetMessageWorkers(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	s.messageQueues = make([]chan *Message, workers)
+	for i := range s.messageQueues {
+		s.messageQueues[i] = make(chan *Message, messageQueueSize)
+	}
+}
+
+// messageQueue returns the queue of the worker responsible for a topic
+func (s *RelayServer) messageQueue(topic string) chan *Message {
+	if len(s.messageQueues) == 1 {
+		return s.messageQueues[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(topic))
+	return s.messageQueues[h.Sum32()%uint32(len(s.messageQueues))]
 }
 
 // Start starts the relay server
 func (s *RelayServer) Start() {
-	go s.processMessages()
+	for _, queue := range s.messageQueues {
+		s.workerWg.Add(1)
+		go s.processMessages(queue)
+	}
+	go s.reconcileClientsLoop()
+	if s.idleTimeout > 0 {
+		go s.reapIdleClientsLoop()
+	}
+}
+
+// reconcileClientsLoop periodically reconciles the clients map and prunes
+// expired buffered messages
+func (s *RelayServer) reconcileClientsLoop() {
+	ticker := time.NewTicker(clientReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.reconcileClients()
+			if pruned := s.subscriptionManager.PruneBufferedMessages(); pruned > 0 {
+				s.logger.Infof("Pruned %d expired buffered messages", pruned)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// reapIdleClientsLoop periodically closes idle connections
+func (s *RelayServer) reapIdleClientsLoop() {
+	interval := s.idleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.reapIdleClients()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// reapIdleClients closes the connections that have been idle for longer than
+// the idle timeout. Clients get a going-away close frame and the connection is
+// closed after closeGracePeriod if they do not answer it.
+func (s *RelayServer) reapIdleClients() int {
+	now := time.Now()
+	idle := make(map[*websocket.Conn]*ClientInfo)
+
+	s.mutex.RLock()
+	for conn, client := range s.clients {
+		if now.Sub(time.Unix(0, client.lastActivity.Load())) >= s.idleTimeout {
+			idle[conn] = client
+		}
+	}
+	s.mutex.RUnlock()
+
+	for conn, client := range idle {
+		s.logger.Infof("Closing connection of client %s: idle for longer than %s",
+			client.ID, s.idleTimeout)
+		if err := s.sendCloseFrame(conn, client.frames, CloseReasonIdle); err != nil {
+			s.logger.Debugf("Failed to send close frame to client %s: %v", client.ID, err)
+			conn.Close()
+			continue
+		}
+		time.AfterFunc(closeGracePeriod, func() {
+			conn.Close()
+		})
+	}
+
+	return len(idle)
+}
+
+// touchClient records activity on a connection for the idle timeout
+func (s *RelayServer) touchClient(conn *websocket.Conn) {
+	s.mutex.RLock()
+	client, ok := s.clients[conn]
+	s.mutex.RUnlock()
+
+	if ok {
+		client.lastActivity.Store(time.Now().UnixNano())
+	}
+}
+
+// Shutdown stops accepting connections and the relay server's background
+// goroutines, sends a close frame to every client and waits for the message
+// workers and connection handlers to finish or ctx to be done. Connections
+// whose client does not answer the close frame within closeGracePeriod are
+// closed without waiting further.
+func (s *RelayServer) Shutdown(ctx context.Context) error {
+	s.logger.Info("Shutting down relay server")
+
+	s.shutdownOnce.Do(func() {
+		close(s.done)
+	})
+
+	// Stop forwarding to the upstream relay
+	if s.upstream != nil {
+		s.upstream.Close()
+	}
+
+	// Ask all clients to close their connections
+	s.mutex.RLock()
+	clients := make(map[*websocket.Conn]*ClientInfo, len(s.clients))
+	for conn, client := range s.clients {
+		clients[conn] = client
+	}
+	s.mutex.RUnlock()
+
+	for conn, client := range clients {
+		if err := s.sendCloseFrame(conn, client.frames, CloseReasonShutdown); err != nil {
+			s.logger.Debugf("Failed to send close frame to client %s: %v", client.ID, err)
+			conn.Close()
+		}
+	}
+
+	// Wait for the message workers and connection handlers to finish
+	finished := make(chan struct{})
+	go func() {
+		s.workerWg.Wait()
+		s.connWg.Wait()
+		close(finished)
+	}()
+
+	closeAll := func() {
+		for conn := range clients {
+			conn.Close()
+		}
+	}
+
+	grace := time.NewTimer(closeGracePeriod)
+	defer grace.Stop()
+
+	select {
+	case <-finished:
+	case <-grace.C:
+		s.logger.Warn("Clients did not close their connections in time, closing them")
+		closeAll()
+		select {
+		case <-finished:
+		case <-ctx.Done():
+			return fmt.Errorf("relay shutdown: %w", ctx.Err())
+		}
+	case <-ctx.Done():
+		closeAll()
+		return fmt.Errorf("relay shutdown: %w", ctx.Err())
+	}
+
+	s.logger.Infof("Relay server stopped, closed %d connections", len(clients))
+	return nil
+}
+
+// reconcileClients cross-checks the clients map against the connections that
+// are still alive and removes the entries of dead ones, which a handler that
+// exited without running the cleanup in handleConnection would leave behind.
+// A connection is dead when a ping can no longer be written to it. Live
+// connections stay, with or without subscriptions; the idle timeout closes those.
+func (s *RelayServer) reconcileClients() int {
+	s.mutex.RLock()
+	clients := make(map[*websocket.Conn]*ClientInfo, len(s.clients))
+	for conn, client := range s.clients {
+		clients[conn] = client
+	}
+	s.mutex.RUnlock()
+
+	// Probe the connections outside the lock
+	var orphaned []*websocket.Conn
+	for conn, client := range clients {
+		err := conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(clientProbeTimeout))
+		if err == nil {
+			client.frames.RecordSent(websocket.PingMessage)
+			continue
+		}
+		s.logger.Warnf("Reaping orphaned client %s (connected %s ago): %v",
+			client.ID, time.Since(client.ConnectedAt).Round(time.Second), err)
+		orphaned = append(orphaned, conn)
+	}
+
+	s.mutex.Lock()
+	for _, conn := range orphaned {
+		delete(s.clients, conn)
+	}
+	remaining := len(s.clients)
+	s.mutex.Unlock()
+
+	for _, conn := range orphaned {
+		conn.Close()
+	}
+
+	s.logger.Infof("Client map reconciled: %d entries, %d orphaned removed", remaining, len(orphaned))
+	return len(orphaned)
 }
 
 // HandleWebSocket handles WebSocket connections
 func (s *RelayServer) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	// Log connection attempt with detailed information
 	connectionURL := fmt.Sprintf("%s://%s%s", websocketProtocol(r), r.Host, r.URL.Path)
-	s.logger.Info(fmt.Sprintf("WebSocket connection attempt from %s to %s", r.RemoteAddr, connectionURL))
-	s.logger.Debug(fmt.Sprintf("WebSocket request headers: %+v", r.Header))
+	s.logger.Infof("WebSocket connection attempt from %s to %s", r.RemoteAddr, connectionURL)
+	s.logger.Debugf("WebSocket request headers: %+v", r.Header)
+
+	// Refuse new connections once shutdown has started
+	select {
+	case <-s.done:
+		http.Error(w, "Relay server is shutting down", http.StatusServiceUnavailable)
+		return
+	default:
+	}
+
+	// Authenticate the connection before upgrading
+	s.mutex.RLock()
+	authFunc := s.authFunc
+	s.mutex.RUnlock()
+
+	var clientID string
+	if authFunc != nil {
+		id, ok := authFunc(r)
+		if !ok {
+			s.logger.Warnf("Rejected unauthenticated WebSocket connection from %s", r.RemoteAddr)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		clientID = id
+	}
+
+	// Reserve a connection slot, refusing the connection when all are taken.
+	// The slot is released when the connection handler finishes.
+	if active := s.activeConnections.Add(1); s.maxConnections > 0 && active > s.maxConnections {
+		s.activeConnections.Add(-1)
+		s.logger.Warnf("Rejected WebSocket connection from %s: connection limit of %d reached", r.RemoteAddr, s.maxConnections)
+		http.Error(w, "Too many connections", http.StatusServiceUnavailable)
+		return
+	}
 
 	// Upgrade the HTTP connection to a WebSocket connection
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		s.logger.Error(fmt.Sprintf("Failed to upgrade connection: %v", err))
-		s.logger.Error(fmt.Sprintf("Connection details: URL=%s, RemoteAddr=%s, Headers=%v",
-			connectionURL, r.RemoteAddr, r.Header))
-		http.Error(w, fmt.Sprintf("WebSocket upgrade failed: %v", err), http.StatusInternalServerError)
+		s.activeConnections.Add(-1)
+		s.logger.Errorf("Failed to upgrade connection: %v", err)
+		s.logger.Errorf("Connection details: URL=%s, RemoteAddr=%s, Headers=%v",
+			connectionURL, r.RemoteAddr, r.Header)
+		// Upgrade has already replied with an error status, e.g. 403 for a disallowed origin
 		return
 	}
 
-	// Generate a client ID
-	clientID := uuid.New().String()
+	// Generate a client ID unless authentication provided one
+	if clientID == "" {
+		clientID = uuid.New().String()
+	}
+	frames := &FrameStats{}
+	lastActivity := &atomic.Int64{}
+	lastActivity.Store(time.Now().UnixNano())
 
 	// Add the client to the clients map
 	s.mutex.Lock()
-	s.clients[conn] = clientID
+	s.clients[conn] = &ClientInfo{
+		ID:           clientID,
+		RemoteAddr:   r.RemoteAddr,
+		UserAgent:    r.UserAgent(),
+		Origin:       r.Header.Get("Origin"),
+		ConnectedAt:  time.Now(),
+		frames:       frames,
+		writeMutex:   &sync.Mutex{},
+		lastActivity: lastActivity,
+	}
 	s.mutex.Unlock()
 
-	s.logger.Info(fmt.Sprintf("Client %s connected successfully to %s", clientID, connectionURL))
-	s.logger.Debug(fmt.Sprintf("Connection details: Protocol=%s, RemoteAddr=%s",
-		websocketProtocol(r), r.RemoteAddr))
+	s.logger.Infof("Client %s connected successfully to %s", clientID, connectionURL)
+	s.logger.Infof("Client %s metadata: User-Agent=%q, Origin=%q", clientID, r.UserAgent(), r.Header.Get("Origin"))
+	s.logger.Debugf("Connection details: Protocol=%s, RemoteAddr=%s",
+		websocketProtocol(r), r.RemoteAddr)
 
 	// Handle the connection
-	go s.handleConnection(conn, clientID)
+	metrics.RelayConnections.Inc()
+	s.connWg.Add(1)
+	go s.handleConnection(conn, clientID, frames)
 }
 
 // websocketProtocol determines if the connection is using wss:// or ws:// based on the request
@@ -85,103 +619,212 @@ func websocketProtocol(r *http.Request) string {
 }
 
 // handleConnection handles a WebSocket connection
-func (s *RelayServer) handleConnection(conn *websocket.Conn, clientID string) {
+func (s *RelayServer) handleConnection(conn *websocket.Conn, clientID string, frames *FrameStats) {
+	log := s.logger.With("clientID", clientID)
+	stopPing := make(chan struct{})
+
+	defer s.connWg.Done()
 	defer func() {
+		// Stop the ping ticker
+		close(stopPing)
+
+		if s.logFrameStats {
+			log.Infof("Client %s frame summary: %s", clientID, frames.Summary())
+		}
+
 		// Unsubscribe from all topics
 		s.subscriptionManager.UnsubscribeAll(clientID)
 
-		// Remove the client from the clients map
+		// Forget requests still waiting on the upstream relay
+		if s.upstream != nil {
+			s.upstream.clientDisconnected(conn)
+		}
+
+		// Remove the client from the clients map and drop its rate limiter
 		s.mutex.Lock()
 		delete(s.clients, conn)
+		delete(s.publishLimiters, clientID)
 		s.mutex.Unlock()
 
-		// Close the connection
+		// Close the connection and release its slot
 		conn.Close()
+		s.activeConnections.Add(-1)
+		metrics.RelayConnections.Dec()
 
-		s.logger.Info(fmt.Sprintf("Client %s disconnected", clientID))
+		log.Infof("Client %s disconnected", clientID)
 	}()
 
 	// Set read deadline
 	if err := conn.SetReadDeadline(time.Now().Add(60 * time.Second)); err != nil {
-		s.logger.Error(fmt.Sprintf("Failed to set read deadline: %v", err))
+		log.Errorf("Failed to set read deadline: %v", err)
 		return
 	}
 
+	// Bound the size of incoming messages so a client cannot exhaust memory
+	conn.SetReadLimit(s.maxMessageSize)
+
+	// Count pings from the client and our pong replies
+	conn.SetPingHandler(CountingPingHandler(conn, frames))
+
 	// Set pong handler
 	conn.SetPongHandler(func(string) error {
+		frames.RecordReceived(websocket.PongMessage)
 		if err := conn.SetReadDeadline(time.Now().Add(60 * time.Second)); err != nil {
-			s.logger.Error(fmt.Sprintf("Failed to set read deadline in pong handler: %v", err))
+			log.Errorf("Failed to set read deadline in pong handler: %v", err)
 		}
 		return nil
 	})
 
 	// Start ping ticker
-	go s.pingClient(conn)
+	go s.pingClient(conn, frames, stopPing)
+
+	// Ask the client to reconnect once the connection reaches its maximum age
+	if s.maxConnectionAge > 0 {
+		expiry := time.AfterFunc(s.maxConnectionAge, func() {
+			s.expireConnection(conn, clientID, frames)
+		})
+		defer expiry.Stop()
+	}
 
 	// Log connection details
-	s.logger.Info(fmt.Sprintf("Starting message loop for client %s", clientID))
+	log.Infof("Starting message loop for client %s", clientID)
 	remoteAddr := conn.RemoteAddr().String()
 	localAddr := conn.LocalAddr().String()
-	s.logger.Debug(fmt.Sprintf("WebSocket connection details - Remote: %s, Local: %s", remoteAddr, localAddr))
+	log.Debugf("WebSocket connection details - Remote: %s, Local: %s", remoteAddr, localAddr)
 
 	// Read messages from the client
 	for {
-		_, message, err := conn.ReadMessage()
+		messageType, message, err := conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				s.logger.Error(fmt.Sprintf("Unexpected close error for client %s: %v", clientID, err))
-				s.logger.Debug(fmt.Sprintf("Connection details - Remote: %s, Local: %s", remoteAddr, localAddr))
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				frames.RecordReceived(websocket.CloseMessage)
+			}
+			if errors.Is(err, websocket.ErrReadLimit) {
+				// The websocket library has already sent a 1009 (message too big) close frame
+				log.Warnf("Client %s sent a message larger than %d bytes, closing the connection", clientID, s.maxMessageSize)
+				frames.RecordSent(websocket.CloseMessage)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Errorf("Unexpected close error for client %s: %v", clientID, err)
+				log.Debugf("Connection details - Remote: %s, Local: %s", remoteAddr, localAddr)
 			} else {
-				s.logger.Info(fmt.Sprintf("WebSocket connection closed for client %s: %v", clientID, err))
+				log.Infof("WebSocket connection closed for client %s: %v", clientID, err)
 			}
 			break
 		}
 
+		frames.RecordReceived(messageType)
+		s.touchClient(conn)
+		if messageType == websocket.TextMessage {
+			s.recorder.record("in", clientID, message)
+		}
+
 		// Log the raw message
-		s.logger.Debug(fmt.Sprintf("Received raw message from client %s: %s", clientID, string(message)))
+		log.Debugf("Received raw message from client %s: %s", clientID, string(message))
 
 		// Parse the JSON-RPC request
 		request, err := ParseJSONRPCRequest(string(message))
 		if err != nil {
-			s.logger.Error(fmt.Sprintf("Failed to parse JSON-RPC request from client %s: %v", clientID, err))
-			s.logger.Debug(fmt.Sprintf("Invalid JSON-RPC message: %s", string(message)))
-			s.sendErrorResponse(conn, 0, -32700, "Parse error")
+			log.Errorf("Failed to parse JSON-RPC request from client %s: %v", clientID, err)
+			log.Debugf("Invalid JSON-RPC message: %s", string(message))
+			s.sendErrorResponse(conn, ID{}, -32700, "Parse error")
 			continue
 		}
 
 		// Log the parsed request
 		requestJSON, _ := json.MarshalIndent(request, "", "  ")
-		s.logger.Debug(fmt.Sprintf("Parsed JSON-RPC request from client %s: %s", clientID, string(requestJSON)))
+		log.Debugf("Parsed JSON-RPC request from client %s: %s", clientID, string(requestJSON))
 
 		// Handle the request
 		s.handleRequest(conn, clientID, request)
 	}
 }
 
-// pingClient sends ping messages to the client
-func (s *RelayServer) pingClient(conn *websocket.Conn) {
+// pingClient sends ping messages to the client until stop is closed
+func (s *RelayServer) pingClient(conn *websocket.Conn, frames *FrameStats, stop <-chan struct{}) {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		if err := conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(10*time.Second)); err != nil {
-			s.logger.Error(fmt.Sprintf("Failed to send ping: %v", err))
+	for {
+		select {
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(10*time.Second)); err != nil {
+				s.logger.Errorf("Failed to send ping: %v", err)
+				return
+			}
+			frames.RecordSent(websocket.PingMessage)
+		case <-stop:
 			return
 		}
 	}
 }
 
+// expireConnection sends a close frame asking the client to reconnect and
+// closes the connection if the client does not answer within closeGracePeriod
+func (s *RelayServer) expireConnection(conn *websocket.Conn, clientID string, frames *FrameStats) {
+	s.logger.Infof("Connection of client %s reached the maximum age of %s, asking it to reconnect",
+		clientID, s.maxConnectionAge)
+
+	if err := s.sendCloseFrame(conn, frames, CloseReasonReconnect); err != nil {
+		s.logger.Errorf("Failed to send close frame to client %s: %v", clientID, err)
+		conn.Close()
+		return
+	}
+
+	// The read loop ends when the client echoes the close frame; don't wait forever for it
+	time.AfterFunc(closeGracePeriod, func() {
+		conn.Close()
+	})
+}
+
+// sendCloseFrame sends a going-away close frame with the given reason
+func (s *RelayServer) sendCloseFrame(conn *websocket.Conn, frames *FrameStats, reason string) error {
+	message := websocket.FormatCloseMessage(websocket.CloseGoingAway, reason)
+	if err := conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(10*time.Second)); err != nil {
+		return err
+	}
+	frames.RecordSent(websocket.CloseMessage)
+	return nil
+}
+
 // handleRequest handles a JSON-RPC request
 func (s *RelayServer) handleRequest(conn *websocket.Conn, clientID string, request *JSONRPCRequest) {
+	// Validate the protocol version in strict mode
+	if s.strictJSONRPC && request.JSONRPC != s.jsonrpcVersion {
+		s.logger.Warnf("Rejected request from client %s with jsonrpc %q (expected %q)",
+			clientID, request.JSONRPC, s.jsonrpcVersion)
+		s.sendErrorResponse(conn, request.ID, -32600, "Invalid Request: unsupported jsonrpc version")
+		return
+	}
+
 	switch request.Method {
+	case "":
+		// Clients acknowledge irn_subscription notifications with a response
+		s.logger.Debugf("Client %s acknowledged notification %s", clientID, request.ID)
 	case "subscribe":
 		s.handleSubscribe(conn, clientID, request)
 	case "publish":
 		s.handlePublish(conn, clientID, request)
 	case "unsubscribe":
 		s.handleUnsubscribe(conn, clientID, request)
+	case "irn_batchSubscribe":
+		s.handleBatchSubscribe(conn, clientID, request)
+	case "irn_batchPublish":
+		s.handleBatchPublish(conn, clientID, request)
 	default:
-		s.logger.Warn(fmt.Sprintf("Unknown method: %s", request.Method))
+		if s.upstream != nil {
+			s.logger.Infof("Forwarding unknown method %s from client %s to upstream relay", request.Method, clientID)
+			if err := s.upstream.Forward(conn, clientID, request); err != nil {
+				s.logger.Errorf("Failed to forward %s upstream: %v", request.Method, err)
+				if errors.Is(err, errUpstreamReconnecting) {
+					s.sendErrorResponse(conn, request.ID, -32000, "Upstream relay reconnecting")
+				} else {
+					s.sendErrorResponse(conn, request.ID, -32000, "Upstream relay unavailable")
+				}
+			}
+			return
+		}
+		s.logger.Warnf("Unknown method: %s", request.Method)
 		s.sendErrorResponse(conn, request.ID, -32601, "Method not found")
 	}
 }
@@ -192,30 +835,49 @@ func (s *RelayServer) handleSubscribe(conn *websocket.Conn, clientID string, req
 	var params SubscribeParams
 	paramsBytes, err := json.Marshal(request.Params)
 	if err != nil {
-		s.logger.Error(fmt.Sprintf("Failed to marshal params: %v", err))
+		s.logger.Errorf("Failed to marshal params: %v", err)
 		s.sendErrorResponse(conn, request.ID, -32602, "Invalid params")
 		return
 	}
 
 	err = json.Unmarshal(paramsBytes, &params)
 	if err != nil {
-		s.logger.Error(fmt.Sprintf("Failed to unmarshal params: %v", err))
+		s.logger.Errorf("Failed to unmarshal params: %v", err)
 		s.sendErrorResponse(conn, request.ID, -32602, "Invalid params")
 		return
 	}
 
-	// Subscribe to the topic
-	err = s.subscriptionManager.Subscribe(params.Topic, clientID, conn)
-	if err != nil {
-		s.logger.Error(fmt.Sprintf("Failed to subscribe: %v", err))
-		s.sendErrorResponse(conn, request.ID, -32000, "Subscription error")
+	subscription, rpcErr := s.subscribe(conn, clientID, params)
+	if rpcErr != nil {
+		s.sendErrorResponse(conn, request.ID, rpcErr.Code, rpcErr.Message)
 		return
 	}
 
-	// Send a success response
-	s.sendSuccessResponse(conn, request.ID, true)
+	// Answer with the subscription ID, as IRN relays do
+	s.sendSuccessResponse(conn, request.ID, subscription.ID)
 
-	s.logger.Info(fmt.Sprintf("Client %s subscribed to topic %s", clientID, params.Topic))
+	s.sendReplay(subscription)
+}
+
+// subscribe subscribes a client to a topic and returns the subscription, or
+// the error to send the client if the subscription is refused. The caller must
+// answer the client and then call sendReplay, which makes the subscription ready.
+func (s *RelayServer) subscribe(conn *websocket.Conn, clientID string, params SubscribeParams) (*Subscription, *JSONRPCError) {
+	// Only subscribe to topics the client is allowed to use
+	if !s.getTopicAuthorizer().CanSubscribe(clientID, params.Topic) {
+		s.logger.Warnf("Rejected subscription of client %s to unauthorized topic %s", clientID, params.Topic)
+		return nil, &JSONRPCError{Code: ErrorCodeUnauthorized, Message: "Unauthorized"}
+	}
+
+	// Subscribe to the topic
+	subscription, err := s.subscriptionManager.subscribe(params.Topic, clientID, conn, params.Observer, true)
+	if err != nil {
+		s.logger.Errorf("Failed to subscribe: %v", err)
+		return nil, &JSONRPCError{Code: -32000, Message: "Subscription error"}
+	}
+
+	s.logger.Infof("Client %s subscribed to topic %s", clientID, params.Topic)
+	return subscription, nil
 }
 
 // handlePublish handles a publish request
@@ -224,28 +886,101 @@ func (s *RelayServer) handlePublish(conn *websocket.Conn, clientID string, reque
 	var params PublishParams
 	paramsBytes, err := json.Marshal(request.Params)
 	if err != nil {
-		s.logger.Error(fmt.Sprintf("Failed to marshal params: %v", err))
+		s.logger.Errorf("Failed to marshal params: %v", err)
 		s.sendErrorResponse(conn, request.ID, -32602, "Invalid params")
 		return
 	}
 
 	err = json.Unmarshal(paramsBytes, &params)
 	if err != nil {
-		s.logger.Error(fmt.Sprintf("Failed to unmarshal params: %v", err))
+		s.logger.Errorf("Failed to unmarshal params: %v", err)
 		s.sendErrorResponse(conn, request.ID, -32602, "Invalid params")
 		return
 	}
 
+	if rpcErr := s.publish(conn, clientID, params); rpcErr != nil {
+		s.sendErrorResponse(conn, request.ID, rpcErr.Code, rpcErr.Message)
+		return
+	}
+
+	// Send a success response
+	s.sendSuccessResponse(conn, request.ID, true)
+}
+
+// publish queues a message from a client for delivery, returning the error to
+// send the client if the message is refused
+func (s *RelayServer) publish(conn *websocket.Conn, clientID string, params PublishParams) *JSONRPCError {
+	// Reject publishes over the client's rate limit instead of filling the message queue
+	if !s.allowPublish(clientID) {
+		s.logger.Warnf("Rate limited publish from client %s", clientID)
+		return &JSONRPCError{Code: ErrorCodeRateLimited, Message: "Rate limited"}
+	}
+
+	// Messages must live for at least a second and at most the configured maximum
+	if params.TTL <= 0 {
+		s.logger.Warnf("Rejected publish from client %s to topic %s with TTL %d", clientID, params.Topic, params.TTL)
+		return &JSONRPCError{Code: -32602, Message: "Invalid params: ttl must be positive"}
+	}
+	if maxTTL := int(s.maxMessageTTL / time.Second); params.TTL > maxTTL {
+		s.logger.Infof("Clamping TTL of message from client %s to topic %s from %d to %d seconds",
+			clientID, params.Topic, params.TTL, maxTTL)
+		params.TTL = maxTTL
+	}
+
+	// Only publish to topics the client is allowed to use
+	if !s.getTopicAuthorizer().CanPublish(clientID, params.Topic) {
+		s.logger.Warnf("Rejected publish from client %s to unauthorized topic %s", clientID, params.Topic)
+		return &JSONRPCError{Code: ErrorCodeUnauthorized, Message: "Unauthorized"}
+	}
+
+	// Observers are read-only
+	if s.subscriptionManager.IsObserver(clientID) {
+		s.logger.Warnf("Rejected publish from observer client %s to topic %s", clientID, params.Topic)
+		return &JSONRPCError{Code: -32000, Message: "Observers cannot publish"}
+	}
+
+	// In strict mode, fail fast when nobody would receive the message
+	if s.rejectPublishNoSubscribers && len(s.subscriptionManager.GetSubscribers(params.Topic)) == 0 {
+		s.logger.Warnf("Rejected publish from client %s to topic %s without subscribers", clientID, params.Topic)
+		return &JSONRPCError{Code: ErrorCodeNoSubscribers, Message: "No subscribers"}
+	}
+
 	// Create a new message
 	message := NewMessage(params.Topic, params.Message, params.TTL)
+	message.SetClockSkewTolerance(s.clockSkewTolerance)
+	if params.Receipt {
+		message.receiptConn = conn
+		message.receiptClientID = clientID
+	}
 
-	// Add the message to the queue
-	s.messageQueue <- message
+	select {
+	case <-s.done:
+		return &JSONRPCError{Code: -32000, Message: "Relay server is shutting down"}
+	default:
+	}
 
-	// Send a success response
-	s.sendSuccessResponse(conn, request.ID, true)
+	// Add the message to the queue without blocking the client's read loop.
+	// Each topic has a single FIFO worker queue; stamping the sequence number
+	// and enqueueing under one lock makes the sequence order of concurrent
+	// publishes to a topic the order in which they are delivered.
+	s.sequenceMutex.Lock()
+	s.lastSequence++
+	message.Seq = s.lastSequence
+	select {
+	case s.messageQueue(message.Topic) <- message:
+		s.sequenceMutex.Unlock()
+	default:
+		s.sequenceMutex.Unlock()
+		s.messagesDropped.Add(1)
+		metrics.RelayMessagesDropped.Inc()
+		s.logger.Warnf("Dropped message from client %s to topic %s: message queue is full", clientID, params.Topic)
+		return &JSONRPCError{Code: ErrorCodeQueueFull, Message: "Message queue full"}
+	}
+	s.messagesReceived.Add(1)
+	metrics.RelayMessagesPublished.Inc()
 
-	s.logger.Info(fmt.Sprintf("Client %s published message to topic %s", clientID, params.Topic))
+	s.logger.Infof("Client %s published message to topic %s", clientID, params.Topic)
+	return nil
 }
 
 // handleUnsubscribe handles an unsubscribe request
@@ -254,14 +989,14 @@ func (s *RelayServer) handleUnsubscribe(conn *websocket.Conn, clientID string, r
 	var params UnsubscribeParams
 	paramsBytes, err := json.Marshal(request.Params)
 	if err != nil {
-		s.logger.Error(fmt.Sprintf("Failed to marshal params: %v", err))
+		s.logger.Errorf("Failed to marshal params: %v", err)
 		s.sendErrorResponse(conn, request.ID, -32602, "Invalid params")
 		return
 	}
 
 	err = json.Unmarshal(paramsBytes, &params)
 	if err != nil {
-		s.logger.Error(fmt.Sprintf("Failed to unmarshal params: %v", err))
+		s.logger.Errorf("Failed to unmarshal params: %v", err)
 		s.sendErrorResponse(conn, request.ID, -32602, "Invalid params")
 		return
 	}
@@ -269,7 +1004,7 @@ func (s *RelayServer) handleUnsubscribe(conn *websocket.Conn, clientID string, r
 	// Unsubscribe from the topic
 	err = s.subscriptionManager.Unsubscribe(params.Topic, clientID)
 	if err != nil {
-		s.logger.Error(fmt.Sprintf("Failed to unsubscribe: %v", err))
+		s.logger.Errorf("Failed to unsubscribe: %v", err)
 		s.sendErrorResponse(conn, request.ID, -32000, "Unsubscription error")
 		return
 	}
@@ -277,78 +1012,224 @@ func (s *RelayServer) handleUnsubscribe(conn *websocket.Conn, clientID string, r
 	// Send a success response
 	s.sendSuccessResponse(conn, request.ID, true)
 
-	s.logger.Info(fmt.Sprintf("Client %s unsubscribed from topic %s", clientID, params.Topic))
+	s.logger.Infof("Client %s unsubscribed from topic %s", clientID, params.Topic)
 }
 
-// processMessages processes messages in the queue
-func (s *RelayServer) processMessages() {
-	for message := range s.messageQueue {
+// processMessages processes messages in a worker's queue
+func (s *RelayServer) processMessages(queue <-chan *Message) {
+	defer s.workerWg.Done()
+
+	for {
+		var message *Message
+		select {
+		case message = <-queue:
+		case <-s.done:
+			s.drainQueue(queue)
+			return
+		}
+		log := s.logger.With("topic", message.Topic)
+
 		// Log message received from queue
-		s.logger.Debug(fmt.Sprintf("Processing message from queue for topic %s", message.Topic))
-		s.logger.Debug(fmt.Sprintf("Message payload (first 100 chars): %s", truncateString(message.Payload, 100)))
+		log.Debugf("Processing message from queue for topic %s", message.Topic)
+		log.Debugf("Message payload (first 100 chars): %s", truncateString(message.Payload, 100))
 
 		// Skip expired messages
 		if message.IsExpired() {
+			s.messagesExpired.Add(1)
+			metrics.RelayMessagesExpired.Inc()
 			ttlSeconds := int(message.ExpiresAt.Sub(message.CreatedAt).Seconds())
-			s.logger.Info(fmt.Sprintf("Skipping expired message for topic %s (TTL: %d seconds, Created: %s)",
-				message.Topic, ttlSeconds, message.CreatedAt.Format(time.RFC3339)))
+			log.Infof("Skipping expired message for topic %s (TTL: %d seconds, Created: %s)",
+				message.Topic, ttlSeconds, message.CreatedAt.Format(time.RFC3339))
+			for _, subscriber := range s.subscriptionManager.GetSubscribers(message.Topic) {
+				s.recordDeadLetter(message, subscriber.ClientID, ErrMessageExpired)
+			}
 			continue
 		}
 
-		// Get subscribers for the topic
-		subscribers := s.subscriptionManager.GetSubscribers(message.Topic)
+		// Get subscribers for the topic, buffering the message for the next
+		// subscriber if there is none yet
+		subscribers, buffered := s.subscriptionManager.GetSubscribersOrBuffer(message)
+		if buffered {
+			log.Infof("Buffered message for topic %s until a client subscribes", message.Topic)
+		}
 		if len(subscribers) == 0 {
-			s.logger.Info(fmt.Sprintf("No subscribers for topic %s", message.Topic))
+			log.Infof("No subscribers for topic %s", message.Topic)
+			s.sendReceipt(message, 0)
 			continue
 		}
 
-		s.logger.Debug(fmt.Sprintf("Found %d subscribers for topic %s", len(subscribers), message.Topic))
-		for i, subscriber := range subscribers {
-			s.logger.Debug(fmt.Sprintf("Subscriber %d: ClientID=%s", i+1, subscriber.ClientID))
+		// Observers receive messages but are not counted as subscribers
+		observerCount := 0
+		for _, subscriber := range subscribers {
+			if subscriber.Observer {
+				observerCount++
+			}
 		}
-
-		// Create a JSON-RPC notification
-		notification := map[string]interface{}{
-			"jsonrpc": "2.0",
-			"method":  "message",
-			"params": map[string]interface{}{
-				"topic":   message.Topic,
-				"message": message.Payload,
-			},
+		if observerCount == len(subscribers) {
+			log.Infof("No subscribers for topic %s (%d observers)", message.Topic, observerCount)
 		}
 
-		// Marshal the notification
-		notificationBytes, err := json.Marshal(notification)
-		if err != nil {
-			s.logger.Error(fmt.Sprintf("Failed to marshal notification: %v", err))
-			s.logger.Debug(fmt.Sprintf("Failed notification content: %+v", notification))
-			continue
+		log.Debugf("Found %d subscribers and %d observers for topic %s",
+			len(subscribers)-observerCount, observerCount, message.Topic)
+		for i, subscriber := range subscribers {
+			log.Debugf("Subscriber %d: ClientID=%s, Observer=%t", i+1, subscriber.ClientID, subscriber.Observer)
 		}
 
-		// Log the notification being sent
-		notificationJSON, _ := json.MarshalIndent(notification, "", "  ")
-		s.logger.Debug(fmt.Sprintf("Sending notification: %s", string(notificationJSON)))
-
-		// Send the notification to all subscribers
+		// Send an irn_subscription notification to all subscribers
 		successCount := 0
+		observerSuccessCount := 0
+		receiptCount := 0
 		for _, subscriber := range subscribers {
-			err := subscriber.Connection.WriteMessage(websocket.TextMessage, notificationBytes)
+			// Wait until a new subscriber has its subscribe response and replay
+			<-subscriber.ready
+
+			notificationBytes, err := s.subscriptionNotification(subscriber, message).ToJSON()
+			if err != nil {
+				log.Errorf("Failed to marshal notification for client %s: %v", subscriber.ClientID, err)
+				continue
+			}
+			log.Debugf("Sending notification: %s", notificationBytes)
+
+			err = s.writeText(subscriber.Connection, []byte(notificationBytes))
 			if err != nil {
-				s.logger.Error(fmt.Sprintf("Failed to send notification to client %s: %v", subscriber.ClientID, err))
-				s.logger.Debug(fmt.Sprintf("Connection details for failed client: %s", subscriber.Connection.RemoteAddr()))
+				log.Errorf("Failed to send notification to client %s: %v", subscriber.ClientID, err)
+				log.Debugf("Connection details for failed client: %s", subscriber.Connection.RemoteAddr())
+				s.recordDeadLetter(message, subscriber.ClientID, fmt.Errorf("failed to send notification: %w", err))
 				// Unsubscribe the client if we can't send messages
 				s.subscriptionManager.UnsubscribeAll(subscriber.ClientID)
 			} else {
-				successCount++
-				s.logger.Debug(fmt.Sprintf("Successfully sent notification to client %s", subscriber.ClientID))
+				s.touchClient(subscriber.Connection)
+				if subscriber.Observer {
+					observerSuccessCount++
+				} else {
+					successCount++
+					// The publisher's own subscription does not count as a delivery
+					if subscriber.ClientID != message.receiptClientID {
+						receiptCount++
+					}
+				}
+				log.Debugf("Successfully sent notification to client %s", subscriber.ClientID)
 			}
 		}
 
-		s.logger.Info(fmt.Sprintf("Sent message to %d/%d subscribers for topic %s",
-			successCount, len(subscribers), message.Topic))
+		s.messagesDelivered.Add(int64(successCount + observerSuccessCount))
+		metrics.RelayMessagesDelivered.Add(float64(successCount + observerSuccessCount))
+		log.Infof("Sent message to %d/%d subscribers and %d/%d observers for topic %s",
+			successCount, len(subscribers)-observerCount, observerSuccessCount, observerCount, message.Topic)
+
+		s.sendReceipt(message, receiptCount)
 	}
 }
 
+// subscriptionNotification creates the irn_subscription notification that
+// delivers a message to a subscriber. Like IRN relays, it is a request the
+// client acknowledges with a response carrying the same ID.
+func (s *RelayServer) subscriptionNotification(subscriber *Subscription, message *Message) *JSONRPCRequest {
+	return NewJSONRPCRequestWithVersion(s.jsonrpcVersion, NumberID(s.notificationCounter.Add(1)), "irn_subscription", SubscriptionParams{
+		ID: subscriber.ID,
+		Data: SubscriptionData{
+			Topic:       message.Topic,
+			Message:     message.Payload,
+			PublishedAt: message.CreatedAt.UnixMilli(),
+			Seq:         message.Seq,
+		},
+	})
+}
+
+// sendReplay sends a new subscription the messages buffered for its topic
+// before it was made, then lets the workers deliver newer messages to it. It
+// is called once the client has been answered, so notifications always follow
+// the subscribe response.
+func (s *RelayServer) sendReplay(subscriber *Subscription) {
+	defer subscriber.markReady()
+
+	messages := subscriber.replay
+	subscriber.replay = nil
+	if len(messages) == 0 {
+		return
+	}
+
+	// Keep the messages for the next subscriber if this one is already gone
+	if !s.subscriptionManager.isSubscribed(subscriber) {
+		s.logger.Warnf("Client %s unsubscribed from topic %s before %d buffered messages were delivered, keeping them",
+			subscriber.ClientID, subscriber.Topic, len(messages))
+		s.subscriptionManager.restoreBufferedMessages(subscriber.Topic, messages)
+		return
+	}
+
+	delivered := 0
+	for _, message := range messages {
+		notificationBytes, err := s.subscriptionNotification(subscriber, message).ToJSON()
+		if err != nil {
+			s.logger.Errorf("Failed to marshal notification for client %s: %v", subscriber.ClientID, err)
+			continue
+		}
+
+		if err := s.writeText(subscriber.Connection, []byte(notificationBytes)); err != nil {
+			s.logger.Errorf("Failed to send buffered message to client %s: %v", subscriber.ClientID, err)
+			s.recordDeadLetter(message, subscriber.ClientID, fmt.Errorf("failed to send notification: %w", err))
+			continue
+		}
+		delivered++
+	}
+
+	if delivered > 0 {
+		s.touchClient(subscriber.Connection)
+		s.messagesDelivered.Add(int64(delivered))
+		metrics.RelayMessagesDelivered.Add(float64(delivered))
+	}
+	s.logger.Infof("Sent %d/%d buffered messages for topic %s to client %s",
+		delivered, len(messages), subscriber.Topic, subscriber.ClientID)
+}
+
+// drainQueue discards the messages left in a queue when the relay shuts down
+func (s *RelayServer) drainQueue(queue <-chan *Message) {
+	dropped := 0
+	for {
+		select {
+		case <-queue:
+			dropped++
+		default:
+			if dropped > 0 {
+				s.logger.Warnf("Dropped %d undelivered messages on shutdown", dropped)
+			}
+			return
+		}
+	}
+}
+
+// sendReceipt sends an irn_receipt notification to the publisher of a message
+// that requested a delivery receipt
+func (s *RelayServer) sendReceipt(message *Message, delivered int) {
+	if message.receiptConn == nil {
+		return
+	}
+
+	notification := map[string]interface{}{
+		"jsonrpc": s.jsonrpcVersion,
+		"method":  "irn_receipt",
+		"params": ReceiptParams{
+			ID:        message.ID,
+			Topic:     message.Topic,
+			Delivered: delivered,
+		},
+	}
+
+	notificationBytes, err := json.Marshal(notification)
+	if err != nil {
+		s.logger.Errorf("Failed to marshal receipt: %v", err)
+		return
+	}
+
+	if err := s.writeText(message.receiptConn, notificationBytes); err != nil {
+		s.logger.Errorf("Failed to send receipt to client %s: %v", message.receiptClientID, err)
+		return
+	}
+
+	s.logger.Debugf("Sent receipt for message %s on topic %s to client %s (delivered: %d)",
+		message.ID, message.Topic, message.receiptClientID, delivered)
+}
+
 // truncateString truncates a string to the specified length and adds "..." if truncated
 func truncateString(s string, maxLength int) string {
 	if len(s) <= maxLength {
@@ -357,71 +1238,134 @@ func truncateString(s string, maxLength int) string {
 	return s[:maxLength] + "..."
 }
 
-// sendSuccessResponse sends a success response
-func (s *RelayServer) sendSuccessResponse(conn *websocket.Conn, id int, result interface{}) {
-	// Get client ID for logging
+// writeText sends a text frame to a client, counting it in the client's frame stats
+func (s *RelayServer) writeText(conn *websocket.Conn, data []byte) error {
 	s.mutex.RLock()
-	clientID, ok := s.clients[conn]
+	client, ok := s.clients[conn]
 	s.mutex.RUnlock()
 
-	if !ok {
-		clientID = "unknown"
+	// Connections support one concurrent writer, and several workers may deliver to the same client
+	if ok {
+		client.writeMutex.Lock()
+	}
+	err := conn.WriteMessage(websocket.TextMessage, data)
+	if ok {
+		client.writeMutex.Unlock()
+	}
+	if err != nil {
+		return err
 	}
 
-	response := NewJSONRPCResponse(id, result)
+	if ok {
+		client.frames.RecordSent(websocket.TextMessage)
+		s.recorder.record("out", client.ID, data)
+	} else {
+		s.recorder.record("out", "unknown", data)
+	}
+	return nil
+}
+
+// clientID returns the client ID for a connection, or "unknown"
+func (s *RelayServer) clientID(conn *websocket.Conn) string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if client, ok := s.clients[conn]; ok {
+		return client.ID
+	}
+	return "unknown"
+}
+
+// sendSuccessResponse sends a success response
+func (s *RelayServer) sendSuccessResponse(conn *websocket.Conn, id ID, result interface{}) {
+	// Get client ID for logging
+	clientID := s.clientID(conn)
+
+	response := NewJSONRPCResponseWithVersion(s.jsonrpcVersion, id, result)
 	responseJSON, err := response.ToJSON()
 	if err != nil {
-		s.logger.Error(fmt.Sprintf("Failed to marshal response for client %s: %v", clientID, err))
+		s.logger.Errorf("Failed to marshal response for client %s: %v", clientID, err)
 		return
 	}
 
 	// Log the response being sent
-	s.logger.Debug(fmt.Sprintf("Sending success response to client %s: %s", clientID, responseJSON))
+	s.logger.Debugf("Sending success response to client %s: %s", clientID, responseJSON)
 
-	err = conn.WriteMessage(websocket.TextMessage, []byte(responseJSON))
+	err = s.writeText(conn, []byte(responseJSON))
 	if err != nil {
-		s.logger.Error(fmt.Sprintf("Failed to send response to client %s: %v", clientID, err))
-		s.logger.Debug(fmt.Sprintf("Failed response content: %s", responseJSON))
+		s.logger.Errorf("Failed to send response to client %s: %v", clientID, err)
+		s.logger.Debugf("Failed response content: %s", responseJSON)
 	} else {
-		s.logger.Info(fmt.Sprintf("Successfully sent response to client %s for request ID %d", clientID, id))
+		s.logger.Infof("Successfully sent response to client %s for request ID %s", clientID, id)
 	}
 }
 
 // sendErrorResponse sends an error response
-func (s *RelayServer) sendErrorResponse(conn *websocket.Conn, id int, code int, message string) {
+func (s *RelayServer) sendErrorResponse(conn *websocket.Conn, id ID, code int, message string) {
 	// Get client ID for logging
-	s.mutex.RLock()
-	clientID, ok := s.clients[conn]
-	s.mutex.RUnlock()
-
-	if !ok {
-		clientID = "unknown"
-	}
+	clientID := s.clientID(conn)
 
-	response := NewJSONRPCErrorResponse(id, code, message)
+	response := NewJSONRPCErrorResponseWithVersion(s.jsonrpcVersion, id, code, message)
 	responseJSON, err := response.ToJSON()
 	if err != nil {
-		s.logger.Error(fmt.Sprintf("Failed to marshal error response for client %s: %v", clientID, err))
+		s.logger.Errorf("Failed to marshal error response for client %s: %v", clientID, err)
 		return
 	}
 
 	// Log the error response being sent
-	s.logger.Debug(fmt.Sprintf("Sending error response to client %s: %s", clientID, responseJSON))
+	s.logger.Debugf("Sending error response to client %s: %s", clientID, responseJSON)
 
-	err = conn.WriteMessage(websocket.TextMessage, []byte(responseJSON))
+	err = s.writeText(conn, []byte(responseJSON))
 	if err != nil {
-		s.logger.Error(fmt.Sprintf("Failed to send error response to client %s: %v", clientID, err))
-		s.logger.Debug(fmt.Sprintf("Failed error response content: %s", responseJSON))
+		s.logger.Errorf("Failed to send error response to client %s: %v", clientID, err)
+		s.logger.Debugf("Failed error response content: %s", responseJSON)
 	} else {
-		s.logger.Info(fmt.Sprintf("Sent error response to client %s: code=%d, message=%s", clientID, code, message))
+		s.logger.Infof("Sent error response to client %s: code=%d, message=%s", clientID, code, message)
 	}
 }
 
+// GetClients returns information about all connected clients
+func (s *RelayServer) GetClients() []ClientInfo {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	clients := make([]ClientInfo, 0, len(s.clients))
+	for _, client := range s.clients {
+		clients = append(clients, *client)
+	}
+
+	return clients
+}
+
+// GetTopicSubscriptionCounts returns the number of subscriptions per topic
+func (s *RelayServer) GetTopicSubscriptionCounts() map[string]int {
+	return s.subscriptionManager.GetTopicSubscriptionCounts()
+}
+
 // GetStats returns statistics about the relay server
 func (s *RelayServer) GetStats() map[string]interface{} {
-	return map[string]interface{}{
-		"clients":       s.subscriptionManager.GetClientCount(),
-		"subscriptions": s.subscriptionManager.GetSubscriptionCount(),
-		"topics":        s.subscriptionManager.GetTopicCount(),
+	s.mutex.RLock()
+	connections := len(s.clients)
+	s.mutex.RUnlock()
+
+	stats := map[string]interface{}{
+		"connections":    connections,
+		"clients":        s.subscriptionManager.GetClientCount(),
+		"subscriptions":  s.subscriptionManager.GetSubscriptionCount(),
+		"topics":         s.subscriptionManager.GetTopicCount(),
+		"uptime_seconds": int64(time.Since(s.startedAt).Seconds()),
+		"messages": map[string]int64{
+			"received":  s.messagesReceived.Load(),
+			"delivered": s.messagesDelivered.Load(),
+			"expired":   s.messagesExpired.Load(),
+			"dropped":   s.messagesDropped.Load(),
+			"buffered":  int64(s.subscriptionManager.GetBufferedMessageCount()),
+		},
 	}
+
+	if s.upstream != nil {
+		stats["upstream"] = s.upstream.Status()
+	}
+
+	return stats
 }
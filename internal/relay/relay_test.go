@@ -0,0 +1,753 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/korjavin/wctestapp/internal/logger"
+)
+
+// testTimeout bounds every wait on the relay in tests
+const testTimeout = 5 * time.Second
+
+// newTestLogger returns a logger that only reports errors
+func newTestLogger() *logger.Logger {
+	return logger.NewLogger(logger.ErrorLevel, "test", logger.TextFormat)
+}
+
+// startTestRelay starts a relay server behind a test HTTP server and returns
+// it with its WebSocket URL. configure, if not nil, is called before Start.
+// The relay is shut down when the test ends.
+func startTestRelay(t testing.TB, configure func(*RelayServer)) (*RelayServer, string) {
+	t.Helper()
+
+	s := NewRelayServer(newTestLogger())
+	if configure != nil {
+		configure(s)
+	}
+	s.Start()
+
+	server := httptest.NewServer(http.HandlerFunc(s.HandleWebSocket))
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			t.Errorf("relay shutdown: %v", err)
+		}
+		server.Close()
+	})
+
+	return s, "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// testFrame is a JSON-RPC frame received from the relay
+type testFrame struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	Result  json.RawMessage `json:"result"`
+	Error   *JSONRPCError   `json:"error"`
+}
+
+// testClient is a WebSocket client of a test relay
+type testClient struct {
+	t      testing.TB
+	conn   *websocket.Conn
+	nextID int64
+}
+
+// dialTestRelay connects a client to a test relay. The connection is closed
+// when the test ends, before the relay shuts down.
+func dialTestRelay(t testing.TB, url string) *testClient {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial relay: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return &testClient{t: t, conn: conn}
+}
+
+// send sends a request without waiting for its response and returns its ID
+func (c *testClient) send(method string, params any) int64 {
+	c.t.Helper()
+
+	c.nextID++
+	request := NewJSONRPCRequest(NumberID(c.nextID), method, params)
+	if err := c.conn.WriteJSON(request); err != nil {
+		c.t.Fatalf("send %s: %v", method, err)
+	}
+	return c.nextID
+}
+
+// read reads the next frame from the relay
+func (c *testClient) read() testFrame {
+	c.t.Helper()
+
+	c.conn.SetReadDeadline(time.Now().Add(testTimeout))
+	var frame testFrame
+	if err := c.conn.ReadJSON(&frame); err != nil {
+		c.t.Fatalf("read frame: %v", err)
+	}
+	return frame
+}
+
+// call sends a request and returns its response, which must be the next frame
+func (c *testClient) call(method string, params any) testFrame {
+	c.t.Helper()
+
+	id := c.send(method, params)
+	frame := c.read()
+	if frame.Method != "" || string(frame.ID) != string(mustMarshal(c.t, id)) {
+		c.t.Fatalf("%s: expected response %d, got %+v", method, id, frame)
+	}
+	return frame
+}
+
+// subscribe subscribes to a topic and fails the test if the relay refuses
+func (c *testClient) subscribe(topic string) {
+	c.t.Helper()
+
+	if frame := c.call("subscribe", SubscribeParams{Topic: topic}); frame.Error != nil {
+		c.t.Fatalf("subscribe %s: %+v", topic, frame.Error)
+	}
+}
+
+// publish publishes a message and fails the test if the relay refuses it
+func (c *testClient) publish(topic string, message string) {
+	c.t.Helper()
+
+	if frame := c.call("publish", PublishParams{Topic: topic, Message: message, TTL: 300}); frame.Error != nil {
+		c.t.Fatalf("publish to %s: %+v", topic, frame.Error)
+	}
+}
+
+// notification reads the next frame, which must be an irn_subscription notification
+func (c *testClient) notification() SubscriptionData {
+	c.t.Helper()
+
+	frame := c.read()
+	if frame.Method != "irn_subscription" {
+		c.t.Fatalf("expected irn_subscription notification, got %+v", frame)
+	}
+	var params SubscriptionParams
+	if err := json.Unmarshal(frame.Params, &params); err != nil {
+		c.t.Fatalf("decode notification: %v", err)
+	}
+	return params.Data
+}
+
+// expectNoFrame fails the test if the relay sends a frame within wait
+func (c *testClient) expectNoFrame(wait time.Duration) {
+	c.t.Helper()
+
+	c.conn.SetReadDeadline(time.Now().Add(wait))
+	var frame testFrame
+	if err := c.conn.ReadJSON(&frame); err == nil {
+		c.t.Fatalf("expected no frame, got %+v", frame)
+	}
+}
+
+// mustMarshal marshals v to JSON
+func mustMarshal(t testing.TB, v any) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return data
+}
+
+// waitFor polls cond until it holds, failing the test after testTimeout
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPublishThenSubscribeDeliversBufferedMessages(t *testing.T) {
+	s, url := startTestRelay(t, nil)
+
+	publisher := dialTestRelay(t, url)
+	for _, message := range []string{"one", "two", "three"} {
+		publisher.publish("topic", message)
+	}
+	waitFor(t, "messages to be buffered", func() bool {
+		return s.subscriptionManager.GetBufferedMessageCount() == 3
+	})
+
+	subscriber := dialTestRelay(t, url)
+	subscriber.subscribe("topic")
+	for _, want := range []string{"one", "two", "three"} {
+		if got := subscriber.notification(); got.Message != want || got.Topic != "topic" {
+			t.Fatalf("got message %q on %q, want %q on topic", got.Message, got.Topic, want)
+		}
+	}
+
+	if count := s.subscriptionManager.GetBufferedMessageCount(); count != 0 {
+		t.Errorf("%d messages still buffered after delivery", count)
+	}
+
+	// Buffered messages go to the first subscriber only
+	late := dialTestRelay(t, url)
+	late.subscribe("topic")
+	late.expectNoFrame(100 * time.Millisecond)
+}
+
+func TestBatchSubscribeDeliversBufferedMessages(t *testing.T) {
+	_, url := startTestRelay(t, nil)
+
+	publisher := dialTestRelay(t, url)
+	publisher.publish("a", "for a")
+	publisher.publish("b", "for b")
+
+	subscriber := dialTestRelay(t, url)
+	frame := subscriber.call("irn_batchSubscribe", BatchSubscribeParams{Topics: []string{"a", "b"}})
+	if frame.Error != nil {
+		t.Fatalf("batch subscribe: %+v", frame.Error)
+	}
+
+	got := map[string]string{}
+	for range 2 {
+		data := subscriber.notification()
+		got[data.Topic] = data.Message
+	}
+	if got["a"] != "for a" || got["b"] != "for b" {
+		t.Errorf("got %v, want the message of each topic", got)
+	}
+}
+
+func TestObserverDoesNotTakeBufferedMessages(t *testing.T) {
+	s, url := startTestRelay(t, nil)
+
+	publisher := dialTestRelay(t, url)
+	publisher.publish("topic", "hello")
+	waitFor(t, "the message to be buffered", func() bool {
+		return s.subscriptionManager.GetBufferedMessageCount() == 1
+	})
+
+	observer := dialTestRelay(t, url)
+	if frame := observer.call("subscribe", SubscribeParams{Topic: "topic", Observer: true}); frame.Error != nil {
+		t.Fatalf("observe: %+v", frame.Error)
+	}
+	observer.expectNoFrame(100 * time.Millisecond)
+
+	subscriber := dialTestRelay(t, url)
+	subscriber.subscribe("topic")
+	if got := subscriber.notification(); got.Message != "hello" {
+		t.Errorf("got %q, want the buffered message", got.Message)
+	}
+}
+
+// clientCount returns the number of entries in the relay's clients map
+func clientCount(s *RelayServer) int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return len(s.clients)
+}
+
+// deadConn returns a server-side WebSocket connection that has been closed
+func deadConn(t *testing.T) *websocket.Conn {
+	t.Helper()
+
+	upgraded := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		upgraded <- conn
+	}))
+	defer server.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer client.Close()
+
+	conn := <-upgraded
+	conn.Close()
+	return conn
+}
+
+func TestReconcileClientsReapsOrphanedEntry(t *testing.T) {
+	s, url := startTestRelay(t, nil)
+
+	live := dialTestRelay(t, url)
+	waitFor(t, "the client to connect", func() bool { return clientCount(s) == 1 })
+
+	// An entry whose handler is gone without having removed it
+	orphan := deadConn(t)
+	s.mutex.Lock()
+	s.clients[orphan] = &ClientInfo{ID: "orphan", ConnectedAt: time.Now(), frames: &FrameStats{}}
+	s.mutex.Unlock()
+
+	if reaped := s.reconcileClients(); reaped != 1 {
+		t.Errorf("reaped %d entries, want 1", reaped)
+	}
+	s.mutex.RLock()
+	_, ok := s.clients[orphan]
+	s.mutex.RUnlock()
+	if ok {
+		t.Error("orphaned entry is still in the clients map")
+	}
+
+	// The live client is kept and still works
+	if count := clientCount(s); count != 1 {
+		t.Errorf("%d clients left, want the live one", count)
+	}
+	live.subscribe("topic")
+}
+
+func TestReconcileClientsKeepsLiveClientsWithoutSubscriptions(t *testing.T) {
+	s, url := startTestRelay(t, nil)
+
+	publisher := dialTestRelay(t, url)
+	waitFor(t, "the client to connect", func() bool { return clientCount(s) == 1 })
+
+	// A publish-only client connected long ago is not an orphan
+	s.mutex.Lock()
+	for _, client := range s.clients {
+		client.ConnectedAt = time.Now().Add(-time.Hour)
+	}
+	s.mutex.Unlock()
+
+	if reaped := s.reconcileClients(); reaped != 0 {
+		t.Errorf("reaped %d live clients", reaped)
+	}
+	publisher.publish("topic", "still connected")
+}
+
+// expectInOrder reads count notifications and checks that the messages of each
+// topic arrive numbered from zero upwards with increasing sequence numbers
+func expectInOrder(t *testing.T, c *testClient, count int) {
+	t.Helper()
+
+	next := map[string]int{}
+	lastSeq := map[string]uint64{}
+	for range count {
+		data := c.notification()
+		if want := fmt.Sprint(next[data.Topic]); data.Message != want {
+			t.Fatalf("topic %s: got message %s, want %s", data.Topic, data.Message, want)
+		}
+		if data.Seq <= lastSeq[data.Topic] {
+			t.Fatalf("topic %s: sequence %d after %d", data.Topic, data.Seq, lastSeq[data.Topic])
+		}
+		next[data.Topic]++
+		lastSeq[data.Topic] = data.Seq
+	}
+}
+
+func TestMessagesAreDeliveredInOrderPerTopic(t *testing.T) {
+	// Few enough messages that a worker's queue cannot fill up even if all
+	// topics hash to it
+	const messagesPerTopic = 15
+	topics := []string{"a", "b", "c", "d", "e", "f"}
+
+	_, url := startTestRelay(t, func(s *RelayServer) { s.SetMessageWorkers(4) })
+
+	subscriber := dialTestRelay(t, url)
+	for _, topic := range topics {
+		subscriber.subscribe(topic)
+	}
+
+	// Interleave the topics without waiting for responses, so that every
+	// worker has messages queued at once
+	publisher := dialTestRelay(t, url)
+	for n := range messagesPerTopic {
+		for _, topic := range topics {
+			publisher.send("publish", PublishParams{Topic: topic, Message: fmt.Sprint(n), TTL: 300})
+		}
+	}
+
+	expectInOrder(t, subscriber, messagesPerTopic*len(topics))
+}
+
+func TestBufferedAndLiveMessagesAreDeliveredInOrder(t *testing.T) {
+	// However the publishes race the subscription, no more than the buffer
+	// cap can be buffered, so none are dropped
+	const buffered = MaxBufferedMessagesPerTopic / 4
+	const live = MaxBufferedMessagesPerTopic - buffered
+	topics := []string{"a", "b", "c", "d"}
+
+	_, url := startTestRelay(t, func(s *RelayServer) { s.SetMessageWorkers(4) })
+
+	// Publish the first messages before anyone subscribes, then keep
+	// publishing while the subscriptions are made, so that some messages are
+	// replayed from the buffer and the rest delivered by the workers
+	publisher := dialTestRelay(t, url)
+	for n := range buffered {
+		for _, topic := range topics {
+			publisher.publish(topic, fmt.Sprint(n))
+		}
+	}
+
+	subscriber := dialTestRelay(t, url)
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for n := buffered; n < buffered+live; n++ {
+			for _, topic := range topics {
+				publisher.send("publish", PublishParams{Topic: topic, Message: fmt.Sprint(n), TTL: 300})
+			}
+		}
+	}()
+	if frame := subscriber.call("irn_batchSubscribe", BatchSubscribeParams{Topics: topics}); frame.Error != nil {
+		t.Fatalf("batch subscribe: %+v", frame.Error)
+	}
+	<-published
+
+	expectInOrder(t, subscriber, (buffered+live)*len(topics))
+}
+
+func BenchmarkMessageDelivery(b *testing.B) {
+	const topics = 16
+	// maxInFlight bounds the undelivered messages so that no queue fills up
+	const maxInFlight = 64
+
+	for _, workers := range []int{1, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			_, url := startTestRelay(b, func(s *RelayServer) { s.SetMessageWorkers(workers) })
+
+			// One subscriber per topic, so deliveries on different topics do
+			// not contend for one connection
+			inFlight := make(chan struct{}, maxInFlight)
+			names := make([]string, topics)
+			for i := range names {
+				names[i] = fmt.Sprintf("topic-%d", i)
+				subscriber := dialTestRelay(b, url)
+				subscriber.subscribe(names[i])
+				go func() {
+					for {
+						if _, _, err := subscriber.conn.ReadMessage(); err != nil {
+							return
+						}
+						<-inFlight
+					}
+				}()
+			}
+
+			// Discard the publish responses so the relay never blocks writing them
+			publisher := dialTestRelay(b, url)
+			go func() {
+				for {
+					if _, _, err := publisher.conn.ReadMessage(); err != nil {
+						return
+					}
+				}
+			}()
+
+			b.ResetTimer()
+			for i := range b.N {
+				inFlight <- struct{}{}
+				publisher.send("publish", PublishParams{Topic: names[i%topics], Message: "payload", TTL: 300})
+			}
+			// The channel can only be filled once every message was delivered
+			for range maxInFlight {
+				inFlight <- struct{}{}
+			}
+		})
+	}
+}
+
+func TestConnectionsBeyondMaxConnectionsAreRefused(t *testing.T) {
+	s, url := startTestRelay(t, func(s *RelayServer) { s.SetMaxConnections(2) })
+
+	first := dialTestRelay(t, url)
+	dialTestRelay(t, url)
+
+	conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil {
+		conn.Close()
+		t.Fatal("third connection was accepted")
+	}
+	if resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("got response %v, want 503 Service Unavailable", resp)
+	}
+
+	// Closing a connection frees its slot
+	first.conn.Close()
+	waitFor(t, "the connection slot to be released", func() bool { return s.activeConnections.Load() == 1 })
+	dialTestRelay(t, url).subscribe("topic")
+}
+
+func TestPublishTTLValidation(t *testing.T) {
+	s, url := startTestRelay(t, func(s *RelayServer) { s.SetMaxMessageTTL(time.Hour) })
+	publisher := dialTestRelay(t, url)
+
+	for _, ttl := range []int{0, -1} {
+		frame := publisher.call("publish", PublishParams{Topic: "topic", Message: "m", TTL: ttl})
+		if frame.Error == nil || frame.Error.Code != -32602 {
+			t.Errorf("TTL %d: got error %+v, want invalid params", ttl, frame.Error)
+		}
+	}
+	if count := s.subscriptionManager.GetBufferedMessageCount(); count != 0 {
+		t.Fatalf("%d messages with invalid TTLs were accepted", count)
+	}
+
+	// A TTL over the maximum is clamped, not rejected
+	frame := publisher.call("publish", PublishParams{Topic: "topic", Message: "m", TTL: 7 * 24 * 3600})
+	if frame.Error != nil {
+		t.Fatalf("TTL over the maximum: %+v", frame.Error)
+	}
+	waitFor(t, "the message to be buffered", func() bool {
+		return s.subscriptionManager.GetBufferedMessageCount() == 1
+	})
+
+	s.subscriptionManager.mutex.RLock()
+	message := s.subscriptionManager.buffered["topic"][0]
+	s.subscriptionManager.mutex.RUnlock()
+	if ttl := message.ExpiresAt.Sub(message.CreatedAt); ttl != time.Hour {
+		t.Errorf("message TTL is %s, want it clamped to 1h", ttl)
+	}
+}
+
+func TestSetMaxMessageTTL(t *testing.T) {
+	s := NewRelayServer(newTestLogger())
+
+	for _, tt := range []struct {
+		ttl, want time.Duration
+	}{
+		{ttl: time.Hour, want: time.Hour},
+		{ttl: 0, want: DefaultMaxMessageTTL},
+		{ttl: -time.Minute, want: DefaultMaxMessageTTL},
+		{ttl: time.Millisecond, want: time.Second},
+	} {
+		s.SetMaxMessageTTL(tt.ttl)
+		if s.maxMessageTTL != tt.want {
+			t.Errorf("SetMaxMessageTTL(%s): got %s, want %s", tt.ttl, s.maxMessageTTL, tt.want)
+		}
+	}
+}
+
+// expectClose reads until the relay closes the connection and returns the close error
+func (c *testClient) expectClose() *websocket.CloseError {
+	c.t.Helper()
+
+	c.conn.SetReadDeadline(time.Now().Add(testTimeout))
+	for {
+		_, _, err := c.conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) {
+			c.t.Fatalf("expected a close frame, got %v", err)
+		}
+		return closeErr
+	}
+}
+
+func TestConnectionsAreAskedToReconnectAtMaxAge(t *testing.T) {
+	s, url := startTestRelay(t, func(s *RelayServer) { s.SetMaxConnectionAge(100 * time.Millisecond) })
+	client := dialTestRelay(t, url)
+
+	closeErr := client.expectClose()
+	if closeErr.Code != websocket.CloseGoingAway || closeErr.Text != CloseReasonReconnect {
+		t.Errorf("got close %d %q, want %d %q", closeErr.Code, closeErr.Text, websocket.CloseGoingAway, CloseReasonReconnect)
+	}
+	waitFor(t, "the connection to be removed", func() bool { return clientCount(s) == 0 })
+}
+
+func TestPublishWithoutSubscribers(t *testing.T) {
+	t.Run("accepted by default", func(t *testing.T) {
+		s, url := startTestRelay(t, nil)
+		publisher := dialTestRelay(t, url)
+
+		publisher.publish("topic", "nobody listens")
+		waitFor(t, "the message to be buffered", func() bool {
+			return s.subscriptionManager.GetBufferedMessageCount() == 1
+		})
+	})
+
+	t.Run("rejected when enabled", func(t *testing.T) {
+		_, url := startTestRelay(t, func(s *RelayServer) { s.SetRejectPublishNoSubscribers(true) })
+		publisher := dialTestRelay(t, url)
+
+		frame := publisher.call("publish", PublishParams{Topic: "topic", Message: "nobody listens", TTL: 300})
+		if frame.Error == nil || frame.Error.Code != ErrorCodeNoSubscribers {
+			t.Fatalf("got error %+v, want code %d", frame.Error, ErrorCodeNoSubscribers)
+		}
+
+		subscriber := dialTestRelay(t, url)
+		subscriber.subscribe("topic")
+		publisher.publish("topic", "someone listens")
+		if got := subscriber.notification(); got.Message != "someone listens" {
+			t.Errorf("got %q, want the published message", got.Message)
+		}
+	})
+}
+
+func TestPublishIsRejectedWhenTheQueueIsFull(t *testing.T) {
+	// Without Start no worker drains the queue
+	s := NewRelayServer(newTestLogger())
+	server := httptest.NewServer(http.HandlerFunc(s.HandleWebSocket))
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+		s.Shutdown(ctx)
+		server.Close()
+	})
+	publisher := dialTestRelay(t, "ws"+strings.TrimPrefix(server.URL, "http"))
+
+	for range messageQueueSize {
+		publisher.publish("topic", "queued")
+	}
+
+	// The client is answered right away instead of its read loop blocking
+	frame := publisher.call("publish", PublishParams{Topic: "topic", Message: "no room", TTL: 300})
+	if frame.Error == nil || frame.Error.Code != ErrorCodeQueueFull {
+		t.Fatalf("got error %+v, want code %d", frame.Error, ErrorCodeQueueFull)
+	}
+	if dropped := s.messagesDropped.Load(); dropped != 1 {
+		t.Errorf("counted %d dropped messages, want 1", dropped)
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{name: "no list", origin: "https://evil.example", want: true},
+		{name: "wildcard", allowed: []string{"https://app.example", "*"}, origin: "https://evil.example", want: true},
+		{name: "exact match", allowed: []string{"https://app.example"}, origin: "https://app.example", want: true},
+		{name: "case and trailing slash", allowed: []string{"HTTPS://App.Example/"}, origin: "https://app.example", want: true},
+		{name: "not listed", allowed: []string{"https://app.example"}, origin: "https://evil.example", want: false},
+		{name: "other scheme", allowed: []string{"https://app.example"}, origin: "http://app.example", want: false},
+		{name: "no origin header", allowed: []string{"https://app.example"}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewRelayServer(newTestLogger())
+			s.SetAllowedOrigins(tt.allowed)
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if got := s.checkOrigin(r); got != tt.want {
+				t.Errorf("checkOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDisallowedOriginIsRefused(t *testing.T) {
+	_, url := startTestRelay(t, func(s *RelayServer) { s.SetAllowedOrigins([]string{"https://app.example"}) })
+
+	header := http.Header{"Origin": {"https://evil.example"}}
+	conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+	if err == nil {
+		conn.Close()
+		t.Fatal("connection from a disallowed origin was accepted")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("got response %v, want 403 Forbidden", resp)
+	}
+
+	header.Set("Origin", "https://app.example")
+	conn, _, err = websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("connection from an allowed origin: %v", err)
+	}
+	conn.Close()
+}
+
+// sendRaw sends a raw text frame to the relay and returns the next frame
+func (c *testClient) sendRaw(message string) testFrame {
+	c.t.Helper()
+
+	if err := c.conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+		c.t.Fatalf("send: %v", err)
+	}
+	return c.read()
+}
+
+func TestJSONRPCVersionValidation(t *testing.T) {
+	const request = `{"jsonrpc":"1.0","id":1,"method":"subscribe","params":{"topic":"topic"}}`
+
+	t.Run("lenient", func(t *testing.T) {
+		_, url := startTestRelay(t, nil)
+		client := dialTestRelay(t, url)
+
+		frame := client.sendRaw(request)
+		if frame.Error != nil {
+			t.Fatalf("request with another version was rejected: %+v", frame.Error)
+		}
+		if frame.JSONRPC != JSONRPCVersion {
+			t.Errorf("response has version %q, want %q", frame.JSONRPC, JSONRPCVersion)
+		}
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		_, url := startTestRelay(t, func(s *RelayServer) { s.SetJSONRPCVersion("2.0", true) })
+		client := dialTestRelay(t, url)
+
+		frame := client.sendRaw(request)
+		if frame.Error == nil || frame.Error.Code != -32600 {
+			t.Fatalf("got error %+v, want invalid request", frame.Error)
+		}
+		if frame := client.sendRaw(strings.Replace(request, `"1.0"`, `"2.0"`, 1)); frame.Error != nil {
+			t.Errorf("request with the configured version was rejected: %+v", frame.Error)
+		}
+	})
+
+	t.Run("custom version", func(t *testing.T) {
+		_, url := startTestRelay(t, func(s *RelayServer) { s.SetJSONRPCVersion("1.0", true) })
+		client := dialTestRelay(t, url)
+
+		frame := client.sendRaw(request)
+		if frame.Error != nil || frame.JSONRPC != "1.0" {
+			t.Errorf("got version %q and error %+v, want a 1.0 response", frame.JSONRPC, frame.Error)
+		}
+	})
+}
+
+func TestAuthFunc(t *testing.T) {
+	s, url := startTestRelay(t, func(s *RelayServer) {
+		s.SetAuthFunc(func(r *http.Request) (string, bool) {
+			token := r.URL.Query().Get("token")
+			return "client-" + token, token != ""
+		})
+	})
+
+	conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil {
+		conn.Close()
+		t.Fatal("unauthenticated connection was accepted")
+	}
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("got response %v, want 401 Unauthorized", resp)
+	}
+
+	client := dialTestRelay(t, url+"?token=alice")
+	client.subscribe("topic")
+	if clients := s.GetClients(); len(clients) != 1 || clients[0].ID != "client-alice" {
+		t.Errorf("got clients %+v, want the ID from the auth func", clients)
+	}
+}
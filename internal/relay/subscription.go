@@ -1,6 +1,8 @@
 package relay
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"sync"
 	"time"
@@ -8,20 +10,42 @@ import (
 	"slices"
 
 	"github.com/gorilla/websocket"
+	"github.com/korjavin/wctestapp/internal/logger"
 )
 
 // Subscription represents a subscription to a topic
 type Subscription struct {
+	ID         string // sent to the client in irn_subscription notifications
 	Topic      string
 	ClientID   string
 	Connection *websocket.Conn
+	Observer   bool // read-only subscription that is not counted as a subscriber
 	CreatedAt  time.Time
+
+	// ready is closed once the subscriber has been sent the subscribe response
+	// and the messages buffered before it subscribed. Workers wait for it, so
+	// that newer messages are not delivered ahead of them.
+	ready     chan struct{}
+	readyOnce sync.Once
+	replay    []*Message // buffered messages to send before ready is closed
+}
+
+// markReady lets workers deliver messages to the subscription
+func (sub *Subscription) markReady() {
+	sub.readyOnce.Do(func() {
+		close(sub.ready)
+	})
 }
 
+// MaxBufferedMessagesPerTopic is how many messages published to a topic
+// without subscribers are kept for the next subscriber. Older ones are dropped.
+const MaxBufferedMessagesPerTopic = 32
+
 // SubscriptionManager manages subscriptions to topics
 type SubscriptionManager struct {
 	subscriptions map[string][]*Subscription // topic -> subscriptions
 	clients       map[string]*websocket.Conn // clientID -> connection
+	buffered      map[string][]*Message      // topic -> messages published while it had no subscribers
 	mutex         sync.RWMutex
 	logger        Logger
 }
@@ -31,29 +55,66 @@ func NewSubscriptionManager(logger Logger) *SubscriptionManager {
 	return &SubscriptionManager{
 		subscriptions: make(map[string][]*Subscription),
 		clients:       make(map[string]*websocket.Conn),
+		buffered:      make(map[string][]*Message),
 		logger:        logger,
 	}
 }
 
-// Subscribe subscribes a client to a topic
-func (m *SubscriptionManager) Subscribe(topic string, clientID string, conn *websocket.Conn) error {
+// Subscribe subscribes a client to a topic and returns the subscription ID
+func (m *SubscriptionManager) Subscribe(topic string, clientID string, conn *websocket.Conn) (string, error) {
+	sub, err := m.subscribe(topic, clientID, conn, false, false)
+	if err != nil {
+		return "", err
+	}
+	return sub.ID, nil
+}
+
+// SubscribeObserver subscribes a client to a topic as a read-only observer and
+// returns the subscription ID
+func (m *SubscriptionManager) SubscribeObserver(topic string, clientID string, conn *websocket.Conn) (string, error) {
+	sub, err := m.subscribe(topic, clientID, conn, true, false)
+	if err != nil {
+		return "", err
+	}
+	return sub.ID, nil
+}
+
+// subscribe subscribes a client to a topic. A client subscribing again to the
+// same topic gets its existing subscription. With pending set, a new
+// subscription is not ready until markReady is called, and a regular one takes
+// the messages buffered for its topic, all atomically with respect to
+// GetSubscribersOrBuffer.
+func (m *SubscriptionManager) subscribe(topic string, clientID string, conn *websocket.Conn, observer bool, pending bool) (*Subscription, error) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
 	// Check if the client is already subscribed to the topic
 	for _, sub := range m.subscriptions[topic] {
 		if sub.ClientID == clientID {
-			m.logger.Info(fmt.Sprintf("Client %s is already subscribed to topic %s", clientID, topic))
-			return nil
+			m.logger.Infof("Client %s is already subscribed to topic %s", clientID, topic)
+			return sub, nil
 		}
 	}
 
+	id, err := newSubscriptionID()
+	if err != nil {
+		return nil, err
+	}
+
 	// Create a new subscription
 	subscription := &Subscription{
+		ID:         id,
 		Topic:      topic,
 		ClientID:   clientID,
 		Connection: conn,
+		Observer:   observer,
 		CreatedAt:  time.Now(),
+		ready:      make(chan struct{}),
+	}
+	if !pending {
+		subscription.markReady()
+	} else if !observer {
+		subscription.replay = m.takeBufferedMessages(topic)
 	}
 
 	// Add the subscription to the topic
@@ -62,8 +123,22 @@ func (m *SubscriptionManager) Subscribe(topic string, clientID string, conn *web
 	// Add the client connection
 	m.clients[clientID] = conn
 
-	m.logger.Info(fmt.Sprintf("Client %s subscribed to topic %s", clientID, topic))
-	return nil
+	if observer {
+		m.logger.Infof("Client %s subscribed to topic %s as observer", clientID, topic)
+	} else {
+		m.logger.Infof("Client %s subscribed to topic %s", clientID, topic)
+	}
+	return subscription, nil
+}
+
+// newSubscriptionID generates a random 32-byte hex subscription ID, the
+// format IRN relays use
+func newSubscriptionID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate subscription ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
 }
 
 // Unsubscribe unsubscribes a client from a topic
@@ -74,7 +149,7 @@ func (m *SubscriptionManager) Unsubscribe(topic string, clientID string) error {
 	// Find the subscription
 	subs, ok := m.subscriptions[topic]
 	if !ok {
-		m.logger.Warn(fmt.Sprintf("Topic %s not found for unsubscribe", topic))
+		m.logger.Warnf("Topic %s not found for unsubscribe", topic)
 		return nil
 	}
 
@@ -82,19 +157,19 @@ func (m *SubscriptionManager) Unsubscribe(topic string, clientID string) error {
 	for i, sub := range subs {
 		if sub.ClientID == clientID {
 			m.subscriptions[topic] = slices.Delete(subs, i, i+1)
-			m.logger.Info(fmt.Sprintf("Client %s unsubscribed from topic %s", clientID, topic))
+			m.logger.Infof("Client %s unsubscribed from topic %s", clientID, topic)
 
 			// If there are no more subscriptions for this topic, remove the topic
 			if len(m.subscriptions[topic]) == 0 {
 				delete(m.subscriptions, topic)
-				m.logger.Info(fmt.Sprintf("Removed empty topic %s", topic))
+				m.logger.Infof("Removed empty topic %s", topic)
 			}
 
 			return nil
 		}
 	}
 
-	m.logger.Warn(fmt.Sprintf("Client %s not found in topic %s for unsubscribe", clientID, topic))
+	m.logger.Warnf("Client %s not found in topic %s for unsubscribe", clientID, topic)
 	return nil
 }
 
@@ -119,12 +194,12 @@ func (m *SubscriptionManager) UnsubscribeAll(clientID string) {
 		for i, sub := range m.subscriptions[topic] {
 			if sub.ClientID == clientID {
 				m.subscriptions[topic] = append(m.subscriptions[topic][:i], m.subscriptions[topic][i+1:]...)
-				m.logger.Info(fmt.Sprintf("Client %s unsubscribed from topic %s", clientID, topic))
+				m.logger.Infof("Client %s unsubscribed from topic %s", clientID, topic)
 
 				// If there are no more subscriptions for this topic, remove the topic
 				if len(m.subscriptions[topic]) == 0 {
 					delete(m.subscriptions, topic)
-					m.logger.Info(fmt.Sprintf("Removed empty topic %s", topic))
+					m.logger.Infof("Removed empty topic %s", topic)
 				}
 
 				break
@@ -134,15 +209,136 @@ func (m *SubscriptionManager) UnsubscribeAll(clientID string) {
 
 	// Remove the client connection
 	delete(m.clients, clientID)
-	m.logger.Info(fmt.Sprintf("Removed client %s", clientID))
+	m.logger.Infof("Removed client %s", clientID)
 }
 
-// GetSubscribers returns all subscribers to a topic
+// GetSubscribers returns all subscribers to a topic. The slice is a copy, so
+// callers may iterate it while subscriptions change.
 func (m *SubscriptionManager) GetSubscribers(topic string) []*Subscription {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
-	return m.subscriptions[topic]
+	return slices.Clone(m.subscriptions[topic])
+}
+
+// GetSubscribersOrBuffer returns all subscribers to a message's topic. If none
+// of them is a regular subscriber, the message is also buffered for the next
+// one, so that a peer subscribing shortly after a publish still receives it.
+// Checking and buffering happen atomically with respect to Subscribe, so a
+// message is either delivered to or buffered for every new subscriber.
+func (m *SubscriptionManager) GetSubscribersOrBuffer(message *Message) (subscribers []*Subscription, buffered bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	subscribers = slices.Clone(m.subscriptions[message.Topic])
+	for _, sub := range subscribers {
+		if !sub.Observer {
+			return subscribers, false
+		}
+	}
+
+	// Drop expired messages, then the oldest ones beyond the cap
+	now := time.Now()
+	messages := slices.DeleteFunc(m.buffered[message.Topic], func(buffered *Message) bool {
+		return buffered.isExpiredAt(now)
+	})
+	messages = append(messages, message)
+	if len(messages) > MaxBufferedMessagesPerTopic {
+		dropped := len(messages) - MaxBufferedMessagesPerTopic
+		m.logger.Warnf("Dropped %d buffered messages for topic %s over the limit of %d",
+			dropped, message.Topic, MaxBufferedMessagesPerTopic)
+		messages = slices.Delete(messages, 0, dropped)
+	}
+	m.buffered[message.Topic] = messages
+
+	return subscribers, true
+}
+
+// takeBufferedMessages removes and returns the unexpired messages buffered
+// for a topic, oldest first. The caller must hold the write lock.
+func (m *SubscriptionManager) takeBufferedMessages(topic string) []*Message {
+	messages := m.buffered[topic]
+	delete(m.buffered, topic)
+
+	now := time.Now()
+	return slices.DeleteFunc(messages, func(message *Message) bool {
+		return message.isExpiredAt(now)
+	})
+}
+
+// restoreBufferedMessages puts back messages taken for a subscriber that went
+// away before they could be delivered, ahead of any buffered since
+func (m *SubscriptionManager) restoreBufferedMessages(topic string, messages []*Message) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	messages = append(slices.Clone(messages), m.buffered[topic]...)
+	if len(messages) > MaxBufferedMessagesPerTopic {
+		messages = slices.Delete(messages, 0, len(messages)-MaxBufferedMessagesPerTopic)
+	}
+	m.buffered[topic] = messages
+}
+
+// PruneBufferedMessages drops expired buffered messages, and the buffers of
+// topics left empty, and returns the number of messages dropped. Buffers are
+// otherwise only pruned when a message is published to their topic.
+func (m *SubscriptionManager) PruneBufferedMessages() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	pruned := 0
+	for topic, messages := range m.buffered {
+		remaining := slices.DeleteFunc(messages, func(message *Message) bool {
+			return message.isExpiredAt(now)
+		})
+		pruned += len(messages) - len(remaining)
+		if len(remaining) == 0 {
+			delete(m.buffered, topic)
+		} else {
+			m.buffered[topic] = remaining
+		}
+	}
+
+	return pruned
+}
+
+// isSubscribed checks if a subscription is still active
+func (m *SubscriptionManager) isSubscribed(subscription *Subscription) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return slices.Contains(m.subscriptions[subscription.Topic], subscription)
+}
+
+// GetBufferedMessageCount returns the number of buffered messages, including
+// expired ones that have not been pruned yet
+func (m *SubscriptionManager) GetBufferedMessageCount() int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	count := 0
+	for _, messages := range m.buffered {
+		count += len(messages)
+	}
+
+	return count
+}
+
+// IsObserver checks if a client holds any observer subscription
+func (m *SubscriptionManager) IsObserver(clientID string) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for _, subs := range m.subscriptions {
+		for _, sub := range subs {
+			if sub.ClientID == clientID && sub.Observer {
+				return true
+			}
+		}
+	}
+
+	return false
 }
 
 // GetTopics returns all topics
@@ -166,6 +362,15 @@ func (m *SubscriptionManager) GetClientConnection(clientID string) *websocket.Co
 	return m.clients[clientID]
 }
 
+// HasClient checks if a client has subscribed to any topic
+func (m *SubscriptionManager) HasClient(clientID string) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	_, ok := m.clients[clientID]
+	return ok
+}
+
 // GetClientCount returns the number of clients
 func (m *SubscriptionManager) GetClientCount() int {
 	m.mutex.RLock()
@@ -187,6 +392,19 @@ func (m *SubscriptionManager) GetSubscriptionCount() int {
 	return count
 }
 
+// GetTopicSubscriptionCounts returns the number of subscriptions per topic
+func (m *SubscriptionManager) GetTopicSubscriptionCounts() map[string]int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	counts := make(map[string]int, len(m.subscriptions))
+	for topic, subs := range m.subscriptions {
+		counts[topic] = len(subs)
+	}
+
+	return counts
+}
+
 // GetTopicCount returns the number of topics
 func (m *SubscriptionManager) GetTopicCount() int {
 	m.mutex.RLock()
@@ -196,9 +414,4 @@ func (m *SubscriptionManager) GetTopicCount() int {
 }
 
 // Logger interface for logging
-type Logger interface {
-	Debug(msg string)
-	Info(msg string)
-	Warn(msg string)
-	Error(msg string)
-}
+type Logger = logger.FieldLogger
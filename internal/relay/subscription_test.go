@@ -0,0 +1,115 @@
+package relay
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// bufferMessage buffers a message on a topic without subscribers
+func bufferMessage(t *testing.T, m *SubscriptionManager, message *Message) {
+	t.Helper()
+
+	if _, buffered := m.GetSubscribersOrBuffer(message); !buffered {
+		t.Fatalf("message on %s was not buffered", message.Topic)
+	}
+}
+
+func TestBufferedMessagesAreCappedPerTopic(t *testing.T) {
+	m := NewSubscriptionManager(newTestLogger())
+
+	for i := range MaxBufferedMessagesPerTopic + 5 {
+		bufferMessage(t, m, NewMessage("topic", fmt.Sprint(i), 300))
+	}
+
+	messages := m.takeBufferedMessages("topic")
+	if len(messages) != MaxBufferedMessagesPerTopic {
+		t.Fatalf("got %d buffered messages, want %d", len(messages), MaxBufferedMessagesPerTopic)
+	}
+	if messages[0].Payload != "5" {
+		t.Errorf("oldest kept message is %q, want the oldest ones dropped first", messages[0].Payload)
+	}
+}
+
+func TestExpiredBufferedMessagesAreNotReplayed(t *testing.T) {
+	m := NewSubscriptionManager(newTestLogger())
+
+	expired := NewMessage("topic", "expired", 1)
+	expired.ExpiresAt = time.Now().Add(-time.Second)
+	bufferMessage(t, m, expired)
+	bufferMessage(t, m, NewMessage("topic", "fresh", 300))
+
+	subscription, err := m.subscribe("topic", "client", nil, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subscription.replay) != 1 || subscription.replay[0].Payload != "fresh" {
+		t.Errorf("got replay %v, want only the unexpired message", subscription.replay)
+	}
+}
+
+func TestBufferedMessagesWithinClockSkewToleranceAreReplayed(t *testing.T) {
+	m := NewSubscriptionManager(newTestLogger())
+
+	// Both messages are past their TTL, but only one beyond the tolerance
+	tolerated := NewMessage("topic", "tolerated", 1)
+	tolerated.ExpiresAt = time.Now().Add(-time.Second)
+	tolerated.SetClockSkewTolerance(time.Minute)
+	bufferMessage(t, m, tolerated)
+	expired := NewMessage("topic", "expired", 1)
+	expired.ExpiresAt = time.Now().Add(-2 * time.Minute)
+	expired.SetClockSkewTolerance(time.Minute)
+	bufferMessage(t, m, expired)
+
+	subscription, err := m.subscribe("topic", "client", nil, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subscription.replay) != 1 || subscription.replay[0].Payload != "tolerated" {
+		t.Errorf("got replay %v, want only the message within the tolerance", subscription.replay)
+	}
+}
+
+func TestPruneBufferedMessagesRemovesExpiredTopics(t *testing.T) {
+	m := NewSubscriptionManager(newTestLogger())
+
+	expired := NewMessage("stale", "expired", 1)
+	expired.ExpiresAt = time.Now().Add(-time.Second)
+	bufferMessage(t, m, expired)
+	bufferMessage(t, m, NewMessage("live", "fresh", 300))
+
+	if pruned := m.PruneBufferedMessages(); pruned != 1 {
+		t.Errorf("pruned %d messages, want 1", pruned)
+	}
+	if _, ok := m.buffered["stale"]; ok {
+		t.Error("topic with only expired messages was kept")
+	}
+	if len(m.buffered["live"]) != 1 {
+		t.Error("unexpired message was pruned")
+	}
+}
+
+func TestReplayIsKeptWhenSubscriberLeaves(t *testing.T) {
+	s := NewRelayServer(newTestLogger())
+	m := s.subscriptionManager
+
+	bufferMessage(t, m, NewMessage("topic", "hello", 300))
+	subscription, err := m.subscribe("topic", "client", nil, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The client goes away before the replay is sent
+	m.UnsubscribeAll("client")
+	s.sendReplay(subscription)
+
+	select {
+	case <-subscription.ready:
+	default:
+		t.Error("subscription was not made ready")
+	}
+	messages := m.takeBufferedMessages("topic")
+	if len(messages) != 1 || messages[0].Payload != "hello" {
+		t.Errorf("got buffered %v, want the message kept for the next subscriber", messages)
+	}
+}
@@ -0,0 +1,423 @@
+package relay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// upstreamRelay forwards JSON-RPC requests the relay does not handle itself to
+// an upstream relay over a single persistent connection. Request IDs are
+// rewritten so requests from different clients cannot collide upstream, and
+// responses are routed back to the originating client with the original ID.
+type upstreamRelay struct {
+	url     string
+	write   func(conn *websocket.Conn, data []byte) error // writes a text frame to a client
+	conn    *websocket.Conn
+	pending map[ID]*forwardedRequest // upstream request ID -> originating request
+	nextID  int
+
+	requestTimeout time.Duration // how long a forwarded request may wait for its response
+
+	reconnect         bool // re-dial with backoff when the connection drops
+	reconnecting      bool
+	reconnectAttempts int
+	lastError         string
+	connectedAt       time.Time
+	closed            bool
+	done              chan struct{}
+
+	mutex      sync.Mutex
+	writeMutex sync.Mutex // serializes writes to the upstream connection
+	logger     Logger
+}
+
+const (
+	// upstreamReconnectInitialDelay is the delay before the first reconnect attempt
+	upstreamReconnectInitialDelay = 1 * time.Second
+	// upstreamReconnectMaxDelay caps the exponential reconnect backoff
+	upstreamReconnectMaxDelay = 30 * time.Second
+	// upstreamHandshakeTimeout bounds the WebSocket handshake with the upstream relay
+	upstreamHandshakeTimeout = 10 * time.Second
+	// upstreamWriteTimeout bounds a single write to the upstream relay
+	upstreamWriteTimeout = 10 * time.Second
+	// upstreamRequestTimeout is how long a forwarded request waits for its response
+	upstreamRequestTimeout = 30 * time.Second
+)
+
+// errUpstreamReconnecting is returned when a request is forwarded while the
+// upstream connection is being re-established
+var errUpstreamReconnecting = errors.New("upstream relay is reconnecting")
+
+// UpstreamStatus describes the health of the upstream relay connection
+type UpstreamStatus struct {
+	URL               string    `json:"url"`
+	Connected         bool      `json:"connected"`
+	Reconnecting      bool      `json:"reconnecting"`
+	ReconnectAttempts int       `json:"reconnect_attempts"`
+	ConnectedAt       time.Time `json:"connected_at,omitempty"`
+	LastError         string    `json:"last_error,omitempty"`
+	PendingRequests   int       `json:"pending_requests"`
+}
+
+// forwardedRequest records where a forwarded request came from
+type forwardedRequest struct {
+	conn       *websocket.Conn
+	clientID   string
+	originalID ID
+	method     string
+	timer      *time.Timer // expires the request if the upstream never answers
+}
+
+// newUpstreamRelay creates a new upstream relay forwarder
+func newUpstreamRelay(url string, write func(conn *websocket.Conn, data []byte) error, logger Logger) *upstreamRelay {
+	return &upstreamRelay{
+		url:            url,
+		write:          write,
+		pending:        make(map[ID]*forwardedRequest),
+		requestTimeout: upstreamRequestTimeout,
+		done:           make(chan struct{}),
+		logger:         logger,
+	}
+}
+
+// dial opens a new connection to the upstream relay
+func (u *upstreamRelay) dial() (*websocket.Conn, error) {
+	dialer := websocket.Dialer{
+		HandshakeTimeout: upstreamHandshakeTimeout,
+	}
+	conn, _, err := dialer.Dial(u.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to upstream relay: %w", err)
+	}
+	return conn, nil
+}
+
+// connect returns the upstream connection, dialing it if necessary. The dial
+// happens without the mutex held so a slow upstream does not block Status or
+// other forwards.
+func (u *upstreamRelay) connect() (*websocket.Conn, error) {
+	u.mutex.Lock()
+	// Reject rather than buffer while reconnecting so clients fail fast
+	if u.reconnecting {
+		u.mutex.Unlock()
+		return nil, errUpstreamReconnecting
+	}
+	if u.conn != nil {
+		conn := u.conn
+		u.mutex.Unlock()
+		return conn, nil
+	}
+	u.mutex.Unlock()
+
+	u.logger.Infof("Connecting to upstream relay at %s", u.url)
+
+	conn, err := u.dial()
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if u.closed {
+		if conn != nil {
+			conn.Close()
+		}
+		return nil, errors.New("upstream relay forwarder is closed")
+	}
+	if err != nil {
+		u.lastError = err.Error()
+		return nil, err
+	}
+	if u.conn != nil {
+		// Another forward connected while this one was dialing
+		conn.Close()
+		return u.conn, nil
+	}
+
+	u.logger.Infof("Connected to upstream relay at %s", u.url)
+
+	u.setConnection(conn)
+	return conn, nil
+}
+
+// setConnection installs a freshly dialed connection and starts reading from it.
+// Must be called with the mutex held.
+func (u *upstreamRelay) setConnection(conn *websocket.Conn) {
+	u.conn = conn
+	u.connectedAt = time.Now()
+	u.lastError = ""
+	go u.readResponses(conn)
+}
+
+// reconnectLoop re-dials the upstream relay with exponential backoff until it
+// succeeds or the forwarder is closed
+func (u *upstreamRelay) reconnectLoop() {
+	delay := upstreamReconnectInitialDelay
+	for {
+		select {
+		case <-time.After(delay):
+		case <-u.done:
+			return
+		}
+
+		u.mutex.Lock()
+		u.reconnectAttempts++
+		attempt := u.reconnectAttempts
+		u.mutex.Unlock()
+
+		u.logger.Infof("Reconnecting to upstream relay at %s (attempt %d)", u.url, attempt)
+
+		conn, err := u.dial()
+
+		u.mutex.Lock()
+		if u.closed {
+			u.mutex.Unlock()
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		if err != nil {
+			u.lastError = err.Error()
+			u.mutex.Unlock()
+
+			delay *= 2
+			if delay > upstreamReconnectMaxDelay {
+				delay = upstreamReconnectMaxDelay
+			}
+			u.logger.Warnf("Upstream relay reconnect attempt %d failed: %v (retrying in %s)", attempt, err, delay)
+			continue
+		}
+
+		u.reconnecting = false
+		u.reconnectAttempts = 0
+		u.setConnection(conn)
+		u.mutex.Unlock()
+
+		u.logger.Infof("Reconnected to upstream relay at %s after %d attempts", u.url, attempt)
+		return
+	}
+}
+
+// connectionLost drops a failed upstream connection and, if enabled, starts
+// reconnecting in the background. Must be called with the mutex held.
+func (u *upstreamRelay) connectionLost(conn *websocket.Conn, err error) {
+	if u.conn != conn {
+		return
+	}
+
+	u.dropConnection(conn)
+	if err != nil {
+		u.lastError = err.Error()
+	}
+
+	if u.reconnect && !u.closed && !u.reconnecting {
+		u.reconnecting = true
+		go u.reconnectLoop()
+	}
+}
+
+// Forward sends a request to the upstream relay on behalf of a client. The
+// request fails with an error response if the upstream does not answer within
+// the request timeout.
+func (u *upstreamRelay) Forward(conn *websocket.Conn, clientID string, request *JSONRPCRequest) error {
+	upstreamConn, err := u.connect()
+	if err != nil {
+		return err
+	}
+
+	u.mutex.Lock()
+
+	// Rewrite the ID so it is unique on the upstream connection
+	u.nextID++
+	upstreamID := NumberID(int64(u.nextID))
+
+	forwarded := *request
+	forwarded.ID = upstreamID
+	if forwarded.JSONRPC == "" {
+		forwarded.JSONRPC = "2.0"
+	}
+
+	forwardedJSON, err := forwarded.ToJSON()
+	if err != nil {
+		u.mutex.Unlock()
+		return err
+	}
+
+	pending := &forwardedRequest{
+		conn:       conn,
+		clientID:   clientID,
+		originalID: request.ID,
+		method:     request.Method,
+	}
+	pending.timer = time.AfterFunc(u.requestTimeout, func() { u.expire(upstreamID, pending) })
+	u.pending[upstreamID] = pending
+	u.mutex.Unlock()
+
+	u.logger.Debugf("Forwarding %s from client %s upstream (id %s -> %s): %s",
+		request.Method, clientID, request.ID, upstreamID, forwardedJSON)
+
+	u.writeMutex.Lock()
+	upstreamConn.SetWriteDeadline(time.Now().Add(upstreamWriteTimeout))
+	err = upstreamConn.WriteMessage(websocket.TextMessage, []byte(forwardedJSON))
+	u.writeMutex.Unlock()
+
+	if err != nil {
+		u.mutex.Lock()
+		if u.pending[upstreamID] == pending {
+			pending.timer.Stop()
+			delete(u.pending, upstreamID)
+		}
+		u.connectionLost(upstreamConn, err)
+		u.mutex.Unlock()
+		return fmt.Errorf("failed to forward request upstream: %w", err)
+	}
+
+	return nil
+}
+
+// expire fails a forwarded request the upstream relay has not answered in time
+func (u *upstreamRelay) expire(upstreamID ID, forwarded *forwardedRequest) {
+	u.mutex.Lock()
+	if u.pending[upstreamID] != forwarded {
+		// Answered, failed or dropped in the meantime
+		u.mutex.Unlock()
+		return
+	}
+	delete(u.pending, upstreamID)
+	u.mutex.Unlock()
+
+	u.logger.Warnf("Upstream relay did not answer %s from client %s within %s",
+		forwarded.method, forwarded.clientID, u.requestTimeout)
+
+	response := NewJSONRPCErrorResponse(forwarded.originalID, -32000, "Upstream relay timed out")
+	if responseJSON, err := response.ToJSON(); err == nil {
+		if err := u.write(forwarded.conn, []byte(responseJSON)); err != nil {
+			u.logger.Debugf("Failed to notify client %s of upstream timeout: %v", forwarded.clientID, err)
+		}
+	}
+}
+
+// clientDisconnected forgets the pending requests of a client that has gone away
+func (u *upstreamRelay) clientDisconnected(conn *websocket.Conn) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	for id, forwarded := range u.pending {
+		if forwarded.conn == conn {
+			forwarded.timer.Stop()
+			delete(u.pending, id)
+		}
+	}
+}
+
+// readResponses reads responses from the upstream relay and routes them to clients
+func (u *upstreamRelay) readResponses(conn *websocket.Conn) {
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			u.logger.Warnf("Upstream relay connection closed: %v", err)
+			u.mutex.Lock()
+			u.connectionLost(conn, err)
+			u.mutex.Unlock()
+			return
+		}
+
+		u.logger.Debugf("Received from upstream relay: %s", string(message))
+
+		var response JSONRPCResponse
+		if err := json.Unmarshal(message, &response); err != nil {
+			u.logger.Errorf("Failed to parse upstream relay message: %v", err)
+			continue
+		}
+
+		u.mutex.Lock()
+		forwarded, ok := u.pending[response.ID]
+		if ok {
+			forwarded.timer.Stop()
+			delete(u.pending, response.ID)
+		}
+		u.mutex.Unlock()
+
+		if !ok {
+			// Requests initiated by the upstream relay cannot be routed to a client
+			u.logger.Debugf("Dropping upstream message with unknown id %s", response.ID)
+			continue
+		}
+
+		// Restore the client's original ID
+		response.ID = forwarded.originalID
+		responseJSON, err := response.ToJSON()
+		if err != nil {
+			u.logger.Errorf("Failed to marshal upstream response: %v", err)
+			continue
+		}
+
+		if err := u.write(forwarded.conn, []byte(responseJSON)); err != nil {
+			u.logger.Errorf("Failed to send upstream response to client %s: %v", forwarded.clientID, err)
+			continue
+		}
+
+		u.logger.Infof("Relayed upstream response for %s to client %s", forwarded.method, forwarded.clientID)
+	}
+}
+
+// dropConnection closes the upstream connection and fails all pending requests.
+// Must be called with the mutex held.
+func (u *upstreamRelay) dropConnection(conn *websocket.Conn) {
+	if u.conn != conn {
+		return
+	}
+
+	conn.Close()
+	u.conn = nil
+
+	for id, forwarded := range u.pending {
+		forwarded.timer.Stop()
+		response := NewJSONRPCErrorResponse(forwarded.originalID, -32000, "Upstream relay disconnected")
+		if responseJSON, err := response.ToJSON(); err == nil {
+			if err := u.write(forwarded.conn, []byte(responseJSON)); err != nil {
+				u.logger.Debugf("Failed to notify client %s of upstream disconnect: %v", forwarded.clientID, err)
+			}
+		}
+		delete(u.pending, id)
+	}
+}
+
+// Status returns the current health of the upstream connection
+func (u *upstreamRelay) Status() UpstreamStatus {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	status := UpstreamStatus{
+		URL:               u.url,
+		Connected:         u.conn != nil,
+		Reconnecting:      u.reconnecting,
+		ReconnectAttempts: u.reconnectAttempts,
+		LastError:         u.lastError,
+		PendingRequests:   len(u.pending),
+	}
+	if u.conn != nil {
+		status.ConnectedAt = u.connectedAt
+	}
+	return status
+}
+
+// Close closes the upstream connection and stops reconnecting
+func (u *upstreamRelay) Close() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if u.closed {
+		return
+	}
+	u.closed = true
+	close(u.done)
+
+	if u.conn != nil {
+		u.dropConnection(u.conn)
+	}
+}
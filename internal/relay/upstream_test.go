@@ -0,0 +1,261 @@
+package relay
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeUpstream is an upstream relay that answers every request with its
+// method name as the result, except "hold" requests, which it never answers
+type fakeUpstream struct {
+	url   string
+	conns chan *websocket.Conn // connections from the relay, in the order they were made
+}
+
+// startFakeUpstream starts a fake upstream relay that is stopped when the test ends
+func startFakeUpstream(t *testing.T) *fakeUpstream {
+	t.Helper()
+
+	upstream := &fakeUpstream{conns: make(chan *websocket.Conn, 10)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upstream upgrade: %v", err)
+			return
+		}
+		upstream.conns <- conn
+
+		for {
+			var request JSONRPCRequest
+			if err := conn.ReadJSON(&request); err != nil {
+				return
+			}
+			if request.Method == "hold" {
+				continue
+			}
+			if err := conn.WriteJSON(NewJSONRPCResponse(request.ID, request.Method)); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	upstream.url = "ws" + strings.TrimPrefix(server.URL, "http")
+	return upstream
+}
+
+// result decodes the result of a response as a string
+func (f testFrame) result(t *testing.T) string {
+	t.Helper()
+
+	var result string
+	if err := json.Unmarshal(f.Result, &result); err != nil {
+		t.Fatalf("decode result %s: %v", f.Result, err)
+	}
+	return result
+}
+
+func TestUnknownMethodsAreForwardedUpstream(t *testing.T) {
+	upstream := startFakeUpstream(t)
+	_, url := startTestRelay(t, func(s *RelayServer) { s.SetUpstreamRelayURL(upstream.url) })
+
+	client := dialTestRelay(t, url)
+	frame := client.call("irn_fetchMessages", map[string]string{"topic": "topic"})
+	if frame.Error != nil || frame.result(t) != "irn_fetchMessages" {
+		t.Fatalf("got %+v, want the upstream response", frame)
+	}
+
+	// Requests from different clients with the same ID get their own responses
+	other := dialTestRelay(t, url)
+	other.nextID = client.nextID
+	client.send("first", nil)
+	other.send("second", nil)
+	if frame := client.read(); frame.result(t) != "first" {
+		t.Errorf("first client got %s", frame.Result)
+	}
+	if frame := other.read(); frame.result(t) != "second" {
+		t.Errorf("second client got %s", frame.Result)
+	}
+
+	// String IDs are restored too
+	frame = client.sendRaw(`{"jsonrpc":"2.0","id":"abc-123","method":"irn_fetchMessages"}`)
+	if string(frame.ID) != `"abc-123"` {
+		t.Errorf("got response id %s, want the original string id", frame.ID)
+	}
+
+	// Methods the relay handles itself are not forwarded
+	client.subscribe("topic")
+}
+
+func TestUnknownMethodsWithoutUpstream(t *testing.T) {
+	_, url := startTestRelay(t, nil)
+	client := dialTestRelay(t, url)
+
+	frame := client.call("irn_fetchMessages", nil)
+	if frame.Error == nil || frame.Error.Code != -32601 {
+		t.Errorf("got error %+v, want method not found", frame.Error)
+	}
+}
+
+func TestUpstreamDropFailsPendingRequestsAndReconnects(t *testing.T) {
+	upstream := startFakeUpstream(t)
+	s, url := startTestRelay(t, func(s *RelayServer) {
+		s.SetUpstreamReconnect(true)
+		s.SetUpstreamRelayURL(upstream.url)
+	})
+	client := dialTestRelay(t, url)
+
+	// A request the upstream never answers is pending when the connection drops
+	client.send("hold", nil)
+	upstreamConn := <-upstream.conns
+	waitFor(t, "the request to be pending", func() bool { return s.upstream.Status().PendingRequests == 1 })
+	upstreamConn.Close()
+
+	frame := client.read()
+	if frame.Error == nil || frame.Error.Message != "Upstream relay disconnected" {
+		t.Fatalf("got %+v, want the pending request to fail", frame)
+	}
+
+	// Requests fail fast while reconnecting
+	status := s.upstream.Status()
+	if status.Connected || !status.Reconnecting || status.LastError == "" {
+		t.Errorf("got status %+v, want reconnecting with the last error", status)
+	}
+	if frame := client.call("irn_fetchMessages", nil); frame.Error == nil || frame.Error.Message != "Upstream relay reconnecting" {
+		t.Errorf("got %+v, want a reconnecting error", frame)
+	}
+
+	// Once reconnected, requests are forwarded again
+	waitFor(t, "the upstream relay to reconnect", func() bool { return s.upstream.Status().Connected })
+	if frame := client.call("irn_fetchMessages", nil); frame.Error != nil || frame.result(t) != "irn_fetchMessages" {
+		t.Errorf("got %+v after reconnecting, want the upstream response", frame)
+	}
+	status = s.upstream.Status()
+	if status.Reconnecting || status.ReconnectAttempts != 0 || status.LastError != "" {
+		t.Errorf("got status %+v, want a healthy connection", status)
+	}
+	if stats := s.GetStats(); stats["upstream"] == nil {
+		t.Error("relay stats do not report the upstream relay")
+	}
+}
+
+func TestUpstreamDropWithoutReconnect(t *testing.T) {
+	upstream := startFakeUpstream(t)
+	s, url := startTestRelay(t, func(s *RelayServer) { s.SetUpstreamRelayURL(upstream.url) })
+	client := dialTestRelay(t, url)
+
+	client.call("irn_fetchMessages", nil)
+	(<-upstream.conns).Close()
+	waitFor(t, "the upstream connection to drop", func() bool { return !s.upstream.Status().Connected })
+	if s.upstream.Status().Reconnecting {
+		t.Error("reconnecting with reconnects disabled")
+	}
+
+	// The next forwarded request dials again
+	if frame := client.call("irn_fetchMessages", nil); frame.Error != nil {
+		t.Errorf("got %+v, want the request to re-dial the upstream relay", frame)
+	}
+}
+
+func TestUnansweredUpstreamRequestsExpire(t *testing.T) {
+	upstream := startFakeUpstream(t)
+	s, url := startTestRelay(t, func(s *RelayServer) {
+		s.SetUpstreamRelayURL(upstream.url)
+		s.upstream.requestTimeout = 100 * time.Millisecond
+	})
+	client := dialTestRelay(t, url)
+
+	id := client.send("hold", nil)
+	frame := client.read()
+	if frame.Error == nil || frame.Error.Message != "Upstream relay timed out" {
+		t.Fatalf("got %+v, want the request to time out", frame)
+	}
+	if string(frame.ID) != string(mustMarshal(t, id)) {
+		t.Errorf("got response id %s, want %d", frame.ID, id)
+	}
+	if pending := s.upstream.Status().PendingRequests; pending != 0 {
+		t.Errorf("%d requests still pending after the timeout", pending)
+	}
+
+	// The connection itself is still usable
+	if frame := client.call("irn_fetchMessages", nil); frame.Error != nil || frame.result(t) != "irn_fetchMessages" {
+		t.Errorf("got %+v, want the upstream response", frame)
+	}
+}
+
+func TestPendingUpstreamRequestsAreForgottenWhenTheClientDisconnects(t *testing.T) {
+	upstream := startFakeUpstream(t)
+	s, url := startTestRelay(t, func(s *RelayServer) { s.SetUpstreamRelayURL(upstream.url) })
+
+	client := dialTestRelay(t, url)
+	client.send("hold", nil)
+	client.send("hold", nil)
+	waitFor(t, "the requests to be pending", func() bool { return s.upstream.Status().PendingRequests == 2 })
+
+	client.conn.Close()
+	waitFor(t, "the pending requests to be forgotten", func() bool { return s.upstream.Status().PendingRequests == 0 })
+}
+
+func TestStalledUpstreamDialDoesNotBlockStatus(t *testing.T) {
+	// A listener that accepts connections but never completes the handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	var (
+		mutex    sync.Mutex
+		accepted []net.Conn
+	)
+	dialed := make(chan struct{}, 1)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mutex.Lock()
+			accepted = append(accepted, conn)
+			mutex.Unlock()
+			dialed <- struct{}{}
+		}
+	}()
+	closeStalled := func() {
+		listener.Close()
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, conn := range accepted {
+			conn.Close()
+		}
+	}
+	t.Cleanup(closeStalled)
+
+	s, url := startTestRelay(t, func(s *RelayServer) { s.SetUpstreamRelayURL("ws://" + listener.Addr().String()) })
+	client := dialTestRelay(t, url)
+	client.send("irn_fetchMessages", nil)
+	<-dialed
+
+	status := make(chan UpstreamStatus, 1)
+	go func() { status <- s.upstream.Status() }()
+	select {
+	case got := <-status:
+		if got.Connected {
+			t.Errorf("got status %+v while the dial is stalled", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Status blocked on the stalled upstream dial")
+	}
+
+	// The forward fails once the dial is released
+	closeStalled()
+	if frame := client.read(); frame.Error == nil || frame.Error.Message != "Upstream relay unavailable" {
+		t.Errorf("got %+v, want the forward to fail", frame)
+	}
+}
@@ -0,0 +1,367 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/korjavin/wctestapp/internal/relay"
+	"github.com/korjavin/wctestapp/internal/wallet"
+	"github.com/korjavin/wctestapp/pkg/utils"
+)
+
+const (
+	// clientWSMaxMessageSize bounds the commands a browser may send
+	clientWSMaxMessageSize = 64 << 10
+	// clientWSWriteTimeout bounds each write to the browser
+	clientWSWriteTimeout = 10 * time.Second
+	// clientWSCloseGracePeriod is how long a browser may take to answer our close frame
+	clientWSCloseGracePeriod = 5 * time.Second
+)
+
+// clientUpgrader upgrades browser connections to the WebSocket API. The
+// default origin check only allows pages served by this server.
+var clientUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// clientCommand is a command sent by the browser over the WebSocket API
+type clientCommand struct {
+	ID        json.RawMessage `json:"id,omitempty"` // echoed in the reply
+	Type      string          `json:"type"`         // createSession, sign or disconnect
+	SessionID string          `json:"session_id,omitempty"`
+	Message   string          `json:"message,omitempty"`
+}
+
+// clientReply is a message sent to the browser: the result of a command, an
+// error, or a wallet event of one of the connection's sessions
+type clientReply struct {
+	ID     json.RawMessage        `json:"id,omitempty"`
+	Type   string                 `json:"type"` // result, error or event
+	Result map[string]interface{} `json:"result,omitempty"`
+	Error  *apiError              `json:"error,omitempty"`
+	Event  map[string]interface{} `json:"event,omitempty"`
+}
+
+// clientConn is a browser connection to the WebSocket API and the sessions
+// whose events it receives
+type clientConn struct {
+	conn       *websocket.Conn
+	writeMutex sync.Mutex
+
+	sessions      map[string]func() // session ID -> unsubscribe
+	sessionsMutex sync.Mutex
+
+	ctx     context.Context // canceled when the connection closes
+	pending sync.WaitGroup  // tracks commands and event forwarders
+}
+
+// handleClientWS handles the browser WebSocket API endpoint. The browser
+// sends JSON commands to create sessions, request signatures and disconnect
+// sessions, and receives their results and the wallet events of every session
+// it created or used on the connection. Commands are answered asynchronously,
+// so a reply carries the ID of its command.
+func (s *Server) handleClientWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := clientUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already replied with an HTTP error
+		s.logger.Warn(fmt.Sprintf("Failed to upgrade browser connection: %v", err))
+		return
+	}
+	conn.SetReadLimit(clientWSMaxMessageSize)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &clientConn{
+		conn:     conn,
+		sessions: make(map[string]func()),
+		ctx:      ctx,
+	}
+
+	if !s.trackClient(client) {
+		cancel()
+		client.sendClose()
+		conn.Close()
+		return
+	}
+
+	defer func() {
+		// Stop forwarding events and abandon running commands
+		cancel()
+		client.unfollowAll()
+		client.pending.Wait()
+		conn.Close()
+		s.untrackClient(client)
+	}()
+
+	for {
+		var command clientCommand
+		if err := conn.ReadJSON(&command); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				s.logger.Warn(fmt.Sprintf("Browser connection closed: %v", err))
+			}
+			return
+		}
+
+		client.pending.Add(1)
+		go func() {
+			defer client.pending.Done()
+			s.handleClientCommand(client, command)
+		}()
+	}
+}
+
+// trackClient registers a browser connection so that Shutdown can close it.
+// It returns false once the server is shutting down.
+func (s *Server) trackClient(client *clientConn) bool {
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+
+	if s.clientsClosed {
+		return false
+	}
+	s.clients[client] = struct{}{}
+	s.clientsWg.Add(1)
+	return true
+}
+
+// untrackClient removes a browser connection whose handler has finished
+func (s *Server) untrackClient(client *clientConn) {
+	s.clientsMutex.Lock()
+	delete(s.clients, client)
+	s.clientsMutex.Unlock()
+
+	s.clientsWg.Done()
+}
+
+// closeClients sends close frames to all browser connections and waits for
+// their handlers to finish. Connections whose browser does not answer within
+// the grace period, or by the deadline of ctx, are closed.
+func (s *Server) closeClients(ctx context.Context) error {
+	s.clientsMutex.Lock()
+	s.clientsClosed = true
+	clients := make([]*clientConn, 0, len(s.clients))
+	for client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.clientsMutex.Unlock()
+
+	for _, client := range clients {
+		if err := client.sendClose(); err != nil {
+			client.conn.Close()
+		}
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		s.clientsWg.Wait()
+		close(finished)
+	}()
+
+	closeAll := func() {
+		for _, client := range clients {
+			client.conn.Close()
+		}
+	}
+
+	grace := time.NewTimer(clientWSCloseGracePeriod)
+	defer grace.Stop()
+
+	select {
+	case <-finished:
+	case <-grace.C:
+		s.logger.Warn("Browsers did not close their connections in time, closing them")
+		closeAll()
+		select {
+		case <-finished:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	case <-ctx.Done():
+		closeAll()
+		return ctx.Err()
+	}
+
+	if len(clients) > 0 {
+		s.logger.Info(fmt.Sprintf("Closed %d browser connections", len(clients)))
+	}
+	return nil
+}
+
+// handleClientCommand runs a browser command and replies with its result
+func (s *Server) handleClientCommand(client *clientConn, command clientCommand) {
+	switch command.Type {
+	case "createSession":
+		s.clientCreateSession(client, command)
+	case "sign":
+		s.clientSign(client, command)
+	case "disconnect":
+		s.clientDisconnect(client, command)
+	default:
+		client.replyError(command, errorCodeInvalidRequest, fmt.Sprintf("Unknown command type %q", command.Type))
+	}
+}
+
+// clientCreateSession creates a session, follows its events and replies with
+// its pairing URI and QR code
+func (s *Server) clientCreateSession(client *clientConn, command clientCommand) {
+	ctx, cancel := context.WithTimeout(client.ctx, s.config.CreateSessionTimeout)
+	defer cancel()
+
+	session, err := s.walletClient.CreateAndConnect(ctx)
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("Failed to create session: %v", err))
+		switch {
+		case session != nil && errors.Is(err, context.DeadlineExceeded):
+			client.replyError(command, errorCodeTimeout, "Timed out connecting to relay")
+		case errors.Is(err, wallet.ErrInsecureRelay):
+			client.replyError(command, errorCodeInsecureRelay, "Relay URL is not secure")
+		case session != nil:
+			client.replyError(command, errorCodeRelayUnavailable, "Failed to connect to relay")
+		default:
+			client.replyError(command, errorCodeInternal, "Internal Server Error")
+		}
+		return
+	}
+
+	s.followSession(client, session.ID)
+
+	pairingURI := session.GeneratePairingURIWithRelay(s.config.RelayWebSocketURL())
+	result := map[string]interface{}{
+		"session_id":  session.ID,
+		"pairing_uri": pairingURI,
+	}
+	if qrCode, err := utils.GenerateQRCode(pairingURI, 256); err != nil {
+		s.logger.Error(fmt.Sprintf("Failed to generate QR code: %v", err))
+		result["qr_error"] = err.Error()
+	} else {
+		result["qr_code"] = qrCode
+	}
+
+	client.replyResult(command, result)
+}
+
+// clientSign requests a personal_sign signature and replies with it
+func (s *Server) clientSign(client *clientConn, command clientCommand) {
+	if command.Message == "" {
+		client.replyError(command, errorCodeInvalidRequest, "Missing message")
+		return
+	}
+
+	session := s.walletClient.GetSession(command.SessionID)
+	if session == nil {
+		client.replyError(command, errorCodeSessionNotFound, "Session not found")
+		return
+	}
+	s.followSession(client, session.ID)
+
+	ctx, cancel := context.WithTimeout(client.ctx, signRequestTimeout)
+	defer cancel()
+
+	signature, err := s.walletClient.SignMessage(ctx, session, command.Message)
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("Failed to sign message: %v", err))
+		switch {
+		case errors.Is(err, wallet.ErrSessionNotActive):
+			client.replyError(command, errorCodeSessionNotActive, "Session is not active")
+		case errors.Is(err, wallet.ErrSignMethodNotAllowed):
+			client.replyError(command, errorCodeSignMethodNotAllowed, "Sign method not allowed")
+		case errors.Is(err, context.DeadlineExceeded):
+			client.replyError(command, errorCodeTimeout, "Timed out waiting for wallet")
+		case errors.As(err, new(*wallet.ResponseError)):
+			client.replyError(command, errorCodeWalletRejected, fmt.Sprintf("Wallet rejected request: %v", err))
+		default:
+			client.replyError(command, errorCodeInternal, "Internal Server Error")
+		}
+		return
+	}
+
+	client.replyResult(command, map[string]interface{}{
+		"session_id": session.ID,
+		"message":    command.Message,
+		"signature":  signature,
+	})
+}
+
+// clientDisconnect disconnects a session
+func (s *Server) clientDisconnect(client *clientConn, command clientCommand) {
+	session := s.walletClient.GetSession(command.SessionID)
+	if session == nil {
+		client.replyError(command, errorCodeSessionNotFound, "Session not found")
+		return
+	}
+
+	if err := s.walletClient.DisconnectSession(session, wallet.ReasonUserDisconnected); err != nil {
+		s.logger.Error(fmt.Sprintf("Failed to disconnect session: %v", err))
+		client.replyError(command, errorCodeInternal, "Internal Server Error")
+		return
+	}
+
+	client.replyResult(command, map[string]interface{}{
+		"success": true,
+	})
+}
+
+// followSession forwards a session's wallet events to the browser, unless
+// the connection already follows it
+func (s *Server) followSession(client *clientConn, sessionID string) {
+	client.sessionsMutex.Lock()
+	defer client.sessionsMutex.Unlock()
+
+	if _, ok := client.sessions[sessionID]; ok || client.ctx.Err() != nil {
+		return
+	}
+
+	events, unsubscribe := s.walletClient.Subscribe(sessionID)
+	client.sessions[sessionID] = unsubscribe
+
+	client.pending.Add(1)
+	go func() {
+		defer client.pending.Done()
+		for event := range events {
+			client.send(clientReply{Type: "event", Event: sessionEventPayload(event)})
+		}
+	}()
+}
+
+// unfollowAll stops forwarding the events of all followed sessions
+func (c *clientConn) unfollowAll() {
+	c.sessionsMutex.Lock()
+	defer c.sessionsMutex.Unlock()
+
+	for sessionID, unsubscribe := range c.sessions {
+		unsubscribe()
+		delete(c.sessions, sessionID)
+	}
+}
+
+// replyResult sends the result of a command
+func (c *clientConn) replyResult(command clientCommand, result map[string]interface{}) {
+	c.send(clientReply{ID: command.ID, Type: "result", Result: result})
+}
+
+// replyError sends the error of a command
+func (c *clientConn) replyError(command clientCommand, code string, message string) {
+	c.send(clientReply{ID: command.ID, Type: "error", Error: &apiError{Code: code, Message: message}})
+}
+
+// sendClose asks the browser to close the connection because the server is shutting down
+func (c *clientConn) sendClose() error {
+	message := websocket.FormatCloseMessage(websocket.CloseGoingAway, relay.CloseReasonShutdown)
+	return c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(clientWSWriteTimeout))
+}
+
+// send writes a message to the browser. Write errors are ignored: they mean
+// the browser went away, which the read loop notices.
+func (c *clientConn) send(reply clientReply) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(clientWSWriteTimeout))
+	_ = c.conn.WriteJSON(reply)
+}
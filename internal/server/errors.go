@@ -0,0 +1,57 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// Error codes returned in API error responses
+const (
+	errorCodeInvalidRequest       = "invalid_request"
+	errorCodeMethodNotAllowed     = "method_not_allowed"
+	errorCodeNotFound             = "not_found"
+	errorCodeSessionNotFound      = "session_not_found"
+	errorCodeSessionNotActive     = "session_not_active"
+	errorCodeSignMethodNotAllowed = "sign_method_not_allowed"
+	errorCodeNotSupported         = "not_supported"
+	errorCodeUnauthorized         = "unauthorized"
+	errorCodeForbidden            = "forbidden"
+	errorCodeTimeout              = "timeout"
+	errorCodeInsecureRelay        = "insecure_relay"
+	errorCodeRelayUnavailable     = "relay_unavailable"
+	errorCodeShuttingDown         = "shutting_down"
+	errorCodeWalletRejected       = "wallet_rejected"
+	errorCodeInternal             = "internal_error"
+)
+
+// apiError is the body of an API error response
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeJSONError writes an API error response of the form
+// {"error":{"code":...,"message":...}} with the given status
+func writeJSONError(w http.ResponseWriter, status int, code string, message string) {
+	writeJSONErrorWithFields(w, status, code, message, nil)
+}
+
+// writeJSONErrorWithFields writes an API error response like writeJSONError,
+// with additional top-level fields next to the error object
+func writeJSONErrorWithFields(w http.ResponseWriter, status int, code string, message string, fields map[string]interface{}) {
+	// Set the content type
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+
+	body := map[string]interface{}{
+		"error": apiError{Code: code, Message: message},
+	}
+	for key, value := range fields {
+		body[key] = value
+	}
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Failed to encode JSON error response: %v", err)
+	}
+}
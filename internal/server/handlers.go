@@ -1,15 +1,28 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/korjavin/wctestapp/internal/wallet"
 	"github.com/korjavin/wctestapp/pkg/utils"
 )
 
+// signRequestTimeout is how long sign endpoints wait for the wallet to respond
+const signRequestTimeout = 2 * time.Minute
+
+// maxBatchSignatures is the maximum number of signatures verified in one batch request
+const maxBatchSignatures = 100
+
 // TemplateData represents the data passed to templates
 type TemplateData struct {
 	Title            string
@@ -76,7 +89,7 @@ func (s *Server) handleConnected(w http.ResponseWriter, r *http.Request) {
 	var signatureDetails map[string]string
 	if message != "" && signature != "" {
 		var err error
-		signatureDetails, err = s.walletClient.GetSignatureDetails(message, signature)
+		signatureDetails, err = s.walletClient.GetSessionSignatureDetails(r.Context(), session, message, signature)
 		if err != nil {
 			s.logger.Error(fmt.Sprintf("Failed to get signature details: %v", err))
 		}
@@ -113,20 +126,62 @@ func (s *Server) handleConnected(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleCreateSession(w http.ResponseWriter, r *http.Request) {
 	// Only allow POST requests
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		writeJSONError(w, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+
+	// Parse the optional list of QR code sizes
+	qrSizes, err := parseQRSizes(r.URL.Query().Get("sizes"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, fmt.Sprintf("Invalid sizes: %v", err))
 		return
 	}
 
-	// Create a new session
-	session, err := s.walletClient.CreateSession()
+	// Compact responses return raw base64 QR images plus their MIME type
+	// instead of data URIs
+	compact := false
+	if value := r.URL.Query().Get("compact"); value != "" {
+		compact, err = strconv.ParseBool(value)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, "Invalid compact")
+			return
+		}
+	}
+
+	// Create a new session and subscribe to its pairing topic within the time budget
+	ctx, cancel := context.WithTimeout(r.Context(), s.config.CreateSessionTimeout)
+	defer cancel()
+
+	session, err := s.walletClient.CreateAndConnect(ctx)
 	if err != nil {
 		s.logger.Error(fmt.Sprintf("Failed to create session: %v", err))
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		if session == nil {
+			writeJSONError(w, http.StatusInternalServerError, errorCodeInternal, "Internal Server Error")
+			return
+		}
+
+		// The session was created but its relay subscription failed, so the
+		// client learns its ID and status and may retry the relay step
+		fields := map[string]interface{}{
+			"session_id": session.ID,
+			"status":     session.Status,
+		}
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			writeJSONErrorWithFields(w, http.StatusGatewayTimeout, errorCodeTimeout, "Timed out connecting to relay", fields)
+		case errors.Is(err, wallet.ErrInsecureRelay):
+			writeJSONErrorWithFields(w, http.StatusBadGateway, errorCodeInsecureRelay, "Relay URL is not secure", fields)
+		default:
+			writeJSONErrorWithFields(w, http.StatusBadGateway, errorCodeRelayUnavailable, "Failed to connect to relay", fields)
+		}
 		return
 	}
 
 	// Generate the pairing URI with our relay URL
 	relayURL := s.config.RelayWebSocketURL()
+	if !wallet.IsSecureRelayURL(relayURL) {
+		s.logger.Warn(fmt.Sprintf("Pairing URI uses insecure relay URL %s; wallets may refuse it", relayURL))
+	}
 	pairingURI := session.GeneratePairingURIWithRelay(relayURL)
 
 	// Also generate the standard URI without relay URL for comparison
@@ -138,33 +193,72 @@ func (s *Server) handleCreateSession(w http.ResponseWriter, r *http.Request) {
 	s.logger.Info(fmt.Sprintf("Enhanced Pairing URI (with relay): %s", pairingURI))
 	s.logger.Info(fmt.Sprintf("QR Code now contains our relay URL to ensure wallet connects to our server"))
 
-	// Generate a QR code for the pairing URI
-	qrCode, err := utils.GenerateQRCode(pairingURI, 256)
-	if err != nil {
+	// Generate a QR code for the pairing URI. The QR code is optional: if it
+	// cannot be generated, the pairing URI is still returned so the user can
+	// pair by copying it.
+	var qrCode *string
+	var qrError string
+	if code, err := utils.GenerateQRCode(pairingURI, 256); err != nil {
 		s.logger.Error(fmt.Sprintf("Failed to generate QR code: %v", err))
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+		qrError = err.Error()
+	} else {
+		qrCode = &code
 	}
 
-	// Connect to the relay server
-	err = s.walletClient.ConnectToRelay(session)
-	if err != nil {
-		s.logger.Error(fmt.Sprintf("Failed to connect to relay: %v", err))
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+	// The SVG QR code scales without blurring, e.g. for print and high-DPI screens
+	var qrCodeSVG string
+	if qrError == "" {
+		qrCodeSVG, err = utils.GenerateQRCodeSVG(pairingURI, 256)
+		if err != nil {
+			s.logger.Error(fmt.Sprintf("Failed to generate SVG QR code: %v", err))
+		}
+	}
+
+	// Generate QR codes at the additional requested sizes
+	var qrCodes map[int]string
+	if len(qrSizes) > 0 && qrError == "" {
+		qrCodes, err = utils.GenerateQRCodes(pairingURI, qrSizes)
+		if err != nil {
+			s.logger.Error(fmt.Sprintf("Failed to generate QR codes: %v", err))
+			qrError = err.Error()
+		}
+	}
+
+	if compact {
+		if qrCode != nil {
+			code := strings.TrimPrefix(*qrCode, utils.QRCodeDataURIPrefix)
+			qrCode = &code
+		}
+		for size, code := range qrCodes {
+			qrCodes[size] = strings.TrimPrefix(code, utils.QRCodeDataURIPrefix)
+		}
 	}
 
 	// Set the content type
 	w.Header().Set("Content-Type", "application/json")
 
 	// Return the session details
-	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+	response := map[string]interface{}{
 		"session_id":  session.ID,
 		"pairing_uri": pairingURI,
 		"qr_code":     qrCode,
-	}); err != nil {
+	}
+	if qrCodeSVG != "" {
+		response["qr_code_svg"] = qrCodeSVG
+	}
+	if qrCodes != nil {
+		response["qr_codes"] = qrCodes
+	}
+	if compact {
+		response["mime_type"] = utils.QRCodeMIMEType
+	}
+	if qrError != "" {
+		response["qr_error"] = qrError
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
 		s.logger.Error(fmt.Sprintf("Failed to encode JSON response: %v", err))
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, errorCodeInternal, "Internal Server Error")
 		return
 	}
 }
@@ -174,14 +268,14 @@ func (s *Server) handleSessionStatus(w http.ResponseWriter, r *http.Request) {
 	// Get the session ID from the query parameters
 	sessionID := r.URL.Query().Get("session")
 	if sessionID == "" {
-		http.Error(w, "Missing session ID", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, "Missing session ID")
 		return
 	}
 
 	// Get the session
 	session := s.walletClient.GetSession(sessionID)
 	if session == nil {
-		http.Error(w, "Session not found", http.StatusNotFound)
+		writeJSONError(w, http.StatusNotFound, errorCodeSessionNotFound, "Session not found")
 		return
 	}
 
@@ -193,9 +287,74 @@ func (s *Server) handleSessionStatus(w http.ResponseWriter, r *http.Request) {
 		"session_id":     session.ID,
 		"status":         session.Status,
 		"wallet_address": session.WalletAddress.Hex(),
+		"chains":         session.Chains(),
+		"last_event":     s.lastSessionEvent(session.ID),
+		"relay_url":      s.walletClient.SessionRelay(session),
 	}); err != nil {
 		s.logger.Error(fmt.Sprintf("Failed to encode JSON response: %v", err))
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, errorCodeInternal, "Internal Server Error")
+		return
+	}
+}
+
+// handleSessionAccounts handles the session accounts API endpoint. It asks the
+// wallet for its accounts with eth_accounts and returns them.
+func (s *Server) handleSessionAccounts(w http.ResponseWriter, r *http.Request) {
+	// Get the session ID from the query parameters
+	sessionID := r.URL.Query().Get("session")
+	if sessionID == "" {
+		writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, "Missing session ID")
+		return
+	}
+
+	// Get the session
+	session := s.walletClient.GetSession(sessionID)
+	if session == nil {
+		writeJSONError(w, http.StatusNotFound, errorCodeSessionNotFound, "Session not found")
+		return
+	}
+
+	// Allow the response to outlive the server's default write timeout while we wait for the wallet
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Now().Add(signRequestTimeout + 5*time.Second)); err != nil {
+		s.logger.Warn(fmt.Sprintf("Failed to extend write deadline: %v", err))
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), signRequestTimeout)
+	defer cancel()
+
+	// Request the accounts
+	accounts, err := s.walletClient.GetAccounts(ctx, session)
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("Failed to get accounts: %v", err))
+		switch {
+		case errors.Is(err, wallet.ErrSessionNotActive):
+			writeJSONError(w, http.StatusBadRequest, errorCodeSessionNotActive, "Session is not active")
+		case errors.Is(err, context.DeadlineExceeded):
+			writeJSONError(w, http.StatusGatewayTimeout, errorCodeTimeout, "Timed out waiting for wallet")
+		case errors.As(err, new(*wallet.ResponseError)):
+			writeJSONError(w, http.StatusBadGateway, errorCodeWalletRejected, fmt.Sprintf("Wallet rejected request: %v", err))
+		default:
+			writeJSONError(w, http.StatusInternalServerError, errorCodeInternal, "Internal Server Error")
+		}
+		return
+	}
+
+	addresses := make([]string, len(accounts))
+	for i, account := range accounts {
+		addresses[i] = account.Hex()
+	}
+
+	// Set the content type
+	w.Header().Set("Content-Type", "application/json")
+
+	// Return the accounts
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"session_id": session.ID,
+		"accounts":   addresses,
+	}); err != nil {
+		s.logger.Error(fmt.Sprintf("Failed to encode JSON response: %v", err))
+		writeJSONError(w, http.StatusInternalServerError, errorCodeInternal, "Internal Server Error")
 		return
 	}
 }
@@ -204,29 +363,29 @@ func (s *Server) handleSessionStatus(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleDisconnectSession(w http.ResponseWriter, r *http.Request) {
 	// Only allow POST requests
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		writeJSONError(w, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed, "Method Not Allowed")
 		return
 	}
 
 	// Get the session ID from the query parameters
 	sessionID := r.URL.Query().Get("session")
 	if sessionID == "" {
-		http.Error(w, "Missing session ID", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, "Missing session ID")
 		return
 	}
 
 	// Get the session
 	session := s.walletClient.GetSession(sessionID)
 	if session == nil {
-		http.Error(w, "Session not found", http.StatusNotFound)
+		writeJSONError(w, http.StatusNotFound, errorCodeSessionNotFound, "Session not found")
 		return
 	}
 
 	// Disconnect the session
-	err := s.walletClient.DisconnectSession(session)
+	err := s.walletClient.DisconnectSession(session, wallet.ReasonUserDisconnected)
 	if err != nil {
 		s.logger.Error(fmt.Sprintf("Failed to disconnect session: %v", err))
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, errorCodeInternal, "Internal Server Error")
 		return
 	}
 
@@ -238,7 +397,402 @@ func (s *Server) handleDisconnectSession(w http.ResponseWriter, r *http.Request)
 		"success": true,
 	}); err != nil {
 		s.logger.Error(fmt.Sprintf("Failed to encode JSON response: %v", err))
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, errorCodeInternal, "Internal Server Error")
+		return
+	}
+}
+
+// handleReconnectSession handles the admin reconnect session API endpoint
+func (s *Server) handleReconnectSession(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+
+	// Get the session ID from the query parameters
+	sessionID := r.URL.Query().Get("session")
+	if sessionID == "" {
+		writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, "Missing session ID")
+		return
+	}
+
+	// Get the session
+	session := s.walletClient.GetSession(sessionID)
+	if session == nil {
+		writeJSONError(w, http.StatusNotFound, errorCodeSessionNotFound, "Session not found")
+		return
+	}
+
+	// Reconnect the session
+	err := s.walletClient.ReconnectSession(session)
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("Failed to reconnect session: %v", err))
+		writeJSONError(w, http.StatusInternalServerError, errorCodeInternal, "Internal Server Error")
+		return
+	}
+
+	// Set the content type
+	w.Header().Set("Content-Type", "application/json")
+
+	// Return success
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	}); err != nil {
+		s.logger.Error(fmt.Sprintf("Failed to encode JSON response: %v", err))
+		writeJSONError(w, http.StatusInternalServerError, errorCodeInternal, "Internal Server Error")
+		return
+	}
+}
+
+// handleCleanup handles the manual session cleanup admin API endpoint
+func (s *Server) handleCleanup(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+
+	// Remove expired sessions now
+	removed := s.walletClient.CleanupExpiredSessions()
+	s.logger.Info(fmt.Sprintf("Manual cleanup removed %d expired sessions", removed))
+
+	// Set the content type
+	w.Header().Set("Content-Type", "application/json")
+
+	// Return the number of removed sessions
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"removed": removed,
+	}); err != nil {
+		s.logger.Error(fmt.Sprintf("Failed to encode JSON response: %v", err))
+		writeJSONError(w, http.StatusInternalServerError, errorCodeInternal, "Internal Server Error")
+		return
+	}
+}
+
+// handleRelayClients handles the relay clients admin API endpoint
+func (s *Server) handleRelayClients(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+
+	// Set the content type
+	w.Header().Set("Content-Type", "application/json")
+
+	// Return the connected clients
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"clients": s.relayServer.GetClients(),
+	}); err != nil {
+		s.logger.Error(fmt.Sprintf("Failed to encode JSON response: %v", err))
+		writeJSONError(w, http.StatusInternalServerError, errorCodeInternal, "Internal Server Error")
+		return
+	}
+}
+
+// handleRelayStats handles the relay stats API endpoint
+func (s *Server) handleRelayStats(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+
+	// Set the content type
+	w.Header().Set("Content-Type", "application/json")
+
+	// Return the relay statistics
+	if err := json.NewEncoder(w).Encode(s.relayServer.GetStats()); err != nil {
+		s.logger.Error(fmt.Sprintf("Failed to encode JSON response: %v", err))
+		writeJSONError(w, http.StatusInternalServerError, errorCodeInternal, "Internal Server Error")
+		return
+	}
+}
+
+// handleRelayFrames handles the relay frame capture admin API endpoint. GET
+// returns the captured frames, which can be replayed with /api/admin/replay,
+// and POST with ?record=true or ?record=false starts or stops capturing.
+func (s *Server) handleRelayFrames(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		record, err := strconv.ParseBool(r.URL.Query().Get("record"))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, "Invalid record parameter")
+			return
+		}
+		s.relayServer.RecordFrames(record)
+		s.logger.Info(fmt.Sprintf("Relay frame recording enabled: %t", record))
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+
+	// Set the content type
+	w.Header().Set("Content-Type", "application/json")
+
+	// Return the captured frames
+	if err := json.NewEncoder(w).Encode(s.relayServer.GetRecordedFrames()); err != nil {
+		s.logger.Error(fmt.Sprintf("Failed to encode JSON response: %v", err))
+		writeJSONError(w, http.StatusInternalServerError, errorCodeInternal, "Internal Server Error")
+		return
+	}
+}
+
+// handleReplay handles the replay admin API endpoint. The request body is a
+// capture from /api/admin/frames; its relay notifications are fed into the
+// wallet client. The optional speed parameter keeps the recorded timing
+// (1 replays in real time, 0 without delay) and client_id limits the replay
+// to frames sent to one relay client.
+func (s *Server) handleReplay(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+
+	source, err := wallet.LoadReplaySource(r.Body)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, err.Error())
+		return
+	}
+
+	if speed := r.URL.Query().Get("speed"); speed != "" {
+		value, err := strconv.ParseFloat(speed, 64)
+		if err != nil || value < 0 {
+			writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, "Invalid speed parameter")
+			return
+		}
+		source.SetSpeed(value)
+	}
+	source.SetClientID(r.URL.Query().Get("client_id"))
+
+	// A timed replay can outlive the server's default write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		s.logger.Warn(fmt.Sprintf("Failed to clear write deadline: %v", err))
+	}
+
+	// Replay the capture
+	replayed, err := s.walletClient.Replay(r.Context(), source)
+	if err != nil {
+		s.logger.Warn(fmt.Sprintf("Replay stopped after %d frames: %v", replayed, err))
+		return
+	}
+
+	// Set the content type
+	w.Header().Set("Content-Type", "application/json")
+
+	// Return the number of replayed frames
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"replayed": replayed,
+	}); err != nil {
+		s.logger.Error(fmt.Sprintf("Failed to encode JSON response: %v", err))
+		writeJSONError(w, http.StatusInternalServerError, errorCodeInternal, "Internal Server Error")
+		return
+	}
+}
+
+// handleSessionMessages handles the debug session message log API endpoint
+func (s *Server) handleSessionMessages(w http.ResponseWriter, r *http.Request) {
+	// The message log is only available in debug mode
+	if !s.config.Debug {
+		http.NotFound(w, r)
+		return
+	}
+
+	// It holds decrypted session traffic, so unlike the other admin endpoints
+	// it is never open in debug mode without an admin token
+	if s.config.AdminToken == "" {
+		writeJSONError(w, http.StatusForbidden, errorCodeForbidden, "Forbidden")
+		return
+	}
+
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+
+	// Get the session ID from the query parameters
+	sessionID := r.URL.Query().Get("session")
+	if sessionID == "" {
+		writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, "Missing session ID")
+		return
+	}
+
+	// Get the session
+	session := s.walletClient.GetSession(sessionID)
+	if session == nil {
+		writeJSONError(w, http.StatusNotFound, errorCodeSessionNotFound, "Session not found")
+		return
+	}
+
+	// Set the content type
+	w.Header().Set("Content-Type", "application/json")
+
+	// Return the message log
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"session_id": session.ID,
+		"messages":   s.walletClient.GetMessageLog(session),
+	}); err != nil {
+		s.logger.Error(fmt.Sprintf("Failed to encode JSON response: %v", err))
+		writeJSONError(w, http.StatusInternalServerError, errorCodeInternal, "Internal Server Error")
+		return
+	}
+}
+
+// handleDebugSessions handles the debug sessions API endpoint, returning all
+// sessions, optionally filtered by ?status=
+func (s *Server) handleDebugSessions(w http.ResponseWriter, r *http.Request) {
+	// Sessions are only exposed in debug mode
+	if !s.config.Debug {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+
+	// Collect the sessions, skipping those of other statuses when filtering
+	status := r.URL.Query().Get("status")
+	sessions := make([]json.RawMessage, 0)
+	for _, session := range s.walletClient.GetAllSessions() {
+		if status != "" && string(session.Status) != status {
+			continue
+		}
+		sessionJSON, err := session.ToJSON()
+		if err != nil {
+			s.logger.Error(fmt.Sprintf("Failed to encode session %s: %v", session.ID, err))
+			writeJSONError(w, http.StatusInternalServerError, errorCodeInternal, "Internal Server Error")
+			return
+		}
+		sessions = append(sessions, json.RawMessage(sessionJSON))
+	}
+
+	// Set the content type
+	w.Header().Set("Content-Type", "application/json")
+
+	// Return the sessions
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"count":    len(sessions),
+		"sessions": sessions,
+	}); err != nil {
+		s.logger.Error(fmt.Sprintf("Failed to encode JSON response: %v", err))
+		writeJSONError(w, http.StatusInternalServerError, errorCodeInternal, "Internal Server Error")
+		return
+	}
+}
+
+// recentLogSource is implemented by loggers that keep recent lines in memory
+type recentLogSource interface {
+	RingBufferEnabled() bool
+	RecentLines(n int) []string
+}
+
+// handleAdminLogs handles the admin logs endpoint, returning the most recent
+// log lines as JSON, or as plain text with ?format=text
+func (s *Server) handleAdminLogs(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+
+	source, ok := s.logger.(recentLogSource)
+	if !ok || !source.RingBufferEnabled() {
+		writeJSONError(w, http.StatusNotFound, errorCodeNotFound, "Log buffer is not enabled")
+		return
+	}
+
+	// Get the number of lines from the query parameters
+	n := 200
+	if value := r.URL.Query().Get("n"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, "Invalid n")
+			return
+		}
+		n = parsed
+	}
+
+	lines := source.RecentLines(n)
+
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, line := range lines {
+			fmt.Fprintln(w, line)
+		}
+		return
+	}
+
+	// Set the content type
+	w.Header().Set("Content-Type", "application/json")
+
+	// Return the log lines
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"lines": lines,
+	}); err != nil {
+		s.logger.Error(fmt.Sprintf("Failed to encode JSON response: %v", err))
+		writeJSONError(w, http.StatusInternalServerError, errorCodeInternal, "Internal Server Error")
+		return
+	}
+}
+
+// handleHealth handles the liveness probe endpoint, which succeeds while the process serves requests
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	// Set the content type
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "ok",
+	}); err != nil {
+		s.logger.Error(fmt.Sprintf("Failed to encode JSON response: %v", err))
+	}
+}
+
+// handleReady handles the readiness probe endpoint, which succeeds once the
+// relay is delivering messages and the HTTP listener is up
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	status := "ready"
+	if !s.ready.Load() {
+		status = "not ready"
+	}
+
+	// Set the content type
+	w.Header().Set("Content-Type", "application/json")
+	if status != "ready" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": status,
+	}); err != nil {
+		s.logger.Error(fmt.Sprintf("Failed to encode JSON response: %v", err))
+	}
+}
+
+// handleMetrics handles the metrics API endpoint
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+
+	// Set the content type
+	w.Header().Set("Content-Type", "application/json")
+
+	// Return the metrics
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"sessions": s.walletClient.GetLifecycleMetrics(),
+	}); err != nil {
+		s.logger.Error(fmt.Sprintf("Failed to encode JSON response: %v", err))
+		writeJSONError(w, http.StatusInternalServerError, errorCodeInternal, "Internal Server Error")
 		return
 	}
 }
@@ -247,7 +801,7 @@ func (s *Server) handleDisconnectSession(w http.ResponseWriter, r *http.Request)
 func (s *Server) handleSignMessage(w http.ResponseWriter, r *http.Request) {
 	// Only allow POST requests
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		writeJSONError(w, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed, "Method Not Allowed")
 		return
 	}
 
@@ -255,42 +809,81 @@ func (s *Server) handleSignMessage(w http.ResponseWriter, r *http.Request) {
 	var request struct {
 		SessionID string `json:"session_id"`
 		Message   string `json:"message"`
+		Address   string `json:"address"`
 	}
 
 	err := json.NewDecoder(r.Body).Decode(&request)
 	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, "Invalid request body")
 		return
 	}
 
 	// Validate the request
 	if request.SessionID == "" {
-		http.Error(w, "Missing session ID", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, "Missing session ID")
 		return
 	}
 	if request.Message == "" {
-		http.Error(w, "Missing message", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, "Missing message")
 		return
 	}
 
+	// The signer address is optional, but if given it must be a well-formed address
+	var address common.Address
+	if request.Address != "" {
+		address, err = wallet.ParseAddress(request.Address)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, err.Error())
+			return
+		}
+	}
+
 	// Get the session
 	session := s.walletClient.GetSession(request.SessionID)
 	if session == nil {
-		http.Error(w, "Session not found", http.StatusNotFound)
+		writeJSONError(w, http.StatusNotFound, errorCodeSessionNotFound, "Session not found")
 		return
 	}
 
 	// Check if the session is active
-	if session.Status != "active" {
-		http.Error(w, "Session is not active", http.StatusBadRequest)
+	if session.Status != wallet.SessionStatusActive {
+		writeJSONError(w, http.StatusBadRequest, errorCodeSessionNotActive, "Session is not active")
+		return
+	}
+
+	// The wallet can only sign for the address it connected with
+	if request.Address != "" && address != session.WalletAddress {
+		writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, fmt.Sprintf("Address %s does not match the session's wallet address", address.Hex()))
 		return
 	}
 
+	// Allow the response to outlive the server's default write timeout while we wait for the wallet
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Now().Add(signRequestTimeout + 5*time.Second)); err != nil {
+		s.logger.Warn(fmt.Sprintf("Failed to extend write deadline: %v", err))
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), signRequestTimeout)
+	defer cancel()
+
 	// Sign the message
-	signature, err := s.walletClient.SignMessage(session, request.Message)
+	signature, err := s.walletClient.SignMessage(ctx, session, request.Message)
 	if err != nil {
 		s.logger.Error(fmt.Sprintf("Failed to sign message: %v", err))
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		switch {
+		case errors.Is(err, wallet.ErrSessionNotActive):
+			writeJSONError(w, http.StatusBadRequest, errorCodeSessionNotActive, "Session is not active")
+		case errors.Is(err, wallet.ErrSignMethodNotAllowed):
+			writeJSONError(w, http.StatusForbidden, errorCodeSignMethodNotAllowed, "Sign method not allowed")
+		case errors.Is(err, wallet.ErrInvalidAddress):
+			writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, err.Error())
+		case errors.Is(err, context.DeadlineExceeded):
+			writeJSONError(w, http.StatusGatewayTimeout, errorCodeTimeout, "Timed out waiting for wallet")
+		case errors.As(err, new(*wallet.ResponseError)):
+			writeJSONError(w, http.StatusBadGateway, errorCodeWalletRejected, fmt.Sprintf("Wallet rejected request: %v", err))
+		default:
+			writeJSONError(w, http.StatusInternalServerError, errorCodeInternal, "Internal Server Error")
+		}
 		return
 	}
 
@@ -298,11 +891,312 @@ func (s *Server) handleSignMessage(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	// Return the signature
-	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+	response := map[string]interface{}{
 		"signature": signature,
+		"address":   session.WalletAddress.Hex(),
+	}
+	if s.walletClient.DemoWallet() != nil {
+		response["demo_signed"] = true
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.logger.Error(fmt.Sprintf("Failed to encode JSON response: %v", err))
+		writeJSONError(w, http.StatusInternalServerError, errorCodeInternal, "Internal Server Error")
+		return
+	}
+}
+
+// handleSendTransaction handles the send transaction API endpoint. It sends an
+// eth_sendTransaction request to the wallet and waits for the transaction hash.
+func (s *Server) handleSendTransaction(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+
+	// Parse the request body
+	var request struct {
+		SessionID   string          `json:"session_id"`
+		Transaction wallet.TxParams `json:"transaction"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	// Validate the request
+	if request.SessionID == "" {
+		writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, "Missing session ID")
+		return
+	}
+	if request.Transaction.From == "" {
+		writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, "Missing transaction sender")
+		return
+	}
+
+	// Get the session
+	session := s.walletClient.GetSession(request.SessionID)
+	if session == nil {
+		writeJSONError(w, http.StatusNotFound, errorCodeSessionNotFound, "Session not found")
+		return
+	}
+
+	// Allow the response to outlive the server's default write timeout while we wait for the wallet
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Now().Add(signRequestTimeout + 5*time.Second)); err != nil {
+		s.logger.Warn(fmt.Sprintf("Failed to extend write deadline: %v", err))
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), signRequestTimeout)
+	defer cancel()
+
+	// Send the transaction
+	txHash, err := s.walletClient.SendTransaction(ctx, session, request.Transaction)
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("Failed to send transaction: %v", err))
+		switch {
+		case errors.Is(err, wallet.ErrSessionNotActive):
+			writeJSONError(w, http.StatusBadRequest, errorCodeSessionNotActive, "Session is not active")
+		case errors.Is(err, wallet.ErrSignMethodNotAllowed):
+			writeJSONError(w, http.StatusForbidden, errorCodeSignMethodNotAllowed, err.Error())
+		case errors.Is(err, wallet.ErrNotSupportedByDemoWallet):
+			writeJSONError(w, http.StatusNotImplemented, errorCodeNotSupported, err.Error())
+		case errors.Is(err, wallet.ErrInvalidAddress), errors.Is(err, wallet.ErrInvalidTransaction),
+			errors.Is(err, wallet.ErrSenderMismatch):
+			writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, err.Error())
+		case errors.Is(err, context.DeadlineExceeded):
+			writeJSONError(w, http.StatusGatewayTimeout, errorCodeTimeout, "Timed out waiting for wallet")
+		case errors.As(err, new(*wallet.ResponseError)):
+			writeJSONError(w, http.StatusBadGateway, errorCodeWalletRejected, fmt.Sprintf("Wallet rejected request: %v", err))
+		default:
+			writeJSONError(w, http.StatusInternalServerError, errorCodeInternal, "Internal Server Error")
+		}
+		return
+	}
+
+	// Set the content type
+	w.Header().Set("Content-Type", "application/json")
+
+	// Return the transaction hash
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"tx_hash": txHash,
 	}); err != nil {
 		s.logger.Error(fmt.Sprintf("Failed to encode JSON response: %v", err))
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, errorCodeInternal, "Internal Server Error")
+		return
+	}
+}
+
+// handleBatchSignatures handles the batch signature details API endpoint. Each
+// signature is verified independently; all_valid reports whether every one of
+// them could be verified.
+func (s *Server) handleBatchSignatures(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+
+	// Parse the request body
+	var request struct {
+		Items []wallet.SignatureItem `json:"items"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	// Validate the request
+	if len(request.Items) == 0 {
+		writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, "Missing items")
+		return
+	}
+	if len(request.Items) > maxBatchSignatures {
+		writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, fmt.Sprintf("Too many items, at most %d are allowed", maxBatchSignatures))
+		return
+	}
+
+	// Verify the signatures
+	results, err := s.walletClient.GetBatchSignatureDetails(request.Items)
+	if err != nil {
+		s.logger.Warn(fmt.Sprintf("Some batch signatures could not be verified: %v", err))
+	}
+
+	// Set the content type
+	w.Header().Set("Content-Type", "application/json")
+
+	// Return the per-item details
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"results":   results,
+		"all_valid": err == nil,
+	}); err != nil {
+		s.logger.Error(fmt.Sprintf("Failed to encode JSON response: %v", err))
+		writeJSONError(w, http.StatusInternalServerError, errorCodeInternal, "Internal Server Error")
+		return
+	}
+}
+
+// handleVerifySignature handles the verify signature API endpoint.
+// It checks a personal_sign signature against an expected signer and returns
+// the recovered address along with the signature's components.
+func (s *Server) handleVerifySignature(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+
+	// Parse the request body
+	var request struct {
+		Message         string `json:"message"`
+		Signature       string `json:"signature"`
+		ExpectedAddress string `json:"expected_address"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	// Validate the request
+	if request.Message == "" {
+		writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, "Missing message")
+		return
+	}
+	if request.Signature == "" {
+		writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, "Missing signature")
+		return
+	}
+	expectedAddress, err := wallet.ParseAddress(request.ExpectedAddress)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, fmt.Sprintf("Invalid expected_address: %v", err))
+		return
+	}
+
+	// Recover the signer; a signature that cannot be decoded or recovered is
+	// malformed input
+	details, err := s.walletClient.GetSignatureDetails(request.Message, request.Signature)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, fmt.Sprintf("Invalid signature: %v", err))
+		return
+	}
+	valid, err := wallet.VerifySignature(request.Message, request.Signature, expectedAddress)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, fmt.Sprintf("Invalid signature: %v", err))
+		return
+	}
+
+	// Set the content type
+	w.Header().Set("Content-Type", "application/json")
+
+	// Return the verification result
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"valid":             valid,
+		"recovered_address": details["recovered_address"],
+		"r":                 details["r"],
+		"s":                 details["s"],
+		"v":                 details["v"],
+		"message_hash":      details["message_hash"],
+	}); err != nil {
+		s.logger.Error(fmt.Sprintf("Failed to encode JSON response: %v", err))
+		writeJSONError(w, http.StatusInternalServerError, errorCodeInternal, "Internal Server Error")
+		return
+	}
+}
+
+// handleSignTypedData handles the sign typed data API endpoint.
+// It validates the EIP-712 typed data, sends an eth_signTypedData_v4 request
+// to the wallet, waits for the signature and verifies it.
+func (s *Server) handleSignTypedData(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+
+	// Parse the request body
+	var request struct {
+		SessionID string          `json:"session_id"`
+		TypedData json.RawMessage `json:"typed_data"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	// Validate the request
+	if request.SessionID == "" {
+		writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, "Missing session ID")
+		return
+	}
+	if len(request.TypedData) == 0 {
+		writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, "Missing typed data")
+		return
+	}
+
+	// The typed data may be sent either as an object or as a JSON-encoded string
+	typedData := []byte(request.TypedData)
+	var typedDataString string
+	if err := json.Unmarshal(request.TypedData, &typedDataString); err == nil {
+		typedData = []byte(typedDataString)
+	}
+
+	// Get the session
+	session := s.walletClient.GetSession(request.SessionID)
+	if session == nil {
+		writeJSONError(w, http.StatusNotFound, errorCodeSessionNotFound, "Session not found")
+		return
+	}
+
+	// Allow the response to outlive the server's default write timeout while we wait for the wallet
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Now().Add(signRequestTimeout + 5*time.Second)); err != nil {
+		s.logger.Warn(fmt.Sprintf("Failed to extend write deadline: %v", err))
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), signRequestTimeout)
+	defer cancel()
+
+	// Sign the typed data
+	result, err := s.walletClient.SignTypedData(ctx, session, typedData)
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("Failed to sign typed data: %v", err))
+		switch {
+		case errors.Is(err, wallet.ErrSessionNotActive):
+			writeJSONError(w, http.StatusBadRequest, errorCodeSessionNotActive, "Session is not active")
+		case errors.Is(err, wallet.ErrSignMethodNotAllowed):
+			writeJSONError(w, http.StatusForbidden, errorCodeSignMethodNotAllowed, "Sign method not allowed")
+		case errors.Is(err, context.DeadlineExceeded):
+			writeJSONError(w, http.StatusGatewayTimeout, errorCodeTimeout, "Timed out waiting for wallet")
+		case errors.As(err, new(*wallet.ResponseError)):
+			writeJSONError(w, http.StatusBadGateway, errorCodeWalletRejected, fmt.Sprintf("Wallet rejected request: %v", err))
+		case errors.Is(err, wallet.ErrInvalidTypedData):
+			writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, err.Error())
+		default:
+			writeJSONError(w, http.StatusInternalServerError, errorCodeInternal, "Internal Server Error")
+		}
+		return
+	}
+
+	// Set the content type
+	w.Header().Set("Content-Type", "application/json")
+
+	// Return the verified signature
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"signature":         result.Signature,
+		"valid":             result.Valid,
+		"recovered_address": result.RecoveredAddress.Hex(),
+	}); err != nil {
+		s.logger.Error(fmt.Sprintf("Failed to encode JSON response: %v", err))
+		writeJSONError(w, http.StatusInternalServerError, errorCodeInternal, "Internal Server Error")
 		return
 	}
 }
@@ -311,3 +1205,29 @@ func (s *Server) handleSignMessage(w http.ResponseWriter, r *http.Request) {
 func (s *Server) GetSignatureDetails(message, signature string) (map[string]string, error) {
 	return s.walletClient.GetSignatureDetails(message, signature)
 }
+
+// parseQRSizes parses a comma-separated list of QR code sizes, e.g. "128,256,512"
+func parseQRSizes(value string) ([]int, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(value, ",")
+	if len(parts) > utils.MaxQRCodeSizes {
+		return nil, fmt.Errorf("at most %d sizes are allowed", utils.MaxQRCodeSizes)
+	}
+
+	sizes := make([]int, 0, len(parts))
+	for _, part := range parts {
+		size, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("invalid size %q", part)
+		}
+		if size < utils.MinQRCodeSize || size > utils.MaxQRCodeSize {
+			return nil, fmt.Errorf("size %d out of range (%d-%d)", size, utils.MinQRCodeSize, utils.MaxQRCodeSize)
+		}
+		sizes = append(sizes, size)
+	}
+
+	return sizes, nil
+}
@@ -1,8 +1,12 @@
 package server
 
 import (
+	"compress/gzip"
+	"crypto/subtle"
+	"io"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -58,6 +62,67 @@ func CORSMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// gzipResponseWriter writes the response body through a gzip writer
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	writer io.Writer
+}
+
+// Write compresses the response body
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	return w.writer.Write(b)
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// GzipMiddleware compresses responses for clients that accept gzip
+func GzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Del("Content-Length")
+
+		next.ServeHTTP(&gzipResponseWriter{ResponseWriter: w, writer: gz}, r)
+	})
+}
+
+// AdminMiddleware restricts a handler to admins. When token is set, requests
+// must carry it as a bearer token; otherwise access is only allowed in debug mode.
+func AdminMiddleware(token string, debug bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				if !debug {
+					writeJSONError(w, http.StatusForbidden, errorCodeForbidden, "Forbidden")
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				writeJSONError(w, http.StatusUnauthorized, errorCodeUnauthorized, "Unauthorized")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // ApplyMiddleware applies middleware to a handler
 func ApplyMiddleware(h http.Handler, middleware ...func(http.Handler) http.Handler) http.Handler {
 	for _, m := range middleware {
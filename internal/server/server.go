@@ -2,13 +2,21 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/korjavin/wctestapp/internal/config"
+	"github.com/korjavin/wctestapp/internal/logger"
+	"github.com/korjavin/wctestapp/internal/metrics"
 	"github.com/korjavin/wctestapp/internal/relay"
 	"github.com/korjavin/wctestapp/internal/wallet"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // Server represents the HTTP server
@@ -18,23 +26,112 @@ type Server struct {
 	relayServer  *relay.RelayServer
 	walletClient *wallet.WalletClient
 	logger       Logger
+
+	// Last lifecycle event per session ID, so the UI can reflect transient states
+	sessionEvents map[string]wallet.SessionEvent
+	eventsMutex   sync.RWMutex
+
+	// Set once the relay workers run and the HTTP listener is up, and cleared on shutdown
+	ready atomic.Bool
+	// Set by Shutdown; requests that still arrive are refused
+	shuttingDown atomic.Bool
+
+	// Browser WebSocket API connections. They are hijacked, so the HTTP
+	// server does not track them and Shutdown closes them itself.
+	clients       map[*clientConn]struct{}
+	clientsClosed bool // set by Shutdown; new connections are refused
+	clientsMutex  sync.Mutex
+	clientsWg     sync.WaitGroup // tracks the connection handlers
 }
 
 // Logger interface for logging
-type Logger interface {
-	Debug(msg string)
-	Info(msg string)
-	Warn(msg string)
-	Error(msg string)
-}
+type Logger = logger.FieldLogger
 
 // NewServer creates a new server
 func NewServer(config *config.Config, logger Logger) *Server {
 	// Create the relay server
 	relayServer := relay.NewRelayServer(logger)
+	relayServer.SetMessageWorkers(config.MessageWorkers)
+	relayServer.SetMaxConnectionAge(config.MaxConnectionAge)
+	relayServer.SetMaxConnections(config.MaxConnections)
+	relayServer.SetIdleTimeout(config.IdleTimeout)
+	relayServer.SetMaxMessageTTL(config.MaxMessageTTL)
+	relayServer.SetMaxMessageSize(config.MaxMessageSize)
+	relayServer.SetRejectPublishNoSubscribers(config.RejectPublishNoSubscribers)
+	relayServer.SetPublishRateLimit(config.PublishRateLimit, config.PublishRateBurst)
+	relayServer.SetAllowedOrigins(config.AllowedOrigins)
+	if len(config.TopicAllowPatterns) > 0 {
+		authorizer, err := relay.NewPatternTopicAuthorizer(config.TopicAllowPatterns)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Failed to restrict relay topics, allowing all topics: %v", err))
+		} else {
+			relayServer.SetTopicAuthorizer(authorizer)
+		}
+	}
+	relayServer.SetUpstreamReconnect(config.UpstreamReconnect)
+	relayServer.SetUpstreamRelayURL(config.UpstreamRelayURL)
+	relayServer.SetLogFrameStats(config.LogFrameStats)
+	relayServer.SetJSONRPCVersion(config.JSONRPCVersion, config.StrictJSONRPC)
+	relayServer.SetRecordFramePayloads(config.Debug)
+	relayServer.SetClockSkewTolerance(config.ClockSkewTolerance)
+	if config.DeadLetterPath != "" {
+		if sink, err := relay.NewFileDeadLetterSink(config.DeadLetterPath, logger); err != nil {
+			logger.Error(fmt.Sprintf("Failed to open dead-letter file %s, discarding undeliverable messages: %v", config.DeadLetterPath, err))
+		} else {
+			relayServer.SetDeadLetterSink(sink)
+		}
+	}
 
 	// Create the wallet client
-	walletClient := wallet.NewWalletClient(config.RelayWebSocketURL(), logger)
+	relayURLs := append([]string{config.RelayWebSocketURL()}, config.FallbackRelayURLs...)
+	walletClient := wallet.NewWalletClient(relayURLs, logger)
+	walletClient.SetAllowedSignMethods(config.AllowedSignMethods)
+	walletClient.SetRequireSecureRelay(config.RequireSecureRelay)
+	if config.RelayCAFile != "" || config.RelayInsecureSkipVerify {
+		if config.RelayInsecureSkipVerify {
+			logger.Warn("RELAY_INSECURE_SKIP_VERIFY is enabled: relay certificates are not verified")
+		}
+		if tlsConfig, err := wallet.LoadRelayTLSConfig(config.RelayCAFile, config.RelayInsecureSkipVerify); err != nil {
+			logger.Error(fmt.Sprintf("Failed to load relay TLS config, using system defaults: %v", err))
+		} else {
+			walletClient.SetTLSConfig(tlsConfig)
+		}
+	}
+	walletClient.SetSingleTopicMode(config.SingleTopicMode)
+	walletClient.SetSessionTTL(config.SessionTTL)
+	walletClient.SetClockSkewTolerance(config.ClockSkewTolerance)
+	walletClient.SetLogFrameStats(config.LogFrameStats)
+	walletClient.SetMessageLog(config.Debug, config.LogSecrets)
+	walletClient.SetDisconnectGracePeriod(config.DisconnectGracePeriod)
+
+	reconnectPolicy := wallet.DefaultReconnectPolicy()
+	reconnectPolicy.MaxRetries = config.ReconnectMaxRetries
+	walletClient.SetReconnectPolicy(reconnectPolicy)
+	walletClient.SetPingInterval(config.WalletPingInterval, 0)
+	walletClient.SetRPCURL(config.EthRPCURL)
+	walletClient.SetMaxMessageSize(config.MaxMessageSize)
+
+	switch config.SessionStore {
+	case "memory":
+	case "file":
+		if err := walletClient.SetSessionStore(wallet.NewFileSessionStore(config.SessionStorePath)); err != nil {
+			logger.Error(fmt.Sprintf("Failed to open session store %s, keeping sessions in memory: %v", config.SessionStorePath, err))
+		}
+	default:
+		logger.Warn(fmt.Sprintf("Unknown session store %q, keeping sessions in memory", config.SessionStore))
+	}
+
+	// The demo wallet signs with a key held by the server, so it is only allowed in debug mode
+	if config.DemoWallet {
+		if !config.Debug {
+			logger.Warn("DEMO_WALLET is ignored because DEBUG is disabled")
+		} else if demo, err := wallet.NewDemoWallet(config.DemoWalletKey); err != nil {
+			logger.Error(fmt.Sprintf("Failed to create demo wallet: %v", err))
+		} else {
+			logger.Warn(fmt.Sprintf("Demo wallet mode enabled: sessions are signed in-process by %s", demo.Address().Hex()))
+			walletClient.SetDemoWallet(demo)
+		}
+	}
 
 	// Create the HTTP server
 	httpServer := &http.Server{
@@ -44,31 +141,72 @@ func NewServer(config *config.Config, logger Logger) *Server {
 		IdleTimeout:  60 * time.Second,
 	}
 
-	return &Server{
-		config:       config,
-		httpServer:   httpServer,
-		relayServer:  relayServer,
-		walletClient: walletClient,
-		logger:       logger,
+	server := &Server{
+		config:        config,
+		httpServer:    httpServer,
+		relayServer:   relayServer,
+		walletClient:  walletClient,
+		logger:        logger,
+		sessionEvents: make(map[string]wallet.SessionEvent),
+		clients:       make(map[*clientConn]struct{}),
+	}
+
+	// Track session events for the status endpoint
+	walletClient.OnSessionEvent(server.recordSessionEvent)
+
+	if config.EnableMetrics {
+		err := metrics.Register(prometheus.DefaultRegisterer,
+			metrics.NewLabeledGaugeFunc("wctestapp_relay_topic_subscriptions",
+				"Number of relay subscriptions per topic.", "topic", relayServer.GetTopicSubscriptionCounts),
+			metrics.NewLabeledGaugeFunc("wctestapp_wallet_sessions",
+				"Number of wallet client sessions by status.", "status", walletClient.GetSessionCountsByStatus),
+		)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Failed to register Prometheus metrics: %v", err))
+		}
 	}
+
+	return server
 }
 
-// Start starts the server
-func (s *Server) Start() error {
-	// Create a new router
-	router := http.NewServeMux()
+// recordSessionEvent stores the latest event for a session, and forgets the
+// session's events once it is removed
+func (s *Server) recordSessionEvent(session *wallet.Session, event wallet.SessionEvent) {
+	s.eventsMutex.Lock()
+	defer s.eventsMutex.Unlock()
 
-	// Set up routes
-	s.setupRoutes(router)
+	if event == wallet.SessionEventRemoved {
+		delete(s.sessionEvents, session.ID)
+		return
+	}
+	s.sessionEvents[session.ID] = event
+}
+
+// lastSessionEvent returns the latest event recorded for a session
+func (s *Server) lastSessionEvent(sessionID string) wallet.SessionEvent {
+	s.eventsMutex.RLock()
+	defer s.eventsMutex.RUnlock()
 
+	return s.sessionEvents[sessionID]
+}
+
+// Start starts the server
+func (s *Server) Start() error {
 	// Set the router as the HTTP handler
-	s.httpServer.Handler = router
+	s.httpServer.Handler = s.handler()
 
 	// Start the relay server
 	s.relayServer.Start()
 
 	// Start the wallet client cleanup task
-	s.walletClient.StartCleanupTask()
+	s.walletClient.StartCleanupTask(s.config.CleanupInterval)
+
+	// Resume relay subscriptions for restored sessions once the relay is listening
+	go func() {
+		if err := s.walletClient.ResumeSessions(context.Background()); err != nil {
+			s.logger.Error(fmt.Sprintf("Failed to resume sessions: %v", err))
+		}
+	}()
 
 	// Log the external URL
 	s.logger.Info(fmt.Sprintf("External URL: %s", s.config.ExternalURL()))
@@ -76,16 +214,81 @@ func (s *Server) Start() error {
 
 	// Start the HTTP server
 	s.logger.Info(fmt.Sprintf("Starting server on %s", s.config.ServerAddress()))
+	listener, err := net.Listen("tcp", s.httpServer.Addr)
+	if err != nil {
+		return err
+	}
+
+	// The relay workers were started above, so the server is ready once it listens
+	s.ready.Store(true)
+
 	if s.config.EnableTLS {
-		return s.httpServer.ListenAndServeTLS(s.config.CertFile, s.config.KeyFile)
+		return s.httpServer.ServeTLS(listener, s.config.CertFile, s.config.KeyFile)
 	}
-	return s.httpServer.ListenAndServe()
+	return s.httpServer.Serve(listener)
 }
 
-// Shutdown gracefully shuts down the server
+// Shutdown gracefully shuts down the server.
+// Teardown is ordered so that no new work arrives while the server is torn
+// down: the HTTP server stops accepting connections first, and requests that
+// still arrive on open connections are refused. Then browser WebSocket API
+// connections are sent close frames, the wallet client closes its relay
+// connections, and the relay sends close frames to its remaining clients and
+// shuts down. Finally the HTTP server finishes draining the requests in
+// flight, which the closed wallet client ends. WebSocket connections are
+// hijacked, so the HTTP server neither tracks nor waits for them; they are
+// closed by the browser connection and relay steps. All steps share the
+// deadline of ctx.
 func (s *Server) Shutdown(ctx context.Context) error {
 	s.logger.Info("Shutting down server")
-	return s.httpServer.Shutdown(ctx)
+
+	// Fail readiness probes and refuse new requests while draining
+	s.ready.Store(false)
+	s.shuttingDown.Store(true)
+
+	// http.Server.Shutdown closes the listeners at once and then waits for
+	// the requests in flight, which may need the teardown below to end
+	httpDone := make(chan error, 1)
+	go func() {
+		httpDone <- s.httpServer.Shutdown(ctx)
+	}()
+
+	var errs []error
+
+	if err := s.closeClients(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("browser connections: %w", err))
+	}
+
+	if err := s.walletClient.Close(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("wallet client: %w", err))
+	}
+
+	if err := s.relayServer.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("relay server: %w", err))
+	}
+
+	if err := <-httpDone; err != nil {
+		errs = append(errs, fmt.Errorf("http server: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// handler returns the server's HTTP handler: its routes, refusing requests
+// once the server is shutting down
+func (s *Server) handler() http.Handler {
+	router := http.NewServeMux()
+	s.setupRoutes(router)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Probes still get their answer, so that they see the server is not ready
+		if s.shuttingDown.Load() && r.URL.Path != "/healthz" && r.URL.Path != "/readyz" {
+			w.Header().Set("Connection", "close")
+			writeJSONError(w, http.StatusServiceUnavailable, errorCodeShuttingDown, "Server is shutting down")
+			return
+		}
+		router.ServeHTTP(w, r)
+	})
 }
 
 // setupRoutes sets up the HTTP routes
@@ -95,13 +298,43 @@ func (s *Server) setupRoutes(router *http.ServeMux) {
 	router.Handle("/static/", http.StripPrefix("/static/", fs))
 
 	// WebSocket relay endpoint
-	router.HandleFunc("/relay", s.relayServer.HandleWebSocket)
+	router.HandleFunc(s.config.RelayPath, s.relayServer.HandleWebSocket)
 
 	// API endpoints
-	router.HandleFunc("/api/session/create", s.handleCreateSession)
+	router.Handle("/api/session/create", GzipMiddleware(http.HandlerFunc(s.handleCreateSession)))
 	router.HandleFunc("/api/session/status", s.handleSessionStatus)
+	router.HandleFunc("/api/session/events", s.handleSessionEvents)
+	router.HandleFunc("/api/ws", s.handleClientWS)
+	router.HandleFunc("/api/session/accounts", s.handleSessionAccounts)
 	router.HandleFunc("/api/session/disconnect", s.handleDisconnectSession)
 	router.HandleFunc("/api/message/sign", s.handleSignMessage)
+	router.HandleFunc("/api/message/sign-typed", s.handleSignTypedData)
+	router.HandleFunc("/api/message/sendTransaction", s.handleSendTransaction)
+	router.HandleFunc("/api/message/verify", s.handleVerifySignature)
+	router.HandleFunc("/api/signature/batch", s.handleBatchSignatures)
+	router.HandleFunc("/api/relay/stats", s.handleRelayStats)
+
+	// Health endpoints for probes
+	router.HandleFunc("/healthz", s.handleHealth)
+	router.HandleFunc("/readyz", s.handleReady)
+
+	// Admin endpoints
+	admin := AdminMiddleware(s.config.AdminToken, s.config.Debug)
+	router.Handle("/api/admin/session/reconnect", admin(http.HandlerFunc(s.handleReconnectSession)))
+	router.Handle("/api/admin/cleanup", admin(http.HandlerFunc(s.handleCleanup)))
+	router.Handle("/api/relay/clients", admin(http.HandlerFunc(s.handleRelayClients)))
+	router.Handle("/api/admin/frames", admin(http.HandlerFunc(s.handleRelayFrames)))
+	router.Handle("/api/admin/replay", admin(http.HandlerFunc(s.handleReplay)))
+	router.Handle("/admin/logs", admin(http.HandlerFunc(s.handleAdminLogs)))
+	router.Handle("/api/session/messages", admin(http.HandlerFunc(s.handleSessionMessages)))
+	// Outside debug mode the sessions listing is not routed, so it is a plain 404
+	if s.config.Debug {
+		router.Handle("/api/debug/sessions", admin(http.HandlerFunc(s.handleDebugSessions)))
+	}
+	router.HandleFunc("/api/metrics", s.handleMetrics)
+	if s.config.EnableMetrics {
+		router.Handle("/metrics", promhttp.Handler())
+	}
 
 	// Web pages
 	router.HandleFunc("/", s.handleIndex)
@@ -0,0 +1,449 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/korjavin/wctestapp/internal/config"
+	"github.com/korjavin/wctestapp/internal/logger"
+	"github.com/korjavin/wctestapp/internal/wallet"
+)
+
+// testTimeout bounds every wait in these tests
+const testTimeout = 5 * time.Second
+
+func newTestLogger() *logger.Logger {
+	return logger.NewLogger(logger.ErrorLevel, "test", logger.TextFormat)
+}
+
+// newTestConfig returns a configuration for a server on the loopback interface
+func newTestConfig() *config.Config {
+	cfg := config.DefaultConfig()
+	cfg.ServerHost = "127.0.0.1"
+	cfg.Debug = false
+	cfg.AdminToken = "admin-token"
+	return cfg
+}
+
+// startTestServer serves a server's routes from a test HTTP server, with the
+// relay running and the wallet client pointed at it, and returns the test
+// server's URL. configure, if not nil, adjusts the configuration before the
+// server is created. The server is shut down when the test ends.
+func startTestServer(t *testing.T, configure func(*config.Config)) (*Server, string) {
+	t.Helper()
+
+	ts := httptest.NewUnstartedServer(nil)
+	cfg := newTestConfig()
+	cfg.ServerURL = "http://" + ts.Listener.Addr().String()
+	if configure != nil {
+		configure(cfg)
+	}
+
+	s := NewServer(cfg, newTestLogger())
+	ts.Config.Handler = s.handler()
+	s.relayServer.Start()
+	ts.Start()
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			t.Errorf("server shutdown: %v", err)
+		}
+		ts.Close()
+	})
+
+	return s, ts.URL
+}
+
+// doRequest sends a request to the test server, with the admin token if admin is set
+func doRequest(t *testing.T, method, url string, body string, admin bool) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if admin {
+		req.Header.Set("Authorization", "Bearer admin-token")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// decodeJSON decodes a JSON response body
+func decodeJSON(t *testing.T, resp *http.Response, v any) {
+	t.Helper()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("decode %s: %v", data, err)
+	}
+}
+
+// expectJSONError checks that a response is a JSON API error with the given status and code
+func expectJSONError(t *testing.T, resp *http.Response, status int, code string) apiError {
+	t.Helper()
+
+	if resp.StatusCode != status {
+		t.Errorf("got status %d, want %d", resp.StatusCode, status)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("got content type %q, want application/json", contentType)
+	}
+
+	var body map[string]apiError
+	decodeJSON(t, resp, &body)
+	apiErr, ok := body["error"]
+	if !ok || len(body) != 1 {
+		t.Fatalf("got body %v, want only an error object", body)
+	}
+	if apiErr.Code != code || apiErr.Message == "" {
+		t.Errorf("got error %+v, want code %s with a message", apiErr, code)
+	}
+	return apiErr
+}
+
+func TestAPIErrorsAreJSON(t *testing.T) {
+	_, url := startTestServer(t, nil)
+
+	resp := doRequest(t, http.MethodPost, url+"/api/session/create?sizes=abc", "", false)
+	if apiErr := expectJSONError(t, resp, http.StatusBadRequest, errorCodeInvalidRequest); !strings.Contains(apiErr.Message, "abc") {
+		t.Errorf("got message %q, want it to name the invalid size", apiErr.Message)
+	}
+
+	resp = doRequest(t, http.MethodPost, url+"/api/message/verify", "{", false)
+	expectJSONError(t, resp, http.StatusBadRequest, errorCodeInvalidRequest)
+
+	resp = doRequest(t, http.MethodGet, url+"/api/session/create", "", false)
+	expectJSONError(t, resp, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed)
+
+	resp = doRequest(t, http.MethodPost, url+"/api/admin/cleanup", "", false)
+	expectJSONError(t, resp, http.StatusUnauthorized, errorCodeUnauthorized)
+}
+
+func TestManualCleanupRemovesExpiredSessions(t *testing.T) {
+	s, url := startTestServer(t, func(cfg *config.Config) { cfg.SessionTTL = time.Millisecond })
+	c := s.GetWalletClient()
+
+	for range 2 {
+		if _, err := c.CreateSession(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.SetSessionTTL(time.Hour)
+	live, err := c.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	for _, want := range []int{2, 0} {
+		resp := doRequest(t, http.MethodPost, url+"/api/admin/cleanup", "", true)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("got status %d, want 200", resp.StatusCode)
+		}
+		var body struct {
+			Removed int `json:"removed"`
+		}
+		decodeJSON(t, resp, &body)
+		if body.Removed != want {
+			t.Errorf("cleanup removed %d sessions, want %d", body.Removed, want)
+		}
+	}
+
+	if sessions := c.GetAllSessions(); len(sessions) != 1 || sessions[0] != live {
+		t.Errorf("got %d sessions after the cleanup, want only the live one", len(sessions))
+	}
+}
+
+func TestSessionEventsOfRemovedSessionsAreForgotten(t *testing.T) {
+	s, url := startTestServer(t, func(cfg *config.Config) { cfg.SessionTTL = time.Millisecond })
+	c := s.GetWalletClient()
+
+	expired, err := c.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetSessionTTL(time.Hour)
+	live, err := c.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.recordSessionEvent(expired, wallet.SessionEventReconnectFailed)
+	s.recordSessionEvent(live, wallet.SessionEventReconnected)
+	time.Sleep(10 * time.Millisecond)
+
+	resp := doRequest(t, http.MethodPost, url+"/api/admin/cleanup", "", true)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want 200", resp.StatusCode)
+	}
+
+	s.eventsMutex.RLock()
+	defer s.eventsMutex.RUnlock()
+	if _, ok := s.sessionEvents[expired.ID]; ok || len(s.sessionEvents) != 1 {
+		t.Errorf("got events %v, want only the live session's", s.sessionEvents)
+	}
+	if event := s.sessionEvents[live.ID]; event != wallet.SessionEventReconnected {
+		t.Errorf("got event %q for the live session, want reconnected", event)
+	}
+}
+
+// freePort returns a TCP port on the loopback interface that is free to listen on
+func freePort(t *testing.T) int {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestShutdownLeavesNoGoroutines(t *testing.T) {
+	// Connections of earlier tests may still be winding down
+	http.DefaultClient.CloseIdleConnections()
+	time.Sleep(100 * time.Millisecond)
+	before := runtime.NumGoroutine()
+
+	port := freePort(t)
+	cfg := newTestConfig()
+	cfg.ServerPort = port
+	cfg.ServerURL = fmt.Sprintf("http://127.0.0.1:%d", port)
+	cfg.CleanupInterval = time.Minute
+	s := NewServer(cfg, newTestLogger())
+
+	served := make(chan error, 1)
+	go func() { served <- s.Start() }()
+
+	// Exercise the relay and the wallet client so that both have connections
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	deadline := time.Now().Add(testTimeout)
+	for {
+		resp, err := client.Get(cfg.ServerURL + "/readyz")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server did not become ready: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	resp, err := client.Post(cfg.ServerURL+"/api/session/create", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d creating a session", resp.StatusCode)
+	}
+	if len(s.relayServer.GetClients()) == 0 {
+		t.Fatal("the wallet client is not connected to the relay")
+	}
+
+	// Keep a browser WebSocket API connection open, following a session
+	browser, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d/api/ws", port), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer browser.Close()
+	if err := browser.WriteJSON(clientCommand{ID: json.RawMessage("1"), Type: "createSession"}); err != nil {
+		t.Fatal(err)
+	}
+	var reply clientReply
+	if err := browser.ReadJSON(&reply); err != nil || reply.Type != "result" {
+		t.Fatalf("got reply %+v (%v), want a result", reply, err)
+	}
+	browserClosed := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := browser.ReadMessage(); err != nil {
+				browserClosed <- err
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("Start returned %v, want http.ErrServerClosed", err)
+	}
+	select {
+	case err := <-browserClosed:
+		if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+			t.Errorf("browser connection ended with %v, want a going-away close", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("the browser connection was not closed")
+	}
+	browser.Close()
+
+	// Goroutines exit asynchronously once their connections are closed
+	deadline = time.Now().Add(testTimeout)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			var stacks strings.Builder
+			pprof.Lookup("goroutine").WriteTo(&stacks, 1)
+			t.Fatalf("%d goroutines before the server started, %d after it shut down:\n%s",
+				before, runtime.NumGoroutine(), stacks.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSessionMessagesRequireTheAdminToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		debug  bool
+		token  string
+		admin  bool
+		status int
+		code   string
+	}{
+		{name: "debug without a token", debug: true, status: http.StatusForbidden, code: errorCodeForbidden},
+		{name: "debug without the token in the request", debug: true, token: "admin-token", status: http.StatusUnauthorized, code: errorCodeUnauthorized},
+		{name: "debug with the token", debug: true, token: "admin-token", admin: true, status: http.StatusNotFound, code: errorCodeSessionNotFound},
+		{name: "not debug", token: "admin-token", admin: true, status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, url := startTestServer(t, func(cfg *config.Config) {
+				cfg.Debug = tt.debug
+				cfg.AdminToken = tt.token
+			})
+
+			resp := doRequest(t, http.MethodGet, url+"/api/session/messages?session=unknown", "", tt.admin)
+			if tt.code == "" {
+				if resp.StatusCode != tt.status {
+					t.Errorf("got status %d, want %d", resp.StatusCode, tt.status)
+				}
+				return
+			}
+			expectJSONError(t, resp, tt.status, tt.code)
+		})
+	}
+}
+
+func TestCreateSessionReportsTheSessionWhenTheRelayFails(t *testing.T) {
+	// A listener that never accepts stalls the WebSocket handshake
+	stalled, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stalled.Close()
+
+	tests := []struct {
+		name   string
+		relay  string
+		status int
+		code   string
+	}{
+		{name: "relay refuses connections", relay: fmt.Sprintf("http://127.0.0.1:%d", freePort(t)), status: http.StatusBadGateway, code: errorCodeRelayUnavailable},
+		{name: "relay times out", relay: "http://" + stalled.Addr().String(), status: http.StatusGatewayTimeout, code: errorCodeTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, url := startTestServer(t, func(cfg *config.Config) {
+				cfg.ServerURL = tt.relay
+				cfg.CreateSessionTimeout = 200 * time.Millisecond
+			})
+
+			resp := doRequest(t, http.MethodPost, url+"/api/session/create", "", false)
+			if resp.StatusCode != tt.status {
+				t.Errorf("got status %d, want %d", resp.StatusCode, tt.status)
+			}
+			var body struct {
+				Error     apiError `json:"error"`
+				SessionID string   `json:"session_id"`
+				Status    string   `json:"status"`
+			}
+			decodeJSON(t, resp, &body)
+			if body.Error.Code != tt.code {
+				t.Errorf("got error code %s, want %s", body.Error.Code, tt.code)
+			}
+			if body.SessionID == "" || body.Status != string(wallet.SessionStatusRelayUnavailable) {
+				t.Fatalf("got session %q with status %q, want a relay_unavailable session", body.SessionID, body.Status)
+			}
+
+			// The client can follow up on the session it was told about
+			resp = doRequest(t, http.MethodGet, url+"/api/session/status?session="+body.SessionID, "", false)
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("got status %d for the session, want 200", resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestRequestsAreRefusedWhileShuttingDown(t *testing.T) {
+	s, url := startTestServer(t, nil)
+	s.shuttingDown.Store(true)
+
+	resp := doRequest(t, http.MethodPost, url+"/api/session/create", "", false)
+	expectJSONError(t, resp, http.StatusServiceUnavailable, errorCodeShuttingDown)
+	if n := len(s.GetWalletClient().GetAllSessions()); n != 0 {
+		t.Errorf("got %d sessions, want none created while shutting down", n)
+	}
+
+	// Probes are still answered
+	if resp := doRequest(t, http.MethodGet, url+"/healthz", "", false); resp.StatusCode != http.StatusOK {
+		t.Errorf("got status %d from the liveness probe, want 200", resp.StatusCode)
+	}
+	if resp := doRequest(t, http.MethodGet, url+"/readyz", "", false); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("got status %d from the readiness probe, want 503", resp.StatusCode)
+	}
+}
+
+func TestDemoWalletTransactionsAreNotSupported(t *testing.T) {
+	s, url := startTestServer(t, func(cfg *config.Config) {
+		cfg.Debug = true
+		cfg.DemoWallet = true
+	})
+
+	resp := doRequest(t, http.MethodPost, url+"/api/session/create", "", false)
+	var created struct {
+		SessionID string `json:"session_id"`
+	}
+	decodeJSON(t, resp, &created)
+	session := s.GetWalletClient().GetSession(created.SessionID)
+	if session == nil || session.Status != wallet.SessionStatusActive {
+		t.Fatalf("got session %+v, want one activated by the demo wallet", session)
+	}
+
+	from := session.WalletAddress.Hex()
+	body := fmt.Sprintf(`{"session_id":%q,"transaction":{"from":%q,"to":%q,"value":"0x1"}}`, session.ID, from, from)
+	resp = doRequest(t, http.MethodPost, url+"/api/message/sendTransaction", body, false)
+	expectJSONError(t, resp, http.StatusNotImplemented, errorCodeNotSupported)
+}
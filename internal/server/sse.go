@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/korjavin/wctestapp/internal/wallet"
+)
+
+// sseHeartbeatInterval is how often an idle event stream gets a comment line,
+// so proxies and browsers keep the connection open
+const sseHeartbeatInterval = 15 * time.Second
+
+// handleSessionEvents handles the session events API endpoint. It streams
+// server-sent events for a session: a status event with the current status
+// on connect, then one event per wallet event, named after its type. The
+// stream ends when the session is disconnected or the client goes away.
+func (s *Server) handleSessionEvents(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+
+	// Get the session ID from the query parameters
+	sessionID := r.URL.Query().Get("session")
+	if sessionID == "" {
+		writeJSONError(w, http.StatusBadRequest, errorCodeInvalidRequest, "Missing session ID")
+		return
+	}
+
+	// Subscribe before reading the status so no change is missed
+	events, unsubscribe := s.walletClient.Subscribe(sessionID)
+	defer unsubscribe()
+
+	// Get the session
+	session := s.walletClient.GetSession(sessionID)
+	if session == nil {
+		writeJSONError(w, http.StatusNotFound, errorCodeSessionNotFound, "Session not found")
+		return
+	}
+
+	// The stream outlives the server's default write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		s.logger.Warn(fmt.Sprintf("Failed to clear write deadline: %v", err))
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	// Send the current status first
+	if err := writeSSE(w, rc, "status", map[string]interface{}{
+		"session_id":     session.ID,
+		"status":         session.Status,
+		"wallet_address": session.WalletAddress.Hex(),
+		"chains":         session.Chains(),
+	}); err != nil {
+		s.logger.Warn(fmt.Sprintf("Failed to send session status event: %v", err))
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := writeSSE(w, rc, string(event.Type), sessionEventPayload(event)); err != nil {
+				s.logger.Warn(fmt.Sprintf("Failed to send %s event for session %s: %v", event.Type, sessionID, err))
+				return
+			}
+			if event.Type == wallet.WalletEventSessionDisconnected {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// sessionEventPayload converts a wallet event to the data of a server-sent event
+func sessionEventPayload(event wallet.WalletEvent) map[string]interface{} {
+	payload := map[string]interface{}{
+		"type":       event.Type,
+		"session_id": event.SessionID,
+		"time":       event.Time,
+	}
+	if event.RequestID != 0 {
+		payload["request_id"] = event.RequestID
+	}
+	if event.Signature != "" {
+		payload["signature"] = event.Signature
+	}
+	if event.Err != nil {
+		payload["error"] = event.Err.Error()
+	}
+	return payload
+}
+
+// writeSSE writes one server-sent event with JSON data and flushes it
+func writeSSE(w http.ResponseWriter, rc *http.ResponseController, event string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event data: %w", err)
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, encoded); err != nil {
+		return err
+	}
+	return rc.Flush()
+}
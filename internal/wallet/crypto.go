@@ -3,7 +3,10 @@ package wallet
 import (
 	"crypto/ecdsa"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
@@ -20,18 +23,165 @@ type SignRequest struct {
 
 // SignResponse represents a response to a sign request
 type SignResponse struct {
-	ID     int    `json:"id"`
-	Result string `json:"result"`
+	ID     int            `json:"id"`
+	Result string         `json:"result"`
+	Error  *ResponseError `json:"error,omitempty"`
+
+	// RawResult holds the result as sent, for requests whose result is not a string
+	RawResult json.RawMessage `json:"-"`
+}
+
+// UnmarshalJSON decodes a response, keeping the raw result alongside string results
+func (r *SignResponse) UnmarshalJSON(data []byte) error {
+	var response struct {
+		ID     int             `json:"id"`
+		Result json.RawMessage `json:"result"`
+		Error  *ResponseError  `json:"error,omitempty"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return err
+	}
+
+	*r = SignResponse{ID: response.ID, Error: response.Error, RawResult: response.Result}
+	if len(response.Result) > 0 && response.Result[0] == '"' {
+		if err := json.Unmarshal(response.Result, &r.Result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResponseError represents an error returned by the wallet for a request
+type ResponseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error implements the error interface
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("wallet error %d: %s", e.Code, e.Message)
 }
 
-// NewPersonalSignRequest creates a new personal_sign request
-func NewPersonalSignRequest(id int, message string, address string) *SignRequest {
+// ErrInvalidAddress is returned for strings that are not valid Ethereum addresses
+var ErrInvalidAddress = errors.New("invalid Ethereum address")
+
+// ParseAddress validates an Ethereum address and returns it. Addresses in a
+// single case are accepted as is; mixed-case addresses must carry a valid
+// EIP-55 checksum, so that a typo is not silently accepted.
+func ParseAddress(address string) (common.Address, error) {
+	if !common.IsHexAddress(address) {
+		return common.Address{}, fmt.Errorf("%w: %q", ErrInvalidAddress, address)
+	}
+
+	parsed := common.HexToAddress(address)
+	digits := strings.TrimPrefix(strings.TrimPrefix(address, "0x"), "0X")
+	if digits != strings.ToLower(digits) && digits != strings.ToUpper(digits) &&
+		digits != strings.TrimPrefix(parsed.Hex(), "0x") {
+		return common.Address{}, fmt.Errorf("%w: %q has an invalid EIP-55 checksum", ErrInvalidAddress, address)
+	}
+
+	return parsed, nil
+}
+
+// NewPersonalSignRequest creates a new personal_sign request. The address is
+// validated and sent in its EIP-55 checksummed form.
+func NewPersonalSignRequest(id int, message string, address string) (*SignRequest, error) {
+	parsed, err := ParseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
 	return &SignRequest{
 		ID:     id,
 		Method: "personal_sign",
 		Params: []any{
 			message,
+			parsed.Hex(),
+		},
+	}, nil
+}
+
+// NewAccountsRequest creates an eth_accounts request
+func NewAccountsRequest(id int) *SignRequest {
+	return &SignRequest{
+		ID:     id,
+		Method: "eth_accounts",
+		Params: []any{},
+	}
+}
+
+// ErrInvalidTransaction is returned for transactions with malformed fields
+var ErrInvalidTransaction = errors.New("invalid transaction")
+
+// TxParams holds the parameters of an eth_sendTransaction request. All fields
+// are hex strings; only From is required.
+type TxParams struct {
+	From     string `json:"from"`
+	To       string `json:"to,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Data     string `json:"data,omitempty"`
+	Gas      string `json:"gas,omitempty"`
+	GasPrice string `json:"gasPrice,omitempty"`
+}
+
+// Validate checks that the transaction fields are well-formed and returns a
+// copy with the addresses in their EIP-55 checksummed form
+func (tx TxParams) Validate() (TxParams, error) {
+	from, err := ParseAddress(tx.From)
+	if err != nil {
+		return tx, fmt.Errorf("from: %w", err)
+	}
+	tx.From = from.Hex()
+
+	if tx.To != "" {
+		to, err := ParseAddress(tx.To)
+		if err != nil {
+			return tx, fmt.Errorf("to: %w", err)
+		}
+		tx.To = to.Hex()
+	}
+
+	quantities := []struct{ name, value string }{
+		{"value", tx.Value},
+		{"gas", tx.Gas},
+		{"gasPrice", tx.GasPrice},
+	}
+	for _, q := range quantities {
+		if q.value == "" {
+			continue
+		}
+		if _, err := hexutil.DecodeBig(q.value); err != nil {
+			return tx, fmt.Errorf("%w: %s: %v", ErrInvalidTransaction, q.name, err)
+		}
+	}
+
+	if tx.Data != "" {
+		if _, err := hexutil.Decode(tx.Data); err != nil {
+			return tx, fmt.Errorf("%w: data: %v", ErrInvalidTransaction, err)
+		}
+	}
+
+	return tx, nil
+}
+
+// NewSendTransactionRequest creates a new eth_sendTransaction request
+func NewSendTransactionRequest(id int, tx TxParams) *SignRequest {
+	return &SignRequest{
+		ID:     id,
+		Method: "eth_sendTransaction",
+		Params: []any{tx},
+	}
+}
+
+// NewSignTypedDataRequest creates a new eth_signTypedData_v4 request. Wallets
+// expect the typed data as a JSON-encoded string parameter.
+func NewSignTypedDataRequest(id int, address string, typedData json.RawMessage) *SignRequest {
+	return &SignRequest{
+		ID:     id,
+		Method: "eth_signTypedData_v4",
+		Params: []any{
 			address,
+			string(typedData),
 		},
 	}
 }
@@ -44,8 +194,8 @@ func EncryptRequest(request *SignRequest, session *Session) (string, error) {
 		return "", fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	// Encrypt the request with the session's symmetric key
-	encrypted, err := utils.EncryptWithSymmetricKey(requestJSON, session.SymKey)
+	// Encrypt the request with the session's symmetric key in a WalletConnect envelope
+	encrypted, err := utils.EncryptWithChaCha20(requestJSON, session.SymKey)
 	if err != nil {
 		return "", fmt.Errorf("failed to encrypt request: %w", err)
 	}
@@ -55,8 +205,8 @@ func EncryptRequest(request *SignRequest, session *Session) (string, error) {
 
 // DecryptResponse decrypts a response from a session
 func DecryptResponse(encryptedResponse string, session *Session) (*SignResponse, error) {
-	// Decrypt the response with the session's symmetric key
-	decrypted, err := utils.DecryptWithSymmetricKey(encryptedResponse, session.SymKey)
+	// Decrypt the response envelope with the session's symmetric key
+	decrypted, err := utils.DecryptWithChaCha20(encryptedResponse, session.SymKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decrypt response: %w", err)
 	}
@@ -71,32 +221,32 @@ func DecryptResponse(encryptedResponse string, session *Session) (*SignResponse,
 	return &response, nil
 }
 
-// VerifySignature verifies a signature
-func VerifySignature(message string, signature string, address common.Address) (bool, error) {
-	// Convert the message to bytes
-	messageBytes := []byte(message)
+// PersonalMessageBytes returns the bytes a wallet signs for a personal_sign
+// message. Like MetaMask, a 0x-prefixed hex message is signed as the bytes it
+// encodes; any other message is signed as its UTF-8 bytes.
+func PersonalMessageBytes(message string) []byte {
+	if decoded, err := hexutil.Decode(message); err == nil {
+		return decoded
+	}
+	return []byte(message)
+}
 
-	// Add the Ethereum signed message prefix
-	prefixedMessage := fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", len(messageBytes), messageBytes)
-	prefixedMessageBytes := []byte(prefixedMessage)
+// HashPersonalMessage returns the EIP-191 hash a personal_sign signature
+// covers. The prefix carries the message length in bytes, not characters.
+func HashPersonalMessage(message []byte) common.Hash {
+	prefix := []byte("\x19Ethereum Signed Message:\n" + strconv.Itoa(len(message)))
+	return crypto.Keccak256Hash(prefix, message)
+}
 
-	// Hash the prefixed message
-	hash := crypto.Keccak256Hash(prefixedMessageBytes)
+// VerifySignature verifies a signature
+func VerifySignature(message string, signature string, address common.Address) (bool, error) {
+	// Hash the message bytes with the Ethereum signed message prefix
+	hash := HashPersonalMessage(PersonalMessageBytes(message))
 
 	// Convert the signature from hex to bytes
-	signatureBytes, err := hexutil.Decode(signature)
+	signatureBytes, err := decodeSignature(signature)
 	if err != nil {
-		return false, fmt.Errorf("failed to decode signature: %w", err)
-	}
-
-	// The signature should be 65 bytes: R (32 bytes) + S (32 bytes) + V (1 byte)
-	if len(signatureBytes) != 65 {
-		return false, fmt.Errorf("invalid signature length: %d", len(signatureBytes))
-	}
-
-	// Adjust the V value (last byte) for Ethereum's implementation
-	if signatureBytes[64] < 27 {
-		signatureBytes[64] += 27
+		return false, err
 	}
 
 	// Recover the public key
@@ -112,6 +262,28 @@ func VerifySignature(message string, signature string, address common.Address) (
 	return recoveredAddress == address, nil
 }
 
+// decodeSignature decodes a hex personal_sign signature, with or without the
+// 0x prefix. V may use either the 0/1 or the 27/28 convention; the returned
+// signature has V as 0 or 1, as SigToPub expects.
+func decodeSignature(signature string) ([]byte, error) {
+	if !strings.HasPrefix(signature, "0x") && !strings.HasPrefix(signature, "0X") {
+		signature = "0x" + signature
+	}
+	signatureBytes, err := hexutil.Decode(signature)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode signature: %w", err)
+	}
+
+	// Normalize V, then convert it to the 0/1 recovery ID
+	signatureBytes, err = utils.NormalizeSignatureV(signatureBytes)
+	if err != nil {
+		return nil, err
+	}
+	signatureBytes[64] -= 27
+
+	return signatureBytes, nil
+}
+
 // FormatSignature formats a signature for display
 func FormatSignature(signature string) string {
 	return signature
@@ -125,30 +297,18 @@ func GetMessageToSign(message string) string {
 // GetSignatureDetails gets the details of a signature
 func GetSignatureDetails(message string, signature string) (map[string]string, error) {
 	// Convert the signature from hex to bytes
-	signatureBytes, err := hexutil.Decode(signature)
+	signatureBytes, err := decodeSignature(signature)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode signature: %w", err)
-	}
-
-	// The signature should be 65 bytes: R (32 bytes) + S (32 bytes) + V (1 byte)
-	if len(signatureBytes) != 65 {
-		return nil, fmt.Errorf("invalid signature length: %d", len(signatureBytes))
+		return nil, err
 	}
 
-	// Extract R, S, and V
+	// Extract R, S, and V, reporting V in the 27/28 convention
 	r := hexutil.Encode(signatureBytes[:32])
 	s := hexutil.Encode(signatureBytes[32:64])
-	v := signatureBytes[64]
-
-	// Convert the message to bytes
-	messageBytes := []byte(message)
-
-	// Add the Ethereum signed message prefix
-	prefixedMessage := fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", len(messageBytes), messageBytes)
-	prefixedMessageBytes := []byte(prefixedMessage)
+	v := signatureBytes[64] + 27
 
-	// Hash the prefixed message
-	hash := crypto.Keccak256Hash(prefixedMessageBytes)
+	// Hash the message bytes with the Ethereum signed message prefix
+	hash := HashPersonalMessage(PersonalMessageBytes(message))
 
 	// Recover the public key
 	pubKey, err := crypto.SigToPub(hash.Bytes(), signatureBytes)
@@ -170,6 +330,34 @@ func GetSignatureDetails(message string, signature string) (map[string]string, e
 	}, nil
 }
 
+// SignatureItem is a message and its signature, as verified in a batch
+type SignatureItem struct {
+	Message   string `json:"message"`
+	Signature string `json:"signature"`
+}
+
+// GetBatchSignatureDetails gets the details of each signature in a batch. A
+// failing item does not abort the batch: its result holds only the message,
+// signature and an "error" entry, and its error is included in the returned
+// error, which is nil if every item succeeded.
+func GetBatchSignatureDetails(items []SignatureItem) ([]map[string]string, error) {
+	results := make([]map[string]string, len(items))
+	var errs []error
+	for i, item := range items {
+		details, err := GetSignatureDetails(item.Message, item.Signature)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("item %d: %w", i, err))
+			details = map[string]string{
+				"message":   item.Message,
+				"signature": item.Signature,
+				"error":     err.Error(),
+			}
+		}
+		results[i] = details
+	}
+	return results, errors.Join(errs...)
+}
+
 // GenerateKeyPair generates a new ECDSA key pair
 func GenerateKeyPair() (*ecdsa.PrivateKey, *ecdsa.PublicKey, error) {
 	return utils.GenerateKeyPair()
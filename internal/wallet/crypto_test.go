@@ -0,0 +1,137 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// testKey is the first Hardhat development account, a well-known test key
+const testKey = "ac0974bec39a17e36ba4a6b4d238ff944bacb478cbed5efcae784d7bf4f2ff80"
+
+// testAddress is the address of testKey
+var testAddress = common.HexToAddress("0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266")
+
+func TestPersonalMessageBytes(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    []byte
+	}{
+		{name: "ascii", message: "Hello World", want: []byte("Hello World")},
+		{name: "accented", message: "héllo wörld", want: []byte("héllo wörld")},
+		{name: "emoji", message: "gm 👋🦊", want: []byte("gm 👋🦊")},
+		{name: "hex", message: "0x68656c6c6f", want: []byte("hello")},
+		{name: "hex bytes", message: "0xdeadbeef", want: []byte{0xde, 0xad, 0xbe, 0xef}},
+		{name: "odd-length hex is text", message: "0x123", want: []byte("0x123")},
+		{name: "not hex", message: "0xhello", want: []byte("0xhello")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PersonalMessageBytes(tt.message); !bytes.Equal(got, tt.want) {
+				t.Errorf("got %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashPersonalMessageMatchesMetaMask(t *testing.T) {
+	// The hash ethers.js and MetaMask document for "Hello World"
+	if got := HashPersonalMessage([]byte("Hello World")).Hex(); got != "0xa1de988600a42c4b4ab089b619297c17d53cffae5d5120d82d8a92d0bb3b78f2" {
+		t.Errorf("got hash %s for Hello World", got)
+	}
+
+	// The prefix carries the length in bytes, which differs from the length
+	// in characters for accented and emoji messages
+	for _, message := range []string{"héllo wörld", "gm 👋🦊", "0x68656c6c6f", "0xdeadbeef"} {
+		data := PersonalMessageBytes(message)
+		if got, want := HashPersonalMessage(data).Bytes(), accounts.TextHash(data); !bytes.Equal(got, want) {
+			t.Errorf("%q: got hash %x, want %x", message, got, want)
+		}
+	}
+}
+
+func TestVerifySignatureOfDemoWallet(t *testing.T) {
+	demo, err := NewDemoWallet("0x" + testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if demo.Address() != testAddress {
+		t.Fatalf("demo wallet address %s, want %s", demo.Address().Hex(), testAddress.Hex())
+	}
+
+	for _, message := range []string{"Hello World", "héllo wörld", "gm 👋🦊", "0x68656c6c6f"} {
+		signature, err := demo.SignMessage(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		valid, err := VerifySignature(message, signature, testAddress)
+		if err != nil || !valid {
+			t.Errorf("%q: signature %s not valid for %s: %v", message, signature, testAddress.Hex(), err)
+		}
+
+		// A hex message is signed as the bytes it encodes
+		if message == "0x68656c6c6f" {
+			if valid, _ := VerifySignature("hello", signature, testAddress); !valid {
+				t.Error("signature of a hex message does not cover the bytes it encodes")
+			}
+			if valid, _ := VerifySignature("0x68656c6c6e", signature, testAddress); valid {
+				t.Error("signature verified for a different message")
+			}
+		}
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		wantErr bool
+	}{
+		{name: "checksummed", address: "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266"},
+		{name: "lower case", address: "0xf39fd6e51aad88f6f4ce6ab8827279cfffb92266"},
+		{name: "upper case", address: "0xF39FD6E51AAD88F6F4CE6AB8827279CFFFB92266"},
+		{name: "no prefix", address: "f39fd6e51aad88f6f4ce6ab8827279cfffb92266"},
+		{name: "bad checksum", address: "0xF39fd6e51aad88F6F4ce6aB8827279cffFb92266", wantErr: true},
+		{name: "too short", address: "0xf39fd6e51aad88f6f4ce6ab8827279cfffb922", wantErr: true},
+		{name: "not hex", address: "0xg39fd6e51aad88f6f4ce6ab8827279cfffb92266", wantErr: true},
+		{name: "empty", address: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address, err := ParseAddress(tt.address)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidAddress) {
+					t.Errorf("got %s, %v, want ErrInvalidAddress", address.Hex(), err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if address != testAddress {
+				t.Errorf("got %s, want %s", address.Hex(), testAddress.Hex())
+			}
+		})
+	}
+}
+
+func TestNewPersonalSignRequestChecksumsAddress(t *testing.T) {
+	request, err := NewPersonalSignRequest(1, "hello", "0xf39fd6e51aad88f6f4ce6ab8827279cfffb92266")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := request.Params[1]; got != testAddress.Hex() {
+		t.Errorf("got address %v, want the checksummed %s", got, testAddress.Hex())
+	}
+
+	if _, err := NewPersonalSignRequest(1, "hello", "0x1234"); !errors.Is(err, ErrInvalidAddress) {
+		t.Errorf("got %v for a malformed address, want ErrInvalidAddress", err)
+	}
+}
@@ -0,0 +1,59 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/korjavin/wctestapp/pkg/utils"
+)
+
+// DemoWallet is an in-process wallet used for self-contained demos when no
+// external wallet is available. Sessions are activated with its address and
+// sign requests are signed locally instead of being published to the relay.
+type DemoWallet struct {
+	privateKey *ecdsa.PrivateKey
+	address    common.Address
+}
+
+// NewDemoWallet creates a demo wallet from a hex private key, or generates a
+// new key if hexKey is empty
+func NewDemoWallet(hexKey string) (*DemoWallet, error) {
+	var privateKey *ecdsa.PrivateKey
+	var err error
+	if hexKey == "" {
+		privateKey, _, err = utils.GenerateKeyPair()
+	} else {
+		privateKey, err = utils.HexToPrivateKey(strings.TrimPrefix(hexKey, "0x"))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load demo wallet key: %w", err)
+	}
+
+	return &DemoWallet{
+		privateKey: privateKey,
+		address:    utils.PublicKeyToAddress(&privateKey.PublicKey),
+	}, nil
+}
+
+// Address returns the demo wallet's address
+func (d *DemoWallet) Address() common.Address {
+	return d.address
+}
+
+// SignMessage signs a message the way a wallet answers personal_sign: the
+// message is EIP-191 prefixed and the signature's v is 27 or 28
+func (d *DemoWallet) SignMessage(message string) (string, error) {
+	hash := HashPersonalMessage(PersonalMessageBytes(message))
+
+	signature, err := crypto.Sign(hash.Bytes(), d.privateKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign message: %w", err)
+	}
+	signature[64] += 27
+
+	return hexutil.Encode(signature), nil
+}
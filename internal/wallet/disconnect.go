@@ -0,0 +1,149 @@
+package wallet
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// sessionDeleteAckTimeout is how long DisconnectSession waits for the relay to
+// acknowledge the wc_sessionDelete publish before closing the connections
+const sessionDeleteAckTimeout = 2 * time.Second
+
+// unsubscribeAckTimeout is how long DisconnectSession waits for the relay to
+// acknowledge each unsubscribe before closing the connection anyway
+const unsubscribeAckTimeout = time.Second
+
+// JSON-RPC IDs of the requests sent to the relay
+const (
+	subscribeRequestID   = 1
+	publishRequestID     = 2
+	unsubscribeRequestID = 3
+)
+
+// relayAckKey identifies a request sent to the relay on a topic's connection
+type relayAckKey struct {
+	topic string
+	id    int
+}
+
+// DisconnectReason is the reason sent to the wallet in a wc_sessionDelete request
+type DisconnectReason struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Reasons for disconnecting a session, using WalletConnect's reason codes
+var (
+	// ReasonUserDisconnected is sent when the user ends the session
+	ReasonUserDisconnected = DisconnectReason{Code: 6000, Message: "User disconnected."}
+	// ReasonConnectionLost is sent when the relay connection could not be restored
+	ReasonConnectionLost = DisconnectReason{Code: 6000, Message: "Connection to the relay was lost."}
+)
+
+// notifySessionDelete tells the wallet the session is ending by publishing a
+// wc_sessionDelete request on the session topic, and waits briefly for the
+// relay to acknowledge the publish. It does not dial: when the session topic
+// connection is already gone the wallet cannot be reached and nothing is sent.
+func (c *WalletClient) notifySessionDelete(session *Session, reason DisconnectReason) error {
+	c.mutex.RLock()
+	conn := c.connections[session.SessionTopic]
+	c.mutex.RUnlock()
+
+	if conn == nil {
+		c.logger.Infof("Session topic of session %s is not connected, not notifying the wallet", session.ID)
+		return nil
+	}
+
+	request := &protocolRequest{
+		ID:      c.nextRequestID(),
+		JSONRPC: "2.0",
+		Method:  "wc_sessionDelete",
+		Params:  reason,
+	}
+
+	ack := c.registerRelayAck(session.SessionTopic, publishRequestID)
+	defer c.unregisterRelayAck(session.SessionTopic, publishRequestID, ack)
+
+	if err := c.publishEncrypted(session, topicKindSession, request); err != nil {
+		return fmt.Errorf("failed to send wc_sessionDelete for session %s: %w", session.ID, err)
+	}
+
+	select {
+	case err := <-ack:
+		if err != nil {
+			return fmt.Errorf("relay rejected wc_sessionDelete for session %s: %w", session.ID, err)
+		}
+		c.logger.Infof("Notified the wallet that session %s was deleted: %s (%d)", session.ID, reason.Message, reason.Code)
+		return nil
+	case <-time.After(sessionDeleteAckTimeout):
+		return fmt.Errorf("relay did not acknowledge wc_sessionDelete for session %s within %s", session.ID, sessionDeleteAckTimeout)
+	}
+}
+
+// unsubscribeTopic unsubscribes from a topic on its connection and waits
+// briefly for the relay's acknowledgement, so the relay drops the
+// subscription before the connection is closed
+func (c *WalletClient) unsubscribeTopic(conn *websocket.Conn, topic string) error {
+	ack := c.registerRelayAck(topic, unsubscribeRequestID)
+	defer c.unregisterRelayAck(topic, unsubscribeRequestID, ack)
+
+	if err := c.sendUnsubscribe(conn, topic); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-ack:
+		if err != nil {
+			return fmt.Errorf("relay rejected unsubscribe from topic %s: %w", topic, err)
+		}
+		return nil
+	case <-time.After(unsubscribeAckTimeout):
+		return fmt.Errorf("relay did not acknowledge unsubscribe from topic %s within %s", topic, unsubscribeAckTimeout)
+	}
+}
+
+// registerRelayAck registers a channel for the relay's answer to the next
+// request with the given ID on a topic's connection
+func (c *WalletClient) registerRelayAck(topic string, id int) chan error {
+	ch := make(chan error, 1)
+
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+
+	c.relayAcks[relayAckKey{topic, id}] = ch
+	return ch
+}
+
+// unregisterRelayAck removes an ack channel if it is still registered
+func (c *WalletClient) unregisterRelayAck(topic string, id int, ch chan error) {
+	key := relayAckKey{topic, id}
+
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+
+	if c.relayAcks[key] == ch {
+		delete(c.relayAcks, key)
+	}
+}
+
+// handleRelayAck delivers the relay's answer to a request on a topic's
+// connection to the waiting caller, if any
+func (c *WalletClient) handleRelayAck(topic string, id int, responseErr *ResponseError) {
+	key := relayAckKey{topic, id}
+
+	c.pendingMutex.Lock()
+	ch, ok := c.relayAcks[key]
+	delete(c.relayAcks, key)
+	c.pendingMutex.Unlock()
+
+	if !ok {
+		return
+	}
+	if responseErr != nil {
+		ch <- responseErr
+		return
+	}
+	ch <- nil
+}
@@ -0,0 +1,151 @@
+package wallet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// ERC1271MagicValue is returned by isValidSignature(bytes32,bytes) when a
+// contract accepts a signature. It is also the function's selector.
+var ERC1271MagicValue = [4]byte{0x16, 0x26, 0xba, 0x7e}
+
+// VerifyERC1271Signature verifies a personal_sign signature from a smart
+// contract wallet, such as a Safe, by calling isValidSignature on the
+// contract through the JSON-RPC node at rpcURL. The contract is given the
+// EIP-191 hash of the message. It returns false without an error when the
+// contract rejects the signature or is not an EIP-1271 contract.
+func VerifyERC1271Signature(ctx context.Context, contractAddr common.Address, message, signature []byte, rpcURL string) (bool, error) {
+	hash := HashPersonalMessage(message)
+
+	result, err := ethCall(ctx, rpcURL, contractAddr, encodeIsValidSignature(hash, signature))
+	if err != nil {
+		return false, fmt.Errorf("isValidSignature call on %s failed: %w", contractAddr.Hex(), err)
+	}
+
+	// The bytes4 return value is left-aligned in a 32-byte word
+	return len(result) >= 4 && bytes.Equal(result[:4], ERC1271MagicValue[:]), nil
+}
+
+// encodeIsValidSignature ABI-encodes a call to isValidSignature(bytes32,bytes)
+func encodeIsValidSignature(hash common.Hash, signature []byte) []byte {
+	data := make([]byte, 0, 4+32*4+len(signature))
+	data = append(data, ERC1271MagicValue[:]...)
+	data = append(data, hash.Bytes()...)
+	data = append(data, common.LeftPadBytes(big.NewInt(64).Bytes(), 32)...) // offset of the bytes argument
+	data = append(data, common.LeftPadBytes(big.NewInt(int64(len(signature))).Bytes(), 32)...)
+	data = append(data, signature...)
+	if padding := len(signature) % 32; padding != 0 {
+		data = append(data, make([]byte, 32-padding)...)
+	}
+	return data
+}
+
+// ethCall executes an eth_call against the latest block and returns its result
+func ethCall(ctx context.Context, rpcURL string, to common.Address, data []byte) ([]byte, error) {
+	request, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  "eth_call",
+		"params": []interface{}{
+			map[string]string{"to": to.Hex(), "data": hexutil.Encode(data)},
+			"latest",
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal eth_call request: %w", err)
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, rpcURL, bytes.NewReader(request))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create eth_call request: %w", err)
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+
+	httpResponse, err := http.DefaultClient.Do(httpRequest)
+	if err != nil {
+		return nil, fmt.Errorf("eth_call request failed: %w", err)
+	}
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("eth_call request failed with status %d", httpResponse.StatusCode)
+	}
+
+	var response struct {
+		Result hexutil.Bytes  `json:"result"`
+		Error  *ResponseError `json:"error"`
+	}
+	if err := json.NewDecoder(httpResponse.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to parse eth_call response: %w", err)
+	}
+	if response.Error != nil {
+		return nil, response.Error
+	}
+	return response.Result, nil
+}
+
+// SetRPCURL sets the JSON-RPC node used to verify smart contract wallet
+// signatures with EIP-1271. An empty URL disables the check.
+func (c *WalletClient) SetRPCURL(url string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.rpcURL = url
+}
+
+// GetSessionSignatureDetails gets the details of a personal_sign signature
+// from a session's wallet. When the signature does not recover to the
+// session's wallet address and an RPC URL is set, the wallet address is
+// treated as a smart contract wallet and the signature is checked with
+// EIP-1271. The details report the signature type and whether it is valid.
+func (c *WalletClient) GetSessionSignatureDetails(ctx context.Context, session *Session, message, signature string) (map[string]string, error) {
+	details, err := GetSignatureDetails(message, signature)
+	if err == nil && common.HexToAddress(details["recovered_address"]) == session.WalletAddress {
+		details["signature_type"] = "eoa"
+		details["valid"] = "true"
+		return details, nil
+	}
+
+	c.mutex.RLock()
+	rpcURL := c.rpcURL
+	c.mutex.RUnlock()
+
+	if rpcURL != "" {
+		valid, callErr := verifyContractSignature(ctx, session.WalletAddress, message, signature, rpcURL)
+		if callErr != nil {
+			c.logger.Warnf("EIP-1271 check for session %s failed: %v", session.ID, callErr)
+		}
+		if valid {
+			return map[string]string{
+				"message":          message,
+				"signature":        signature,
+				"signature_type":   "erc1271",
+				"contract_address": session.WalletAddress.Hex(),
+				"valid":            "true",
+			}, nil
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	details["signature_type"] = "eoa"
+	details["valid"] = "false"
+	return details, nil
+}
+
+// verifyContractSignature decodes a hex signature and checks it with EIP-1271
+func verifyContractSignature(ctx context.Context, contractAddr common.Address, message, signature, rpcURL string) (bool, error) {
+	signatureBytes, err := hexutil.Decode(signature)
+	if err != nil {
+		return false, fmt.Errorf("failed to decode signature: %w", err)
+	}
+	return VerifyERC1271Signature(ctx, contractAddr, PersonalMessageBytes(message), signatureBytes, rpcURL)
+}
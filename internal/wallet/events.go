@@ -0,0 +1,119 @@
+package wallet
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// WalletEventType identifies the kind of a WalletEvent
+type WalletEventType string
+
+const (
+	// WalletEventSignatureReceived is delivered when the wallet answers a request with a signature
+	WalletEventSignatureReceived WalletEventType = "signature_received"
+	// WalletEventSessionActivated is delivered when a session becomes active
+	WalletEventSessionActivated WalletEventType = "session_activated"
+	// WalletEventSessionDisconnected is delivered when a session is disconnected
+	WalletEventSessionDisconnected WalletEventType = "session_disconnected"
+	// WalletEventError is delivered when the wallet answers with an error or a message cannot be decrypted
+	WalletEventError WalletEventType = "error"
+)
+
+// walletEventBuffer is the capacity of each event subscription's channel
+const walletEventBuffer = 16
+
+// WalletEvent is an event delivered to subscribers of a session
+type WalletEvent struct {
+	Type      WalletEventType
+	SessionID string
+	Time      time.Time
+	RequestID int    // request answered, for signatures and wallet errors
+	Signature string // set for WalletEventSignatureReceived
+	Err       error  // set for WalletEventError
+}
+
+// eventSubscriptions holds the event channels of each session's subscribers
+type eventSubscriptions struct {
+	subscribers map[string][]chan WalletEvent // session ID -> channels
+	closed      bool
+	mutex       sync.RWMutex
+}
+
+// Subscribe returns a channel delivering events for a session, and a function
+// that ends the subscription and closes the channel. The channel is buffered;
+// events that do not fit because the subscriber falls behind are dropped
+// rather than stalling the relay listener. All channels are closed when the
+// client is closed.
+func (c *WalletClient) Subscribe(sessionID string) (<-chan WalletEvent, func()) {
+	subs := c.events
+	ch := make(chan WalletEvent, walletEventBuffer)
+
+	subs.mutex.Lock()
+	defer subs.mutex.Unlock()
+
+	if subs.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	subs.subscribers[sessionID] = append(subs.subscribers[sessionID], ch)
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			subs.mutex.Lock()
+			defer subs.mutex.Unlock()
+
+			channels := subs.subscribers[sessionID]
+			for i, other := range channels {
+				if other == ch {
+					subs.subscribers[sessionID] = append(channels[:i:i], channels[i+1:]...)
+					close(ch)
+					break
+				}
+			}
+			if len(subs.subscribers[sessionID]) == 0 {
+				delete(subs.subscribers, sessionID)
+			}
+		})
+	}
+	return ch, unsubscribe
+}
+
+// publishWalletEvent delivers an event to the session's subscribers without blocking
+func (c *WalletClient) publishWalletEvent(session *Session, event WalletEvent) {
+	event.SessionID = session.ID
+	event.Time = time.Now()
+
+	c.events.mutex.RLock()
+	defer c.events.mutex.RUnlock()
+
+	for _, ch := range c.events.subscribers[session.ID] {
+		select {
+		case ch <- event:
+		default:
+			c.logger.Warnf("Dropped %s event for session %s: subscriber is not keeping up", event.Type, session.ID)
+		}
+	}
+}
+
+// closeEventSubscriptions closes all subscription channels
+func (c *WalletClient) closeEventSubscriptions() {
+	c.events.mutex.Lock()
+	defer c.events.mutex.Unlock()
+
+	for _, channels := range c.events.subscribers {
+		for _, ch := range channels {
+			close(ch)
+		}
+	}
+	c.events.subscribers = make(map[string][]chan WalletEvent)
+	c.events.closed = true
+}
+
+// isSignature reports whether a response result looks like a 65-byte ECDSA signature
+func isSignature(result string) bool {
+	b, err := hexutil.Decode(result)
+	return err == nil && len(b) == 65
+}
@@ -0,0 +1,264 @@
+package wallet
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// ReconnectPolicy controls how dropped relay connections are re-dialed. The
+// delay between attempts doubles from InitialDelay up to MaxDelay, and a
+// random part of each delay is spread by Jitter so that many clients do not
+// re-dial at once.
+type ReconnectPolicy struct {
+	InitialDelay time.Duration // delay before the first re-dial
+	MaxDelay     time.Duration // cap on the delay between re-dials
+	MaxRetries   int           // re-dials before giving up; 0 retries until the session gives up
+	Jitter       float64       // fraction of each delay that is randomized, from 0 to 1
+}
+
+// DefaultReconnectPolicy returns the reconnect policy used unless configured otherwise
+func DefaultReconnectPolicy() ReconnectPolicy {
+	return ReconnectPolicy{
+		InitialDelay: 500 * time.Millisecond,
+		MaxDelay:     5 * time.Second,
+		Jitter:       0.2,
+	}
+}
+
+// backoff returns the delay before the given re-dial attempt, counting from zero
+func (p ReconnectPolicy) backoff(attempt int) time.Duration {
+	delay := p.InitialDelay
+	for i := 0; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter > 0 && delay > 0 {
+		spread := time.Duration(float64(delay) * p.Jitter)
+		delay = delay - spread + time.Duration(rand.Int64N(int64(2*spread)+1))
+	}
+	return delay
+}
+
+// exhausted reports whether no re-dials are left after the given number of attempts
+func (p ReconnectPolicy) exhausted(attempts int) bool {
+	return p.MaxRetries > 0 && attempts >= p.MaxRetries
+}
+
+// SetReconnectPolicy sets how dropped relay connections are re-dialed.
+// Missing delays are taken from DefaultReconnectPolicy.
+func (c *WalletClient) SetReconnectPolicy(policy ReconnectPolicy) {
+	defaults := DefaultReconnectPolicy()
+	if policy.InitialDelay <= 0 {
+		policy.InitialDelay = defaults.InitialDelay
+	}
+	if policy.MaxDelay < policy.InitialDelay {
+		policy.MaxDelay = max(defaults.MaxDelay, policy.InitialDelay)
+	}
+	policy.Jitter = min(max(policy.Jitter, 0), 1)
+	policy.MaxRetries = max(policy.MaxRetries, 0)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.reconnectPolicy = policy
+}
+
+// SetDisconnectGracePeriod sets how long a session whose relay connection
+// dropped stays in the reconnecting state before it is finalized as
+// disconnected. Zero disconnects the session as soon as the connection drops.
+func (c *WalletClient) SetDisconnectGracePeriod(period time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.gracePeriod = period
+}
+
+// rotateConnection re-dials a topic whose connection the relay closed because
+// it reached its maximum age. If the re-dial fails, the topic is handled like
+// any other dropped connection.
+func (c *WalletClient) rotateConnection(topic string) {
+	if err := c.connectToTopic(topic); err != nil {
+		c.logger.Warnf("Failed to reconnect topic %s after the relay closed it: %v", topic, err)
+		c.handleConnectionLost(topic)
+		return
+	}
+	c.logger.Infof("Reconnected topic %s after the relay closed it", topic)
+}
+
+// handleConnectionLost is called when the listener for a topic exits without
+// the connection having been closed by us. If the topic belongs to an active
+// session, the session is kept in the reconnecting state while the topic is
+// re-dialed, and only disconnected once the grace period runs out. The topic
+// of a pending session is re-dialed until the session expires, so a wallet can
+// still pair with it. Disconnected and expired sessions are not reconnected.
+func (c *WalletClient) handleConnectionLost(topic string) {
+	session := c.sessionManager.GetSessionBySessionTopic(topic)
+	if session == nil {
+		session = c.sessionManager.GetSessionByPairingTopic(topic)
+	}
+	if session == nil || session.IsExpired() {
+		return
+	}
+
+	c.mutex.Lock()
+	gracePeriod := c.gracePeriod
+	if session.Status == SessionStatusPending {
+		c.mutex.Unlock()
+		c.logger.Warnf("Lost relay connection for pending session %s, re-dialing topic %s", session.ID, topic)
+		go c.recoverPendingTopic(session, topic)
+		return
+	}
+	if session.Status != SessionStatusActive && session.Status != SessionStatusReconnecting {
+		c.mutex.Unlock()
+		return
+	}
+	c.graceTopics[topic] = session
+	startGrace := session.Status == SessionStatusActive
+	if startGrace {
+		c.updateSession(session, func(s *Session) { s.SetStatus(SessionStatusReconnecting) })
+	}
+	c.mutex.Unlock()
+
+	if gracePeriod <= 0 {
+		c.logger.Warnf("Lost relay connection for topic %s, disconnecting session %s", topic, session.ID)
+		c.finalizeGrace(session)
+		return
+	}
+
+	if startGrace {
+		c.logger.Warnf("Lost relay connection for session %s, waiting up to %s for it to recover",
+			session.ID, gracePeriod)
+		c.emitSessionEvent(session, SessionEventReconnecting)
+	}
+
+	go c.recoverTopic(session, topic, time.Now().Add(gracePeriod))
+}
+
+// recoverTopic re-dials a dropped topic with backoff until it succeeds, the
+// session leaves the reconnecting state, or the deadline passes
+func (c *WalletClient) recoverTopic(session *Session, topic string, deadline time.Time) {
+	c.mutex.RLock()
+	policy := c.reconnectPolicy
+	c.mutex.RUnlock()
+
+	for attempt := 0; ; attempt++ {
+		delay := policy.backoff(attempt)
+		if time.Now().Add(delay).After(deadline) {
+			delay = time.Until(deadline)
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-c.done:
+			return
+		}
+
+		c.mutex.RLock()
+		stillReconnecting := session.Status == SessionStatusReconnecting && c.graceTopics[topic] == session
+		c.mutex.RUnlock()
+		if !stillReconnecting {
+			return
+		}
+
+		err := c.connectToTopic(topic)
+		if err == nil {
+			c.topicRecovered(session, topic)
+			return
+		}
+
+		if !time.Now().Before(deadline) {
+			c.logger.Warnf("Session %s did not recover within the grace period: %v", session.ID, err)
+			c.finalizeGrace(session)
+			return
+		}
+		if policy.exhausted(attempt + 1) {
+			c.logger.Warnf("Session %s did not recover after %d re-dials: %v", session.ID, attempt+1, err)
+			c.finalizeGrace(session)
+			return
+		}
+
+		c.logger.Debugf("Re-dial of topic %s for session %s failed: %v", topic, session.ID, err)
+	}
+}
+
+// recoverPendingTopic re-dials the dropped topic of a pending session with
+// backoff until it succeeds, the session stops pending or expires, or the
+// retries run out, in which case the session is marked relay unavailable
+func (c *WalletClient) recoverPendingTopic(session *Session, topic string) {
+	c.mutex.RLock()
+	policy := c.reconnectPolicy
+	c.mutex.RUnlock()
+
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-time.After(policy.backoff(attempt)):
+		case <-c.done:
+			return
+		}
+
+		c.mutex.RLock()
+		pending := session.Status == SessionStatusPending
+		c.mutex.RUnlock()
+		if !pending || session.IsExpired() {
+			return
+		}
+
+		err := c.connectToTopic(topic)
+		if err == nil {
+			c.logger.Infof("Recovered topic %s for pending session %s", topic, session.ID)
+			return
+		}
+
+		if policy.exhausted(attempt + 1) {
+			c.logger.Warnf("Pending session %s did not recover after %d re-dials: %v", session.ID, attempt+1, err)
+			c.mutex.Lock()
+			c.updateSession(session, func(s *Session) { s.SetStatus(SessionStatusRelayUnavailable) })
+			c.mutex.Unlock()
+			return
+		}
+
+		c.logger.Debugf("Re-dial of topic %s for pending session %s failed: %v", topic, session.ID, err)
+	}
+}
+
+// topicRecovered marks a topic as recovered and reactivates the session once
+// all of its dropped topics are back
+func (c *WalletClient) topicRecovered(session *Session, topic string) {
+	c.mutex.Lock()
+	delete(c.graceTopics, topic)
+	for _, pending := range c.graceTopics {
+		if pending == session {
+			c.mutex.Unlock()
+			c.logger.Infof("Recovered topic %s for session %s, waiting for its other topics", topic, session.ID)
+			return
+		}
+	}
+	c.updateSession(session, func(s *Session) { s.SetStatus(SessionStatusActive) })
+	c.mutex.Unlock()
+
+	c.logger.Infof("Session %s recovered its relay connection", session.ID)
+	c.emitSessionEvent(session, SessionEventReconnected)
+}
+
+// finalizeGrace gives up on a reconnecting session and disconnects it
+func (c *WalletClient) finalizeGrace(session *Session) {
+	c.mutex.Lock()
+	for topic, pending := range c.graceTopics {
+		if pending == session {
+			delete(c.graceTopics, topic)
+		}
+	}
+	reconnecting := session.Status == SessionStatusReconnecting
+	c.mutex.Unlock()
+
+	if !reconnecting {
+		return
+	}
+
+	c.emitSessionEvent(session, SessionEventReconnectFailed)
+	if err := c.DisconnectSession(session, ReasonConnectionLost); err != nil {
+		c.logger.Errorf("Failed to disconnect session %s: %v", session.ID, err)
+	}
+}
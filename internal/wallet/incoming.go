@@ -0,0 +1,131 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// IncomingKind classifies a JSON-RPC message received from the wallet
+type IncomingKind string
+
+const (
+	// IncomingRequest is a request from the wallet, such as wc_sessionSettle
+	IncomingRequest IncomingKind = "request"
+	// IncomingResponse is a successful response to one of our requests
+	IncomingResponse IncomingKind = "response"
+	// IncomingError is an error response to one of our requests
+	IncomingError IncomingKind = "error"
+)
+
+// ErrMalformedMessage is returned for messages that are not valid JSON-RPC
+var ErrMalformedMessage = errors.New("malformed JSON-RPC message")
+
+// IncomingMessage is a decrypted JSON-RPC message classified by ParseIncoming
+type IncomingMessage struct {
+	Kind   IncomingKind
+	ID     int
+	Method string          // set for requests
+	Params json.RawMessage // set for requests
+	Result json.RawMessage // set for responses
+	Error  *ResponseError  // set for error responses
+	Raw    []byte          // the message as received
+}
+
+// ParseIncoming classifies a decrypted JSON-RPC message as a request, a
+// response or an error response and extracts its ID and method. The ID may
+// be a number or a string holding an integer, as some wallets send. A
+// missing jsonrpc field is tolerated, but any version other than 2.0 is not.
+func ParseIncoming(decrypted []byte) (IncomingMessage, error) {
+	var message struct {
+		JSONRPC *string         `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Method  *string         `json:"method"`
+		Params  json.RawMessage `json:"params"`
+		Result  json.RawMessage `json:"result"`
+		Error   json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(decrypted, &message); err != nil {
+		return IncomingMessage{}, fmt.Errorf("%w: %v", ErrMalformedMessage, err)
+	}
+
+	if message.JSONRPC != nil && *message.JSONRPC != "2.0" {
+		return IncomingMessage{}, fmt.Errorf("%w: unsupported jsonrpc version %q", ErrMalformedMessage, *message.JSONRPC)
+	}
+
+	id, err := parseMessageID(message.ID)
+	if err != nil {
+		return IncomingMessage{}, err
+	}
+
+	incoming := IncomingMessage{ID: id, Raw: decrypted}
+	switch {
+	case message.Method != nil:
+		if *message.Method == "" {
+			return IncomingMessage{}, fmt.Errorf("%w: empty method", ErrMalformedMessage)
+		}
+		incoming.Kind = IncomingRequest
+		incoming.Method = *message.Method
+		incoming.Params = message.Params
+	case isJSONValue(message.Error):
+		var responseErr ResponseError
+		if err := json.Unmarshal(message.Error, &responseErr); err != nil {
+			return IncomingMessage{}, fmt.Errorf("%w: invalid error: %v", ErrMalformedMessage, err)
+		}
+		incoming.Kind = IncomingError
+		incoming.Error = &responseErr
+	case message.Result != nil:
+		incoming.Kind = IncomingResponse
+		incoming.Result = message.Result
+	default:
+		return IncomingMessage{}, fmt.Errorf("%w: neither method, result nor error", ErrMalformedMessage)
+	}
+
+	// Responses must say which request they answer
+	if incoming.Kind != IncomingRequest && len(message.ID) == 0 {
+		return IncomingMessage{}, fmt.Errorf("%w: %s without an ID", ErrMalformedMessage, incoming.Kind)
+	}
+
+	return incoming, nil
+}
+
+// parseMessageID parses a JSON-RPC ID given as a number or a string holding
+// an integer. A missing or null ID is zero.
+func parseMessageID(raw json.RawMessage) (int, error) {
+	if !isJSONValue(raw) {
+		return 0, nil
+	}
+
+	var text string
+	if raw[0] == '"' {
+		if err := json.Unmarshal(raw, &text); err != nil {
+			return 0, fmt.Errorf("%w: invalid ID: %v", ErrMalformedMessage, err)
+		}
+	} else {
+		text = string(raw)
+	}
+
+	id, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: ID %s is not an integer", ErrMalformedMessage, raw)
+	}
+	return int(id), nil
+}
+
+// isJSONValue reports whether a raw field was present and not null
+func isJSONValue(raw json.RawMessage) bool {
+	return len(raw) > 0 && !bytes.Equal(raw, []byte("null"))
+}
+
+// signResponse converts a response or error response into a SignResponse
+func (m IncomingMessage) signResponse() (*SignResponse, error) {
+	response := &SignResponse{ID: m.ID, Error: m.Error, RawResult: m.Result}
+	if len(m.Result) > 0 && m.Result[0] == '"' {
+		if err := json.Unmarshal(m.Result, &response.Result); err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
+}
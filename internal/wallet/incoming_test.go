@@ -0,0 +1,98 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseIncoming(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    IncomingKind
+		wantID  int
+		wantErr bool
+	}{
+		{name: "request", message: `{"jsonrpc":"2.0","id":1,"method":"wc_sessionSettle","params":{}}`, want: IncomingRequest, wantID: 1},
+		{name: "request without id", message: `{"jsonrpc":"2.0","method":"wc_sessionPing"}`, want: IncomingRequest},
+		{name: "response", message: `{"jsonrpc":"2.0","id":2,"result":"0xabc"}`, want: IncomingResponse, wantID: 2},
+		{name: "null result", message: `{"jsonrpc":"2.0","id":3,"result":null}`, want: IncomingResponse, wantID: 3},
+		{name: "error response", message: `{"jsonrpc":"2.0","id":4,"error":{"code":5000,"message":"User rejected."}}`, want: IncomingError, wantID: 4},
+		{name: "null error with result", message: `{"jsonrpc":"2.0","id":5,"result":true,"error":null}`, want: IncomingResponse, wantID: 5},
+		{name: "string id", message: `{"jsonrpc":"2.0","id":"1699999999999999","result":"0xabc"}`, want: IncomingResponse, wantID: 1699999999999999},
+		{name: "missing jsonrpc", message: `{"id":6,"result":"0xabc"}`, want: IncomingResponse, wantID: 6},
+		{name: "wrong jsonrpc", message: `{"jsonrpc":"1.0","id":7,"result":"0xabc"}`, wantErr: true},
+		{name: "empty method", message: `{"jsonrpc":"2.0","id":8,"method":""}`, wantErr: true},
+		{name: "response without id", message: `{"jsonrpc":"2.0","result":"0xabc"}`, wantErr: true},
+		{name: "non-integer id", message: `{"jsonrpc":"2.0","id":"abc","result":"0xabc"}`, wantErr: true},
+		{name: "invalid error", message: `{"jsonrpc":"2.0","id":9,"error":"rejected"}`, wantErr: true},
+		{name: "neither", message: `{"jsonrpc":"2.0","id":10}`, wantErr: true},
+		{name: "not json", message: `not json`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			incoming, err := ParseIncoming([]byte(tt.message))
+			if tt.wantErr {
+				if !errors.Is(err, ErrMalformedMessage) {
+					t.Errorf("got %+v, %v, want ErrMalformedMessage", incoming, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if incoming.Kind != tt.want || incoming.ID != tt.wantID {
+				t.Errorf("got %s %d, want %s %d", incoming.Kind, incoming.ID, tt.want, tt.wantID)
+			}
+			if string(incoming.Raw) != tt.message {
+				t.Errorf("got raw %s, want the message as received", incoming.Raw)
+			}
+		})
+	}
+}
+
+func TestParseIncomingFields(t *testing.T) {
+	request, err := ParseIncoming([]byte(`{"jsonrpc":"2.0","id":1,"method":"wc_sessionSettle","params":{"expiry":1}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.Method != "wc_sessionSettle" || string(request.Params) != `{"expiry":1}` {
+		t.Errorf("got method %q and params %s", request.Method, request.Params)
+	}
+
+	errResponse, err := ParseIncoming([]byte(`{"jsonrpc":"2.0","id":2,"error":{"code":5000,"message":"User rejected."}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errResponse.Error == nil || errResponse.Error.Code != 5000 || errResponse.Error.Message != "User rejected." {
+		t.Errorf("got error %+v", errResponse.Error)
+	}
+}
+
+func TestIncomingSignResponse(t *testing.T) {
+	incoming, err := ParseIncoming([]byte(`{"jsonrpc":"2.0","id":1,"result":"0xabc"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := incoming.signResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.ID != 1 || response.Result != "0xabc" || response.Error != nil {
+		t.Errorf("got %+v, want the string result", response)
+	}
+
+	// Results that are not strings are kept raw
+	incoming, err = ParseIncoming([]byte(`{"jsonrpc":"2.0","id":2,"result":{"accepted":true}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err = incoming.signResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Result != "" || string(response.RawResult) != `{"accepted":true}` {
+		t.Errorf("got %+v, want the raw result", response)
+	}
+}
@@ -0,0 +1,127 @@
+package wallet
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/korjavin/wctestapp/internal/relay"
+)
+
+const (
+	// DefaultPingInterval is how often topic connections are pinged unless configured otherwise
+	DefaultPingInterval = 30 * time.Second
+	// pingWriteTimeout bounds how long sending a ping may block
+	pingWriteTimeout = 10 * time.Second
+)
+
+// keepalive tracks the liveness of one topic connection. Every frame received
+// from the relay, including pongs, pushes the connection's read deadline out
+// by timeout, so a half-open connection makes the listener's read fail.
+type keepalive struct {
+	conn     *websocket.Conn
+	interval time.Duration // zero disables pings and read deadlines
+	timeout  time.Duration
+	lastSeen atomic.Int64 // Unix nanoseconds of the last frame received
+}
+
+// touch records that a frame was received and extends the read deadline
+func (k *keepalive) touch() {
+	now := time.Now()
+	k.lastSeen.Store(now.UnixNano())
+	if k.interval > 0 {
+		k.conn.SetReadDeadline(now.Add(k.timeout))
+	}
+}
+
+// alive reports whether a frame was received within the timeout
+func (k *keepalive) alive() bool {
+	if k.interval <= 0 {
+		return true
+	}
+	return time.Since(time.Unix(0, k.lastSeen.Load())) < k.timeout
+}
+
+// SetPingInterval sets how often topic connections are pinged and how long a
+// connection may go without receiving any frame before it is considered dead
+// and re-dialed. A readTimeout that does not exceed the interval defaults to
+// twice the interval; a non-positive interval disables pings. It applies to
+// connections made afterwards.
+func (c *WalletClient) SetPingInterval(interval, readTimeout time.Duration) {
+	if interval <= 0 {
+		interval, readTimeout = 0, 0
+	} else if readTimeout <= interval {
+		readTimeout = 2 * interval
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.pingInterval = interval
+	c.readTimeout = readTimeout
+}
+
+// IsTopicAlive reports whether the client has a connection for the topic that
+// has received a frame from the relay within the read timeout
+func (c *WalletClient) IsTopicAlive(topic string) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	conn, ok := c.connections[topic]
+	if !ok {
+		return false
+	}
+	k, ok := c.keepalives[conn]
+	return ok && k.alive()
+}
+
+// newKeepalive sets up liveness tracking for a new topic connection that is
+// pinged every interval and dropped after timeout without frames
+func newKeepalive(conn *websocket.Conn, frames *relay.FrameStats, interval, timeout time.Duration) *keepalive {
+	k := &keepalive{conn: conn, interval: interval, timeout: timeout}
+	k.lastSeen.Store(time.Now().UnixNano())
+
+	pingHandler := relay.CountingPingHandler(conn, frames)
+	conn.SetPingHandler(func(appData string) error {
+		k.touch()
+		return pingHandler(appData)
+	})
+	conn.SetPongHandler(func(string) error {
+		frames.RecordReceived(websocket.PongMessage)
+		k.touch()
+		return nil
+	})
+	return k
+}
+
+// pingTopic pings a topic's connection until the connection is replaced or
+// closed or the client is closed. A failed ping closes the connection, which
+// makes its listener hand the topic to the reconnection path.
+func (c *WalletClient) pingTopic(topic string, k *keepalive, frames *relay.FrameStats) {
+	defer c.listenerWg.Done()
+
+	ticker := time.NewTicker(k.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		c.mutex.RLock()
+		current := c.connections[topic] == k.conn
+		c.mutex.RUnlock()
+		if !current {
+			return
+		}
+
+		if err := k.conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(pingWriteTimeout)); err != nil {
+			c.logger.Warnf("Failed to ping relay on topic %s, dropping the connection: %v", topic, err)
+			k.conn.Close()
+			return
+		}
+		frames.RecordSent(websocket.PingMessage)
+	}
+}
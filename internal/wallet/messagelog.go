@@ -0,0 +1,98 @@
+package wallet
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// maxLoggedMessages is the maximum number of messages kept per session
+const maxLoggedMessages = 100
+
+// LoggedMessage is a decrypted JSON-RPC message exchanged with a wallet
+type LoggedMessage struct {
+	Time      time.Time       `json:"time"`
+	Direction string          `json:"direction"` // "in" or "out"
+	Topic     string          `json:"topic"`
+	Method    string          `json:"method,omitempty"`
+	ID        any             `json:"id,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"` // only recorded when payloads are enabled
+}
+
+// messageLog keeps a bounded, ordered log of decrypted messages per session
+type messageLog struct {
+	enabled         bool
+	includePayloads bool
+	messages        map[string][]LoggedMessage // session ID -> messages
+	mutex           sync.Mutex
+}
+
+// newMessageLog creates a new, disabled message log
+func newMessageLog() *messageLog {
+	return &messageLog{
+		messages: make(map[string][]LoggedMessage),
+	}
+}
+
+// configure enables or disables the log and payload recording
+func (l *messageLog) configure(enabled bool, includePayloads bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.enabled = enabled
+	l.includePayloads = includePayloads
+	if !enabled {
+		l.messages = make(map[string][]LoggedMessage)
+	}
+}
+
+// record adds a decrypted message to a session's log
+func (l *messageLog) record(sessionID string, direction string, topic string, decrypted []byte) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if !l.enabled {
+		return
+	}
+
+	var envelope struct {
+		ID     any    `json:"id"`
+		Method string `json:"method"`
+	}
+	_ = json.Unmarshal(decrypted, &envelope)
+
+	entry := LoggedMessage{
+		Time:      time.Now(),
+		Direction: direction,
+		Topic:     topic,
+		Method:    envelope.Method,
+		ID:        envelope.ID,
+	}
+	if l.includePayloads && json.Valid(decrypted) {
+		entry.Payload = append(json.RawMessage(nil), decrypted...)
+	}
+
+	messages := append(l.messages[sessionID], entry)
+	if len(messages) > maxLoggedMessages {
+		messages = messages[len(messages)-maxLoggedMessages:]
+	}
+	l.messages[sessionID] = messages
+}
+
+// get returns a copy of a session's log, oldest first
+func (l *messageLog) get(sessionID string) []LoggedMessage {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	messages := make([]LoggedMessage, len(l.messages[sessionID]))
+	copy(messages, l.messages[sessionID])
+	return messages
+}
+
+// remove drops a session's log
+func (l *messageLog) remove(sessionID string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.messages, sessionID)
+}
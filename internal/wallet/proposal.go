@@ -0,0 +1,330 @@
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/korjavin/wctestapp/pkg/utils"
+)
+
+const (
+	// ChainEthereum is the CAIP-2 ID of Ethereum mainnet
+	ChainEthereum = "eip155:1"
+	// ChainPolygon is the CAIP-2 ID of Polygon PoS
+	ChainPolygon = "eip155:137"
+)
+
+// Namespace is a WalletConnect v2 session namespace: the chains, methods and
+// events a session may use within one chain family such as eip155. Once the
+// session is settled it also holds the approved accounts as CAIP-10 IDs,
+// e.g. eip155:1:0xab16a96D359eC26a11e2C2b3d8f8B8942d5Bfcdb.
+type Namespace struct {
+	Chains   []string `json:"chains,omitempty"`
+	Methods  []string `json:"methods"`
+	Events   []string `json:"events"`
+	Accounts []string `json:"accounts,omitempty"`
+}
+
+// RequiredNamespaces returns the namespaces a wallet must approve for a session
+func RequiredNamespaces() map[string]Namespace {
+	return map[string]Namespace{
+		"eip155": {
+			Chains:  []string{ChainEthereum},
+			Methods: []string{"personal_sign", "eth_signTypedData_v4", "eth_sendTransaction"},
+			Events:  []string{"chainChanged", "accountsChanged"},
+		},
+	}
+}
+
+// OptionalNamespaces returns the namespaces a wallet may additionally approve
+func OptionalNamespaces() map[string]Namespace {
+	return map[string]Namespace{
+		"eip155": {
+			Chains:  []string{ChainPolygon},
+			Methods: []string{"personal_sign", "eth_signTypedData_v4", "eth_sendTransaction"},
+			Events:  []string{"chainChanged", "accountsChanged"},
+		},
+	}
+}
+
+// RelayOptions names the relay protocol used by a session
+type RelayOptions struct {
+	Protocol string `json:"protocol"`
+}
+
+// Participant identifies one side of a session
+type Participant struct {
+	PublicKey string               `json:"publicKey"`
+	Metadata  *ParticipantMetadata `json:"metadata,omitempty"`
+}
+
+// ParticipantMetadata describes the app behind a participant
+type ParticipantMetadata struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	URL         string   `json:"url"`
+	Icons       []string `json:"icons"`
+}
+
+// SessionProposal holds the params of a wc_sessionPropose request
+type SessionProposal struct {
+	Relays             []RelayOptions       `json:"relays"`
+	Proposer           Participant          `json:"proposer"`
+	RequiredNamespaces map[string]Namespace `json:"requiredNamespaces"`
+	OptionalNamespaces map[string]Namespace `json:"optionalNamespaces,omitempty"`
+}
+
+// SessionSettlement holds the params of a wc_sessionSettle request
+type SessionSettlement struct {
+	Relay      RelayOptions         `json:"relay"`
+	Controller Participant          `json:"controller"`
+	Namespaces map[string]Namespace `json:"namespaces"`
+	Expiry     int64                `json:"expiry"` // Unix seconds
+}
+
+// protocolRequest is a WalletConnect protocol request, whose params are an object
+type protocolRequest struct {
+	ID      int    `json:"id"`
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+// protocolResponse is a response to a WalletConnect protocol request
+type protocolResponse struct {
+	ID      int            `json:"id"`
+	JSONRPC string         `json:"jsonrpc"`
+	Result  any            `json:"result,omitempty"`
+	Error   *ResponseError `json:"error,omitempty"`
+}
+
+// ErrInvalidSettlement is returned for session settlements that do not
+// approve the required namespaces or carry malformed accounts
+var ErrInvalidSettlement = errors.New("invalid session settlement")
+
+// NewSessionProposal creates the proposal for a session, asking for the
+// required and optional namespaces
+func NewSessionProposal(session *Session) SessionProposal {
+	proposer := Participant{
+		Metadata: &ParticipantMetadata{
+			Name:        "WalletConnect Test App",
+			Description: "Educational WalletConnect v2.0 implementation",
+			Icons:       []string{},
+		},
+	}
+	if session.ClientPubKey != nil {
+		proposer.PublicKey = utils.PublicKeyToHex(session.ClientPubKey)
+	}
+
+	return SessionProposal{
+		Relays:             []RelayOptions{{Protocol: "irn"}},
+		Proposer:           proposer,
+		RequiredNamespaces: RequiredNamespaces(),
+		OptionalNamespaces: OptionalNamespaces(),
+	}
+}
+
+// ProposeSession publishes a wc_sessionPropose request on the session's
+// pairing topic. The wallet answers on the pairing topic and then settles
+// the session on the session topic with wc_sessionSettle, so the session
+// topic is subscribed first.
+func (c *WalletClient) ProposeSession(session *Session) error {
+	if err := c.connectToTopic(session.SessionTopic); err != nil {
+		return fmt.Errorf("failed to subscribe to session topic of session %s: %w", session.ID, err)
+	}
+
+	request := &protocolRequest{
+		ID:      c.nextRequestID(),
+		JSONRPC: "2.0",
+		Method:  "wc_sessionPropose",
+		Params:  NewSessionProposal(session),
+	}
+
+	if err := c.publishEncrypted(session, topicKindPairing, request); err != nil {
+		return fmt.Errorf("failed to propose session %s: %w", session.ID, err)
+	}
+
+	c.logger.Infof("Proposed session %s with required chains %v", session.ID, RequiredNamespaces()["eip155"].Chains)
+	return nil
+}
+
+// handleSessionSettle applies a wc_sessionSettle request from the wallet:
+// the approved namespaces are stored, the wallet address and chain are taken
+// from the account on the first required chain, and the session is activated.
+// Settlements that do not approve the required chains are rejected.
+func (c *WalletClient) handleSessionSettle(session *Session, decrypted string) {
+	var request struct {
+		ID     int               `json:"id"`
+		Params SessionSettlement `json:"params"`
+	}
+	if err := json.Unmarshal([]byte(decrypted), &request); err != nil {
+		c.logger.Errorf("Failed to parse session settlement for session %s: %v", session.ID, err)
+		return
+	}
+
+	address, chainID, err := request.Params.primaryAccount()
+	if err != nil {
+		c.logger.Warnf("Rejecting settlement of session %s: %v", session.ID, err)
+		c.respond(session, request.ID, nil, &ResponseError{Code: -32602, Message: err.Error()})
+		return
+	}
+
+	session.SetNamespaces(request.Params.Namespaces)
+	session.SetAccounts(namespaceAccounts(request.Params.Namespaces))
+	session.SetPeerID(request.Params.Controller.PublicKey)
+	session.SetChainID(chainID)
+	if request.Params.Expiry > 0 {
+		session.ExpiresAt = time.Unix(request.Params.Expiry, 0)
+	}
+	c.SetWalletAddress(session, address)
+	if session.Status != SessionStatusActive {
+		c.ActivateSession(session)
+	}
+
+	c.logger.Infof("Session %s settled by %s on chains %v", session.ID, address.Hex(), session.Chains())
+	c.respond(session, request.ID, true, nil)
+}
+
+// handleSessionUpdate applies a wc_sessionUpdate request from the wallet,
+// which replaces the session's namespaces and with them its accounts. If the
+// session's wallet address is no longer among the accounts, the first
+// account becomes the wallet address.
+func (c *WalletClient) handleSessionUpdate(session *Session, decrypted string) {
+	var request struct {
+		ID     int `json:"id"`
+		Params struct {
+			Namespaces map[string]Namespace `json:"namespaces"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal([]byte(decrypted), &request); err != nil {
+		c.logger.Errorf("Failed to parse session update for session %s: %v", session.ID, err)
+		return
+	}
+
+	accounts := namespaceAccounts(request.Params.Namespaces)
+	session.SetNamespaces(request.Params.Namespaces)
+	session.SetAccounts(accounts)
+	if len(accounts) == 0 {
+		c.logger.Warnf("Wallet removed all accounts from session %s", session.ID)
+	} else if !slices.Contains(accounts, session.WalletAddress) {
+		session.SetWalletAddress(accounts[0])
+	}
+	c.saveSession(session)
+
+	c.logger.Infof("Session %s updated with %d accounts on chains %v", session.ID, len(accounts), session.Chains())
+	c.respond(session, request.ID, true, nil)
+}
+
+// respond publishes a response to a wallet request on the session topic
+func (c *WalletClient) respond(session *Session, id int, result any, responseErr *ResponseError) {
+	c.respondOn(session, topicKindSession, id, result, responseErr)
+}
+
+// respondOn publishes a response to a wallet request on the session's pairing or session topic
+func (c *WalletClient) respondOn(session *Session, kind topicKind, id int, result any, responseErr *ResponseError) {
+	response := &protocolResponse{
+		ID:      id,
+		JSONRPC: "2.0",
+		Result:  result,
+		Error:   responseErr,
+	}
+	if err := c.publishEncrypted(session, kind, response); err != nil {
+		c.logger.Errorf("Failed to respond to request %d of session %s: %v", id, session.ID, err)
+	}
+}
+
+// primaryAccount checks that the settlement approves all required chains and
+// returns the account on the first required chain and its EIP-155 chain ID
+func (s SessionSettlement) primaryAccount() (common.Address, int64, error) {
+	namespace, ok := s.Namespaces["eip155"]
+	if !ok || len(namespace.Accounts) == 0 {
+		return common.Address{}, 0, fmt.Errorf("%w: no eip155 accounts", ErrInvalidSettlement)
+	}
+
+	approved := namespaceChains(s.Namespaces)
+	required := RequiredNamespaces()["eip155"].Chains
+	for _, chain := range required {
+		if !slices.Contains(approved, chain) {
+			return common.Address{}, 0, fmt.Errorf("%w: required chain %s not approved", ErrInvalidSettlement, chain)
+		}
+	}
+
+	// Prefer the account on the first required chain, falling back to the first account
+	var primaryChain string
+	var primaryAddress common.Address
+	for i, account := range namespace.Accounts {
+		chain, address, err := parseAccountID(account)
+		if err != nil {
+			return common.Address{}, 0, err
+		}
+		if i == 0 || chain == required[0] && primaryChain != required[0] {
+			primaryChain, primaryAddress = chain, address
+		}
+	}
+
+	chainID, err := strconv.ParseInt(strings.TrimPrefix(primaryChain, "eip155:"), 10, 64)
+	if err != nil {
+		return common.Address{}, 0, fmt.Errorf("%w: invalid chain %s", ErrInvalidSettlement, primaryChain)
+	}
+	return primaryAddress, chainID, nil
+}
+
+// parseAccountID splits a CAIP-10 account ID into its chain and address
+func parseAccountID(account string) (string, common.Address, error) {
+	index := strings.LastIndex(account, ":")
+	if index <= 0 || !strings.Contains(account[:index], ":") {
+		return "", common.Address{}, fmt.Errorf("%w: malformed account %q", ErrInvalidSettlement, account)
+	}
+
+	address, err := ParseAddress(account[index+1:])
+	if err != nil {
+		return "", common.Address{}, fmt.Errorf("%w: account %q: %v", ErrInvalidSettlement, account, err)
+	}
+	return account[:index], address, nil
+}
+
+// namespaceChains returns the chains of the namespaces, including the chains
+// of their accounts, sorted and without duplicates
+func namespaceChains(namespaces map[string]Namespace) []string {
+	chains := []string{}
+	for _, namespace := range namespaces {
+		chains = append(chains, namespace.Chains...)
+		for _, account := range namespace.Accounts {
+			if index := strings.LastIndex(account, ":"); index > 0 {
+				chains = append(chains, account[:index])
+			}
+		}
+	}
+	slices.Sort(chains)
+	return slices.Compact(chains)
+}
+
+// namespaceAccounts returns the distinct addresses of the eip155 accounts in
+// the namespaces, in order of first appearance. Malformed accounts are skipped.
+func namespaceAccounts(namespaces map[string]Namespace) []common.Address {
+	accounts := []common.Address{}
+	for _, account := range namespaces["eip155"].Accounts {
+		_, address, err := parseAccountID(account)
+		if err == nil && !slices.Contains(accounts, address) {
+			accounts = append(accounts, address)
+		}
+	}
+	return accounts
+}
+
+// approvedNamespaces returns the namespaces a wallet approving all required
+// and optional eip155 chains for one address would settle with
+func approvedNamespaces(address common.Address) map[string]Namespace {
+	namespace := RequiredNamespaces()["eip155"]
+	namespace.Chains = append(namespace.Chains, OptionalNamespaces()["eip155"].Chains...)
+	for _, chain := range namespace.Chains {
+		namespace.Accounts = append(namespace.Accounts, chain+":"+address.Hex())
+	}
+	return map[string]Namespace{"eip155": namespace}
+}
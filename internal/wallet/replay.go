@@ -0,0 +1,114 @@
+package wallet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/korjavin/wctestapp/internal/relay"
+)
+
+// ReplaySource feeds relay notifications captured by the relay's frame
+// recorder back into a wallet client's message path, bypassing the socket.
+// This turns a saved transcript of a failing pairing into a reproducible run.
+type ReplaySource struct {
+	frames   []relay.FrameRecord
+	speed    float64 // multiplier applied to the recorded gaps between frames
+	clientID string  // only replay frames sent to this client, if set
+}
+
+// NewReplaySource creates a replay source from recorded frames. Only frames
+// sent by the relay ("out") with the irn_subscription, message or irn_receipt
+// method and a recorded payload are replayed. By default frames are replayed
+// without delay.
+func NewReplaySource(frames []relay.FrameRecord) *ReplaySource {
+	return &ReplaySource{frames: frames}
+}
+
+// LoadReplaySource reads a capture, a JSON array of recorded frames as
+// returned by the relay's frame recorder, into a replay source
+func LoadReplaySource(r io.Reader) (*ReplaySource, error) {
+	var frames []relay.FrameRecord
+	if err := json.NewDecoder(r).Decode(&frames); err != nil {
+		return nil, fmt.Errorf("failed to parse capture: %w", err)
+	}
+	return NewReplaySource(frames), nil
+}
+
+// SetSpeed sets the replay timing. A speed of 1 keeps the recorded gaps
+// between frames, 2 halves them, and 0 replays all frames without delay.
+func (s *ReplaySource) SetSpeed(speed float64) {
+	if speed < 0 {
+		speed = 0
+	}
+	s.speed = speed
+}
+
+// SetClientID restricts the replay to frames the relay sent to one client.
+// An empty client ID replays frames sent to any client.
+func (s *ReplaySource) SetClientID(clientID string) {
+	s.clientID = clientID
+}
+
+// replayable checks if a recorded frame is a notification the client handles
+func (s *ReplaySource) replayable(frame relay.FrameRecord) bool {
+	if frame.Direction != "out" || len(frame.Payload) == 0 {
+		return false
+	}
+	if s.clientID != "" && frame.ClientID != s.clientID {
+		return false
+	}
+	return frame.Method == "irn_subscription" || frame.Method == "message" || frame.Method == "irn_receipt"
+}
+
+// Replay feeds the frames of a replay source into the client as if they had
+// been received from the relay, and returns how many frames were replayed.
+// Sessions must already exist for the replayed topics for messages to be
+// decrypted. Replay stops early when ctx is done.
+func (c *WalletClient) Replay(ctx context.Context, source *ReplaySource) (int, error) {
+	replayed := 0
+	var previous time.Time
+
+	for _, frame := range source.frames {
+		if !source.replayable(frame) {
+			continue
+		}
+
+		// Keep the recorded gap to the previous frame, scaled by the speed
+		if source.speed > 0 && !previous.IsZero() {
+			gap := time.Duration(float64(frame.Time.Sub(previous)) / source.speed)
+			if gap > 0 {
+				select {
+				case <-time.After(gap):
+				case <-ctx.Done():
+					return replayed, ctx.Err()
+				}
+			}
+		}
+		previous = frame.Time
+
+		if err := ctx.Err(); err != nil {
+			return replayed, err
+		}
+
+		var notification struct {
+			Params struct {
+				Topic string `json:"topic"`
+			} `json:"params"`
+		}
+		if err := json.Unmarshal(frame.Payload, &notification); err != nil {
+			c.logger.Warnf("Skipping replayed frame for client %s: %v", frame.ClientID, err)
+			continue
+		}
+
+		c.logger.Debugf("Replaying %s frame recorded at %s for topic %s",
+			frame.Method, frame.Time.Format(time.RFC3339Nano), notification.Params.Topic)
+		c.handleNotification(nil, notification.Params.Topic, frame.Payload)
+		replayed++
+	}
+
+	c.logger.Infof("Replayed %d recorded frames", replayed)
+	return replayed, nil
+}
@@ -2,9 +2,15 @@ package wallet
 
 import (
 	"crypto/ecdsa"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/url"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -21,28 +27,64 @@ const (
 	SessionStatusActive SessionStatus = "active"
 	// SessionStatusDisconnected indicates a disconnected session
 	SessionStatusDisconnected SessionStatus = "disconnected"
+	// SessionStatusRelayUnavailable indicates a session whose relay subscription could not be established
+	SessionStatusRelayUnavailable SessionStatus = "relay_unavailable"
+	// SessionStatusReconnecting indicates a session whose relay connection dropped and is waiting to recover
+	// within the disconnect grace period
+	SessionStatusReconnecting SessionStatus = "reconnecting"
 )
 
+// DefaultChainID is the EIP-155 chain ID assumed for new sessions (Ethereum mainnet)
+const DefaultChainID int64 = 1
+
 // Session represents a WalletConnect session
 type Session struct {
 	ID            string            `json:"id"`
 	PairingTopic  string            `json:"pairing_topic"`
 	SessionTopic  string            `json:"session_topic"`
 	SymKey        string            `json:"sym_key"`
+	RelayProtocol string            `json:"relay_protocol,omitempty"`
+	RelayURL      string            `json:"relay_url,omitempty"`
 	ClientID      string            `json:"client_id"`
 	PeerID        string            `json:"peer_id"`
 	ClientPubKey  *ecdsa.PublicKey  `json:"-"`
 	ClientPrivKey *ecdsa.PrivateKey `json:"-"`
 	PeerPubKey    *ecdsa.PublicKey  `json:"-"`
 	WalletAddress common.Address    `json:"wallet_address"`
+	ChainID       int64             `json:"chain_id"`
 	Status        SessionStatus     `json:"status"`
 	CreatedAt     time.Time         `json:"created_at"`
 	UpdatedAt     time.Time         `json:"updated_at"`
 	ExpiresAt     time.Time         `json:"expires_at"`
+
+	// Namespaces approved by the wallet when it settled the session
+	Namespaces map[string]Namespace `json:"namespaces,omitempty"`
+	// Accounts the wallet exposes, as last reported by eth_accounts or a session update
+	Accounts []common.Address `json:"accounts,omitempty"`
+
+	ActivatedAt    time.Time `json:"activated_at,omitempty"`
+	DisconnectedAt time.Time `json:"disconnected_at,omitempty"`
+
+	// skewTolerance delays expiry to absorb clock differences between peers
+	skewTolerance time.Duration
 }
 
-// NewSession creates a new WalletConnect session
+// DefaultSessionTTL is how long new sessions live unless configured otherwise
+const DefaultSessionTTL = 24 * time.Hour
+
+// NewSession creates a new WalletConnect session with separate pairing and session topics
 func NewSession() (*Session, error) {
+	return newSession(false, DefaultSessionTTL)
+}
+
+// NewSingleTopicSession creates a new WalletConnect session that uses the same
+// topic for the pairing and session phases, for relays that do not distinguish them
+func NewSingleTopicSession() (*Session, error) {
+	return newSession(true, DefaultSessionTTL)
+}
+
+// newSession creates a new WalletConnect session that expires after ttl
+func newSession(singleTopic bool, ttl time.Duration) (*Session, error) {
 	// Generate a random session ID
 	id, err := utils.GenerateRandomHex(32)
 	if err != nil {
@@ -55,10 +97,13 @@ func NewSession() (*Session, error) {
 		return nil, fmt.Errorf("failed to generate pairing topic: %w", err)
 	}
 
-	// Generate a random session topic
-	sessionTopic, err := utils.GenerateRandomTopic()
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate session topic: %w", err)
+	// Generate a random session topic, unless the pairing topic is reused
+	sessionTopic := pairingTopic
+	if !singleTopic {
+		sessionTopic, err = utils.GenerateRandomTopic()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate session topic: %w", err)
+		}
 	}
 
 	// Generate a symmetric key
@@ -86,10 +131,11 @@ func NewSession() (*Session, error) {
 		ClientID:      clientID,
 		ClientPubKey:  clientPubKey,
 		ClientPrivKey: clientPrivKey,
+		ChainID:       DefaultChainID,
 		Status:        SessionStatusPending,
 		CreatedAt:     now,
 		UpdatedAt:     now,
-		ExpiresAt:     now.Add(24 * time.Hour), // Sessions expire after 24 hours
+		ExpiresAt:     now.Add(ttl),
 	}
 
 	return session, nil
@@ -105,7 +151,8 @@ func (s *Session) GeneratePairingURI() string {
 	return uri
 }
 
-// GeneratePairingURIWithRelay generates a pairing URI that includes the relay URL
+// GeneratePairingURIWithRelay generates a pairing URI that includes the relay URL.
+// Callers should check IsSecureRelayURL and warn before handing out a ws:// relay.
 func (s *Session) GeneratePairingURIWithRelay(relayURL string) string {
 	// URL encode the relay URL
 	encodedRelayURL := url.QueryEscape(relayURL)
@@ -115,9 +162,135 @@ func (s *Session) GeneratePairingURIWithRelay(relayURL string) string {
 	return uri
 }
 
-// IsExpired checks if the session is expired
+// ErrInvalidPairingURI is returned by ParsePairingURI for URIs that are not
+// valid WalletConnect v2 pairing URIs
+var ErrInvalidPairingURI = errors.New("invalid pairing URI")
+
+// ParsePairingURI parses a WalletConnect v2 pairing URI created by another
+// dapp, so this app can act as the wallet side of the pairing.
+// Format: wc:{topic}@2?relay-protocol=irn&symKey={key}[&relay-url={url}]
+// The symmetric key may be hex, as used by WalletConnect, or base64, as used
+// by GeneratePairingURI, and is stored base64 encoded. The returned session is
+// pending and uses the pairing topic until a session topic is settled.
+func ParsePairingURI(uri string) (*Session, error) {
+	rest, ok := strings.CutPrefix(uri, "wc:")
+	if !ok {
+		return nil, fmt.Errorf("%w: scheme must be wc:", ErrInvalidPairingURI)
+	}
+
+	path, rawQuery, _ := strings.Cut(rest, "?")
+	topic, version, ok := strings.Cut(path, "@")
+	if !ok {
+		return nil, fmt.Errorf("%w: missing protocol version", ErrInvalidPairingURI)
+	}
+	if version != "2" {
+		return nil, fmt.Errorf("%w: unsupported protocol version %q, expected 2", ErrInvalidPairingURI, version)
+	}
+	if topicBytes, err := hex.DecodeString(topic); err != nil || len(topicBytes) != 32 {
+		return nil, fmt.Errorf("%w: topic must be 32 hex-encoded bytes", ErrInvalidPairingURI)
+	}
+
+	params, err := parsePairingQuery(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	relayProtocol := params["relay-protocol"]
+	if relayProtocol == "" {
+		return nil, fmt.Errorf("%w: missing relay-protocol", ErrInvalidPairingURI)
+	}
+
+	if params["symKey"] == "" {
+		return nil, fmt.Errorf("%w: missing symKey", ErrInvalidPairingURI)
+	}
+	symKey, err := normalizeSymKey(params["symKey"])
+	if err != nil {
+		return nil, err
+	}
+
+	relayURL := params["relay-url"]
+	if relayURL != "" {
+		parsed, err := url.Parse(relayURL)
+		if err != nil || (parsed.Scheme != "ws" && parsed.Scheme != "wss") || parsed.Host == "" {
+			return nil, fmt.Errorf("%w: relay-url must be a ws:// or wss:// URL", ErrInvalidPairingURI)
+		}
+	}
+
+	session, err := newSession(true, DefaultSessionTTL)
+	if err != nil {
+		return nil, err
+	}
+	session.PairingTopic = topic
+	session.SessionTopic = topic
+	session.SymKey = symKey
+	session.RelayProtocol = relayProtocol
+	session.RelayURL = relayURL
+
+	return session, nil
+}
+
+// parsePairingQuery parses the query of a pairing URI. Values are unescaped
+// without turning "+" into a space, since base64 keys are not escaped.
+func parsePairingQuery(rawQuery string) (map[string]string, error) {
+	params := make(map[string]string)
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, "=")
+		unescaped, err := url.PathUnescape(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: malformed %s parameter: %v", ErrInvalidPairingURI, key, err)
+		}
+		params[key] = unescaped
+	}
+	return params, nil
+}
+
+// normalizeSymKey decodes a hex or base64 symmetric key and returns it base64 encoded
+func normalizeSymKey(symKey string) (string, error) {
+	key, err := hex.DecodeString(symKey)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(symKey)
+	}
+	if err != nil || len(key) != 32 {
+		return "", fmt.Errorf("%w: symKey must be a 32-byte hex or base64 key", ErrInvalidPairingURI)
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// SingleTopic reports whether the session uses one topic for pairing and session phases
+func (s *Session) SingleTopic() bool {
+	return s.PairingTopic == s.SessionTopic
+}
+
+// Topics returns the distinct relay topics used by the session
+func (s *Session) Topics() []string {
+	if s.SingleTopic() {
+		return []string{s.PairingTopic}
+	}
+	return []string{s.PairingTopic, s.SessionTopic}
+}
+
+// IsExpired checks if the session is expired, allowing for the clock skew tolerance
 func (s *Session) IsExpired() bool {
-	return time.Now().After(s.ExpiresAt)
+	return s.isExpiredAt(time.Now())
+}
+
+// isExpiredAt checks if the session is expired at the given time
+func (s *Session) isExpiredAt(now time.Time) bool {
+	return now.After(s.ExpiresAt.Add(s.skewTolerance))
+}
+
+// SetClockSkewTolerance sets how long past ExpiresAt the session is still considered valid
+func (s *Session) SetClockSkewTolerance(tolerance time.Duration) {
+	s.skewTolerance = tolerance
+}
+
+// Extend pushes the session's expiry d further into the future
+func (s *Session) Extend(d time.Duration) {
+	s.ExpiresAt = s.ExpiresAt.Add(d)
+	s.UpdatedAt = time.Now()
 }
 
 // SetWalletAddress sets the wallet address for the session
@@ -126,6 +299,12 @@ func (s *Session) SetWalletAddress(address common.Address) {
 	s.UpdatedAt = time.Now()
 }
 
+// SetChainID sets the EIP-155 chain ID for the session
+func (s *Session) SetChainID(chainID int64) {
+	s.ChainID = chainID
+	s.UpdatedAt = time.Now()
+}
+
 // SetPeerID sets the peer ID for the session
 func (s *Session) SetPeerID(peerID string) {
 	s.PeerID = peerID
@@ -138,6 +317,23 @@ func (s *Session) SetPeerPubKey(pubKey *ecdsa.PublicKey) {
 	s.UpdatedAt = time.Now()
 }
 
+// SetNamespaces sets the namespaces the wallet approved for the session
+func (s *Session) SetNamespaces(namespaces map[string]Namespace) {
+	s.Namespaces = namespaces
+	s.UpdatedAt = time.Now()
+}
+
+// SetAccounts sets the accounts the wallet exposes for the session
+func (s *Session) SetAccounts(accounts []common.Address) {
+	s.Accounts = accounts
+	s.UpdatedAt = time.Now()
+}
+
+// Chains returns the CAIP-2 IDs of the chains approved for the session, such as eip155:1
+func (s *Session) Chains() []string {
+	return namespaceChains(s.Namespaces)
+}
+
 // SetStatus sets the status of the session
 func (s *Session) SetStatus(status SessionStatus) {
 	s.Status = status
@@ -146,59 +342,97 @@ func (s *Session) SetStatus(status SessionStatus) {
 
 // Activate activates the session
 func (s *Session) Activate() {
+	now := time.Now()
 	s.Status = SessionStatusActive
-	s.UpdatedAt = time.Now()
+	s.ActivatedAt = now
+	s.UpdatedAt = now
 }
 
 // Disconnect disconnects the session
 func (s *Session) Disconnect() {
+	now := time.Now()
 	s.Status = SessionStatusDisconnected
-	s.UpdatedAt = time.Now()
+	s.DisconnectedAt = now
+	s.UpdatedAt = now
+}
+
+// PairingDuration returns how long the session took to go from created to active.
+// It returns zero if the session was never activated.
+func (s *Session) PairingDuration() time.Duration {
+	if s.ActivatedAt.IsZero() {
+		return 0
+	}
+	return s.ActivatedAt.Sub(s.CreatedAt)
+}
+
+// ActiveDuration returns how long the session was active before disconnecting.
+// It returns zero if the session was never activated or is not yet disconnected.
+func (s *Session) ActiveDuration() time.Duration {
+	if s.ActivatedAt.IsZero() || s.DisconnectedAt.IsZero() {
+		return 0
+	}
+	return s.DisconnectedAt.Sub(s.ActivatedAt)
+}
+
+// SessionSummary is a key-free view of a session with public keys as hex strings.
+// It never includes the client private key.
+type SessionSummary struct {
+	ID             string        `json:"id"`
+	PairingTopic   string        `json:"pairing_topic"`
+	SessionTopic   string        `json:"session_topic"`
+	SymKey         string        `json:"sym_key"`
+	ClientID       string        `json:"client_id"`
+	PeerID         string        `json:"peer_id"`
+	ClientPubKey   string        `json:"client_pub_key"`
+	PeerPubKey     string        `json:"peer_pub_key,omitempty"`
+	WalletAddress  string        `json:"wallet_address"`
+	ChainID        int64         `json:"chain_id"`
+	Status         SessionStatus `json:"status"`
+	CreatedAt      time.Time     `json:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+	ExpiresAt      time.Time     `json:"expires_at"`
+	ActivatedAt    *time.Time    `json:"activated_at,omitempty"`
+	DisconnectedAt *time.Time    `json:"disconnected_at,omitempty"`
+}
+
+// Summary returns a key-free summary of the session
+func (s *Session) Summary() SessionSummary {
+	summary := SessionSummary{
+		ID:            s.ID,
+		PairingTopic:  s.PairingTopic,
+		SessionTopic:  s.SessionTopic,
+		SymKey:        s.SymKey,
+		ClientID:      s.ClientID,
+		PeerID:        s.PeerID,
+		WalletAddress: s.WalletAddress.Hex(),
+		ChainID:       s.ChainID,
+		Status:        s.Status,
+		CreatedAt:     s.CreatedAt,
+		UpdatedAt:     s.UpdatedAt,
+		ExpiresAt:     s.ExpiresAt,
+	}
+
+	if s.ClientPubKey != nil {
+		summary.ClientPubKey = utils.PublicKeyToHex(s.ClientPubKey)
+	}
+	if s.PeerPubKey != nil {
+		summary.PeerPubKey = utils.PublicKeyToHex(s.PeerPubKey)
+	}
+	if !s.ActivatedAt.IsZero() {
+		activatedAt := s.ActivatedAt
+		summary.ActivatedAt = &activatedAt
+	}
+	if !s.DisconnectedAt.IsZero() {
+		disconnectedAt := s.DisconnectedAt
+		summary.DisconnectedAt = &disconnectedAt
+	}
+
+	return summary
 }
 
 // ToJSON converts the session to JSON
 func (s *Session) ToJSON() (string, error) {
-	// Create a copy of the session without the private key
-	sessionCopy := *s
-	sessionCopy.ClientPrivKey = nil
-
-	// Convert the public keys to hex strings
-	type sessionJSON struct {
-		ID            string        `json:"id"`
-		PairingTopic  string        `json:"pairing_topic"`
-		SessionTopic  string        `json:"session_topic"`
-		SymKey        string        `json:"sym_key"`
-		ClientID      string        `json:"client_id"`
-		PeerID        string        `json:"peer_id"`
-		ClientPubKey  string        `json:"client_pub_key"`
-		PeerPubKey    string        `json:"peer_pub_key,omitempty"`
-		WalletAddress string        `json:"wallet_address"`
-		Status        SessionStatus `json:"status"`
-		CreatedAt     time.Time     `json:"created_at"`
-		UpdatedAt     time.Time     `json:"updated_at"`
-		ExpiresAt     time.Time     `json:"expires_at"`
-	}
-
-	jsonSession := sessionJSON{
-		ID:            sessionCopy.ID,
-		PairingTopic:  sessionCopy.PairingTopic,
-		SessionTopic:  sessionCopy.SessionTopic,
-		SymKey:        sessionCopy.SymKey,
-		ClientID:      sessionCopy.ClientID,
-		PeerID:        sessionCopy.PeerID,
-		ClientPubKey:  utils.PublicKeyToHex(sessionCopy.ClientPubKey),
-		WalletAddress: sessionCopy.WalletAddress.Hex(),
-		Status:        sessionCopy.Status,
-		CreatedAt:     sessionCopy.CreatedAt,
-		UpdatedAt:     sessionCopy.UpdatedAt,
-		ExpiresAt:     sessionCopy.ExpiresAt,
-	}
-
-	if sessionCopy.PeerPubKey != nil {
-		jsonSession.PeerPubKey = utils.PublicKeyToHex(sessionCopy.PeerPubKey)
-	}
-
-	bytes, err := json.Marshal(jsonSession)
+	bytes, err := json.Marshal(s.Summary())
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal session: %w", err)
 	}
@@ -206,36 +440,143 @@ func (s *Session) ToJSON() (string, error) {
 	return string(bytes), nil
 }
 
-// SessionManager manages WalletConnect sessions
+// SessionManager manages WalletConnect sessions. Sessions are cached in memory
+// and written through to a SessionStore so they can survive restarts. It is
+// safe for concurrent use.
 type SessionManager struct {
-	sessions map[string]*Session // session ID -> session
+	sessions    map[string]*Session // session ID -> session
+	store       SessionStore
+	singleTopic bool // create sessions that share one topic for pairing and session
+
+	skewTolerance time.Duration // clock skew tolerance applied to session expiry
+	ttl           time.Duration // lifetime of new sessions
+
+	mutex sync.RWMutex // guards all of the above
 }
 
-// NewSessionManager creates a new session manager
+// NewSessionManager creates a new session manager backed by an in-memory store
 func NewSessionManager() *SessionManager {
 	return &SessionManager{
 		sessions: make(map[string]*Session),
+		store:    NewMemorySessionStore(),
+		ttl:      DefaultSessionTTL,
+	}
+}
+
+// SetStore switches the manager to the given store and loads the sessions it
+// contains, replacing the sessions currently managed
+func (m *SessionManager) SetStore(store SessionStore) error {
+	stored, err := store.List()
+	if err != nil {
+		return fmt.Errorf("failed to load sessions: %w", err)
+	}
+
+	sessions := make(map[string]*Session, len(stored))
+	for _, session := range stored {
+		session.SetClockSkewTolerance(m.skewTolerance)
+		sessions[session.ID] = session
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.store = store
+	m.sessions = sessions
+	return nil
+}
+
+// SetSingleTopicMode makes new sessions use a single topic for both the pairing
+// and session phases. Existing sessions are not affected.
+func (m *SessionManager) SetSingleTopicMode(enabled bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.singleTopic = enabled
+}
+
+// SetSessionTTL sets how long new sessions live. Existing sessions are not
+// affected. A non-positive TTL restores DefaultSessionTTL.
+func (m *SessionManager) SetSessionTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = DefaultSessionTTL
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.ttl = ttl
+}
+
+// SetClockSkewTolerance sets the clock skew tolerance applied to the expiry of
+// new and existing sessions
+func (m *SessionManager) SetClockSkewTolerance(tolerance time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.skewTolerance = tolerance
+	for _, session := range m.sessions {
+		session.SetClockSkewTolerance(tolerance)
 	}
 }
 
 // CreateSession creates a new session
 func (m *SessionManager) CreateSession() (*Session, error) {
-	session, err := NewSession()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	session, err := newSession(m.singleTopic, m.ttl)
 	if err != nil {
 		return nil, err
 	}
+	session.SetClockSkewTolerance(m.skewTolerance)
+
+	if err := m.store.Save(session); err != nil {
+		return nil, fmt.Errorf("failed to save session: %w", err)
+	}
 
 	m.sessions[session.ID] = session
 	return session, nil
 }
 
+// SaveSession persists changes made to a session
+func (m *SessionManager) SaveSession(session *Session) error {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if _, ok := m.sessions[session.ID]; !ok {
+		return ErrSessionNotFound
+	}
+	return m.store.Save(session)
+}
+
+// UpdateSession applies update to a session and persists it. The update runs
+// under the manager's write lock, so it does not race with the manager's own
+// reads of the session, such as GetActiveSessions.
+func (m *SessionManager) UpdateSession(session *Session, update func(*Session)) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.sessions[session.ID]; !ok {
+		return ErrSessionNotFound
+	}
+	update(session)
+	return m.store.Save(session)
+}
+
 // GetSession gets a session by ID
 func (m *SessionManager) GetSession(id string) *Session {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
 	return m.sessions[id]
 }
 
-// GetSessionByPairingTopic gets a session by pairing topic
+// GetSessionByPairingTopic gets a session by pairing topic. Single-topic
+// sessions are found by either lookup, since both topics are the same.
 func (m *SessionManager) GetSessionByPairingTopic(topic string) *Session {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
 	for _, session := range m.sessions {
 		if session.PairingTopic == topic {
 			return session
@@ -246,6 +587,9 @@ func (m *SessionManager) GetSessionByPairingTopic(topic string) *Session {
 
 // GetSessionBySessionTopic gets a session by session topic
 func (m *SessionManager) GetSessionBySessionTopic(topic string) *Session {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
 	for _, session := range m.sessions {
 		if session.SessionTopic == topic {
 			return session
@@ -255,12 +599,19 @@ func (m *SessionManager) GetSessionBySessionTopic(topic string) *Session {
 }
 
 // RemoveSession removes a session
-func (m *SessionManager) RemoveSession(id string) {
+func (m *SessionManager) RemoveSession(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	delete(m.sessions, id)
+	return m.store.Delete(id)
 }
 
 // GetActiveSessions gets all active sessions
 func (m *SessionManager) GetActiveSessions() []*Session {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
 	var activeSessions []*Session
 	for _, session := range m.sessions {
 		if session.Status == SessionStatusActive && !session.IsExpired() {
@@ -270,11 +621,50 @@ func (m *SessionManager) GetActiveSessions() []*Session {
 	return activeSessions
 }
 
-// CleanupExpiredSessions removes expired sessions
-func (m *SessionManager) CleanupExpiredSessions() {
+// GetAllSessions gets all sessions, oldest first
+func (m *SessionManager) GetAllSessions() []*Session {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	sessions := make([]*Session, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+	return sessions
+}
+
+// CountByStatus returns the number of sessions in each status
+func (m *SessionManager) CountByStatus() map[string]int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	counts := make(map[string]int)
+	for _, session := range m.sessions {
+		counts[string(session.Status)]++
+	}
+	return counts
+}
+
+// CleanupExpiredSessions removes expired sessions and returns the removed
+// sessions. Sessions that could not be deleted from the store are returned
+// in the error but are still removed from memory.
+func (m *SessionManager) CleanupExpiredSessions() ([]*Session, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var removed []*Session
+	var errs []error
 	for id, session := range m.sessions {
 		if session.IsExpired() {
 			delete(m.sessions, id)
+			if err := m.store.Delete(id); err != nil {
+				errs = append(errs, fmt.Errorf("failed to delete session %s: %w", id, err))
+			}
+			removed = append(removed, session)
 		}
 	}
+	return removed, errors.Join(errs...)
 }
@@ -0,0 +1,211 @@
+package wallet
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSessionManagerConcurrentUse(t *testing.T) {
+	const workers = 8
+	const sessionsPerWorker = 24
+
+	m := NewSessionManager()
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range sessionsPerWorker {
+				session, err := m.CreateSession()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if m.GetSession(session.ID) != session {
+					t.Error("created session not found")
+				}
+				if err := m.UpdateSession(session, (*Session).Activate); err != nil {
+					t.Error(err)
+				}
+
+				// Expire every other session for the cleanup to remove
+				if i%2 == 0 {
+					if err := m.UpdateSession(session, func(s *Session) { s.ExpiresAt = time.Now().Add(-time.Minute) }); err != nil {
+						t.Error(err)
+					}
+				}
+			}
+		}()
+	}
+
+	// Read and clean up while the sessions are created and activated
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			m.GetActiveSessions()
+			m.GetAllSessions()
+			m.CountByStatus()
+			m.GetSessionByPairingTopic("topic")
+			if _, err := m.CleanupExpiredSessions(); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(done)
+	readers.Wait()
+
+	if _, err := m.CleanupExpiredSessions(); err != nil {
+		t.Fatal(err)
+	}
+	active := m.GetActiveSessions()
+	if want := workers * sessionsPerWorker / 2; len(active) != want || len(m.GetAllSessions()) != want {
+		t.Errorf("got %d active of %d sessions, want %d of each", len(active), len(m.GetAllSessions()), want)
+	}
+	if counts := m.CountByStatus(); counts[string(SessionStatusActive)] != len(active) {
+		t.Errorf("got counts %v, want %d active", counts, len(active))
+	}
+}
+
+func TestUpdateSessionOfRemovedSession(t *testing.T) {
+	m := NewSessionManager()
+	session, err := m.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RemoveSession(session.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.UpdateSession(session, (*Session).Activate); err != ErrSessionNotFound {
+		t.Errorf("got %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestSessionIsExpiredAtBoundary(t *testing.T) {
+	session, err := newSession(false, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiresAt := session.ExpiresAt
+
+	if session.isExpiredAt(expiresAt.Add(-time.Nanosecond)) {
+		t.Error("expired before ExpiresAt")
+	}
+	if session.isExpiredAt(expiresAt) {
+		t.Error("expired at exactly ExpiresAt")
+	}
+	if !session.isExpiredAt(expiresAt.Add(time.Nanosecond)) {
+		t.Error("not expired after ExpiresAt")
+	}
+
+	// The clock skew tolerance moves the boundary
+	session.SetClockSkewTolerance(time.Minute)
+	if session.isExpiredAt(expiresAt.Add(time.Minute)) {
+		t.Error("expired within the clock skew tolerance")
+	}
+	if !session.isExpiredAt(expiresAt.Add(time.Minute + time.Nanosecond)) {
+		t.Error("not expired after the clock skew tolerance")
+	}
+
+	// Extending pushes the boundary out
+	session.Extend(time.Hour)
+	if session.isExpiredAt(expiresAt.Add(time.Hour)) || !session.isExpiredAt(expiresAt.Add(time.Hour+time.Minute+time.Nanosecond)) {
+		t.Error("extension did not move the expiry by an hour")
+	}
+}
+
+func TestSessionManagerTTL(t *testing.T) {
+	m := NewSessionManager()
+
+	m.SetSessionTTL(time.Minute)
+	session, err := m.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl := session.ExpiresAt.Sub(session.CreatedAt); ttl != time.Minute {
+		t.Errorf("session lives %s, want 1m", ttl)
+	}
+
+	m.SetSessionTTL(0)
+	session, err = m.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl := session.ExpiresAt.Sub(session.CreatedAt); ttl != DefaultSessionTTL {
+		t.Errorf("session lives %s after resetting the TTL, want %s", ttl, DefaultSessionTTL)
+	}
+}
+
+func TestSingleTopicSession(t *testing.T) {
+	session, err := NewSingleTopicSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !session.SingleTopic() || session.SessionTopic != session.PairingTopic {
+		t.Errorf("got pairing topic %s and session topic %s, want one topic", session.PairingTopic, session.SessionTopic)
+	}
+	if topics := session.Topics(); len(topics) != 1 || topics[0] != session.PairingTopic {
+		t.Errorf("got topics %v, want only the pairing topic", topics)
+	}
+
+	session, err = NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.SingleTopic() || len(session.Topics()) != 2 {
+		t.Errorf("got topics %v, want separate pairing and session topics", session.Topics())
+	}
+}
+
+func TestSessionManagerSingleTopicLookups(t *testing.T) {
+	m := NewSessionManager()
+	twoTopic, err := m.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Enabling the mode affects new sessions only
+	m.SetSingleTopicMode(true)
+	singleTopic, err := m.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if twoTopic.SingleTopic() || !singleTopic.SingleTopic() {
+		t.Fatal("single-topic mode did not apply to new sessions only")
+	}
+
+	tests := []struct {
+		name   string
+		lookup func(string) *Session
+		topic  string
+		want   *Session
+	}{
+		{name: "two-topic by pairing topic", lookup: m.GetSessionByPairingTopic, topic: twoTopic.PairingTopic, want: twoTopic},
+		{name: "two-topic by session topic", lookup: m.GetSessionBySessionTopic, topic: twoTopic.SessionTopic, want: twoTopic},
+		{name: "two-topic session topic is not a pairing topic", lookup: m.GetSessionByPairingTopic, topic: twoTopic.SessionTopic},
+		{name: "two-topic pairing topic is not a session topic", lookup: m.GetSessionBySessionTopic, topic: twoTopic.PairingTopic},
+		{name: "single-topic by pairing topic", lookup: m.GetSessionByPairingTopic, topic: singleTopic.PairingTopic, want: singleTopic},
+		{name: "single-topic by session topic", lookup: m.GetSessionBySessionTopic, topic: singleTopic.PairingTopic, want: singleTopic},
+		{name: "unknown topic", lookup: m.GetSessionByPairingTopic, topic: "unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.lookup(tt.topic); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
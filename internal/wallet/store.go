@@ -0,0 +1,257 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/korjavin/wctestapp/pkg/utils"
+)
+
+// ErrSessionNotFound is returned by a SessionStore when a session does not exist
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionStore persists sessions
+type SessionStore interface {
+	// Save creates or replaces a session
+	Save(session *Session) error
+	// Load returns the session with the given ID, or ErrSessionNotFound
+	Load(id string) (*Session, error)
+	// Delete removes a session; deleting a missing session is not an error
+	Delete(id string) error
+	// List returns all stored sessions
+	List() ([]*Session, error)
+}
+
+// MemorySessionStore keeps sessions in memory only. Sessions are lost on restart.
+type MemorySessionStore struct {
+	sessions map[string]*Session
+	mutex    sync.RWMutex
+}
+
+// NewMemorySessionStore creates a new in-memory session store
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions: make(map[string]*Session),
+	}
+}
+
+// Save creates or replaces a session
+func (s *MemorySessionStore) Save(session *Session) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.sessions[session.ID] = session
+	return nil
+}
+
+// Load returns the session with the given ID
+func (s *MemorySessionStore) Load(id string) (*Session, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+// Delete removes a session
+func (s *MemorySessionStore) Delete(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.sessions, id)
+	return nil
+}
+
+// List returns all stored sessions
+func (s *MemorySessionStore) List() ([]*Session, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// FileSessionStore persists sessions as JSON in a single file so they survive
+// restarts. The file contains private keys and is written with 0600 permissions.
+type FileSessionStore struct {
+	path  string
+	mutex sync.Mutex
+}
+
+// storedSession is the on-disk form of a session, with keys as hex strings
+type storedSession struct {
+	Session
+	ClientPrivKey string `json:"client_priv_key"`
+	PeerPubKey    string `json:"peer_pub_key,omitempty"`
+}
+
+// NewFileSessionStore creates a session store backed by the JSON file at path.
+// The file is created on the first save.
+func NewFileSessionStore(path string) *FileSessionStore {
+	return &FileSessionStore{path: path}
+}
+
+// Save creates or replaces a session
+func (s *FileSessionStore) Save(session *Session) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	records, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	record := storedSession{Session: *session}
+	if session.ClientPrivKey != nil {
+		record.ClientPrivKey = utils.PrivateKeyToHex(session.ClientPrivKey)
+	}
+	if session.PeerPubKey != nil {
+		record.PeerPubKey = utils.PublicKeyToHex(session.PeerPubKey)
+	}
+	records[session.ID] = record
+
+	return s.write(records)
+}
+
+// Load returns the session with the given ID
+func (s *FileSessionStore) Load(id string) (*Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	records, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	record, ok := records[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return record.toSession()
+}
+
+// Delete removes a session
+func (s *FileSessionStore) Delete(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	records, err := s.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := records[id]; !ok {
+		return nil
+	}
+
+	delete(records, id)
+	return s.write(records)
+}
+
+// List returns all stored sessions
+func (s *FileSessionStore) List() ([]*Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	records, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*Session, 0, len(records))
+	for _, record := range records {
+		session, err := record.toSession()
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// read loads all records from the file. A missing file means no sessions.
+func (s *FileSessionStore) read() (map[string]storedSession, error) {
+	records := make(map[string]storedSession)
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session store: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse session store %s: %w", s.path, err)
+	}
+	return records, nil
+}
+
+// write replaces the file atomically with the given records
+func (s *FileSessionStore) write(records map[string]storedSession) error {
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal sessions: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create session store directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write session store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write session store: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write session store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write session store: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write session store: %w", err)
+	}
+	return nil
+}
+
+// toSession restores a session and its keys from a stored record
+func (r storedSession) toSession() (*Session, error) {
+	session := r.Session
+
+	if r.ClientPrivKey != "" {
+		privateKey, err := utils.HexToPrivateKey(r.ClientPrivKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore key of session %s: %w", session.ID, err)
+		}
+		session.ClientPrivKey = privateKey
+		session.ClientPubKey = privateKey.Public().(*ecdsa.PublicKey)
+	}
+
+	if r.PeerPubKey != "" {
+		publicKey, err := utils.HexToPublicKey(r.PeerPubKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore peer key of session %s: %w", session.ID, err)
+		}
+		session.PeerPubKey = publicKey
+	}
+
+	return &session, nil
+}
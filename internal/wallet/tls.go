@@ -0,0 +1,40 @@
+package wallet
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// LoadRelayTLSConfig builds the TLS configuration for dialing wss:// relays.
+// If caFile is set, its PEM certificates are trusted in addition to the system
+// roots, so a relay with a private or self-signed certificate can be pinned.
+// insecureSkipVerify disables certificate verification and is meant for
+// development only.
+func LoadRelayTLSConfig(caFile string, insecureSkipVerify bool) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+
+	if caFile == "" {
+		return config, nil
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read relay CA file: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in relay CA file %s", caFile)
+	}
+	config.RootCAs = pool
+
+	return config, nil
+}
@@ -0,0 +1,124 @@
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// ErrInvalidTypedData is returned when typed data fails validation
+var ErrInvalidTypedData = errors.New("invalid typed data")
+
+// ParseTypedData parses and validates EIP-712 typed data.
+// The typed data must have a domain, types and message, must declare the
+// EIP712Domain type and its primary type, and must be hashable according to
+// its own type definitions.
+func ParseTypedData(raw []byte) (*apitypes.TypedData, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTypedData, err)
+	}
+	for _, field := range []string{"domain", "types", "message"} {
+		if value, ok := fields[field]; !ok || string(value) == "null" {
+			return nil, fmt.Errorf("%w: missing the %s", ErrInvalidTypedData, field)
+		}
+	}
+
+	var typedData apitypes.TypedData
+	if err := json.Unmarshal(raw, &typedData); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTypedData, err)
+	}
+
+	if len(typedData.Types) == 0 {
+		return nil, fmt.Errorf("%w: no types defined", ErrInvalidTypedData)
+	}
+	if _, ok := typedData.Types["EIP712Domain"]; !ok {
+		return nil, fmt.Errorf("%w: missing the EIP712Domain type", ErrInvalidTypedData)
+	}
+	if typedData.PrimaryType == "" {
+		return nil, fmt.Errorf("%w: missing the primary type", ErrInvalidTypedData)
+	}
+	if _, ok := typedData.Types[typedData.PrimaryType]; !ok {
+		return nil, fmt.Errorf("%w: primary type %s is not defined", ErrInvalidTypedData, typedData.PrimaryType)
+	}
+	if typedData.Message == nil {
+		return nil, fmt.Errorf("%w: missing the message", ErrInvalidTypedData)
+	}
+
+	// Hashing walks the whole structure, so it doubles as a full validation
+	if _, _, err := apitypes.TypedDataAndHash(typedData); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTypedData, err)
+	}
+
+	return &typedData, nil
+}
+
+// ValidateTypedDataChain checks that the typed data domain targets the given chain.
+// Typed data without a chainId in its domain is accepted.
+func ValidateTypedDataChain(typedData *apitypes.TypedData, chainID int64) error {
+	if typedData.Domain.ChainId == nil {
+		return nil
+	}
+
+	domainChainID := (*big.Int)(typedData.Domain.ChainId)
+	if domainChainID.Cmp(big.NewInt(chainID)) != 0 {
+		return fmt.Errorf("%w: domain chainId %s does not match session chain %d", ErrInvalidTypedData, domainChainID, chainID)
+	}
+
+	return nil
+}
+
+// VerifyTypedDataSignature verifies an eth_signTypedData_v4 signature. Unlike
+// VerifySignature, the signed hash is the EIP-712 hash of the typed data rather
+// than the personal_sign prefixed message.
+func VerifyTypedDataSignature(typedDataJSON json.RawMessage, signature string, address common.Address) (bool, error) {
+	typedData, err := ParseTypedData(typedDataJSON)
+	if err != nil {
+		return false, err
+	}
+
+	recovered, err := RecoverTypedDataSigner(typedData, signature)
+	if err != nil {
+		return false, err
+	}
+
+	return recovered == address, nil
+}
+
+// RecoverTypedDataSigner recovers the address that signed the typed data
+func RecoverTypedDataSigner(typedData *apitypes.TypedData, signature string) (common.Address, error) {
+	hash, _, err := apitypes.TypedDataAndHash(*typedData)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("failed to hash typed data: %w", err)
+	}
+
+	// Convert the signature from hex to bytes
+	signatureBytes, err := hexutil.Decode(signature)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("failed to decode signature: %w", err)
+	}
+
+	// The signature should be 65 bytes: R (32 bytes) + S (32 bytes) + V (1 byte)
+	if len(signatureBytes) != 65 {
+		return common.Address{}, fmt.Errorf("invalid signature length: %d", len(signatureBytes))
+	}
+
+	// SigToPub expects a V value of 0 or 1
+	if signatureBytes[64] >= 27 {
+		signatureBytes[64] -= 27
+	}
+
+	// Recover the public key
+	pubKey, err := crypto.SigToPub(hash, signatureBytes)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("failed to recover public key: %w", err)
+	}
+
+	return crypto.PubkeyToAddress(*pubKey), nil
+}
@@ -1,44 +1,307 @@
 package wallet
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/gorilla/websocket"
+	"github.com/korjavin/wctestapp/internal/logger"
+	"github.com/korjavin/wctestapp/internal/metrics"
 	"github.com/korjavin/wctestapp/internal/relay"
+	"github.com/korjavin/wctestapp/pkg/utils"
 )
 
 // WalletClient represents a WalletConnect client
 type WalletClient struct {
 	sessionManager *SessionManager
-	relayURL       string
 	connections    map[string]*websocket.Conn // topic -> connection
+	allowedMethods []string                   // sign methods that may be sent; empty allows all
+	requireSecure  bool                       // refuse to dial a relay that is not wss://
+	frameStats     map[*websocket.Conn]*relay.FrameStats
+	writeMutexes   map[*websocket.Conn]*sync.Mutex // connections support one concurrent writer
+	logFrameStats  bool                            // log per-connection frame counts on disconnect
+	messageLog     *messageLog
+	eventHandlers  []SessionEventHandler
+	demoWallet     *DemoWallet         // signs locally instead of via the relay when set
+	gracePeriod    time.Duration       // how long a dropped session may take to recover
+	rpcURL         string              // JSON-RPC node for EIP-1271 checks; empty disables them
+	maxMessageSize int64               // larger messages from the relay drop the connection
+	graceTopics    map[string]*Session // dropped topics being re-dialed -> their session
 	mutex          sync.RWMutex
 	logger         Logger
+
+	// Relays tried in order when connecting a topic, starting with the active one
+	relays      []string
+	activeRelay int               // index of the relay that last accepted a connection
+	topicRelays map[string]string // topic -> relay URL it is connected through
+	tlsConfig   *tls.Config       // TLS settings for wss:// relays; nil uses the system defaults
+
+	// How dropped topics are re-dialed
+	reconnectPolicy ReconnectPolicy
+
+	// Keepalive pings on topic connections; a zero interval disables them
+	pingInterval time.Duration
+	readTimeout  time.Duration                  // how long a connection may go without receiving a frame
+	keepalives   map[*websocket.Conn]*keepalive // guarded by mutex
+
+	// Topics we recently stopped listening on, and counts of notifications
+	// for topics that match no session at all
+	removedTopics      map[string]time.Time // topic -> removal time
+	unknownTopicCounts map[string]int       // topic -> unknown notification count
+	topicsMutex        sync.Mutex
+
+	// Subscriptions to per-session events
+	events *eventSubscriptions
+
+	// Requests sent to the wallet that are waiting for a response
+	pendingRequests map[int]chan *SignResponse // request ID -> response channel
+	signMessages    map[int]string             // request ID -> personal_sign message, to recover the signer
+	relayAcks       map[relayAckKey]chan error // waiters for the relay's answers to our requests
+	pendingMutex    sync.Mutex
+	requestCounter  atomic.Int64
+
+	// One-shot waiters for incoming methods, keyed by session ID and method
+	methodWaiters map[methodWaiterKey][]chan json.RawMessage
+	waitersMutex  sync.Mutex
+
+	done       chan struct{}  // closed by Close to stop background tasks
+	closed     bool           // set by Close; guarded by mutex
+	listenerWg sync.WaitGroup // tracks message listeners
+
+	// Session lifecycle durations in seconds
+	pairingDurations *metrics.Histogram // created -> active
+	sessionDurations *metrics.Histogram // active -> disconnected
 }
 
-// Logger interface for logging
-type Logger interface {
-	Debug(msg string)
-	Info(msg string)
-	Warn(msg string)
-	Error(msg string)
+// LifecycleMetrics holds snapshots of session lifecycle duration histograms
+type LifecycleMetrics struct {
+	PairingDurationSeconds metrics.HistogramSnapshot `json:"pairing_duration_seconds"`
+	SessionDurationSeconds metrics.HistogramSnapshot `json:"session_duration_seconds"`
+}
+
+// ErrClientClosed is returned when the wallet client has been closed
+var ErrClientClosed = errors.New("wallet client is closed")
+
+// ErrSessionNotActive is returned when a request requires an active session
+var ErrSessionNotActive = errors.New("session is not active")
+
+// ErrSessionExpired is returned when a session expires while waiting for it
+var ErrSessionExpired = errors.New("session expired")
+
+// ErrSessionDisconnected is returned when a session is disconnected while waiting for it
+var ErrSessionDisconnected = errors.New("session disconnected")
+
+// ErrSignMethodNotAllowed is returned when a sign method is not in the allowlist
+var ErrSignMethodNotAllowed = errors.New("sign method not allowed")
+
+// ErrNotSupportedByDemoWallet is returned for requests the in-process demo wallet cannot serve
+var ErrNotSupportedByDemoWallet = errors.New("not supported by the demo wallet")
+
+// ErrSenderMismatch is returned when a transaction is not sent from the session's wallet address
+var ErrSenderMismatch = errors.New("transaction sender does not match the session's wallet address")
+
+// ErrInsecureRelay is returned when a secure relay is required but the relay URL is not wss://
+var ErrInsecureRelay = errors.New("relay URL is not secure")
+
+// TypedDataSignature is the verified result of an eth_signTypedData_v4 request
+type TypedDataSignature struct {
+	Signature        string         `json:"signature"`
+	Valid            bool           `json:"valid"`
+	RecoveredAddress common.Address `json:"recovered_address"`
 }
 
-// NewWalletClient creates a new WalletConnect client
-func NewWalletClient(relayURL string, logger Logger) *WalletClient {
+const (
+	// resumeMaxAttempts is how many times ResumeSessions tries to reach the relay
+	resumeMaxAttempts = 5
+	// resumeInitialBackoff is the delay before the first ResumeSessions retry; it doubles each attempt
+	resumeInitialBackoff = 1 * time.Second
+)
+
+const (
+	// recentlyRemovedTopicWindow is how long notifications for a removed topic are treated as expected
+	recentlyRemovedTopicWindow = 1 * time.Minute
+	// unknownTopicUnsubscribeThreshold is the number of notifications for an unknown topic
+	// after which we ask the relay to stop sending them
+	unknownTopicUnsubscribeThreshold = 3
+)
+
+// SessionEvent represents a session lifecycle event
+type SessionEvent string
+
+const (
+	// SessionEventReconnecting is emitted before a session's relay connections are re-established
+	SessionEventReconnecting SessionEvent = "reconnecting"
+	// SessionEventReconnected is emitted after a session's relay connections are re-established
+	SessionEventReconnected SessionEvent = "reconnected"
+	// SessionEventReconnectFailed is emitted when re-establishing a session's relay connections fails
+	SessionEventReconnectFailed SessionEvent = "reconnect_failed"
+	// SessionEventDelivered is emitted when the relay confirms a request reached the wallet
+	SessionEventDelivered SessionEvent = "delivered"
+	// SessionEventUndelivered is emitted when the relay reports a request reached no subscriber
+	SessionEventUndelivered SessionEvent = "undelivered"
+	// SessionEventRemoved is emitted when an expired session is removed, so
+	// that state kept per session can be dropped
+	SessionEventRemoved SessionEvent = "removed"
+)
+
+// SessionEventHandler is called when a session event occurs
+type SessionEventHandler func(session *Session, event SessionEvent)
+
+// Logger interface for logging
+type Logger = logger.FieldLogger
+
+// NewWalletClient creates a new WalletConnect client. Topics are connected
+// through the first of the relay URLs that accepts the connection.
+func NewWalletClient(relayURLs []string, logger Logger) *WalletClient {
 	return &WalletClient{
 		sessionManager: NewSessionManager(),
-		relayURL:       relayURL,
 		connections:    make(map[string]*websocket.Conn),
+		frameStats:     make(map[*websocket.Conn]*relay.FrameStats),
+		writeMutexes:   make(map[*websocket.Conn]*sync.Mutex),
+		messageLog:     newMessageLog(),
+		graceTopics:    make(map[string]*Session),
 		logger:         logger,
+
+		relays:      relayURLs,
+		topicRelays: make(map[string]string),
+
+		reconnectPolicy: DefaultReconnectPolicy(),
+
+		maxMessageSize: relay.DefaultMaxMessageSize,
+
+		pingInterval: DefaultPingInterval,
+		readTimeout:  2 * DefaultPingInterval,
+		keepalives:   make(map[*websocket.Conn]*keepalive),
+
+		removedTopics:      make(map[string]time.Time),
+		unknownTopicCounts: make(map[string]int),
+
+		events: &eventSubscriptions{subscribers: make(map[string][]chan WalletEvent)},
+
+		pendingRequests: make(map[int]chan *SignResponse),
+		relayAcks:       make(map[relayAckKey]chan error),
+		signMessages:    make(map[int]string),
+		methodWaiters:   make(map[methodWaiterKey][]chan json.RawMessage),
+		done:            make(chan struct{}),
+
+		pairingDurations: metrics.NewHistogram([]float64{5, 10, 30, 60, 120, 300, 600}),
+		sessionDurations: metrics.NewHistogram([]float64{60, 300, 900, 3600, 14400, 86400}),
+	}
+}
+
+// SetAllowedSignMethods restricts the sign methods the client will send to a wallet.
+// An empty list allows all methods.
+func (c *WalletClient) SetAllowedSignMethods(methods []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.allowedMethods = methods
+}
+
+// SetMaxMessageSize sets the largest message in bytes accepted from the relay.
+// A larger message drops the topic's connection, which is then re-dialed. A
+// non-positive size restores relay.DefaultMaxMessageSize. It applies to
+// connections made afterwards.
+func (c *WalletClient) SetMaxMessageSize(size int64) {
+	if size <= 0 {
+		size = relay.DefaultMaxMessageSize
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.maxMessageSize = size
+}
+
+// SetRelays sets the relay URLs topics are connected through. They are tried
+// in order, starting with the first, and a relay that fails is skipped until
+// the others fail too. Existing connections are kept; an empty list is ignored.
+func (c *WalletClient) SetRelays(relayURLs []string) {
+	if len(relayURLs) == 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.relays = relayURLs
+	c.activeRelay = 0
+}
+
+// SetTLSConfig sets the TLS configuration used to dial wss:// relays, e.g. to
+// trust a custom CA. A nil config restores the system defaults. The config is
+// cloned, so later changes by the caller have no effect.
+func (c *WalletClient) SetTLSConfig(config *tls.Config) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.tlsConfig = config.Clone()
+}
+
+// SessionRelay returns the relay URL a session is connected through: that of
+// its session topic once it has one, otherwise that of its pairing topic. It
+// returns an empty string when neither topic is connected.
+func (c *WalletClient) SessionRelay(session *Session) string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	for _, topic := range []string{session.SessionTopic, session.PairingTopic} {
+		if _, ok := c.connections[topic]; ok {
+			return c.topicRelays[topic]
+		}
+	}
+	return ""
+}
+
+// SetRequireSecureRelay makes the client refuse to connect to a relay URL that is not wss://
+func (c *WalletClient) SetRequireSecureRelay(require bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.requireSecure = require
+}
+
+// SetMessageLog enables a bounded per-session log of decrypted messages for
+// debugging. Only methods and IDs are recorded unless includePayloads is set.
+func (c *WalletClient) SetMessageLog(enabled bool, includePayloads bool) {
+	c.messageLog.configure(enabled, includePayloads)
+}
+
+// GetMessageLog returns the decrypted messages recorded for a session, oldest first
+func (c *WalletClient) GetMessageLog(session *Session) []LoggedMessage {
+	return c.messageLog.get(session.ID)
+}
+
+// SetLogFrameStats enables logging a summary of frame counts when a relay connection closes
+func (c *WalletClient) SetLogFrameStats(enabled bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.logFrameStats = enabled
+}
+
+// IsSignMethodAllowed checks if a sign method may be sent to a wallet
+func (c *WalletClient) IsSignMethodAllowed(method string) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if len(c.allowedMethods) == 0 {
+		return true
 	}
+	return slices.Contains(c.allowedMethods, method)
 }
 
 // CreateSession creates a new WalletConnect session
@@ -51,56 +314,217 @@ func (c *WalletClient) CreateSession() (*Session, error) {
 		return nil, fmt.Errorf("failed to create session: %w", err)
 	}
 
-	c.logger.Info(fmt.Sprintf("Created session with ID: %s", session.ID))
+	c.logger.Infof("Created session with ID: %s", session.ID)
+
+	return session, nil
+}
+
+// CreateAndConnect creates a new session, subscribes to its pairing topic and
+// proposes the session to wallets that pair with it, bounded by ctx. If the
+// relay step fails or times out, the created session is still returned
+// alongside the error, with status SessionStatusRelayUnavailable.
+func (c *WalletClient) CreateAndConnect(ctx context.Context) (*Session, error) {
+	session, err := c.CreateSession()
+	if err != nil {
+		return nil, err
+	}
+
+	// The demo wallet pairs instantly without going through the relay
+	if demo := c.DemoWallet(); demo != nil {
+		c.logger.Infof("Activating session %s with demo wallet %s", session.ID, demo.Address().Hex())
+		session.SetWalletAddress(demo.Address())
+		session.SetNamespaces(approvedNamespaces(demo.Address()))
+		c.ActivateSession(session)
+		return session, nil
+	}
+
+	if err := c.ConnectToRelayContext(ctx, session); err != nil {
+		c.updateSession(session, func(s *Session) { s.SetStatus(SessionStatusRelayUnavailable) })
+		return session, err
+	}
+
+	// Wallets that scan the pairing URI pick the proposal up from the pairing topic
+	if err := c.ProposeSession(session); err != nil {
+		c.logger.Warnf("%v", err)
+	}
 
 	return session, nil
 }
 
 // ConnectToRelay connects to the relay server for a session
 func (c *WalletClient) ConnectToRelay(session *Session) error {
-	c.logger.Info(fmt.Sprintf("Connecting to relay server for session: %s", session.ID))
+	return c.ConnectToRelayContext(context.Background(), session)
+}
+
+// ConnectToRelayContext connects to the relay server for a session, bounded by ctx
+func (c *WalletClient) ConnectToRelayContext(ctx context.Context, session *Session) error {
+	c.logger.Infof("Connecting to relay server for session: %s", session.ID)
 
 	// Connect to the relay server for the pairing topic
-	err := c.connectToTopic(session.PairingTopic)
+	err := c.connectToTopicContext(ctx, session.PairingTopic)
 	if err != nil {
 		return fmt.Errorf("failed to connect to pairing topic: %w", err)
 	}
 
-	c.logger.Info(fmt.Sprintf("Connected to pairing topic: %s", session.PairingTopic))
+	c.logger.Infof("Connected to pairing topic: %s", session.PairingTopic)
 
 	return nil
 }
 
 // connectToTopic connects to a topic on the relay server
 func (c *WalletClient) connectToTopic(topic string) error {
+	return c.connectToTopicContext(context.Background(), topic)
+}
+
+// relayHandshakeTimeout bounds the WebSocket handshake with a relay, and the
+// wait for its subscribe response when the caller's context has no deadline
+const relayHandshakeTimeout = 10 * time.Second
+
+// dialSettings are the client settings a topic connection is dialed with,
+// copied under c.mutex so that the dial itself runs without the lock
+type dialSettings struct {
+	tlsConfig      *tls.Config
+	maxMessageSize int64
+	pingInterval   time.Duration
+	readTimeout    time.Duration
+}
+
+// connectToTopicContext connects to a topic on the relay server, bounded by ctx.
+// The relays are dialed without holding c.mutex, so a relay that is slow to
+// answer does not block the client's other connections.
+func (c *WalletClient) connectToTopicContext(ctx context.Context, topic string) error {
+	c.mutex.RLock()
+	closed := c.closed
+	_, connected := c.connections[topic]
+	relays := c.relays
+	activeRelay := c.activeRelay
+	requireSecure := c.requireSecure
+	settings := dialSettings{
+		tlsConfig:      c.tlsConfig,
+		maxMessageSize: c.maxMessageSize,
+		pingInterval:   c.pingInterval,
+		readTimeout:    c.readTimeout,
+	}
+	c.mutex.RUnlock()
+
+	if closed {
+		return ErrClientClosed
+	}
+
+	// Check if we're already connected to this topic
+	if connected {
+		c.logger.Infof("Already connected to topic: %s", topic)
+		return nil
+	}
+
+	if len(relays) == 0 {
+		return errors.New("no relay URL configured")
+	}
+
+	// Try the relays in order, starting with the one that last accepted a
+	// connection, so a relay that is down is only retried once the others fail
+	var errs []error
+	var conn *websocket.Conn
+	var frames *relay.FrameStats
+	var k *keepalive
+	var relayIndex int
+	for i := range relays {
+		index := (activeRelay + i) % len(relays)
+		relayURL := relays[index]
+
+		// Refuse plaintext relays before dialing if a secure relay is required
+		var err error
+		if requireSecure && !IsSecureRelayURL(relayURL) {
+			c.logger.Errorf("Refusing to connect to insecure relay %s", relayURL)
+			err = fmt.Errorf("%w: %s", ErrInsecureRelay, relayURL)
+		} else {
+			conn, frames, k, err = c.dialTopic(ctx, relayURL, topic, settings)
+		}
+		if err == nil {
+			relayIndex = index
+			break
+		}
+
+		if len(relays) == 1 {
+			return err
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", relayURL, err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if conn == nil {
+		return fmt.Errorf("all relays failed: %w", errors.Join(errs...))
+	}
+
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	// Check if we're already connected to this topic
+	// The client may have been closed, or the topic connected by another
+	// caller, while we were dialing
+	if c.closed {
+		conn.Close()
+		return ErrClientClosed
+	}
 	if _, ok := c.connections[topic]; ok {
-		c.logger.Info(fmt.Sprintf("Already connected to topic: %s", topic))
+		c.logger.Infof("Already connected to topic: %s", topic)
+		conn.Close()
 		return nil
 	}
 
-	// Log connection attempt with more details
-	c.logger.Info(fmt.Sprintf("Connecting to relay server at %s for topic %s", c.relayURL, topic))
-	c.logger.Debug(fmt.Sprintf("WebSocket connection details - URL: %s, Protocol: %s",
-		c.relayURL, getWebSocketProtocol(c.relayURL)))
-	c.logger.Info(fmt.Sprintf("NOTE: The wallet app may be using a different relay server than us"))
-	c.logger.Info(fmt.Sprintf("Our relay server: %s", c.relayURL))
+	// Only move the active relay if the relay list was not replaced meanwhile
+	relayURL := relays[relayIndex]
+	if relayIndex < len(c.relays) && c.relays[relayIndex] == relayURL && relayIndex != c.activeRelay {
+		c.logger.Warnf("Relay %s is unavailable, failing over to %s", c.relays[c.activeRelay], relayURL)
+		c.activeRelay = relayIndex
+	}
+	c.topicRelays[topic] = relayURL
+
+	// Store the connection
+	c.connections[topic] = conn
+	c.frameStats[conn] = frames
+	c.writeMutexes[conn] = &sync.Mutex{}
+	c.keepalives[conn] = k
+
+	// Start listening for messages
+	c.listenerWg.Add(1)
+	go c.listenForMessages(topic, conn, frames, k)
+
+	// Ping the relay so that a half-open connection is noticed
+	if k.interval > 0 {
+		c.listenerWg.Add(1)
+		go c.pingTopic(topic, k, frames)
+	}
+
+	return nil
+}
 
-	// Connect to the relay server
-	dialer := websocket.DefaultDialer
-	dialer.HandshakeTimeout = 10 * time.Second
+// dialTopic dials a relay and subscribes to a topic on the new connection.
+// The caller stores the connection and starts its listener.
+func (c *WalletClient) dialTopic(ctx context.Context, relayURL string, topic string, settings dialSettings) (*websocket.Conn, *relay.FrameStats, *keepalive, error) {
+	// Log connection attempt with more details
+	c.logger.Infof("Connecting to relay server at %s for topic %s", relayURL, topic)
+	c.logger.Debugf("WebSocket connection details - URL: %s, Protocol: %s",
+		relayURL, getWebSocketProtocol(relayURL))
+	c.logger.Infof("NOTE: The wallet app may be using a different relay server than us")
+	c.logger.Infof("Our relay server: %s", relayURL)
+
+	// Connect to the relay server with a dialer of our own, so that the TLS
+	// settings and timeout do not leak into websocket.DefaultDialer
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: relayHandshakeTimeout,
+		TLSClientConfig:  settings.tlsConfig.Clone(),
+	}
 
 	// Add custom headers for debugging
 	header := http.Header{}
 	header.Add("X-Client-ID", "WalletClient")
 	header.Add("X-Topic", topic)
 
-	c.logger.Debug(fmt.Sprintf("Dialing WebSocket with headers: %v", header))
+	c.logger.Debugf("Dialing WebSocket with headers: %v", header)
 
-	conn, resp, err := dialer.Dial(c.relayURL, header)
+	conn, resp, err := dialer.DialContext(ctx, relayURL, header)
 	if err != nil {
 		var statusCode int
 		var responseBody string
@@ -113,79 +537,127 @@ func (c *WalletClient) connectToTopic(topic string) error {
 			}
 		}
 
-		c.logger.Error(fmt.Sprintf("Failed to connect to relay server: %v", err))
-		c.logger.Debug(fmt.Sprintf("Connection failure details - Status: %d, Response: %s",
-			statusCode, responseBody))
-		return fmt.Errorf("failed to connect to relay server: %w (status: %d)", err, statusCode)
+		c.logger.Errorf("Failed to connect to relay server: %v", err)
+		c.logger.Debugf("Connection failure details - Status: %d, Response: %s",
+			statusCode, responseBody)
+		if ctxErr := contextError(ctx, err); ctxErr != nil {
+			return nil, nil, nil, fmt.Errorf("failed to connect to relay server: %w", ctxErr)
+		}
+		return nil, nil, nil, fmt.Errorf("failed to connect to relay server: %w (status: %d)", err, statusCode)
 	}
 
-	c.logger.Info(fmt.Sprintf("Successfully connected to relay server for topic %s", topic))
-	c.logger.Debug(fmt.Sprintf("Connection established - Local: %s, Remote: %s",
-		conn.LocalAddr().String(), conn.RemoteAddr().String()))
+	c.logger.Infof("Successfully connected to relay server for topic %s", topic)
+	c.logger.Debugf("Connection established - Local: %s, Remote: %s",
+		conn.LocalAddr().String(), conn.RemoteAddr().String())
+
+	// Bound the size of messages from the relay
+	conn.SetReadLimit(settings.maxMessageSize)
+
+	// Count frames on this connection and track its liveness
+	frames := &relay.FrameStats{}
+	k := newKeepalive(conn, frames, settings.pingInterval, settings.readTimeout)
 
 	// Subscribe to the topic
-	subscribeRequest := relay.NewJSONRPCRequest(1, "subscribe", relay.SubscribeParams{
+	subscribeRequest := relay.NewJSONRPCRequest(relay.NumberID(subscribeRequestID), "subscribe", relay.SubscribeParams{
 		Topic: topic,
 	})
 
 	subscribeRequestJSON, err := subscribeRequest.ToJSON()
 	if err != nil {
 		conn.Close()
-		c.logger.Error(fmt.Sprintf("Failed to marshal subscribe request: %v", err))
-		return fmt.Errorf("failed to marshal subscribe request: %w", err)
+		c.logger.Errorf("Failed to marshal subscribe request: %v", err)
+		return nil, nil, nil, fmt.Errorf("failed to marshal subscribe request: %w", err)
 	}
 
 	// Log the request being sent
-	c.logger.Debug(fmt.Sprintf("Sending subscribe request: %s", subscribeRequestJSON))
+	c.logger.Debugf("Sending subscribe request: %s", subscribeRequestJSON)
 
 	err = conn.WriteMessage(websocket.TextMessage, []byte(subscribeRequestJSON))
 	if err != nil {
 		conn.Close()
-		c.logger.Error(fmt.Sprintf("Failed to send subscribe request: %v", err))
-		return fmt.Errorf("failed to send subscribe request: %w", err)
+		c.logger.Errorf("Failed to send subscribe request: %v", err)
+		return nil, nil, nil, fmt.Errorf("failed to send subscribe request: %w", err)
+	}
+	frames.RecordSent(websocket.TextMessage)
+
+	// Bound the wait for the subscribe response by the context deadline, or
+	// by the handshake timeout if there is none, so that a relay that never
+	// answers cannot hold the caller forever
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(relayHandshakeTimeout)
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, nil, nil, fmt.Errorf("failed to set read deadline: %w", err)
 	}
 
 	// Read the response
-	_, message, err := conn.ReadMessage()
+	messageType, message, err := conn.ReadMessage()
 	if err != nil {
 		conn.Close()
-		c.logger.Error(fmt.Sprintf("Failed to read subscribe response: %v", err))
-		return fmt.Errorf("failed to read subscribe response: %w", err)
+		c.logger.Errorf("Failed to read subscribe response: %v", err)
+		if ctxErr := contextError(ctx, err); ctxErr != nil {
+			return nil, nil, nil, fmt.Errorf("failed to read subscribe response: %w", ctxErr)
+		}
+		return nil, nil, nil, fmt.Errorf("failed to read subscribe response: %w", err)
+	}
+
+	// Clear the deadline for the long-lived listener
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, nil, nil, fmt.Errorf("failed to clear read deadline: %w", err)
 	}
 
+	frames.RecordReceived(messageType)
+	k.touch()
+
 	// Log the raw response
-	c.logger.Debug(fmt.Sprintf("Received raw subscribe response: %s", string(message)))
+	c.logger.Debugf("Received raw subscribe response: %s", string(message))
 
 	// Parse the response
 	var response relay.JSONRPCResponse
 	err = json.Unmarshal(message, &response)
 	if err != nil {
 		conn.Close()
-		c.logger.Error(fmt.Sprintf("Failed to parse subscribe response: %v", err))
-		c.logger.Debug(fmt.Sprintf("Invalid JSON response: %s", string(message)))
-		return fmt.Errorf("failed to parse subscribe response: %w", err)
+		c.logger.Errorf("Failed to parse subscribe response: %v", err)
+		c.logger.Debugf("Invalid JSON response: %s", string(message))
+		return nil, nil, nil, fmt.Errorf("failed to parse subscribe response: %w", err)
 	}
 
 	// Check for errors
 	if response.Error != nil {
 		conn.Close()
-		c.logger.Error(fmt.Sprintf("Subscribe error: %s (code: %d)",
-			response.Error.Message, response.Error.Code))
-		return fmt.Errorf("subscribe error: %s", response.Error.Message)
+		c.logger.Errorf("Subscribe error: %s (code: %d)",
+			response.Error.Message, response.Error.Code)
+		return nil, nil, nil, fmt.Errorf("subscribe error: %s", response.Error.Message)
 	}
 
 	// Log successful subscription
-	c.logger.Info(fmt.Sprintf("Successfully subscribed to topic: %s", topic))
-
-	// Store the connection
-	c.connections[topic] = conn
+	c.logger.Infof("Successfully subscribed to topic: %s", topic)
 
-	// Start listening for messages
-	go c.listenForMessages(topic, conn)
+	return conn, frames, k, nil
+}
 
+// contextError returns the error of ctx if err is due to ctx ending, or nil.
+// Connection deadlines are set from the deadline of ctx and may fire just
+// before ctx itself reports that it ended.
+func contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	var netErr net.Error
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) && errors.As(err, &netErr) && netErr.Timeout() {
+		return context.DeadlineExceeded
+	}
 	return nil
 }
 
+// IsSecureRelayURL checks if a relay URL uses the secure wss:// scheme
+func IsSecureRelayURL(url string) bool {
+	return strings.HasPrefix(strings.ToLower(url), "wss://")
+}
+
 // getWebSocketProtocol determines if the URL is using wss:// or ws:// based on the URL
 func getWebSocketProtocol(url string) string {
 	if strings.HasPrefix(url, "wss://") {
@@ -195,22 +667,50 @@ func getWebSocketProtocol(url string) string {
 }
 
 // listenForMessages listens for messages on a topic
-func (c *WalletClient) listenForMessages(topic string, conn *websocket.Conn) {
+func (c *WalletClient) listenForMessages(topic string, conn *websocket.Conn, frames *relay.FrameStats, k *keepalive) {
+	log := c.logger.With("topic", topic)
 	remoteAddr := conn.RemoteAddr().String()
 	localAddr := conn.LocalAddr().String()
 
-	c.logger.Info(fmt.Sprintf("Starting message listener for topic: %s", topic))
-	c.logger.Debug(fmt.Sprintf("WebSocket connection details - Remote: %s, Local: %s, Protocol: %s",
-		remoteAddr, localAddr, getWebSocketProtocol(c.relayURL)))
+	c.mutex.RLock()
+	relayURL := c.topicRelays[topic]
+	c.mutex.RUnlock()
+
+	log.Infof("Starting message listener for topic: %s", topic)
+	log.Debugf("WebSocket connection details - Remote: %s, Local: %s, Protocol: %s",
+		remoteAddr, localAddr, getWebSocketProtocol(relayURL))
 
+	// Set when the relay closed the connection because it reached its maximum age
+	rotated := false
+
+	defer c.listenerWg.Done()
 	defer func() {
 		c.mutex.Lock()
-		delete(c.connections, topic)
+		// Only remove the entry if it still points at this connection; a
+		// reconnect may already have replaced it with a fresh one. If it does,
+		// the connection dropped rather than being closed by us.
+		lost := c.connections[topic] == conn && !c.closed
+		if lost {
+			delete(c.connections, topic)
+			delete(c.topicRelays, topic)
+		}
+		delete(c.frameStats, conn)
+		delete(c.writeMutexes, conn)
+		delete(c.keepalives, conn)
+		logFrameStats := c.logFrameStats
 		c.mutex.Unlock()
+		if logFrameStats {
+			log.Infof("Topic %s frame summary: %s", topic, frames.Summary())
+		}
 		conn.Close()
-		c.logger.Info(fmt.Sprintf("Disconnected from topic: %s", topic))
-		c.logger.Debug(fmt.Sprintf("Closed WebSocket connection - Remote: %s, Local: %s",
-			remoteAddr, localAddr))
+		log.Infof("Disconnected from topic: %s", topic)
+		log.Debugf("Closed WebSocket connection - Remote: %s, Local: %s",
+			remoteAddr, localAddr)
+		if lost && rotated {
+			c.rotateConnection(topic)
+		} else if lost {
+			c.handleConnectionLost(topic)
+		}
 	}()
 
 	messageCount := 0
@@ -218,87 +718,138 @@ func (c *WalletClient) listenForMessages(topic string, conn *websocket.Conn) {
 	for {
 		messageType, message, err := conn.ReadMessage()
 		if err != nil {
-			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
-				c.logger.Info(fmt.Sprintf("WebSocket connection closed normally for topic %s: %v", topic, err))
+			var closeErr *websocket.CloseError
+			var netErr net.Error
+			if errors.As(err, &closeErr) {
+				frames.RecordReceived(websocket.CloseMessage)
+			}
+			if errors.As(err, &closeErr) && closeErr.Code == websocket.CloseGoingAway && closeErr.Text == relay.CloseReasonReconnect {
+				log.Infof("Relay asked to reconnect topic %s", topic)
+				rotated = true
+			} else if errors.Is(err, websocket.ErrReadLimit) {
+				log.Warnf("Relay sent a message larger than %d bytes on topic %s, dropping the connection", c.maxMessageSize, topic)
+			} else if errors.As(err, &netErr) && netErr.Timeout() {
+				log.Warnf("No frames from relay on topic %s for %s, dropping the connection", topic, k.timeout)
+			} else if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Infof("WebSocket connection closed normally for topic %s: %v", topic, err)
 			} else {
-				c.logger.Error(fmt.Sprintf("Failed to read message from topic %s: %v", topic, err))
-				c.logger.Debug(fmt.Sprintf("WebSocket error details - Remote: %s, Local: %s, Error: %v",
-					remoteAddr, localAddr, err))
+				log.Errorf("Failed to read message from topic %s: %v", topic, err)
+				log.Debugf("WebSocket error details - Remote: %s, Local: %s, Error: %v",
+					remoteAddr, localAddr, err)
 			}
 			break
 		}
 
 		messageCount++
-		c.logger.Debug(fmt.Sprintf("Received message #%d from topic %s (type: %d, size: %d bytes)",
-			messageCount, topic, messageType, len(message)))
+		frames.RecordReceived(messageType)
+		k.touch()
+		log.Debugf("Received message #%d from topic %s (type: %d, size: %d bytes)",
+			messageCount, topic, messageType, len(message))
 
 		// Log the raw message (truncated if too long)
 		if len(message) > 1000 {
-			c.logger.Debug(fmt.Sprintf("Raw message (truncated): %s...", string(message[:1000])))
+			log.Debugf("Raw message (truncated): %s...", string(message[:1000]))
 		} else {
-			c.logger.Debug(fmt.Sprintf("Raw message: %s", string(message)))
+			log.Debugf("Raw message: %s", string(message))
 		}
 
-		// Parse the message
-		var notification struct {
-			JSONRPC string `json:"jsonrpc"`
-			Method  string `json:"method"`
-			Params  struct {
-				Topic   string `json:"topic"`
-				Message string `json:"message"`
-			} `json:"params"`
-		}
+		c.handleNotification(conn, topic, message)
+	}
+}
 
-		err = json.Unmarshal(message, &notification)
-		if err != nil {
-			c.logger.Error(fmt.Sprintf("Failed to parse notification from topic %s: %v", topic, err))
-			c.logger.Debug(fmt.Sprintf("Invalid JSON message: %s", string(message)))
-			continue
-		}
+// handleNotification parses and dispatches a notification frame received from
+// the relay on a topic's connection. conn is nil for replayed frames.
+func (c *WalletClient) handleNotification(conn *websocket.Conn, topic string, message []byte) {
+	log := c.logger.With("topic", topic)
+
+	// Parse the message
+	var notification struct {
+		JSONRPC string `json:"jsonrpc"`
+		Method  string `json:"method"`
+		// ID and Error are set on the relay's responses to our requests
+		ID     relay.ID       `json:"id"`
+		Error  *ResponseError `json:"error"`
+		Params struct {
+			Topic   string `json:"topic"`
+			Message string `json:"message"`
+			// ID is the message ID on irn_receipt notifications and the
+			// subscription ID on irn_subscription notifications
+			ID        string `json:"id"`
+			Delivered int    `json:"delivered"`
+			// Data carries the message on irn_subscription notifications
+			Data relay.SubscriptionData `json:"data"`
+		} `json:"params"`
+	}
+
+	err := json.Unmarshal(message, &notification)
+	if err != nil {
+		log.Errorf("Failed to parse notification from topic %s: %v", topic, err)
+		log.Debugf("Invalid JSON message: %s", string(message))
+		return
+	}
 
-		// Log the parsed notification
-		c.logger.Debug(fmt.Sprintf("Parsed notification - Method: %s, Topic: %s, Message length: %d bytes",
-			notification.Method, notification.Params.Topic, len(notification.Params.Message)))
+	// Log the parsed notification
+	log.Debugf("Parsed notification - Method: %s, Topic: %s, Message length: %d bytes",
+		notification.Method, notification.Params.Topic, len(notification.Params.Message))
+
+	// Handle the message, delivered either in the IRN form or in the legacy form
+	if notification.Method == "irn_subscription" {
+		data := notification.Params.Data
+		log.Infof("Handling message from topic %s (subscription: %s, message length: %d bytes)",
+			data.Topic, notification.Params.ID, len(data.Message))
+		c.ackSubscription(conn, notification.ID)
+		c.handleMessage(conn, data.Topic, data.Message)
+	} else if notification.Method == "message" {
+		log.Infof("Handling message from topic %s (message length: %d bytes)",
+			notification.Params.Topic, len(notification.Params.Message))
+		c.handleMessage(conn, notification.Params.Topic, notification.Params.Message)
+	} else if notification.Method == "irn_receipt" {
+		c.handleReceipt(notification.Params.Topic, notification.Params.ID, notification.Params.Delivered)
+	} else if id, ok := notification.ID.Int64(); ok && notification.Method == "" && id != 0 {
+		c.handleRelayAck(topic, int(id), notification.Error)
+	} else {
+		log.Infof("Received notification with method: %s (not handling)", notification.Method)
+	}
+}
 
-		// Handle the message
-		if notification.Method == "message" {
-			c.logger.Info(fmt.Sprintf("Handling message from topic %s (message length: %d bytes)",
-				notification.Params.Topic, len(notification.Params.Message)))
-			c.handleMessage(notification.Params.Topic, notification.Params.Message)
-		} else {
-			c.logger.Info(fmt.Sprintf("Received notification with method: %s (not handling)", notification.Method))
-		}
+// ackSubscription acknowledges an irn_subscription notification so the relay
+// knows the message arrived. Replayed frames have no connection to answer on.
+func (c *WalletClient) ackSubscription(conn *websocket.Conn, id relay.ID) {
+	if conn == nil {
+		return
+	}
+
+	ackJSON, err := relay.NewJSONRPCResponse(id, true).ToJSON()
+	if err != nil {
+		c.logger.Errorf("Failed to marshal acknowledgement: %v", err)
+		return
+	}
+	if err := c.writeText(conn, []byte(ackJSON)); err != nil {
+		c.logger.Warnf("Failed to acknowledge notification %s: %v", id, err)
 	}
 }
 
 // handleMessage handles a message from the relay server
-func (c *WalletClient) handleMessage(topic string, encryptedMessage string) {
-	c.logger.Info(fmt.Sprintf("Processing message from topic: %s (encrypted length: %d bytes)",
-		topic, len(encryptedMessage)))
+func (c *WalletClient) handleMessage(conn *websocket.Conn, topic string, encryptedMessage string) {
+	c.logger.Infof("Processing message from topic: %s (encrypted length: %d bytes)",
+		topic, len(encryptedMessage))
 
 	// Log the first part of the encrypted message (for debugging)
 	if len(encryptedMessage) > 100 {
-		c.logger.Debug(fmt.Sprintf("Encrypted message (first 100 chars): %s...", encryptedMessage[:100]))
+		c.logger.Debugf("Encrypted message (first 100 chars): %s...", encryptedMessage[:100])
 	} else {
-		c.logger.Debug(fmt.Sprintf("Encrypted message: %s", encryptedMessage))
+		c.logger.Debugf("Encrypted message: %s", encryptedMessage)
 	}
 
 	// Find the session for this topic
-	var session *Session
-	var sessionSource string
-
-	if session = c.sessionManager.GetSessionByPairingTopic(topic); session != nil {
-		sessionSource = "pairing topic"
-	} else if session = c.sessionManager.GetSessionBySessionTopic(topic); session != nil {
-		sessionSource = "session topic"
-	} else {
-		c.logger.Warn(fmt.Sprintf("No session found for topic: %s", topic))
-		c.logger.Debug(fmt.Sprintf("Active sessions: %d", len(c.sessionManager.GetActiveSessions())))
+	session, sessionSource := c.sessionByTopic(topic)
+	if session == nil {
+		c.handleUnknownTopic(conn, topic)
 		return
 	}
 
-	c.logger.Debug(fmt.Sprintf("Found session %s via %s (status: %s)",
-		session.ID, sessionSource, session.Status))
+	c.logger.Debugf("Found session %s via %s (status: %s)",
+		session.ID, sessionSource, session.Status)
 
 	// Decrypt the message
 	startTime := time.Now()
@@ -306,147 +857,1141 @@ func (c *WalletClient) handleMessage(topic string, encryptedMessage string) {
 	decryptDuration := time.Since(startTime)
 
 	if err != nil {
-		c.logger.Error(fmt.Sprintf("Failed to decrypt message: %v", err))
-		c.logger.Debug(fmt.Sprintf("Decryption failure details - Session: %s, Error: %v",
-			session.ID, err))
+		metrics.WalletDecryptFailures.Inc()
+		c.logger.Errorf("Failed to decrypt message: %v", err)
+		c.logger.Debugf("Decryption failure details - Session: %s, Error: %v",
+			session.ID, err)
+		c.publishWalletEvent(session, WalletEvent{Type: WalletEventError, Err: fmt.Errorf("failed to decrypt message: %w", err)})
 		return
 	}
 
-	c.logger.Info(fmt.Sprintf("Successfully decrypted message in %s", decryptDuration))
+	c.logger.Infof("Successfully decrypted message in %s", decryptDuration)
 
 	// Log the decrypted message (truncated if too long)
 	if len(decrypted) > 500 {
-		c.logger.Debug(fmt.Sprintf("Decrypted message (truncated): %s...", decrypted[:500]))
+		c.logger.Debugf("Decrypted message (truncated): %s...", decrypted[:500])
 	} else {
-		c.logger.Debug(fmt.Sprintf("Decrypted message: %s", decrypted))
+		c.logger.Debugf("Decrypted message: %s", decrypted)
 	}
 
-	// Try to parse the decrypted message as JSON for better logging
-	var jsonMessage map[string]interface{}
-	if err := json.Unmarshal([]byte(decrypted), &jsonMessage); err == nil {
-		prettyJSON, _ := json.MarshalIndent(jsonMessage, "", "  ")
-		c.logger.Debug(fmt.Sprintf("Parsed JSON message: %s", string(prettyJSON)))
+	c.messageLog.record(session.ID, "in", string(sessionSource), []byte(decrypted))
 
-		// Log specific message types
-		if method, ok := jsonMessage["method"].(string); ok {
-			c.logger.Info(fmt.Sprintf("Message method: %s", method))
-		}
+	// Classify the decrypted JSON-RPC message
+	incoming, err := ParseIncoming([]byte(decrypted))
+	if err != nil {
+		c.logger.Errorf("Failed to parse decrypted message: %v", err)
+		return
 	}
 
-	// TODO: Handle the decrypted message based on its type
-	c.logger.Info(fmt.Sprintf("Message handling completed for topic: %s", topic))
-}
+	c.logger.Debugf("Parsed %s message - ID: %d, Method: %s", incoming.Kind, incoming.ID, incoming.Method)
 
-// decryptMessage decrypts a message for a session
-func (c *WalletClient) decryptMessage(encryptedMessage string, session *Session) (string, error) {
-	// Decrypt the message with the session's symmetric key
-	decrypted, err := DecryptResponse(encryptedMessage, session)
-	if err != nil {
-		return "", fmt.Errorf("failed to decrypt message: %w", err)
+	// A single-topic session carries both phases on one topic, so the phase is
+	// taken from the method, or from the session status for responses
+	if session.SingleTopic() {
+		sessionSource = singleTopicKind(session, incoming)
+	}
+
+	// Wake anyone waiting for this method before the regular handling
+	if incoming.Kind == IncomingRequest {
+		c.notifyMethodWaiters(session, incoming.Method, decrypted)
+	}
+
+	// Pairing and session topics carry different parts of the protocol
+	switch sessionSource {
+	case topicKindPairing:
+		c.handlePairingMessage(session, incoming)
+	case topicKindSession:
+		c.handleSessionMessage(session, incoming)
 	}
 
-	return fmt.Sprintf("%+v", decrypted), nil
+	c.logger.Infof("Message handling completed for topic: %s", topic)
 }
 
-// SignMessage requests a signature for a message
-func (c *WalletClient) SignMessage(session *Session, message string) (string, error) {
-	c.logger.Info(fmt.Sprintf("Requesting signature for message: %s", message))
+// topicKind identifies which of a session's topics a message arrived on
+type topicKind string
 
-	// Check if the session is active
-	if session.Status != SessionStatusActive {
-		return "", fmt.Errorf("session is not active")
+// singleTopicKind determines which protocol phase a message on a single-topic session belongs to
+func singleTopicKind(session *Session, incoming IncomingMessage) topicKind {
+	if incoming.Kind == IncomingRequest {
+		if slices.Contains(pairingMethods, incoming.Method) {
+			return topicKindPairing
+		}
+		return topicKindSession
 	}
+	if session.Status == SessionStatusActive {
+		return topicKindSession
+	}
+	return topicKindPairing
+}
 
-	// Create a sign request
-	request := NewPersonalSignRequest(1, message, session.WalletAddress.Hex())
+const (
+	topicKindPairing topicKind = "pairing topic"
+	topicKindSession topicKind = "session topic"
+)
 
-	// Encrypt the request
-	encrypted, err := EncryptRequest(request, session)
-	if err != nil {
-		return "", fmt.Errorf("failed to encrypt request: %w", err)
-	}
+// pairingMethods are the methods expected on a pairing topic, which carries the handshake
+var pairingMethods = []string{
+	"wc_sessionPropose",
+	"wc_pairingDelete",
+	"wc_pairingPing",
+	"wc_pairingExtend",
+}
 
-	// Connect to the session topic if not already connected
-	err = c.connectToTopic(session.SessionTopic)
-	if err != nil {
-		return "", fmt.Errorf("failed to connect to session topic: %w", err)
+// sessionMethods are the methods expected on a session topic, which carries requests and session management
+var sessionMethods = []string{
+	"wc_sessionSettle",
+	"wc_sessionUpdate",
+	"wc_sessionExtend",
+	"wc_sessionPing",
+	"wc_sessionDelete",
+	"wc_sessionEvent",
+	"wc_sessionRequest",
+}
+
+// handlePairingMessage handles a decrypted message received on a session's pairing topic
+func (c *WalletClient) handlePairingMessage(session *Session, incoming IncomingMessage) {
+	if incoming.Kind == IncomingRequest {
+		method := incoming.Method
+		if !slices.Contains(pairingMethods, method) {
+			c.logger.Warnf("Unexpected method %s on pairing topic of session %s", method, session.ID)
+			return
+		}
+		c.logger.Infof("Pairing message method: %s", method)
+		if method == "wc_pairingPing" {
+			c.respondOn(session, topicKindPairing, incoming.ID, true, nil)
+		}
+		return
 	}
 
-	// Send the request
-	c.mutex.RLock()
-	conn := c.connections[session.SessionTopic]
-	c.mutex.RUnlock()
+	// Responses answer our requests, e.g. the session proposal
+	c.handleResponseMessage(session, incoming)
+}
 
-	if conn == nil {
-		return "", fmt.Errorf("not connected to session topic")
+// handleSessionMessage handles a decrypted message received on a session's session topic
+func (c *WalletClient) handleSessionMessage(session *Session, incoming IncomingMessage) {
+	if incoming.Kind == IncomingRequest {
+		method := incoming.Method
+		if !slices.Contains(sessionMethods, method) {
+			c.logger.Warnf("Unexpected method %s on session topic of session %s", method, session.ID)
+			return
+		}
+		c.logger.Infof("Session message method: %s", method)
+		switch method {
+		case "wc_sessionSettle":
+			c.handleSessionSettle(session, string(incoming.Raw))
+		case "wc_sessionUpdate":
+			c.handleSessionUpdate(session, string(incoming.Raw))
+		case "wc_sessionPing":
+			c.respond(session, incoming.ID, true, nil)
+		}
+		return
 	}
 
-	// Create a publish request
-	publishRequest := relay.NewJSONRPCRequest(2, "publish", relay.PublishParams{
-		Topic:   session.SessionTopic,
-		Message: encrypted,
-		TTL:     300, // 5 minutes
-	})
+	// Responses and errors answer our requests
+	c.handleResponseMessage(session, incoming)
+}
 
-	publishRequestJSON, err := publishRequest.ToJSON()
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal publish request: %w", err)
+// handleResponseMessage delivers a JSON-RPC response to the request waiting for it
+func (c *WalletClient) handleResponseMessage(session *Session, incoming IncomingMessage) {
+	// Our own responses to wallet requests, such as to wc_sessionSettle, are
+	// echoed back by the relay and match no pending request
+	c.pendingMutex.Lock()
+	_, pending := c.pendingRequests[incoming.ID]
+	c.pendingMutex.Unlock()
+	if !pending {
+		c.logger.Debugf("Ignoring response %d that matches no pending request", incoming.ID)
+		return
 	}
 
-	err = conn.WriteMessage(websocket.TextMessage, []byte(publishRequestJSON))
+	response, err := incoming.signResponse()
 	if err != nil {
-		return "", fmt.Errorf("failed to send publish request: %w", err)
+		c.logger.Errorf("Failed to parse response: %v", err)
+		return
 	}
 
-	c.logger.Info("Sent sign request to wallet")
-
-	// TODO: Wait for the response
-	// For now, we'll just return a placeholder
-	return "Signature request sent. Waiting for wallet approval...", nil
-}
+	// Tell subscribers about signatures and wallet errors
+	switch {
+	case response.Error != nil:
+		c.publishWalletEvent(session, WalletEvent{Type: WalletEventError, RequestID: response.ID, Err: response.Error})
+	case isSignature(response.Result):
+		if message, ok := c.takeSignMessage(response.ID); ok {
+			c.recordSigner(session, message, response.Result)
+		}
+		c.publishWalletEvent(session, WalletEvent{Type: WalletEventSignatureReceived, RequestID: response.ID, Signature: response.Result})
+	}
 
-// GetActiveSessions gets all active sessions
-func (c *WalletClient) GetActiveSessions() []*Session {
-	return c.sessionManager.GetActiveSessions()
+	c.deliverResponse(response)
 }
 
-// GetSession gets a session by ID
-func (c *WalletClient) GetSession(id string) *Session {
-	return c.sessionManager.GetSession(id)
-}
+// handleUnknownTopic handles a notification for a topic that matches no session.
+// Notifications for recently removed topics are expected while the relay catches
+// up and are only logged at debug level. Topics that keep producing notifications
+// are unsubscribed from the relay.
+func (c *WalletClient) handleUnknownTopic(conn *websocket.Conn, topic string) {
+	if c.isRecentlyRemovedTopic(topic) {
+		c.logger.Debugf("Ignoring message for recently removed topic: %s", topic)
+		return
+	}
 
-// DisconnectSession disconnects a session
-func (c *WalletClient) DisconnectSession(session *Session) error {
-	c.logger.Info(fmt.Sprintf("Disconnecting session: %s", session.ID))
+	c.logger.Warnf("No session found for topic: %s", topic)
+	c.logger.Debugf("Active sessions: %d", len(c.sessionManager.GetActiveSessions()))
 
-	// Disconnect from the pairing topic
-	c.mutex.Lock()
-	if conn, ok := c.connections[session.PairingTopic]; ok {
-		conn.Close()
-		delete(c.connections, session.PairingTopic)
+	c.topicsMutex.Lock()
+	c.unknownTopicCounts[topic]++
+	count := c.unknownTopicCounts[topic]
+	if count >= unknownTopicUnsubscribeThreshold {
+		delete(c.unknownTopicCounts, topic)
 	}
+	c.topicsMutex.Unlock()
 
-	// Disconnect from the session topic
-	if conn, ok := c.connections[session.SessionTopic]; ok {
-		conn.Close()
-		delete(c.connections, session.SessionTopic)
+	// Replayed frames have no connection to unsubscribe on
+	if count < unknownTopicUnsubscribeThreshold || conn == nil {
+		return
 	}
-	c.mutex.Unlock()
 
-	// Update the session status
-	session.Disconnect()
-
-	return nil
+	c.logger.Infof("Unsubscribing from persistently unknown topic: %s", topic)
+	if err := c.sendUnsubscribe(conn, topic); err != nil {
+		c.logger.Errorf("Failed to unsubscribe from unknown topic %s: %v", topic, err)
+	}
 }
 
-// CleanupExpiredSessions removes expired sessions
-func (c *WalletClient) CleanupExpiredSessions() {
-	c.sessionManager.CleanupExpiredSessions()
+// sendUnsubscribe sends an unsubscribe request for a topic over a connection
+func (c *WalletClient) sendUnsubscribe(conn *websocket.Conn, topic string) error {
+	unsubscribeRequest := relay.NewJSONRPCRequest(relay.NumberID(unsubscribeRequestID), "unsubscribe", relay.UnsubscribeParams{
+		Topic: topic,
+	})
+
+	unsubscribeRequestJSON, err := unsubscribeRequest.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to marshal unsubscribe request: %w", err)
+	}
+
+	c.logger.Debugf("Sending unsubscribe request: %s", unsubscribeRequestJSON)
+
+	err = c.writeText(conn, []byte(unsubscribeRequestJSON))
+	if err != nil {
+		return fmt.Errorf("failed to send unsubscribe request: %w", err)
+	}
+
+	return nil
+}
+
+// writeText sends a text frame on a relay connection, counting it in the connection's frame stats
+func (c *WalletClient) writeText(conn *websocket.Conn, data []byte) error {
+	c.mutex.RLock()
+	writeMutex, ok := c.writeMutexes[conn]
+	c.mutex.RUnlock()
+
+	// The listener acknowledges and answers messages while requests are
+	// published from other goroutines, but a connection allows one writer
+	if ok {
+		writeMutex.Lock()
+	}
+	err := conn.WriteMessage(websocket.TextMessage, data)
+	if ok {
+		writeMutex.Unlock()
+	}
+	if err != nil {
+		return err
+	}
+
+	c.recordSent(conn, websocket.TextMessage)
+	return nil
+}
+
+// recordSent counts a sent frame in the connection's frame stats
+func (c *WalletClient) recordSent(conn *websocket.Conn, messageType int) {
+	c.mutex.RLock()
+	frames, ok := c.frameStats[conn]
+	c.mutex.RUnlock()
+
+	if ok {
+		frames.RecordSent(messageType)
+	}
+}
+
+// detachConnections removes the connections for the given topics from the
+// client and returns them so they can be closed without holding the mutex
+func (c *WalletClient) detachConnections(topics ...string) map[string]*websocket.Conn {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	detached := make(map[string]*websocket.Conn)
+	for _, topic := range topics {
+		if conn, ok := c.connections[topic]; ok {
+			detached[topic] = conn
+			delete(c.connections, topic)
+			delete(c.topicRelays, topic)
+		}
+	}
+	return detached
+}
+
+// closeConnection sends a normal-closure close frame with the given reason
+// before closing the connection, so the relay sees a clean shutdown
+func (c *WalletClient) closeConnection(conn *websocket.Conn, topic string, reason string) {
+	closeMessage := websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason)
+	if err := conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second)); err != nil {
+		c.logger.Debugf("Failed to send close message for topic %s: %v", topic, err)
+	} else {
+		c.recordSent(conn, websocket.CloseMessage)
+	}
+	conn.Close()
+}
+
+// markTopicsRemoved records that we intentionally stopped listening on the given topics
+func (c *WalletClient) markTopicsRemoved(topics ...string) {
+	c.topicsMutex.Lock()
+	defer c.topicsMutex.Unlock()
+
+	now := time.Now()
+	for _, topic := range topics {
+		c.removedTopics[topic] = now
+	}
+}
+
+// isRecentlyRemovedTopic checks if a topic was removed within the recent window,
+// pruning entries that have aged out
+func (c *WalletClient) isRecentlyRemovedTopic(topic string) bool {
+	c.topicsMutex.Lock()
+	defer c.topicsMutex.Unlock()
+
+	now := time.Now()
+	for t, removedAt := range c.removedTopics {
+		if now.Sub(removedAt) > recentlyRemovedTopicWindow {
+			delete(c.removedTopics, t)
+		}
+	}
+
+	_, ok := c.removedTopics[topic]
+	return ok
+}
+
+// decryptMessage decrypts a message for a session
+func (c *WalletClient) decryptMessage(encryptedMessage string, session *Session) (string, error) {
+	// Decrypt the WalletConnect envelope with the session's symmetric key
+	decrypted, doubleEncoded, err := utils.DecryptWithChaCha20Lenient(encryptedMessage, session.SymKey)
+	if err == nil {
+		if doubleEncoded {
+			c.logger.Warnf("Message for session %s was base64-encoded twice; corrected", session.ID)
+		}
+		return string(decrypted), nil
+	}
+
+	// Fall back to the legacy AES-GCM format used by older versions of this app
+	decrypted, doubleEncoded, legacyErr := utils.DecryptWithSymmetricKeyLenient(encryptedMessage, session.SymKey)
+	if legacyErr != nil {
+		return "", fmt.Errorf("failed to decrypt message: %w", err)
+	}
+	c.logger.Debugf("Message for session %s used the legacy AES-GCM format", session.ID)
+	if doubleEncoded {
+		c.logger.Warnf("Message for session %s was base64-encoded twice; corrected", session.ID)
+	}
+
+	return string(decrypted), nil
+}
+
+// nextRequestID returns a unique JSON-RPC request ID.
+// Like WalletConnect, IDs are derived from the current time with a counter
+// in the low digits so IDs stay unique within the same millisecond.
+func (c *WalletClient) nextRequestID() int {
+	counter := c.requestCounter.Add(1)
+	return int(time.Now().UnixMilli()*1000 + counter%1000)
+}
+
+// registerPending registers a request that expects a response from the wallet
+func (c *WalletClient) registerPending(id int) chan *SignResponse {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+
+	ch := make(chan *SignResponse, 1)
+	c.pendingRequests[id] = ch
+	return ch
+}
+
+// unregisterPending removes a pending request
+func (c *WalletClient) unregisterPending(id int) {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+
+	delete(c.pendingRequests, id)
+	delete(c.signMessages, id)
+}
+
+// registerSignMessage remembers the message of a pending personal_sign request
+func (c *WalletClient) registerSignMessage(id int, message string) {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+
+	c.signMessages[id] = message
+}
+
+// takeSignMessage returns and forgets the message of a personal_sign request
+func (c *WalletClient) takeSignMessage(id int) (string, bool) {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+
+	message, ok := c.signMessages[id]
+	delete(c.signMessages, id)
+	return message, ok
+}
+
+// deliverResponse hands a wallet response to the request waiting for it
+func (c *WalletClient) deliverResponse(response *SignResponse) {
+	c.pendingMutex.Lock()
+	ch, ok := c.pendingRequests[response.ID]
+	delete(c.pendingRequests, response.ID)
+	c.pendingMutex.Unlock()
+
+	if !ok {
+		c.logger.Warnf("Received response for unknown request ID %d", response.ID)
+		return
+	}
+
+	c.logger.Infof("Received response for request ID %d", response.ID)
+	ch <- response
+}
+
+// waitForResponse waits for the wallet's response to a request
+func (c *WalletClient) waitForResponse(ctx context.Context, id int, ch chan *SignResponse) (*SignResponse, error) {
+	defer c.unregisterPending(id)
+
+	select {
+	case response := <-ch:
+		if response.Error != nil {
+			return nil, response.Error
+		}
+		return response, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for response to request %d: %w", id, ctx.Err())
+	}
+}
+
+// methodWaiterKey identifies the incoming method a waiter is waiting for
+type methodWaiterKey struct {
+	sessionID string
+	method    string
+}
+
+// WaitForMethod waits for the next incoming message with the given JSON-RPC
+// method on any of the session's topics and returns its decrypted params.
+// The message is still handled as usual. It fails if no such message arrives
+// within timeout or the client is closed.
+func (c *WalletClient) WaitForMethod(session *Session, method string, timeout time.Duration) (json.RawMessage, error) {
+	key := methodWaiterKey{sessionID: session.ID, method: method}
+	ch := make(chan json.RawMessage, 1)
+
+	c.waitersMutex.Lock()
+	c.methodWaiters[key] = append(c.methodWaiters[key], ch)
+	c.waitersMutex.Unlock()
+
+	defer c.removeMethodWaiter(key, ch)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case params := <-ch:
+		return params, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("waiting for %s on session %s: %w", method, session.ID, context.DeadlineExceeded)
+	case <-c.done:
+		return nil, ErrClientClosed
+	}
+}
+
+// removeMethodWaiter unregisters a method waiter if it is still registered
+func (c *WalletClient) removeMethodWaiter(key methodWaiterKey, ch chan json.RawMessage) {
+	c.waitersMutex.Lock()
+	defer c.waitersMutex.Unlock()
+
+	waiters := slices.DeleteFunc(c.methodWaiters[key], func(waiter chan json.RawMessage) bool {
+		return waiter == ch
+	})
+	if len(waiters) == 0 {
+		delete(c.methodWaiters, key)
+	} else {
+		c.methodWaiters[key] = waiters
+	}
+}
+
+// notifyMethodWaiters hands the params of an incoming message to everyone
+// waiting for its method on the session. Each waiter is notified once.
+func (c *WalletClient) notifyMethodWaiters(session *Session, method string, decrypted string) {
+	key := methodWaiterKey{sessionID: session.ID, method: method}
+
+	c.waitersMutex.Lock()
+	waiting := len(c.methodWaiters[key]) > 0
+	c.waitersMutex.Unlock()
+
+	if !waiting {
+		return
+	}
+
+	var message struct {
+		Params json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal([]byte(decrypted), &message); err != nil {
+		c.logger.Errorf("Failed to extract params of %s for waiters: %v", method, err)
+		return
+	}
+
+	c.waitersMutex.Lock()
+	waiters := c.methodWaiters[key]
+	delete(c.methodWaiters, key)
+	c.waitersMutex.Unlock()
+
+	c.logger.Debugf("Delivering %s on session %s to %d waiters", method, session.ID, len(waiters))
+	for _, ch := range waiters {
+		ch <- message.Params
+	}
+}
+
+// publishRequest encrypts a request and publishes it on the session topic
+func (c *WalletClient) publishRequest(session *Session, request *SignRequest) error {
+	// Refuse methods that are not allowed before anything is sent
+	if !c.IsSignMethodAllowed(request.Method) {
+		c.logger.Warnf("Refusing to send disallowed sign method: %s", request.Method)
+		return fmt.Errorf("%w: %s", ErrSignMethodNotAllowed, request.Method)
+	}
+
+	return c.publishEncrypted(session, topicKindSession, request)
+}
+
+// publishEncrypted encrypts a JSON-RPC message with the session's symmetric
+// key and publishes it on the session's pairing or session topic
+func (c *WalletClient) publishEncrypted(session *Session, kind topicKind, message any) error {
+	topic := session.SessionTopic
+	if kind == topicKindPairing {
+		topic = session.PairingTopic
+	}
+
+	// Encrypt the message
+	messageJSON, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	encrypted, err := utils.EncryptWithChaCha20(messageJSON, session.SymKey)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt request: %w", err)
+	}
+
+	// Connect to the topic if not already connected
+	err = c.connectToTopic(topic)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", kind, err)
+	}
+
+	// Send the message
+	c.mutex.RLock()
+	conn := c.connections[topic]
+	c.mutex.RUnlock()
+
+	if conn == nil {
+		return fmt.Errorf("not connected to %s", kind)
+	}
+
+	// Create a publish request
+	publishRequest := relay.NewJSONRPCRequest(relay.NumberID(publishRequestID), "publish", relay.PublishParams{
+		Topic:   topic,
+		Message: encrypted,
+		TTL:     300, // 5 minutes
+		Receipt: true,
+	})
+
+	publishRequestJSON, err := publishRequest.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to marshal publish request: %w", err)
+	}
+
+	err = c.writeText(conn, []byte(publishRequestJSON))
+	if err != nil {
+		return fmt.Errorf("failed to send publish request: %w", err)
+	}
+
+	c.messageLog.record(session.ID, "out", string(kind), messageJSON)
+
+	return nil
+}
+
+// SignMessage requests a personal_sign signature for a message and blocks
+// until the wallet responds or ctx is done. It returns the hex signature.
+func (c *WalletClient) SignMessage(ctx context.Context, session *Session, message string) (string, error) {
+	c.logger.Infof("Requesting signature for message: %s", message)
+
+	// Check if the session is active
+	if session.Status != SessionStatusActive {
+		return "", ErrSessionNotActive
+	}
+
+	// The demo wallet signs in-process instead of publishing to the relay
+	if demo := c.DemoWallet(); demo != nil {
+		c.logger.Infof("Signing message with demo wallet %s", demo.Address().Hex())
+		return demo.SignMessage(message)
+	}
+
+	// Create the request and register for its response before publishing
+	id := c.nextRequestID()
+	request, err := NewPersonalSignRequest(id, message, session.WalletAddress.Hex())
+	if err != nil {
+		return "", err
+	}
+	ch := c.registerPending(id)
+	c.registerSignMessage(id, message)
+
+	if err := c.publishRequest(session, request); err != nil {
+		c.unregisterPending(id)
+		return "", err
+	}
+
+	c.logger.Infof("Sent sign request %d to wallet", id)
+
+	// Wait for the wallet's response
+	response, err := c.waitForResponse(ctx, id, ch)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("wallet did not answer personal_sign for session %s: %w", session.ID, err)
+		}
+		return "", err
+	}
+
+	c.logger.Infof("Received signature for request %d", id)
+	return response.Result, nil
+}
+
+// SignTypedData requests an eth_signTypedData_v4 signature for EIP-712 typed data.
+// The typed data is validated and its domain chainId checked against the session
+// before anything is sent. It blocks until the wallet responds or ctx is done,
+// then verifies that the signature recovers to the session's wallet address.
+func (c *WalletClient) SignTypedData(ctx context.Context, session *Session, typedDataJSON []byte) (*TypedDataSignature, error) {
+	c.logger.Infof("Requesting typed data signature for session: %s", session.ID)
+
+	// Check if the session is active
+	if session.Status != SessionStatusActive {
+		return nil, ErrSessionNotActive
+	}
+
+	// Validate the typed data
+	typedData, err := ParseTypedData(typedDataJSON)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateTypedDataChain(typedData, session.ChainID); err != nil {
+		return nil, err
+	}
+
+	// Create the request and register for its response before publishing
+	id := c.nextRequestID()
+	request := NewSignTypedDataRequest(id, session.WalletAddress.Hex(), typedDataJSON)
+	ch := c.registerPending(id)
+
+	if err := c.publishRequest(session, request); err != nil {
+		c.unregisterPending(id)
+		return nil, err
+	}
+
+	c.logger.Infof("Sent typed data sign request %d to wallet", id)
+
+	// Wait for the wallet's response
+	response, err := c.waitForResponse(ctx, id, ch)
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify the signature
+	recovered, err := RecoverTypedDataSigner(typedData, response.Result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify typed data signature: %w", err)
+	}
+
+	return &TypedDataSignature{
+		Signature:        response.Result,
+		Valid:            recovered == session.WalletAddress,
+		RecoveredAddress: recovered,
+	}, nil
+}
+
+// SendTransaction asks the wallet to sign and broadcast a transaction with
+// eth_sendTransaction and blocks until it responds or ctx is done. It returns
+// the transaction hash.
+func (c *WalletClient) SendTransaction(ctx context.Context, session *Session, tx TxParams) (string, error) {
+	c.logger.Infof("Requesting transaction for session: %s", session.ID)
+
+	// Check if the session is active
+	if session.Status != SessionStatusActive {
+		return "", ErrSessionNotActive
+	}
+
+	// Validate the transaction and make sure it is sent from the connected wallet
+	tx, err := tx.Validate()
+	if err != nil {
+		return "", err
+	}
+	if tx.From != session.WalletAddress.Hex() {
+		return "", fmt.Errorf("%w: %s", ErrSenderMismatch, tx.From)
+	}
+
+	// The demo wallet is not connected to a chain
+	if c.DemoWallet() != nil {
+		return "", fmt.Errorf("%w: the demo wallet cannot send transactions", ErrNotSupportedByDemoWallet)
+	}
+
+	// Create the request and register for its response before publishing
+	id := c.nextRequestID()
+	request := NewSendTransactionRequest(id, tx)
+	ch := c.registerPending(id)
+
+	if err := c.publishRequest(session, request); err != nil {
+		c.unregisterPending(id)
+		return "", err
+	}
+
+	c.logger.Infof("Sent transaction request %d to wallet", id)
+
+	// Wait for the wallet's response
+	response, err := c.waitForResponse(ctx, id, ch)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("wallet did not answer eth_sendTransaction for session %s: %w", session.ID, err)
+		}
+		return "", err
+	}
+
+	// The result is the hash of the broadcast transaction
+	if hash, err := hexutil.Decode(response.Result); err != nil || len(hash) != common.HashLength {
+		return "", fmt.Errorf("wallet returned an invalid transaction hash %q", response.Result)
+	}
+
+	c.logger.Infof("Wallet sent transaction %s for session %s", response.Result, session.ID)
+	return response.Result, nil
+}
+
+// GetAccounts asks the wallet for its accounts with eth_accounts and blocks
+// until it responds or ctx is done. The accounts are cached on the session.
+// A wallet that exposes no accounts yields an empty list.
+func (c *WalletClient) GetAccounts(ctx context.Context, session *Session) ([]common.Address, error) {
+	c.logger.Infof("Requesting accounts for session: %s", session.ID)
+
+	// Check if the session is active
+	if session.Status != SessionStatusActive {
+		return nil, ErrSessionNotActive
+	}
+
+	// The demo wallet has a single account
+	if demo := c.DemoWallet(); demo != nil {
+		accounts := []common.Address{demo.Address()}
+		session.SetAccounts(accounts)
+		c.saveSession(session)
+		return accounts, nil
+	}
+
+	// Create the request and register for its response before publishing.
+	// eth_accounts only reads, so it is not subject to the sign method allowlist.
+	id := c.nextRequestID()
+	request := NewAccountsRequest(id)
+	ch := c.registerPending(id)
+
+	if err := c.publishEncrypted(session, topicKindSession, request); err != nil {
+		c.unregisterPending(id)
+		return nil, err
+	}
+
+	c.logger.Infof("Sent accounts request %d to wallet", id)
+
+	// Wait for the wallet's response
+	response, err := c.waitForResponse(ctx, id, ch)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("wallet did not answer eth_accounts for session %s: %w", session.ID, err)
+		}
+		return nil, err
+	}
+
+	// The result is an array of addresses, which may be empty
+	var result []string
+	if err := json.Unmarshal(response.RawResult, &result); err != nil {
+		return nil, fmt.Errorf("wallet returned invalid accounts %s: %w", response.RawResult, err)
+	}
+	accounts := make([]common.Address, 0, len(result))
+	for _, account := range result {
+		address, err := ParseAddress(account)
+		if err != nil {
+			return nil, fmt.Errorf("wallet returned an invalid account: %w", err)
+		}
+		accounts = append(accounts, address)
+	}
+	if len(accounts) == 0 {
+		c.logger.Warnf("Wallet exposes no accounts for session %s", session.ID)
+	}
+
+	session.SetAccounts(accounts)
+	c.saveSession(session)
+
+	c.logger.Infof("Wallet exposes %d accounts for session %s", len(accounts), session.ID)
+	return accounts, nil
+}
+
+// GetActiveSessions gets all active sessions
+func (c *WalletClient) GetActiveSessions() []*Session {
+	return c.sessionManager.GetActiveSessions()
+}
+
+// GetAllSessions gets all sessions, whatever their status, oldest first
+func (c *WalletClient) GetAllSessions() []*Session {
+	return c.sessionManager.GetAllSessions()
+}
+
+// GetSession gets a session by ID
+func (c *WalletClient) GetSession(id string) *Session {
+	return c.sessionManager.GetSession(id)
+}
+
+// GetSessionByTopic gets the session whose pairing or session topic is the
+// given topic, or nil if there is none
+func (c *WalletClient) GetSessionByTopic(topic string) *Session {
+	session, _ := c.sessionByTopic(topic)
+	return session
+}
+
+// sessionByTopic finds the session of a topic and which of its topics it is.
+// Pairing topics are checked first, so a single-topic session reports its
+// topic as the pairing topic.
+func (c *WalletClient) sessionByTopic(topic string) (*Session, topicKind) {
+	if session := c.sessionManager.GetSessionByPairingTopic(topic); session != nil {
+		return session, topicKindPairing
+	}
+	if session := c.sessionManager.GetSessionBySessionTopic(topic); session != nil {
+		return session, topicKindSession
+	}
+	return nil, ""
+}
+
+// DisconnectSession disconnects a session. An active session's wallet is
+// first told the session is ending with a wc_sessionDelete request carrying
+// the reason; failing to notify it does not stop the local cleanup.
+func (c *WalletClient) DisconnectSession(session *Session, reason DisconnectReason) error {
+	c.logger.Infof("Disconnecting session: %s (%s)", session.ID, reason.Message)
+
+	// Tell the wallet before the session topic connection is closed
+	if session.Status == SessionStatusActive || session.Status == SessionStatusReconnecting {
+		if err := c.notifySessionDelete(session, reason); err != nil {
+			c.logger.Warnf("Failed to notify the wallet of session %s: %v", session.ID, err)
+		}
+	}
+
+	// Unsubscribe from the pairing and session topics and disconnect
+	for topic, conn := range c.detachConnections(session.Topics()...) {
+		if err := c.unsubscribeTopic(conn, topic); err != nil {
+			c.logger.Warnf("Failed to unsubscribe session %s from topic %s: %v", session.ID, topic, err)
+		}
+		c.closeConnection(conn, topic, "session disconnected")
+	}
+
+	// Late notifications for these topics are expected
+	c.markTopicsRemoved(session.Topics()...)
+
+	// Update the session status
+	wasActive := session.Status == SessionStatusActive || session.Status == SessionStatusReconnecting
+	c.updateSession(session, (*Session).Disconnect)
+	c.publishWalletEvent(session, WalletEvent{Type: WalletEventSessionDisconnected})
+
+	// Record how long the session lived
+	if wasActive {
+		duration := session.ActiveDuration()
+		c.sessionDurations.Observe(duration.Seconds())
+		c.logger.Infof("Session %s disconnected after being active for %s (pairing took %s)",
+			session.ID, duration.Round(time.Second), session.PairingDuration().Round(time.Millisecond))
+	} else {
+		c.logger.Infof("Session %s disconnected before activation, %s after creation",
+			session.ID, session.DisconnectedAt.Sub(session.CreatedAt).Round(time.Second))
+	}
+
+	return nil
+}
+
+// ReconnectSession forces a fresh relay connection for a session without
+// tearing down the session itself. The existing topic connections are closed
+// and the pairing topic (and session topic, if the session is active) are
+// re-dialed and re-subscribed.
+func (c *WalletClient) ReconnectSession(session *Session) error {
+	c.logger.Infof("Reconnecting session: %s", session.ID)
+	c.emitSessionEvent(session, SessionEventReconnecting)
+
+	topics := []string{session.PairingTopic}
+	if session.Status == SessionStatusActive && !session.SingleTopic() {
+		topics = append(topics, session.SessionTopic)
+	}
+
+	// Close the existing connections
+	for topic, conn := range c.detachConnections(session.Topics()...) {
+		c.closeConnection(conn, topic, "session reconnecting")
+	}
+
+	// Re-dial and re-subscribe
+	for _, topic := range topics {
+		if err := c.connectToTopic(topic); err != nil {
+			c.logger.Errorf("Failed to reconnect session %s to topic %s: %v", session.ID, topic, err)
+			c.emitSessionEvent(session, SessionEventReconnectFailed)
+			return fmt.Errorf("failed to reconnect to topic %s: %w", topic, err)
+		}
+	}
+
+	c.logger.Infof("Reconnected session: %s", session.ID)
+	c.emitSessionEvent(session, SessionEventReconnected)
+
+	return nil
+}
+
+// ResumeSessions re-establishes relay subscriptions for all active sessions,
+// e.g. after sessions were restored on startup. Topics that cannot be reached
+// are retried with exponential backoff until the attempts run out or ctx is done.
+func (c *WalletClient) ResumeSessions(ctx context.Context) error {
+	sessions := c.sessionManager.GetActiveSessions()
+	if len(sessions) == 0 {
+		c.logger.Debug("No active sessions to resume")
+		return nil
+	}
+
+	c.logger.Infof("Resuming %d active sessions", len(sessions))
+
+	// Collect the topics to resubscribe to
+	var pending []string
+	for _, session := range sessions {
+		pending = append(pending, session.Topics()...)
+	}
+
+	backoff := resumeInitialBackoff
+	for attempt := 1; attempt <= resumeMaxAttempts; attempt++ {
+		var failed []string
+		for _, topic := range pending {
+			if err := c.connectToTopic(topic); err != nil {
+				if errors.Is(err, ErrClientClosed) {
+					return err
+				}
+				c.logger.Warnf("Failed to resume topic %s (attempt %d/%d): %v",
+					topic, attempt, resumeMaxAttempts, err)
+				failed = append(failed, topic)
+			}
+		}
+
+		if len(failed) == 0 {
+			c.logger.Infof("Resumed %d active sessions", len(sessions))
+			return nil
+		}
+		pending = failed
+
+		if attempt == resumeMaxAttempts {
+			break
+		}
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return fmt.Errorf("resuming sessions: %w", ctx.Err())
+		}
+	}
+
+	return fmt.Errorf("failed to resume %d topics after %d attempts", len(pending), resumeMaxAttempts)
+}
+
+// handleReceipt handles a delivery receipt for a message published to a session topic
+func (c *WalletClient) handleReceipt(topic string, messageID string, delivered int) {
+	session := c.sessionManager.GetSessionBySessionTopic(topic)
+	if session == nil {
+		c.logger.Debugf("Ignoring receipt for message %s on unknown topic %s", messageID, topic)
+		return
+	}
+
+	if delivered == 0 {
+		c.logger.Warnf("Message %s for session %s was not delivered to any subscriber", messageID, session.ID)
+		c.emitSessionEvent(session, SessionEventUndelivered)
+		return
+	}
+
+	c.logger.Infof("Message %s for session %s delivered to %d subscribers", messageID, session.ID, delivered)
+	c.emitSessionEvent(session, SessionEventDelivered)
+}
+
+// SetClockSkewTolerance sets how long past their expiry sessions are still considered valid
+func (c *WalletClient) SetClockSkewTolerance(tolerance time.Duration) {
+	c.sessionManager.SetClockSkewTolerance(tolerance)
+}
+
+// SetSessionStore makes the client persist sessions in the given store and
+// loads the sessions it already contains. Active sessions among them are
+// re-subscribed by ResumeSessions.
+func (c *WalletClient) SetSessionStore(store SessionStore) error {
+	if err := c.sessionManager.SetStore(store); err != nil {
+		return err
+	}
+	c.logger.Infof("Loaded %d active sessions from the session store", len(c.sessionManager.GetActiveSessions()))
+	return nil
+}
+
+// saveSession persists changes made to a session, logging any failure
+func (c *WalletClient) saveSession(session *Session) {
+	if err := c.sessionManager.SaveSession(session); err != nil {
+		c.logger.Errorf("Failed to save session %s: %v", session.ID, err)
+	}
+}
+
+// updateSession changes a session's status or expiry through the session
+// manager and persists it, logging any failure. Changes to fields the manager
+// reads must go through here rather than saveSession.
+func (c *WalletClient) updateSession(session *Session, update func(*Session)) {
+	if err := c.sessionManager.UpdateSession(session, update); err != nil {
+		c.logger.Errorf("Failed to update session %s: %v", session.ID, err)
+	}
+}
+
+// SetSessionTTL sets how long new sessions live before they expire
+func (c *WalletClient) SetSessionTTL(ttl time.Duration) {
+	c.sessionManager.SetSessionTTL(ttl)
+}
+
+// ExtendSession pushes a session's expiry d further into the future
+func (c *WalletClient) ExtendSession(session *Session, d time.Duration) {
+	c.updateSession(session, func(s *Session) { s.Extend(d) })
+	c.logger.Infof("Extended session %s until %s", session.ID, session.ExpiresAt.Format(time.RFC3339))
+}
+
+// SetSingleTopicMode makes new sessions use one topic for both the pairing and session phases
+func (c *WalletClient) SetSingleTopicMode(enabled bool) {
+	c.sessionManager.SetSingleTopicMode(enabled)
+}
+
+// SetDemoWallet makes the client pair and sign with an in-process demo wallet
+// instead of an external wallet. Passing nil disables demo mode.
+func (c *WalletClient) SetDemoWallet(demo *DemoWallet) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.demoWallet = demo
+}
+
+// DemoWallet returns the demo wallet, or nil if demo mode is disabled
+func (c *WalletClient) DemoWallet() *DemoWallet {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.demoWallet
+}
+
+// OnSessionEvent registers a handler that is called for session lifecycle events
+func (c *WalletClient) OnSessionEvent(handler SessionEventHandler) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.eventHandlers = append(c.eventHandlers, handler)
+}
+
+// emitSessionEvent notifies all registered handlers of a session event
+func (c *WalletClient) emitSessionEvent(session *Session, event SessionEvent) {
+	c.mutex.RLock()
+	handlers := make([]SessionEventHandler, len(c.eventHandlers))
+	copy(handlers, c.eventHandlers)
+	c.mutex.RUnlock()
+
+	c.logger.Debugf("Session %s event: %s", session.ID, event)
+	for _, handler := range handlers {
+		handler(session, event)
+	}
+}
+
+// ActivateSession marks a session as active and records how long pairing took
+func (c *WalletClient) ActivateSession(session *Session) {
+	c.updateSession(session, (*Session).Activate)
+	c.publishWalletEvent(session, WalletEvent{Type: WalletEventSessionActivated})
+
+	duration := session.PairingDuration()
+	c.pairingDurations.Observe(duration.Seconds())
+	c.logger.Infof("Session %s activated, pairing took %s", session.ID, duration.Round(time.Millisecond))
+}
+
+// WaitForActivation blocks until the session is activated by the wallet's
+// settlement, and returns it. It fails when the session is disconnected or
+// expires first, or when ctx is done. An already active session is returned
+// immediately.
+func (c *WalletClient) WaitForActivation(ctx context.Context, sessionID string) (*Session, error) {
+	// Subscribe before checking the status so the activation cannot be missed
+	events, unsubscribe := c.Subscribe(sessionID)
+	defer unsubscribe()
+
+	session := c.GetSession(sessionID)
+	if session == nil {
+		return nil, ErrSessionNotFound
+	}
+
+	switch {
+	case session.Status == SessionStatusActive:
+		return session, nil
+	case session.Status == SessionStatusDisconnected:
+		return nil, ErrSessionDisconnected
+	case session.IsExpired():
+		return nil, ErrSessionExpired
+	}
+
+	expiry := time.NewTimer(time.Until(session.ExpiresAt.Add(session.skewTolerance)))
+	defer expiry.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return nil, ErrClientClosed
+			}
+			switch event.Type {
+			case WalletEventSessionActivated:
+				return session, nil
+			case WalletEventSessionDisconnected:
+				return nil, ErrSessionDisconnected
+			}
+		case <-expiry.C:
+			return nil, ErrSessionExpired
+		case <-ctx.Done():
+			return nil, fmt.Errorf("session %s was not activated: %w", sessionID, ctx.Err())
+		}
+	}
+}
+
+// GetLifecycleMetrics returns the session lifecycle duration histograms
+func (c *WalletClient) GetLifecycleMetrics() LifecycleMetrics {
+	return LifecycleMetrics{
+		PairingDurationSeconds: c.pairingDurations.Snapshot(),
+		SessionDurationSeconds: c.sessionDurations.Snapshot(),
+	}
+}
+
+// GetSessionCountsByStatus returns the number of sessions in each status
+func (c *WalletClient) GetSessionCountsByStatus() map[string]int {
+	return c.sessionManager.CountByStatus()
+}
+
+// CleanupExpiredSessions removes expired sessions, closes their relay
+// connections and returns how many were removed. Subscribers of removed
+// sessions get a session_disconnected event, and event handlers a removed event.
+func (c *WalletClient) CleanupExpiredSessions() int {
+	removed, err := c.sessionManager.CleanupExpiredSessions()
+	if err != nil {
+		c.logger.Errorf("Failed to remove expired sessions from the session store: %v", err)
+	}
+	for _, session := range removed {
+		for topic, conn := range c.detachConnections(session.Topics()...) {
+			c.closeConnection(conn, topic, "session expired")
+		}
+		c.markTopicsRemoved(session.Topics()...)
+		c.messageLog.remove(session.ID)
+		c.publishWalletEvent(session, WalletEvent{Type: WalletEventSessionDisconnected})
+		c.emitSessionEvent(session, SessionEventRemoved)
+	}
+	return len(removed)
 }
 
 // SetWalletAddress sets the wallet address for a session
 func (c *WalletClient) SetWalletAddress(session *Session, address common.Address) {
 	session.SetWalletAddress(address)
+	c.saveSession(session)
 }
 
 // GetWalletAddress gets the wallet address for a session
@@ -459,12 +2004,101 @@ func (c *WalletClient) GetSignatureDetails(message, signature string) (map[strin
 	return GetSignatureDetails(message, signature)
 }
 
-// StartCleanupTask starts a task to periodically clean up expired sessions
-func (c *WalletClient) StartCleanupTask() {
-	ticker := time.NewTicker(1 * time.Hour)
+// recordSigner recovers the signer of a personal_sign signature. A session
+// without a wallet address takes the signer as its address; a session whose
+// address differs from the signer gets a warning, since the wallet may have
+// signed with another key.
+func (c *WalletClient) recordSigner(session *Session, message, signature string) {
+	details, err := GetSignatureDetails(message, signature)
+	if err != nil {
+		c.logger.Warnf("Failed to recover signer for session %s: %v", session.ID, err)
+		return
+	}
+
+	signer := common.HexToAddress(details["recovered_address"])
+	switch session.WalletAddress {
+	case common.Address{}:
+		c.logger.Infof("Session %s wallet address recovered from signature: %s", session.ID, signer.Hex())
+		c.SetWalletAddress(session, signer)
+	case signer:
+	default:
+		c.logger.Warnf("Signature for session %s recovers to %s, not the session's wallet address %s; possible key mismatch",
+			session.ID, signer.Hex(), session.WalletAddress.Hex())
+	}
+}
+
+// GetBatchSignatureDetails gets the details of each signature in a batch
+func (c *WalletClient) GetBatchSignatureDetails(items []SignatureItem) ([]map[string]string, error) {
+	return GetBatchSignatureDetails(items)
+}
+
+// StartCleanupTask starts a task that cleans up expired sessions right away
+// and then once per interval, until Close is called. An interval of zero or
+// less disables the automatic cleanup.
+func (c *WalletClient) StartCleanupTask(interval time.Duration) {
+	if interval <= 0 {
+		c.logger.Info("Automatic session cleanup is disabled")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
 	go func() {
-		for range ticker.C {
-			c.CleanupExpiredSessions()
+		defer ticker.Stop()
+		for {
+			removed := c.CleanupExpiredSessions()
+			c.logger.Infof("Session cleanup removed %d expired sessions, next run in %s", removed, interval)
+
+			select {
+			case <-ticker.C:
+			case <-c.done:
+				return
+			}
 		}
 	}()
 }
+
+// Close unsubscribes from all topics, closes all relay connections and stops
+// background tasks. It waits for the message listeners to exit or ctx to be done.
+func (c *WalletClient) Close(ctx context.Context) error {
+	c.logger.Info("Closing wallet client")
+
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+
+	connections := c.connections
+	c.connections = make(map[string]*websocket.Conn)
+	c.topicRelays = make(map[string]string)
+	c.mutex.Unlock()
+
+	// Unsubscribe and close each connection
+	for topic, conn := range connections {
+		if err := c.sendUnsubscribe(conn, topic); err != nil {
+			c.logger.Warnf("Failed to unsubscribe from topic %s: %v", topic, err)
+		}
+
+		c.closeConnection(conn, topic, "wallet client closing")
+	}
+
+	// End event subscriptions
+	c.closeEventSubscriptions()
+
+	// Wait for the listeners to exit
+	finished := make(chan struct{})
+	go func() {
+		c.listenerWg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		c.logger.Infof("Wallet client closed %d connections", len(connections))
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("wallet client close: %w", ctx.Err())
+	}
+}
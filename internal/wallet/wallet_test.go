@@ -0,0 +1,1031 @@
+package wallet
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/korjavin/wctestapp/internal/logger"
+	"github.com/korjavin/wctestapp/internal/relay"
+	"github.com/korjavin/wctestapp/pkg/utils"
+)
+
+// testTimeout bounds every wait in these tests
+const testTimeout = 5 * time.Second
+
+func newTestLogger() *logger.Logger {
+	return logger.NewLogger(logger.ErrorLevel, "test", logger.TextFormat)
+}
+
+// startTestRelay starts a relay server behind a test HTTP server and returns
+// it with its WebSocket URL. configure, if not nil, is called before Start.
+// The relay is shut down when the test ends.
+func startTestRelay(t *testing.T, configure func(*relay.RelayServer)) (*relay.RelayServer, string) {
+	t.Helper()
+
+	s := relay.NewRelayServer(newTestLogger())
+	if configure != nil {
+		configure(s)
+	}
+	s.Start()
+
+	server := httptest.NewServer(http.HandlerFunc(s.HandleWebSocket))
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			t.Errorf("relay shutdown: %v", err)
+		}
+		server.Close()
+	})
+
+	return s, "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// unreachableRelayURL returns the URL of a relay that refuses connections
+func unreachableRelayURL(t *testing.T) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// newTestClient creates a wallet client for the given relays that is closed when the test ends
+func newTestClient(t *testing.T, relayURLs ...string) *WalletClient {
+	t.Helper()
+
+	c := NewWalletClient(relayURLs, newTestLogger())
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+		if err := c.Close(ctx); err != nil {
+			t.Errorf("wallet client close: %v", err)
+		}
+	})
+	return c
+}
+
+// newActiveSession creates a session, activates it as if the wallet had
+// settled it, and connects both of its topics
+func newActiveSession(t *testing.T, c *WalletClient) *Session {
+	t.Helper()
+
+	session, err := c.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ConnectToRelay(session); err != nil {
+		t.Fatal(err)
+	}
+	c.ActivateSession(session)
+	if err := c.connectToTopic(session.SessionTopic); err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+// connection returns the client's connection for a topic, or nil
+func (c *WalletClient) connection(topic string) *websocket.Conn {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.connections[topic]
+}
+
+// recordReconnectEvents records the reconnect events emitted by a client,
+// leaving out delivery receipts, which arrive at any time
+func recordReconnectEvents(c *WalletClient) func() []SessionEvent {
+	var events []SessionEvent
+	var mutex sync.Mutex
+	c.OnSessionEvent(func(_ *Session, event SessionEvent) {
+		if event == SessionEventDelivered || event == SessionEventUndelivered {
+			return
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		events = append(events, event)
+	})
+
+	return func() []SessionEvent {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]SessionEvent(nil), events...)
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after testTimeout
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// testPeer plays the wallet app: it publishes encrypted requests to a
+// session's topics and reads the client's encrypted responses
+type testPeer struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+// dialTestPeer connects a peer to the relay
+func dialTestPeer(t *testing.T, url string) *testPeer {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial relay: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testPeer{t: t, conn: conn}
+}
+
+// send writes a JSON-RPC request to the relay
+func (p *testPeer) send(method string, params any) {
+	p.t.Helper()
+
+	if err := p.conn.WriteJSON(relay.NewJSONRPCRequest(relay.NumberID(1), method, params)); err != nil {
+		p.t.Fatalf("send %s: %v", method, err)
+	}
+}
+
+// subscribe subscribes the peer to a topic and waits for the relay to confirm
+// it, which makes sure later publishes reach the peer. It expects no other
+// responses to be outstanding.
+func (p *testPeer) subscribe(topic string) {
+	p.t.Helper()
+
+	p.send("subscribe", relay.SubscribeParams{Topic: topic})
+
+	if err := p.conn.SetReadDeadline(time.Now().Add(testTimeout)); err != nil {
+		p.t.Fatal(err)
+	}
+	var response relay.JSONRPCResponse
+	if err := p.conn.ReadJSON(&response); err != nil {
+		p.t.Fatalf("subscribe %s: %v", topic, err)
+	}
+	if response.Error != nil {
+		p.t.Fatalf("subscribe %s: %s", topic, response.Error.Message)
+	}
+}
+
+// publish encrypts a message with the session's key and publishes it on a topic
+func (p *testPeer) publish(session *Session, topic string, message any) {
+	p.t.Helper()
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	encrypted, err := utils.EncryptWithChaCha20(data, session.SymKey)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	p.send("publish", relay.PublishParams{Topic: topic, Message: encrypted, TTL: 300})
+}
+
+// ping sends a wc_sessionPing on the session topic and waits for the client to answer it
+func (p *testPeer) ping(session *Session, id int) {
+	p.t.Helper()
+
+	p.publish(session, session.SessionTopic, map[string]any{
+		"id":      id,
+		"jsonrpc": "2.0",
+		"method":  "wc_sessionPing",
+		"params":  map[string]any{},
+	})
+	p.expectResponse(session, id)
+}
+
+// expectResponse reads notifications until one carries the client's
+// response to the request with the given ID
+func (p *testPeer) expectResponse(session *Session, id int) json.RawMessage {
+	p.t.Helper()
+
+	return p.expect(session, func(m IncomingMessage) bool {
+		return m.Kind == IncomingResponse && m.ID == id
+	}).Result
+}
+
+// expectRequest reads notifications until one carries a request from the client with the given method
+func (p *testPeer) expectRequest(session *Session, method string) IncomingMessage {
+	p.t.Helper()
+
+	return p.expect(session, func(m IncomingMessage) bool {
+		return m.Kind == IncomingRequest && m.Method == method
+	})
+}
+
+// expect reads notifications until one carries a message that matches, and returns it
+func (p *testPeer) expect(session *Session, match func(IncomingMessage) bool) IncomingMessage {
+	p.t.Helper()
+
+	if err := p.conn.SetReadDeadline(time.Now().Add(testTimeout)); err != nil {
+		p.t.Fatal(err)
+	}
+	for {
+		var frame struct {
+			Method string                   `json:"method"`
+			Params relay.SubscriptionParams `json:"params"`
+		}
+		if err := p.conn.ReadJSON(&frame); err != nil {
+			p.t.Fatalf("waiting for a message: %v", err)
+		}
+		if frame.Method != "irn_subscription" {
+			continue
+		}
+
+		decrypted, err := utils.DecryptWithChaCha20(frame.Params.Data.Message, session.SymKey)
+		if err != nil {
+			p.t.Fatalf("decrypt: %v", err)
+		}
+		incoming, err := ParseIncoming(decrypted)
+		if err != nil {
+			p.t.Fatal(err)
+		}
+		if match(incoming) {
+			return incoming
+		}
+	}
+}
+
+func TestReconnectSessionRestoresMessageFlow(t *testing.T) {
+	_, url := startTestRelay(t, nil)
+	c := newTestClient(t, url)
+	events := recordReconnectEvents(c)
+	session := newActiveSession(t, c)
+
+	peer := dialTestPeer(t, url)
+	peer.subscribe(session.SessionTopic)
+	peer.ping(session, 1)
+
+	before := c.connection(session.SessionTopic)
+	if err := c.ReconnectSession(session); err != nil {
+		t.Fatal(err)
+	}
+
+	// The topics are on fresh connections and messages flow again
+	for _, topic := range session.Topics() {
+		if conn := c.connection(topic); conn == nil || conn == before {
+			t.Errorf("topic %s was not re-dialed", topic)
+		}
+	}
+	peer.ping(session, 2)
+
+	if session.Status != SessionStatusActive || c.GetSession(session.ID) != session {
+		t.Errorf("session is %s after reconnecting, want it kept active", session.Status)
+	}
+	if got := events(); len(got) != 2 || got[0] != SessionEventReconnecting || got[1] != SessionEventReconnected {
+		t.Errorf("got events %v, want reconnecting then reconnected", got)
+	}
+}
+
+func TestReconnectSessionFailure(t *testing.T) {
+	_, url := startTestRelay(t, nil)
+	c := newTestClient(t, url)
+	events := recordReconnectEvents(c)
+	session := newActiveSession(t, c)
+
+	c.SetRelays([]string{unreachableRelayURL(t)})
+	if err := c.ReconnectSession(session); err == nil {
+		t.Fatal("reconnected to an unreachable relay")
+	}
+	if got := events(); len(got) != 2 || got[1] != SessionEventReconnectFailed {
+		t.Errorf("got events %v, want reconnecting then reconnect_failed", got)
+	}
+}
+
+func TestRecentlyRemovedTopics(t *testing.T) {
+	c := NewWalletClient(nil, newTestLogger())
+
+	c.markTopicsRemoved("removed")
+	if !c.isRecentlyRemovedTopic("removed") || c.isRecentlyRemovedTopic("unknown") {
+		t.Error("only the removed topic should be recently removed")
+	}
+
+	// Notifications for a recently removed topic are not counted as unknown
+	c.handleUnknownTopic(nil, "removed")
+	c.handleUnknownTopic(nil, "unknown")
+	if c.unknownTopicCounts["removed"] != 0 || c.unknownTopicCounts["unknown"] != 1 {
+		t.Errorf("got unknown topic counts %v", c.unknownTopicCounts)
+	}
+
+	// Removals age out of the window
+	c.removedTopics["removed"] = time.Now().Add(-recentlyRemovedTopicWindow - time.Second)
+	if c.isRecentlyRemovedTopic("removed") {
+		t.Error("removal outside the window still counts")
+	}
+	if _, ok := c.removedTopics["removed"]; ok {
+		t.Error("aged-out removal was not pruned")
+	}
+}
+
+func TestDisconnectedSessionTopicsAreRecentlyRemoved(t *testing.T) {
+	_, url := startTestRelay(t, nil)
+	c := newTestClient(t, url)
+	session := newActiveSession(t, c)
+
+	if err := c.DisconnectSession(session, ReasonUserDisconnected); err != nil {
+		t.Fatal(err)
+	}
+	for _, topic := range session.Topics() {
+		if !c.isRecentlyRemovedTopic(topic) {
+			t.Errorf("topic %s of the disconnected session is not recently removed", topic)
+		}
+	}
+}
+
+func TestPersistentlyUnknownTopicIsUnsubscribed(t *testing.T) {
+	s, url := startTestRelay(t, nil)
+	c := newTestClient(t, url)
+
+	// A topic whose session is gone keeps receiving notifications
+	if err := c.connectToTopic("stray"); err != nil {
+		t.Fatal(err)
+	}
+	peer := dialTestPeer(t, url)
+	for i := range unknownTopicUnsubscribeThreshold {
+		if s.GetTopicSubscriptionCounts()["stray"] != 1 {
+			t.Fatalf("unsubscribed after %d notifications, want %d", i, unknownTopicUnsubscribeThreshold)
+		}
+		peer.send("publish", relay.PublishParams{Topic: "stray", Message: "stray message", TTL: 300})
+	}
+
+	waitFor(t, "the client to unsubscribe", func() bool { return s.GetTopicSubscriptionCounts()["stray"] == 0 })
+}
+
+func TestIsSignMethodAllowed(t *testing.T) {
+	c := NewWalletClient(nil, newTestLogger())
+	if !c.IsSignMethodAllowed("eth_sign") {
+		t.Error("an empty allowlist should allow every method")
+	}
+
+	c.SetAllowedSignMethods([]string{"personal_sign", "eth_signTypedData_v4"})
+	for method, want := range map[string]bool{
+		"personal_sign":        true,
+		"eth_signTypedData_v4": true,
+		"eth_sign":             false,
+		"eth_sendTransaction":  false,
+	} {
+		if got := c.IsSignMethodAllowed(method); got != want {
+			t.Errorf("%s: got allowed %t, want %t", method, got, want)
+		}
+	}
+
+	c.SetAllowedSignMethods(nil)
+	if !c.IsSignMethodAllowed("eth_sign") {
+		t.Error("clearing the allowlist should allow every method")
+	}
+}
+
+func TestDisallowedSignMethodIsNotSent(t *testing.T) {
+	_, url := startTestRelay(t, nil)
+	c := newTestClient(t, url)
+	session := newActiveSession(t, c)
+	c.SetWalletAddress(session, testAddress)
+
+	peer := dialTestPeer(t, url)
+	peer.subscribe(session.SessionTopic)
+
+	// A blocked method fails before anything is published
+	c.SetAllowedSignMethods([]string{"eth_signTypedData_v4"})
+	if _, err := c.SignMessage(context.Background(), session, "blocked"); !errors.Is(err, ErrSignMethodNotAllowed) {
+		t.Fatalf("got %v, want ErrSignMethodNotAllowed", err)
+	}
+	c.pendingMutex.Lock()
+	pending := len(c.pendingRequests)
+	c.pendingMutex.Unlock()
+	if pending != 0 {
+		t.Errorf("%d requests still pending after the refusal", pending)
+	}
+
+	// An allowed method reaches the wallet, and the blocked one never did
+	c.SetAllowedSignMethods([]string{"personal_sign"})
+	type result struct {
+		signature string
+		err       error
+	}
+	results := make(chan result, 1)
+	go func() {
+		signature, err := c.SignMessage(context.Background(), session, "allowed")
+		results <- result{signature, err}
+	}()
+
+	request := peer.expectRequest(session, "personal_sign")
+	var params []string
+	if err := json.Unmarshal(request.Params, &params); err != nil {
+		t.Fatal(err)
+	}
+	if len(params) != 2 || params[0] != "allowed" {
+		t.Fatalf("wallet got params %v, want the allowed message first", params)
+	}
+
+	demo, err := NewDemoWallet(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := demo.SignMessage("allowed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer.publish(session, session.SessionTopic, map[string]any{"id": request.ID, "jsonrpc": "2.0", "result": signature})
+
+	select {
+	case r := <-results:
+		if r.err != nil || r.signature != signature {
+			t.Errorf("got %q, %v, want the wallet's signature", r.signature, r.err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the signature")
+	}
+}
+
+func TestResumedSessionReceivesMessages(t *testing.T) {
+	_, url := startTestRelay(t, nil)
+	path := filepath.Join(t.TempDir(), "sessions.json")
+
+	// The first client persists an active session and shuts down
+	before := NewWalletClient([]string{url}, newTestLogger())
+	if err := before.SetSessionStore(NewFileSessionStore(path)); err != nil {
+		t.Fatal(err)
+	}
+	session := newActiveSession(t, before)
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if err := before.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The restarted client loads it and resumes listening on its topics
+	c := newTestClient(t, url)
+	if err := c.SetSessionStore(NewFileSessionStore(path)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ResumeSessions(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, topic := range session.Topics() {
+		if c.connection(topic) == nil {
+			t.Errorf("topic %s was not resumed", topic)
+		}
+	}
+
+	resumed := c.GetSession(session.ID)
+	if resumed == nil || resumed.SymKey != session.SymKey {
+		t.Fatalf("got session %+v, want the persisted session", resumed)
+	}
+	peer := dialTestPeer(t, url)
+	peer.subscribe(session.SessionTopic)
+	peer.ping(resumed, 1)
+}
+
+func TestResumeSessionsRetriesUntilTheRelayIsReachable(t *testing.T) {
+	_, url := startTestRelay(t, nil)
+	c := newTestClient(t, unreachableRelayURL(t))
+	session, err := c.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ActivateSession(session)
+
+	// Give up when the context ends before the relay is back
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.ResumeSessions(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want the context's error", err)
+	}
+
+	// Succeed on a retry once the relay is back
+	resumed := make(chan error, 1)
+	go func() { resumed <- c.ResumeSessions(context.Background()) }()
+	// The first attempt fails at once, well within the backoff before the retry
+	time.Sleep(resumeInitialBackoff / 10)
+	c.SetRelays([]string{url})
+
+	select {
+	case err := <-resumed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the sessions to resume")
+	}
+	for _, topic := range session.Topics() {
+		if c.connection(topic) == nil {
+			t.Errorf("topic %s was not resumed", topic)
+		}
+	}
+}
+
+func TestResumeSessionsWithoutActiveSessions(t *testing.T) {
+	c := newTestClient(t, unreachableRelayURL(t))
+	if _, err := c.CreateSession(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pending sessions are not resumed, so the relay is never dialed
+	if err := c.ResumeSessions(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIsSecureRelayURL(t *testing.T) {
+	for url, want := range map[string]bool{
+		"wss://relay.walletconnect.com": true,
+		"WSS://relay.example.com/ws":    true,
+		"ws://localhost:8080/ws":        false,
+		"https://relay.example.com":     false,
+		"":                              false,
+	} {
+		if got := IsSecureRelayURL(url); got != want {
+			t.Errorf("%q: got %t, want %t", url, got, want)
+		}
+	}
+}
+
+func TestRequireSecureRelayRefusesPlaintextRelays(t *testing.T) {
+	var dials atomic.Int32
+	plaintext := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dials.Add(1)
+		http.NotFound(w, r)
+	}))
+	defer plaintext.Close()
+	plaintextURL := "ws" + strings.TrimPrefix(plaintext.URL, "http")
+
+	s := relay.NewRelayServer(newTestLogger())
+	s.Start()
+	secure := httptest.NewTLSServer(http.HandlerFunc(s.HandleWebSocket))
+	t.Cleanup(func() {
+		if err := s.Shutdown(context.Background()); err != nil {
+			t.Errorf("relay shutdown: %v", err)
+		}
+		secure.Close()
+	})
+	secureURL := "wss" + strings.TrimPrefix(secure.URL, "https")
+	roots := x509.NewCertPool()
+	roots.AddCert(secure.Certificate())
+
+	c := newTestClient(t, plaintextURL)
+	c.SetRequireSecureRelay(true)
+
+	session, err := c.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ConnectToRelay(session); !errors.Is(err, ErrInsecureRelay) {
+		t.Fatalf("got %v, want ErrInsecureRelay", err)
+	}
+	if n := dials.Load(); n != 0 {
+		t.Errorf("dialed the plaintext relay %d times", n)
+	}
+
+	// A secure relay is used instead of a plaintext one
+	c.SetTLSConfig(&tls.Config{RootCAs: roots})
+	c.SetRelays([]string{plaintextURL, secureURL})
+
+	if err := c.ConnectToRelay(session); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.SessionRelay(session); got != secureURL {
+		t.Errorf("connected through %s, want %s", got, secureURL)
+	}
+	if n := dials.Load(); n != 0 {
+		t.Errorf("dialed the plaintext relay %d times", n)
+	}
+}
+
+// startClosingRelay starts a relay that accepts every request and reports how
+// each connection was closed by the client
+func startClosingRelay(t *testing.T) (string, <-chan *websocket.CloseError) {
+	t.Helper()
+
+	closes := make(chan *websocket.CloseError, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		for {
+			var request relay.JSONRPCRequest
+			if err := conn.ReadJSON(&request); err != nil {
+				closeErr, _ := err.(*websocket.CloseError)
+				closes <- closeErr
+				return
+			}
+			if err := conn.WriteJSON(relay.NewJSONRPCResponse(request.ID, true)); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http"), closes
+}
+
+func TestRelaySeesNormalClosures(t *testing.T) {
+	url, closes := startClosingRelay(t)
+	c := NewWalletClient([]string{url}, newTestLogger())
+
+	expectClose := func(reason string) {
+		t.Helper()
+		select {
+		case closeErr := <-closes:
+			if closeErr == nil || closeErr.Code != websocket.CloseNormalClosure || closeErr.Text != reason {
+				t.Errorf("relay saw %v, want a normal closure with reason %q", closeErr, reason)
+			}
+		case <-time.After(testTimeout):
+			t.Fatalf("timed out waiting for the %q closure", reason)
+		}
+	}
+
+	session, err := c.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ConnectToRelay(session); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ReconnectSession(session); err != nil {
+		t.Fatal(err)
+	}
+	expectClose("session reconnecting")
+
+	if err := c.DisconnectSession(session, ReasonUserDisconnected); err != nil {
+		t.Fatal(err)
+	}
+	expectClose("session disconnected")
+
+	session, err = c.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ConnectToRelay(session); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if err := c.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expectClose("wallet client closing")
+}
+
+// sessionStatus reads a session's status under the session manager's lock, which guards updates to it
+func sessionStatus(c *WalletClient, session *Session) SessionStatus {
+	c.sessionManager.mutex.RLock()
+	defer c.sessionManager.mutex.RUnlock()
+
+	return session.Status
+}
+
+// dropConnection closes the client's connection for a topic as if the network had dropped it
+func dropConnection(t *testing.T, c *WalletClient, topic string) {
+	t.Helper()
+
+	conn := c.connection(topic)
+	if conn == nil {
+		t.Fatalf("no connection for topic %s", topic)
+	}
+	conn.Close()
+}
+
+func TestSessionRecoveringWithinTheGracePeriodIsKept(t *testing.T) {
+	_, url := startTestRelay(t, nil)
+	c := newTestClient(t, url)
+	c.SetDisconnectGracePeriod(testTimeout)
+	c.SetReconnectPolicy(ReconnectPolicy{InitialDelay: 10 * time.Millisecond})
+	events := recordReconnectEvents(c)
+	session := newActiveSession(t, c)
+
+	dropConnection(t, c, session.SessionTopic)
+	waitFor(t, "the session to recover", func() bool {
+		got := events()
+		return len(got) == 2 && got[1] == SessionEventReconnected
+	})
+
+	if got := events(); got[0] != SessionEventReconnecting {
+		t.Errorf("got events %v, want reconnecting then reconnected", got)
+	}
+	if status := sessionStatus(c, session); status != SessionStatusActive {
+		t.Errorf("session is %s after recovering, want active", status)
+	}
+
+	// Messages reach the session on its re-dialed topic
+	peer := dialTestPeer(t, url)
+	peer.subscribe(session.SessionTopic)
+	peer.ping(session, 1)
+}
+
+func TestSessionNotRecoveringWithinTheGracePeriodIsDisconnected(t *testing.T) {
+	_, url := startTestRelay(t, nil)
+	c := newTestClient(t, url)
+	c.SetDisconnectGracePeriod(100 * time.Millisecond)
+	c.SetReconnectPolicy(ReconnectPolicy{InitialDelay: 10 * time.Millisecond})
+	events := recordReconnectEvents(c)
+	session := newActiveSession(t, c)
+
+	// The relay is unreachable for the whole grace period
+	c.SetRelays([]string{unreachableRelayURL(t)})
+	dropConnection(t, c, session.SessionTopic)
+
+	waitFor(t, "the session to be disconnected", func() bool {
+		return sessionStatus(c, session) == SessionStatusDisconnected
+	})
+	if got := events(); len(got) != 2 || got[0] != SessionEventReconnecting || got[1] != SessionEventReconnectFailed {
+		t.Errorf("got events %v, want reconnecting then reconnect_failed", got)
+	}
+}
+
+func TestSessionWithoutGracePeriodIsDisconnectedAtOnce(t *testing.T) {
+	_, url := startTestRelay(t, nil)
+	c := newTestClient(t, url)
+	events := recordReconnectEvents(c)
+	session := newActiveSession(t, c)
+
+	dropConnection(t, c, session.SessionTopic)
+	waitFor(t, "the session to be disconnected", func() bool {
+		return sessionStatus(c, session) == SessionStatusDisconnected
+	})
+	if got := events(); len(got) != 1 || got[0] != SessionEventReconnectFailed {
+		t.Errorf("got events %v, want only reconnect_failed without a grace period", got)
+	}
+}
+
+func TestSingleTopicKind(t *testing.T) {
+	pending, err := NewSingleTopicSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	active, err := NewSingleTopicSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	active.Activate()
+
+	tests := []struct {
+		name     string
+		session  *Session
+		incoming IncomingMessage
+		want     topicKind
+	}{
+		{name: "pairing request", session: active, incoming: IncomingMessage{Kind: IncomingRequest, Method: "wc_pairingPing"}, want: topicKindPairing},
+		{name: "session request", session: pending, incoming: IncomingMessage{Kind: IncomingRequest, Method: "wc_sessionSettle"}, want: topicKindSession},
+		{name: "response before activation", session: pending, incoming: IncomingMessage{Kind: IncomingResponse}, want: topicKindPairing},
+		{name: "response after activation", session: active, incoming: IncomingMessage{Kind: IncomingResponse}, want: topicKindSession},
+		{name: "error after activation", session: active, incoming: IncomingMessage{Kind: IncomingError}, want: topicKindSession},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := singleTopicKind(tt.session, tt.incoming); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSingleTopicSessionMessageFlow(t *testing.T) {
+	_, url := startTestRelay(t, nil)
+	c := newTestClient(t, url)
+	c.SetSingleTopicMode(true)
+	session := newActiveSession(t, c)
+
+	if c.GetSessionByTopic(session.PairingTopic) != session {
+		t.Fatal("session not found by its topic")
+	}
+
+	// Session requests on the shared topic are answered as session messages
+	peer := dialTestPeer(t, url)
+	peer.subscribe(session.PairingTopic)
+	peer.ping(session, 1)
+
+	// Reconnecting re-dials the one topic
+	if err := c.ReconnectSession(session); err != nil {
+		t.Fatal(err)
+	}
+	c.mutex.RLock()
+	connections := len(c.connections)
+	c.mutex.RUnlock()
+	if connections != 1 {
+		t.Errorf("got %d connections, want one for the shared topic", connections)
+	}
+	peer.ping(session, 2)
+}
+
+func TestConnectionsClosedAtMaxAgeAreRotated(t *testing.T) {
+	_, url := startTestRelay(t, func(s *relay.RelayServer) { s.SetMaxConnectionAge(100 * time.Millisecond) })
+	c := newTestClient(t, url)
+	events := recordReconnectEvents(c)
+	session := newActiveSession(t, c)
+
+	first := c.connection(session.SessionTopic)
+	waitFor(t, "the connection to be rotated", func() bool {
+		conn := c.connection(session.SessionTopic)
+		return conn != nil && conn != first
+	})
+
+	// Rotation is not a lost connection, so the session stays active throughout
+	if status := sessionStatus(c, session); status != SessionStatusActive {
+		t.Errorf("session is %s after rotation, want active", status)
+	}
+	if got := events(); len(got) != 0 {
+		t.Errorf("got events %v, want none for a rotation", got)
+	}
+}
+
+func TestCleanupTaskRemovesExpiredSessions(t *testing.T) {
+	c := newTestClient(t)
+	c.SetSessionTTL(time.Millisecond)
+	if _, err := c.CreateSession(); err != nil {
+		t.Fatal(err)
+	}
+	c.SetSessionTTL(time.Hour)
+	live, err := c.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.StartCleanupTask(10 * time.Millisecond)
+	waitFor(t, "the expired session to be removed", func() bool {
+		return len(c.GetAllSessions()) == 1
+	})
+	if c.GetSession(live.ID) != live {
+		t.Error("the live session was removed")
+	}
+
+	// Sessions that expire later are removed by later runs
+	c.SetSessionTTL(time.Millisecond)
+	if _, err := c.CreateSession(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the next expired session to be removed", func() bool {
+		return len(c.GetAllSessions()) == 1
+	})
+}
+
+func TestCleanupTaskDisabled(t *testing.T) {
+	c := newTestClient(t)
+	c.SetSessionTTL(time.Millisecond)
+	if _, err := c.CreateSession(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	c.StartCleanupTask(0)
+	time.Sleep(50 * time.Millisecond)
+	if n := len(c.GetAllSessions()); n != 1 {
+		t.Errorf("got %d sessions, want the expired session kept with the cleanup disabled", n)
+	}
+}
+
+func TestCleanupClosesExpiredSessionConnections(t *testing.T) {
+	server, url := startTestRelay(t, nil)
+	c := newTestClient(t, url)
+	expired := newActiveSession(t, c)
+	live := newActiveSession(t, c)
+
+	c.updateSession(expired, func(s *Session) { s.ExpiresAt = time.Now().Add(-time.Hour) })
+	if removed := c.CleanupExpiredSessions(); removed != 1 {
+		t.Fatalf("cleanup removed %d sessions, want 1", removed)
+	}
+
+	for _, topic := range expired.Topics() {
+		if c.connection(topic) != nil {
+			t.Errorf("expired session topic %s is still connected", topic)
+		}
+	}
+	for _, topic := range live.Topics() {
+		if c.connection(topic) == nil {
+			t.Errorf("live session topic %s was disconnected", topic)
+		}
+	}
+	waitFor(t, "the relay to drop the expired session's connections", func() bool {
+		return len(server.GetClients()) == len(live.Topics())
+	})
+}
+
+func TestDecryptMessageCorrectsDoubleEncodedEnvelopes(t *testing.T) {
+	c := newTestClient(t)
+	session, err := c.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const plaintext = `{"id":1,"jsonrpc":"2.0","method":"wc_sessionPing","params":{}}`
+	chacha, err := utils.EncryptWithChaCha20([]byte(plaintext), session.SymKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := utils.EncryptWithSymmetricKey([]byte(plaintext), session.SymKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, encrypted := range map[string]string{"ChaCha20": chacha, "legacy AES-GCM": legacy} {
+		for _, message := range []string{encrypted, base64.StdEncoding.EncodeToString([]byte(encrypted))} {
+			if decrypted, err := c.decryptMessage(message, session); err != nil || decrypted != plaintext {
+				t.Errorf("%s: got %q, %v, want the plaintext", name, decrypted, err)
+			}
+		}
+	}
+}
+
+// startStalledRelay starts a fake relay that accepts connections and reads the
+// subscribe request but never answers it. It returns the relay's URL, a
+// channel that receives each subscribe request and a function that closes the
+// connections, which is also called when the test ends.
+func startStalledRelay(t *testing.T) (string, <-chan struct{}, func()) {
+	t.Helper()
+
+	subscribed := make(chan struct{}, 10)
+	release := make(chan struct{})
+	var once sync.Once
+	closeConns := func() { once.Do(func() { close(release) }) }
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		subscribed <- struct{}{}
+		<-release
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(closeConns)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http"), subscribed, closeConns
+}
+
+func TestStalledRelayDoesNotBlockTheClient(t *testing.T) {
+	url, subscribed, closeConns := startStalledRelay(t)
+	c := NewWalletClient([]string{url}, newTestLogger())
+
+	connected := make(chan error, 1)
+	go func() { connected <- c.connectToTopic("stalled") }()
+	select {
+	case <-subscribed:
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the subscribe request")
+	}
+
+	// The dial waits for the relay without holding the client's lock
+	done := make(chan error, 1)
+	go func() {
+		c.IsTopicAlive("stalled")
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+		done <- c.Close(ctx)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("wallet client close: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the client blocked while a relay was slow to answer")
+	}
+
+	if err := c.connectToTopic("another"); !errors.Is(err, ErrClientClosed) {
+		t.Errorf("got %v connecting after close, want ErrClientClosed", err)
+	}
+
+	// The stalled dial fails once the relay drops it, and is not kept
+	closeConns()
+	select {
+	case err := <-connected:
+		if err == nil {
+			t.Error("connecting through the stalled relay succeeded")
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the stalled dial")
+	}
+	if conn := c.connection("stalled"); conn != nil {
+		t.Error("the stalled topic is connected")
+	}
+}
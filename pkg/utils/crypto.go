@@ -8,11 +8,18 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
+	"io"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
+	"golang.org/x/crypto/chacha20poly1305"
 )
 
+// RandReader is the source of randomness used by GenerateRandomBytes.
+// It defaults to crypto/rand. Overriding it with a deterministic or otherwise
+// non-cryptographically secure reader is intended for testing only.
+var RandReader io.Reader = rand.Reader
+
 // GenerateKeyPair generates a new ECDSA key pair
 func GenerateKeyPair() (*ecdsa.PrivateKey, *ecdsa.PublicKey, error) {
 	privateKey, err := crypto.GenerateKey()
@@ -72,7 +79,7 @@ func PublicKeyToAddress(publicKey *ecdsa.PublicKey) common.Address {
 // GenerateRandomBytes generates random bytes of the specified length
 func GenerateRandomBytes(length int) ([]byte, error) {
 	bytes := make([]byte, length)
-	_, err := rand.Read(bytes)
+	_, err := io.ReadFull(RandReader, bytes)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate random bytes: %w", err)
 	}
@@ -102,18 +109,19 @@ func EncryptWithSymmetricKey(data []byte, keyStr string) (string, error) {
 		return "", fmt.Errorf("failed to create cipher: %w", err)
 	}
 
-	// Generate a random IV
-	iv, err := GenerateRandomBytes(aes.BlockSize)
-	if err != nil {
-		return "", err
-	}
-
 	// Create the GCM mode
 	gcm, err := cipher.NewGCM(block)
 	if err != nil {
 		return "", fmt.Errorf("failed to create GCM: %w", err)
 	}
 
+	// Generate a random IV of the standard GCM nonce size (12 bytes), never
+	// reusing a recent one, since a repeated nonce breaks GCM's security
+	iv, err := usedNonces.generateNonce(key, gcm.NonceSize())
+	if err != nil {
+		return "", err
+	}
+
 	// Encrypt the data
 	ciphertext := gcm.Seal(nil, iv, data, nil)
 
@@ -124,20 +132,185 @@ func EncryptWithSymmetricKey(data []byte, keyStr string) (string, error) {
 	return base64.StdEncoding.EncodeToString(result), nil
 }
 
-// DecryptWithSymmetricKey decrypts data using a symmetric key
+// WalletConnect envelope types. Type 0 envelopes are sealed with the pairing
+// or session symmetric key; type 1 envelopes additionally carry the sender's
+// 32-byte X25519 public key used to derive that key.
+const (
+	EnvelopeType0 byte = 0
+	EnvelopeType1 byte = 1
+)
+
+// envelopeSenderKeySize is the size of the sender public key in a type 1 envelope
+const envelopeSenderKeySize = 32
+
+// EncryptWithChaCha20 encrypts data with ChaCha20-Poly1305 using a base64
+// symmetric key and returns a base64-encoded WalletConnect type 0 envelope:
+// type byte || 12-byte nonce || sealed box
+func EncryptWithChaCha20(data []byte, keyStr string) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(keyStr)
+	if err != nil {
+		return "", fmt.Errorf("invalid symmetric key: %w", err)
+	}
+
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	nonce, err := GenerateRandomBytes(aead.NonceSize())
+	if err != nil {
+		return "", err
+	}
+
+	envelope := make([]byte, 0, 1+len(nonce)+len(data)+aead.Overhead())
+	envelope = append(envelope, EnvelopeType0)
+	envelope = append(envelope, nonce...)
+	envelope = aead.Seal(envelope, nonce, data, nil)
+
+	return base64.StdEncoding.EncodeToString(envelope), nil
+}
+
+// DecryptWithChaCha20 decrypts a base64-encoded WalletConnect type 0 or type 1
+// envelope with ChaCha20-Poly1305 using a base64 symmetric key.
+// Envelopes that were accidentally base64-encoded twice are corrected transparently.
+func DecryptWithChaCha20(encryptedStr string, keyStr string) ([]byte, error) {
+	plaintext, _, err := DecryptWithChaCha20Lenient(encryptedStr, keyStr)
+	return plaintext, err
+}
+
+// DecryptWithChaCha20Lenient decrypts a WalletConnect envelope like
+// DecryptWithChaCha20. If the initial decryption fails and the decoded
+// envelope is itself valid base64, it is decoded once more and decryption is
+// retried. doubleEncoded reports whether this correction was needed.
+func DecryptWithChaCha20Lenient(encryptedStr string, keyStr string) (plaintext []byte, doubleEncoded bool, err error) {
+	envelope, err := base64.StdEncoding.DecodeString(encryptedStr)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid encrypted data: %w", err)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(keyStr)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid symmetric key: %w", err)
+	}
+
+	plaintext, err = openChaCha20Envelope(envelope, key)
+	if err == nil {
+		return plaintext, false, nil
+	}
+
+	// Some clients base64-encode an already encoded envelope; try one extra layer
+	inner, decodeErr := base64.StdEncoding.DecodeString(string(envelope))
+	if decodeErr != nil {
+		return nil, false, err
+	}
+
+	plaintext, innerErr := openChaCha20Envelope(inner, key)
+	if innerErr != nil {
+		return nil, false, err
+	}
+
+	return plaintext, true, nil
+}
+
+// openChaCha20Envelope decrypts a decoded WalletConnect type 0 or type 1 envelope
+func openChaCha20Envelope(envelope []byte, key []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	if len(envelope) == 0 {
+		return nil, fmt.Errorf("encrypted data too short")
+	}
+
+	// Skip the type byte and, for type 1, the sender public key
+	offset := 1
+	switch envelope[0] {
+	case EnvelopeType0:
+	case EnvelopeType1:
+		offset += envelopeSenderKeySize
+	default:
+		return nil, fmt.Errorf("unsupported envelope type %d", envelope[0])
+	}
+
+	if len(envelope) < offset+aead.NonceSize()+aead.Overhead() {
+		return nil, fmt.Errorf("encrypted data too short")
+	}
+	nonce := envelope[offset : offset+aead.NonceSize()]
+	sealed := envelope[offset+aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data: %w", err)
+	}
+
+	return plaintext, nil
+}
+
+// DecryptWithSymmetricKey decrypts data using a symmetric key.
+// Payloads that were accidentally base64-encoded twice are corrected transparently.
 func DecryptWithSymmetricKey(encryptedStr string, keyStr string) ([]byte, error) {
+	plaintext, _, err := DecryptWithSymmetricKeyLenient(encryptedStr, keyStr)
+	return plaintext, err
+}
+
+// DecryptWithSymmetricKeyLenient decrypts data using a symmetric key. If the
+// initial decryption fails and the decoded payload is itself valid base64, it
+// is decoded once more and decryption is retried. doubleEncoded reports whether
+// this correction was needed.
+func DecryptWithSymmetricKeyLenient(encryptedStr string, keyStr string) (plaintext []byte, doubleEncoded bool, err error) {
 	// Decode the base64 encrypted data
 	encrypted, err := base64.StdEncoding.DecodeString(encryptedStr)
 	if err != nil {
-		return nil, fmt.Errorf("invalid encrypted data: %w", err)
+		return nil, false, fmt.Errorf("invalid encrypted data: %w", err)
 	}
 
 	// Decode the base64 key
 	key, err := base64.StdEncoding.DecodeString(keyStr)
 	if err != nil {
-		return nil, fmt.Errorf("invalid symmetric key: %w", err)
+		return nil, false, fmt.Errorf("invalid symmetric key: %w", err)
+	}
+
+	plaintext, err = decryptAESGCM(encrypted, key)
+	if err == nil {
+		return plaintext, false, nil
 	}
 
+	// Some clients base64-encode an already encoded payload; try one extra layer
+	inner, decodeErr := base64.StdEncoding.DecodeString(string(encrypted))
+	if decodeErr != nil {
+		return nil, false, err
+	}
+
+	plaintext, innerErr := decryptAESGCM(inner, key)
+	if innerErr != nil {
+		return nil, false, err
+	}
+
+	return plaintext, true, nil
+}
+
+// legacyIVSize is the size of the IV used by older clients, which sealed with
+// a full AES block as the GCM nonce
+const legacyIVSize = aes.BlockSize
+
+// decryptAESGCM decrypts IV-prefixed AES-GCM ciphertext. Ciphertext with the
+// legacy 16-byte IV is also accepted.
+func decryptAESGCM(encrypted []byte, key []byte) ([]byte, error) {
+	plaintext, err := decryptAESGCMWithNonceSize(encrypted, key, 0)
+	if err == nil {
+		return plaintext, nil
+	}
+
+	if legacy, legacyErr := decryptAESGCMWithNonceSize(encrypted, key, legacyIVSize); legacyErr == nil {
+		return legacy, nil
+	}
+	return nil, err
+}
+
+// decryptAESGCMWithNonceSize decrypts IV-prefixed AES-GCM ciphertext whose IV
+// has the given size, or the standard GCM nonce size if nonceSize is zero
+func decryptAESGCMWithNonceSize(encrypted []byte, key []byte, nonceSize int) ([]byte, error) {
 	// Create the cipher
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -145,17 +318,23 @@ func DecryptWithSymmetricKey(encryptedStr string, keyStr string) ([]byte, error)
 	}
 
 	// Create the GCM mode
-	gcm, err := cipher.NewGCM(block)
+	var gcm cipher.AEAD
+	if nonceSize == 0 {
+		gcm, err = cipher.NewGCM(block)
+	} else {
+		gcm, err = cipher.NewGCMWithNonceSize(block, nonceSize)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to create GCM: %w", err)
 	}
 
 	// Extract the IV from the encrypted data
-	if len(encrypted) < aes.BlockSize {
+	nonceSize = gcm.NonceSize()
+	if len(encrypted) < nonceSize+gcm.Overhead() {
 		return nil, fmt.Errorf("encrypted data too short")
 	}
-	iv := encrypted[:aes.BlockSize]
-	ciphertext := encrypted[aes.BlockSize:]
+	iv := encrypted[:nonceSize]
+	ciphertext := encrypted[nonceSize:]
 
 	// Decrypt the data
 	plaintext, err := gcm.Open(nil, iv, ciphertext, nil)
@@ -189,15 +368,38 @@ func VerifySignature(message []byte, signature []byte, publicKey *ecdsa.PublicKe
 	return crypto.VerifySignature(crypto.FromECDSAPub(publicKey), hash.Bytes(), signature[:64])
 }
 
+// NormalizeSignatureV returns a copy of a 65-byte signature with V in the
+// 27/28 convention. Signatures with V as 0 or 1 are mapped to 27 or 28; any
+// other V value that is not 27 or 28 is an error.
+func NormalizeSignatureV(signature []byte) ([]byte, error) {
+	// The signature should be 65 bytes: R (32 bytes) + S (32 bytes) + V (1 byte)
+	if len(signature) != 65 {
+		return nil, fmt.Errorf("invalid signature length: %d", len(signature))
+	}
+
+	normalized := make([]byte, len(signature))
+	copy(normalized, signature)
+	if normalized[64] < 27 {
+		normalized[64] += 27
+	}
+	if normalized[64] != 27 && normalized[64] != 28 {
+		return nil, fmt.Errorf("invalid signature V value: %d", signature[64])
+	}
+
+	return normalized, nil
+}
+
 // RecoverAddressFromSignature recovers the Ethereum address from a signature
 func RecoverAddressFromSignature(message []byte, signature []byte) (common.Address, error) {
 	// Hash the message using Keccak256
 	hash := crypto.Keccak256Hash(message)
 
-	// The signature should be 65 bytes: R (32 bytes) + S (32 bytes) + V (1 byte)
-	if len(signature) != 65 {
-		return common.Address{}, fmt.Errorf("invalid signature length: %d", len(signature))
+	// Normalize V, then convert it to the 0/1 recovery ID Ecrecover expects
+	signature, err := NormalizeSignatureV(signature)
+	if err != nil {
+		return common.Address{}, err
 	}
+	signature[64] -= 27
 
 	// Recover the public key
 	publicKey, err := crypto.Ecrecover(hash.Bytes(), signature)
@@ -0,0 +1,244 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+)
+
+// mustDecodeHex decodes a hex test vector
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("decode hex: %v", err)
+	}
+	return data
+}
+
+func TestSymmetricKeyRoundTrip(t *testing.T) {
+	key, err := GenerateSymmetricKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := []byte(`{"id":1,"jsonrpc":"2.0","method":"wc_sessionPing","params":{}}`)
+	encrypted, err := EncryptWithSymmetricKey(plaintext, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := DecryptWithSymmetricKey(encrypted, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("got %q, want %q", decrypted, plaintext)
+	}
+
+	otherKey, err := GenerateSymmetricKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecryptWithSymmetricKey(encrypted, otherKey); err == nil {
+		t.Error("decrypting with the wrong key succeeded")
+	}
+}
+
+func TestDecryptWithSymmetricKeyKnownVector(t *testing.T) {
+	// AES-GCM test case 3 from McGrew and Viega, "The Galois/Counter Mode of
+	// Operation": a 12-byte IV followed by the ciphertext and tag
+	key := base64.StdEncoding.EncodeToString(mustDecodeHex(t, "feffe9928665731c6d6a8f9467308308"))
+	iv := mustDecodeHex(t, "cafebabefacedbaddecaf888")
+	ciphertext := mustDecodeHex(t, "42831ec2217774244b7221b784d0d49ce3aa212f2c02a4e035c17e2329aca12e"+
+		"21d514b25466931c7d8f6a5aac84aa051ba30b396a0aac973d58e091473f5985")
+	tag := mustDecodeHex(t, "4d5c2af327cd64a62cf35abd2ba6fab4")
+	want := mustDecodeHex(t, "d9313225f88406e5a55909c5aff5269a86a7a9531534f7da2e4c303d8a318a72"+
+		"1c3c0c95956809532fcf0e2449a6b525b16aedf5aa0de657ba637b391aafd255")
+
+	encrypted := append(append(iv, ciphertext...), tag...)
+	plaintext, err := DecryptWithSymmetricKey(base64.StdEncoding.EncodeToString(encrypted), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plaintext, want) {
+		t.Errorf("got %x, want %x", plaintext, want)
+	}
+
+	// A flipped tag bit must be rejected
+	encrypted[len(encrypted)-1] ^= 1
+	if _, err := DecryptWithSymmetricKey(base64.StdEncoding.EncodeToString(encrypted), key); err == nil {
+		t.Error("tampered ciphertext decrypted")
+	}
+}
+
+func TestChaCha20RoundTrip(t *testing.T) {
+	key, err := GenerateSymmetricKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := []byte("hello wallet")
+	encrypted, err := EncryptWithChaCha20(plaintext, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	envelope, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envelope[0] != EnvelopeType0 {
+		t.Errorf("got envelope type %d, want %d", envelope[0], EnvelopeType0)
+	}
+
+	decrypted, err := DecryptWithChaCha20(encrypted, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("got %q, want %q", decrypted, plaintext)
+	}
+}
+
+func TestDecryptWithChaCha20KnownEnvelope(t *testing.T) {
+	// A WalletConnect type 0 envelope, type byte || nonce || ciphertext || tag,
+	// around the ChaCha20 test vector of RFC 8439 section 2.4.2. With no
+	// additional data, ChaCha20-Poly1305 encrypts with the block counter
+	// starting at 1, so the ciphertext is the RFC's.
+	key := base64.StdEncoding.EncodeToString(mustDecodeHex(t, "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"))
+	nonce := mustDecodeHex(t, "000000000000004a00000000")
+	ciphertext := mustDecodeHex(t, "6e2e359a2568f98041ba0728dd0d6981e97e7aec1d4360c20a27afccfd9fae0b"+
+		"f91b65c5524733ab8f593dabcd62b3571639d624e65152ab8f530c359f0861d8"+
+		"07ca0dbf500d6a6156a38e088a22b65e52bc514d16ccf806818ce91ab7793736"+
+		"5af90bbf74a35be6b40b8eedf2785e42874d")
+	tag := mustDecodeHex(t, "81db63fcb189a03121ae0ac72a3f1f36")
+	want := "Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it."
+
+	envelope := append(append(append([]byte{EnvelopeType0}, nonce...), ciphertext...), tag...)
+	encoded := base64.StdEncoding.EncodeToString(envelope)
+	plaintext, doubleEncoded, err := DecryptWithChaCha20Lenient(encoded, key)
+	if err != nil || doubleEncoded || string(plaintext) != want {
+		t.Errorf("got %q, doubleEncoded=%v, err=%v", plaintext, doubleEncoded, err)
+	}
+
+	// An envelope encoded twice is corrected
+	twice := base64.StdEncoding.EncodeToString([]byte(encoded))
+	plaintext, doubleEncoded, err = DecryptWithChaCha20Lenient(twice, key)
+	if err != nil || !doubleEncoded || string(plaintext) != want {
+		t.Errorf("double encoding: got %q, doubleEncoded=%v, err=%v", plaintext, doubleEncoded, err)
+	}
+
+	// Three layers are not corrected
+	if _, err := DecryptWithChaCha20(base64.StdEncoding.EncodeToString([]byte(twice)), key); err == nil {
+		t.Error("triple-encoded envelope decrypted")
+	}
+
+	// A flipped tag bit must be rejected
+	envelope[len(envelope)-1] ^= 1
+	if _, err := DecryptWithChaCha20(base64.StdEncoding.EncodeToString(envelope), key); err == nil {
+		t.Error("tampered envelope decrypted")
+	}
+}
+
+func TestNormalizeSignatureV(t *testing.T) {
+	tests := []struct {
+		v       byte
+		want    byte
+		wantErr bool
+	}{
+		{v: 0, want: 27},
+		{v: 1, want: 28},
+		{v: 27, want: 27},
+		{v: 28, want: 28},
+		{v: 2, wantErr: true},
+		{v: 29, wantErr: true},
+		{v: 37, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		signature := make([]byte, 65)
+		signature[64] = tt.v
+
+		normalized, err := NormalizeSignatureV(signature)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("V=%d: expected an error", tt.v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("V=%d: %v", tt.v, err)
+			continue
+		}
+		if normalized[64] != tt.want {
+			t.Errorf("V=%d: got %d, want %d", tt.v, normalized[64], tt.want)
+		}
+		if signature[64] != tt.v {
+			t.Errorf("V=%d: input signature was modified", tt.v)
+		}
+	}
+
+	if _, err := NormalizeSignatureV(make([]byte, 64)); err == nil {
+		t.Error("64-byte signature accepted")
+	}
+}
+
+func TestRecoverAddressFromSignatureAcceptsBothVConventions(t *testing.T) {
+	privateKey, publicKey, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := PublicKeyToAddress(publicKey)
+
+	message := []byte("recover me")
+	signature, err := SignMessage(message, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// SignMessage yields V as 0 or 1; wallets usually send 27 or 28
+	shifted := append([]byte{}, signature...)
+	shifted[64] += 27
+	for _, sig := range [][]byte{signature, shifted} {
+		address, err := RecoverAddressFromSignature(message, sig)
+		if err != nil {
+			t.Fatalf("V=%d: %v", sig[64], err)
+		}
+		if address != want {
+			t.Errorf("V=%d: recovered %s, want %s", sig[64], address.Hex(), want.Hex())
+		}
+	}
+}
+
+func TestDecryptWithSymmetricKeyLenientDoubleEncoded(t *testing.T) {
+	key, err := GenerateSymmetricKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := []byte("encoded twice")
+	encrypted, err := EncryptWithSymmetricKey(plaintext, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, doubleEncoded, err := DecryptWithSymmetricKeyLenient(encrypted, key)
+	if err != nil || doubleEncoded || !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("single encoding: got %q, doubleEncoded=%v, err=%v", decrypted, doubleEncoded, err)
+	}
+
+	twice := base64.StdEncoding.EncodeToString([]byte(encrypted))
+	decrypted, doubleEncoded, err = DecryptWithSymmetricKeyLenient(twice, key)
+	if err != nil || !doubleEncoded || !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("double encoding: got %q, doubleEncoded=%v, err=%v", decrypted, doubleEncoded, err)
+	}
+
+	// Three layers are not corrected
+	thrice := base64.StdEncoding.EncodeToString([]byte(twice))
+	if _, _, err := DecryptWithSymmetricKeyLenient(thrice, key); err == nil {
+		t.Error("triple-encoded ciphertext decrypted")
+	}
+}
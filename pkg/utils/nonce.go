@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+)
+
+const (
+	// nonceHistorySize is how many recent nonces are remembered per key
+	nonceHistorySize = 1024
+	// nonceHistoryKeys is how many keys have their nonces remembered
+	nonceHistoryKeys = 1024
+	// maxNonceAttempts is how often a colliding nonce is regenerated before giving up
+	maxNonceAttempts = 3
+)
+
+// nonceHistory remembers the most recent nonces used with each key, so that
+// a nonce is never reused with the same key. Keys are tracked by their hash.
+type nonceHistory struct {
+	mutex sync.Mutex
+	keys  map[[sha256.Size]byte]*nonceSet
+	order [][sha256.Size]byte // keys in the order they were first seen
+}
+
+// nonceSet is a bounded set of nonces that forgets the oldest ones first
+type nonceSet struct {
+	seen  map[string]struct{}
+	order []string
+}
+
+// usedNonces tracks the nonces used by EncryptWithSymmetricKey
+var usedNonces = &nonceHistory{keys: make(map[[sha256.Size]byte]*nonceSet)}
+
+// add records a nonce for a key. It returns false if the nonce was already
+// used with the key, in which case it is not recorded again.
+func (h *nonceHistory) add(key, nonce []byte) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	id := sha256.Sum256(key)
+	set, ok := h.keys[id]
+	if !ok {
+		if len(h.order) >= nonceHistoryKeys {
+			delete(h.keys, h.order[0])
+			h.order = h.order[1:]
+		}
+		set = &nonceSet{seen: make(map[string]struct{})}
+		h.keys[id] = set
+		h.order = append(h.order, id)
+	}
+
+	if _, reused := set.seen[string(nonce)]; reused {
+		return false
+	}
+	if len(set.order) >= nonceHistorySize {
+		delete(set.seen, set.order[0])
+		set.order = set.order[1:]
+	}
+	set.seen[string(nonce)] = struct{}{}
+	set.order = append(set.order, string(nonce))
+	return true
+}
+
+// generateNonce generates a random nonce of the given size that has not been
+// used recently with the key
+func (h *nonceHistory) generateNonce(key []byte, size int) ([]byte, error) {
+	for attempt := 0; attempt < maxNonceAttempts; attempt++ {
+		nonce, err := GenerateRandomBytes(size)
+		if err != nil {
+			return nil, err
+		}
+		if h.add(key, nonce) {
+			return nonce, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to generate a unique nonce after %d attempts", maxNonceAttempts)
+}
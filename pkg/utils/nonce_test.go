@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+)
+
+// newTestNonceHistory returns an empty nonce history
+func newTestNonceHistory() *nonceHistory {
+	return &nonceHistory{keys: make(map[[sha256.Size]byte]*nonceSet)}
+}
+
+// counterNonce returns a 12-byte nonce holding n
+func counterNonce(n int) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], uint64(n))
+	return nonce
+}
+
+func TestNonceHistoryRejectsReuse(t *testing.T) {
+	h := newTestNonceHistory()
+	key := []byte("key")
+
+	if !h.add(key, counterNonce(1)) {
+		t.Fatal("first use of a nonce was rejected")
+	}
+	if h.add(key, counterNonce(1)) {
+		t.Error("reused nonce was accepted")
+	}
+
+	// The same nonce under another key is fine
+	if !h.add([]byte("other key"), counterNonce(1)) {
+		t.Error("nonce used with a different key was rejected")
+	}
+}
+
+func TestNonceHistoryEvictsOldestNonces(t *testing.T) {
+	h := newTestNonceHistory()
+	key := []byte("key")
+
+	for i := range nonceHistorySize {
+		if !h.add(key, counterNonce(i)) {
+			t.Fatalf("nonce %d rejected", i)
+		}
+	}
+
+	// The history is full, so one more nonce forgets the oldest
+	if !h.add(key, counterNonce(nonceHistorySize)) {
+		t.Fatal("nonce beyond the history size rejected")
+	}
+	if len(h.keys) != 1 || len(h.keys[sha256.Sum256(key)].order) != nonceHistorySize {
+		t.Errorf("history holds more than %d nonces", nonceHistorySize)
+	}
+	if !h.add(key, counterNonce(0)) {
+		t.Error("evicted nonce still rejected")
+	}
+	if h.add(key, counterNonce(2)) {
+		t.Error("recent nonce was evicted")
+	}
+}
+
+func TestGenerateNonceRetriesCollisions(t *testing.T) {
+	h := newTestNonceHistory()
+	key := []byte("key")
+
+	// A reader that repeats the same bytes can never produce a fresh nonce
+	reader := RandReader
+	defer func() { RandReader = reader }()
+	RandReader = bytes.NewReader(bytes.Repeat([]byte{7}, 12*(maxNonceAttempts+1)))
+
+	if _, err := h.generateNonce(key, 12); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.generateNonce(key, 12); err == nil {
+		t.Error("repeated nonce was returned")
+	}
+}
+
+func TestEncryptWithSymmetricKeyUses12ByteNonce(t *testing.T) {
+	key, err := GenerateSymmetricKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawKey, _ := base64.StdEncoding.DecodeString(key)
+
+	plaintext := []byte("payload")
+	encrypted, err := EncryptWithSymmetricKey(plaintext, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// IV || ciphertext || 16-byte tag
+	if len(raw) != 12+len(plaintext)+16 {
+		t.Fatalf("got %d bytes, want a 12-byte nonce, the ciphertext and the tag", len(raw))
+	}
+	block, _ := aes.NewCipher(rawKey)
+	gcm, _ := cipher.NewGCM(block)
+	if _, err := gcm.Open(nil, raw[:12], raw[12:], nil); err != nil {
+		t.Errorf("ciphertext does not open with its leading 12-byte nonce: %v", err)
+	}
+}
+
+func TestDecryptWithSymmetricKeyAcceptsLegacyIV(t *testing.T) {
+	key, err := GenerateSymmetricKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawKey, _ := base64.StdEncoding.DecodeString(key)
+
+	// Older clients sealed with a full 16-byte block as the nonce
+	block, _ := aes.NewCipher(rawKey)
+	gcm, err := cipher.NewGCMWithNonceSize(block, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv, err := GenerateRandomBytes(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := []byte("legacy payload")
+	sealed := gcm.Seal(append([]byte{}, iv...), iv, plaintext, nil)
+
+	decrypted, err := DecryptWithSymmetricKey(base64.StdEncoding.EncodeToString(sealed), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("got %q, want %q", decrypted, plaintext)
+	}
+}
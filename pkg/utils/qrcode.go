@@ -4,29 +4,245 @@ import (
 	"bytes"
 	"encoding/base64"
 	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strings"
 
 	qrcode "github.com/skip2/go-qrcode"
 )
 
-// GenerateQRCode generates a QR code for the given content
-func GenerateQRCode(content string, size int) (string, error) {
+// Limits for generating QR codes at multiple sizes
+const (
+	// MinQRCodeSize is the smallest allowed QR code size in pixels
+	MinQRCodeSize = 64
+	// MaxQRCodeSize is the largest allowed QR code size in pixels
+	MaxQRCodeSize = 1024
+	// MaxQRCodeSizes is the maximum number of sizes per request
+	MaxQRCodeSizes = 4
+)
+
+// QRCodeMIMEType is the MIME type of generated QR code images
+const QRCodeMIMEType = "image/png"
+
+// QRCodeDataURIPrefix prefixes the base64 image data in generated QR code data URIs
+const QRCodeDataURIPrefix = "data:" + QRCodeMIMEType + ";base64,"
+
+// QR code error recovery levels, from the smallest code to the most damage tolerated
+const (
+	QRRecoveryLow     = qrcode.Low     // recovers 7% of data
+	QRRecoveryMedium  = qrcode.Medium  // recovers 15% of data; the default
+	QRRecoveryHigh    = qrcode.High    // recovers 25% of data
+	QRRecoveryHighest = qrcode.Highest // recovers 30% of data
+)
+
+// qrLogoMaxFraction is the largest share of the QR code's width a logo may cover.
+// It keeps the covered modules within what High recovery can restore.
+const qrLogoMaxFraction = 0.2
+
+// qrOptions holds the settings applied by QROptions
+type qrOptions struct {
+	level      qrcode.RecoveryLevel
+	foreground color.Color
+	background color.Color
+	logo       image.Image
+}
+
+// QROption customizes a generated QR code
+type QROption func(*qrOptions)
+
+// WithRecoveryLevel sets the error recovery level, e.g. QRRecoveryHigh
+func WithRecoveryLevel(level qrcode.RecoveryLevel) QROption {
+	return func(o *qrOptions) {
+		o.level = level
+	}
+}
+
+// WithColors sets the foreground and background colors
+func WithColors(foreground, background color.Color) QROption {
+	return func(o *qrOptions) {
+		o.foreground = foreground
+		o.background = background
+	}
+}
+
+// WithLogo overlays a logo in the center of the QR code, scaled down to at
+// most a fifth of the code's width. Combine it with QRRecoveryHigh or
+// QRRecoveryHighest so that the code still scans.
+func WithLogo(logo image.Image) QROption {
+	return func(o *qrOptions) {
+		o.logo = logo
+	}
+}
+
+// GenerateQRCode generates a QR code for the given content as a PNG data URI
+func GenerateQRCode(content string, size int, opts ...QROption) (string, error) {
 	if size <= 0 {
 		size = 256 // Default size
 	}
 
-	qr, err := qrcode.New(content, qrcode.Medium)
+	options := qrOptions{
+		level:      qrcode.Medium,
+		foreground: color.Black,
+		background: color.White,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	qr, err := qrcode.New(content, options.level)
 	if err != nil {
 		return "", fmt.Errorf("failed to generate QR code: %w", err)
 	}
+	qr.ForegroundColor = options.foreground
+	qr.BackgroundColor = options.background
 
 	// Create a buffer to store the PNG image
 	var buf bytes.Buffer
-	err = qr.Write(size, &buf)
+	if options.logo == nil {
+		err = qr.Write(size, &buf)
+	} else {
+		err = png.Encode(&buf, overlayLogo(qr.Image(size), options.logo))
+	}
 	if err != nil {
 		return "", fmt.Errorf("failed to write QR code: %w", err)
 	}
 
 	// Encode the image as base64
 	encoded := base64.StdEncoding.EncodeToString(buf.Bytes())
-	return fmt.Sprintf("data:image/png;base64,%s", encoded), nil
+	return QRCodeDataURIPrefix + encoded, nil
+}
+
+// overlayLogo draws a logo over the center of a QR code image, scaling it
+// down with nearest-neighbor sampling to at most qrLogoMaxFraction of the width
+func overlayLogo(code image.Image, logo image.Image) image.Image {
+	bounds := code.Bounds()
+	canvas := image.NewRGBA(bounds)
+	draw.Draw(canvas, bounds, code, bounds.Min, draw.Src)
+
+	logoBounds := logo.Bounds()
+	maxSide := int(float64(bounds.Dx()) * qrLogoMaxFraction)
+	width, height := logoBounds.Dx(), logoBounds.Dy()
+	if width == 0 || height == 0 || maxSide == 0 {
+		return canvas
+	}
+	if width > maxSide || height > maxSide {
+		scale := float64(maxSide) / float64(max(width, height))
+		width = max(1, int(float64(width)*scale))
+		height = max(1, int(float64(height)*scale))
+	}
+
+	left := bounds.Min.X + (bounds.Dx()-width)/2
+	top := bounds.Min.Y + (bounds.Dy()-height)/2
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			sx := logoBounds.Min.X + x*logoBounds.Dx()/width
+			sy := logoBounds.Min.Y + y*logoBounds.Dy()/height
+			scaled.Set(x, y, logo.At(sx, sy))
+		}
+	}
+	draw.Draw(canvas, image.Rect(left, top, left+width, top+height), scaled, image.Point{}, draw.Over)
+	return canvas
+}
+
+// GenerateQRCodeSVG generates a QR code for the given content as an SVG
+// document of size by size pixels. Dark modules are drawn as rects, with
+// horizontal runs merged, so the code stays sharp at any scale.
+func GenerateQRCodeSVG(content string, size int) (string, error) {
+	if size <= 0 {
+		size = 256 // Default size
+	}
+
+	qr, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate QR code: %w", err)
+	}
+
+	// The bitmap includes the quiet zone around the code
+	bitmap := qr.Bitmap()
+	modules := len(bitmap)
+
+	var svg strings.Builder
+	fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		size, size, modules, modules)
+	fmt.Fprintf(&svg, `<rect width="%d" height="%d" fill="#ffffff"/>`, modules, modules)
+	for y, row := range bitmap {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			fmt.Fprintf(&svg, `<rect x="%d" y="%d" width="%d" height="1" fill="#000000"/>`, start, y, x-start)
+		}
+	}
+	svg.WriteString(`</svg>`)
+
+	return svg.String(), nil
+}
+
+// GenerateQRCodeASCII generates a QR code for the given content as text for
+// printing in a terminal. Each character covers two rows of modules using
+// Unicode half blocks. Light modules, including the quiet zone, are drawn and
+// dark modules are left blank, so the code scans on a dark background.
+func GenerateQRCodeASCII(content string) (string, error) {
+	qr, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate QR code: %w", err)
+	}
+
+	// The bitmap includes the quiet zone around the code; true is a dark module
+	bitmap := qr.Bitmap()
+
+	var text strings.Builder
+	for y := 0; y < len(bitmap); y += 2 {
+		for x := range bitmap[y] {
+			// A missing bottom row past the last one is left blank
+			top := !bitmap[y][x]
+			bottom := y+1 < len(bitmap) && !bitmap[y+1][x]
+			switch {
+			case top && bottom:
+				text.WriteString("█")
+			case top:
+				text.WriteString("▀")
+			case bottom:
+				text.WriteString("▄")
+			default:
+				text.WriteString(" ")
+			}
+		}
+		text.WriteString("\n")
+	}
+
+	return text.String(), nil
+}
+
+// GenerateQRCodes generates QR codes for the given content at multiple sizes.
+// The result maps each size to its data URI. The options apply to every size.
+func GenerateQRCodes(content string, sizes []int, opts ...QROption) (map[int]string, error) {
+	if len(sizes) > MaxQRCodeSizes {
+		return nil, fmt.Errorf("too many QR code sizes: %d (max %d)", len(sizes), MaxQRCodeSizes)
+	}
+
+	codes := make(map[int]string, len(sizes))
+	for _, size := range sizes {
+		if size < MinQRCodeSize || size > MaxQRCodeSize {
+			return nil, fmt.Errorf("invalid QR code size %d (must be between %d and %d)", size, MinQRCodeSize, MaxQRCodeSize)
+		}
+		if _, ok := codes[size]; ok {
+			continue
+		}
+
+		code, err := GenerateQRCode(content, size, opts...)
+		if err != nil {
+			return nil, err
+		}
+		codes[size] = code
+	}
+
+	return codes, nil
 }